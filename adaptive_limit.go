@@ -0,0 +1,180 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// AdaptiveLimitConfig configures NewAdaptiveLimiter.
+type AdaptiveLimitConfig struct {
+	// Name identifies the limiter in its LimiterStats, e.g. "search".
+	Name string
+	// InitialLimit is the number of concurrent requests that are allowed
+	// before any latencies have been observed. If zero, 20 is used.
+	InitialLimit int
+	// MinLimit and MaxLimit bound the limit. If zero, 1 and 1000 are used.
+	MinLimit, MaxLimit int
+	// Tolerance is how much the recent latency may exceed the long-term
+	// latency before the limit is reduced, e.g. 1.5 tolerates latencies up to
+	// 50% above normal. If zero, 2 is used.
+	Tolerance float64
+	// Smoothing is the fraction of each adjustment that is applied to the
+	// limit, between 0 and 1. Smaller values react to latency changes more
+	// slowly but are more stable. If zero, 0.2 is used.
+	Smoothing float64
+}
+
+// AdaptiveLimiter is a middleware that limits the number of concurrent
+// requests to the handlers after it, rejecting requests beyond the limit with
+// a 503. Unlike a fixed limit, the limit is adjusted based on the latency of
+// completed requests: it grows while latencies are stable and shrinks when the
+// latency of recent requests rises above the long-term latency, which
+// indicates that requests are queuing in the handlers or their downstream
+// dependencies. This protects overloaded downstreams without having to tune
+// the limit by hand. The algorithm is the latency gradient approach of TCP
+// Vegas as adapted by Netflix's concurrency-limits library.
+//
+// For example:
+//
+//	search := sandwich.NewAdaptiveLimiter(sandwich.AdaptiveLimitConfig{Name: "search"})
+//	mux.Get("/search", search, doSearch)
+//	sandwich.DefaultStats.AddLimiters(search)
+//
+// The state of the limiters added to a StatsCollector is reported by
+// ServeStats, e.g. at /debug/sandwich/stats?limiters.
+//
+// An AdaptiveLimiter may be shared by several routes to limit them together.
+type AdaptiveLimiter struct {
+	cfg AdaptiveLimitConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	shortRTT time.Duration // latency of the most recent request
+	longRTT  float64       // exponentially-weighted average latency, in ns
+	rejected uint64
+}
+
+// LimiterStats is the current state of an AdaptiveLimiter.
+type LimiterStats struct {
+	Name     string        `json:"name"`
+	Limit    int           `json:"limit"`
+	InFlight int           `json:"in_flight"`
+	Rejected uint64        `json:"rejected"`
+	ShortRTT time.Duration `json:"short_rtt_ns"`
+	LongRTT  time.Duration `json:"long_rtt_ns"`
+}
+
+// longRTTWeight is the weight of each sample in the long-term latency average,
+// which roughly averages the latencies of the last 500 requests.
+const longRTTWeight = 0.002
+
+// NewAdaptiveLimiter returns a limiter configured by cfg.
+func NewAdaptiveLimiter(cfg AdaptiveLimitConfig) *AdaptiveLimiter {
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 1000
+	}
+	if cfg.MaxLimit < cfg.MinLimit {
+		cfg.MaxLimit = cfg.MinLimit
+	}
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = 20
+	}
+	if cfg.Tolerance <= 0 {
+		cfg.Tolerance = 2
+	}
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.2
+	}
+	l := &AdaptiveLimiter{cfg: cfg}
+	l.limit = l.clamp(float64(cfg.InitialLimit))
+	return l
+}
+
+// Apply adds the limiter to the chain, see ChainMutation.
+func (l *AdaptiveLimiter) Apply(c chain.Func) chain.Func { return c.Then(l.acquire) }
+
+// acquire admits the request if the limit allows it, and returns a cleanup
+// that records its latency when the request completes.
+func (l *AdaptiveLimiter) acquire() (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight >= int(l.limit) {
+		l.rejected++
+		return nil, Error{
+			Code:      http.StatusServiceUnavailable,
+			ClientMsg: http.StatusText(http.StatusServiceUnavailable),
+			LogMsg: fmt.Sprintf("Concurrency limit %q exceeded: %d requests in flight",
+				l.cfg.Name, l.inFlight),
+		}
+	}
+	l.inFlight++
+	start := time_Now()
+	return func() { l.release(time_Now().Sub(start)) }, nil
+}
+
+func (l *AdaptiveLimiter) release(rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inFlight := l.inFlight
+	l.inFlight--
+	if rtt <= 0 {
+		rtt = 1
+	}
+	l.shortRTT = rtt
+	if l.longRTT == 0 {
+		l.longRTT = float64(rtt)
+	} else {
+		l.longRTT += longRTTWeight * (float64(rtt) - l.longRTT)
+	}
+
+	// Don't grow the limit while it isn't being used, otherwise it would grow
+	// without bound during periods of low traffic.
+	if float64(inFlight) < l.limit/2 && float64(rtt) <= l.cfg.Tolerance*l.longRTT {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1, l.cfg.Tolerance*l.longRTT/float64(rtt)))
+	target := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = l.clamp(l.limit*(1-l.cfg.Smoothing) + target*l.cfg.Smoothing)
+}
+
+func (l *AdaptiveLimiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.cfg.MinLimit), math.Min(float64(l.cfg.MaxLimit), limit))
+}
+
+// Stats returns the current state of the limiter.
+func (l *AdaptiveLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LimiterStats{
+		Name:     l.cfg.Name,
+		Limit:    int(l.limit),
+		InFlight: l.inFlight,
+		Rejected: l.rejected,
+		ShortRTT: l.shortRTT,
+		LongRTT:  time.Duration(l.longRTT),
+	}
+}
+
+// ServeLimiterStats returns a handler that responds with the Stats of each of
+// the limiters as JSON. It's intended for a debug or metrics endpoint of
+// limiters that aren't added to a StatsCollector, see ServeStats.
+func ServeLimiterStats(limiters ...*AdaptiveLimiter) func(w http.ResponseWriter) error {
+	return func(w http.ResponseWriter) error {
+		stats := make([]LimiterStats, len(limiters))
+		for i, l := range limiters {
+			stats[i] = l.Stats()
+		}
+		w.Header().Set(headerContentType, "application/json")
+		return json.NewEncoder(w).Encode(stats)
+	}
+}
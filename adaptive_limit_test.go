@@ -0,0 +1,105 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	time_Now = func() time.Time { return now }
+	defer func() { time_Now = time.Now }()
+
+	l := NewAdaptiveLimiter(AdaptiveLimitConfig{Name: "test", InitialLimit: 4, MaxLimit: 50})
+
+	// runBatch runs a full batch of concurrent requests that each take rtt.
+	runBatch := func(rtt time.Duration) {
+		limit := l.Stats().Limit
+		var releases []func()
+		for i := 0; i < limit; i++ {
+			release, err := l.acquire()
+			require.NoError(t, err)
+			releases = append(releases, release)
+		}
+		_, err := l.acquire()
+		require.Error(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, ToError(err).Code)
+		now = now.Add(rtt)
+		for _, release := range releases {
+			release()
+		}
+	}
+
+	runBatch(10 * time.Millisecond)
+	// The limit only grows gradually, so it's still 4.x.
+	assert.Equal(t, LimiterStats{
+		Name: "test", Limit: 4, Rejected: 1,
+		ShortRTT: 10 * time.Millisecond, LongRTT: 10 * time.Millisecond,
+	}, l.Stats())
+
+	// Stable latencies grow the limit up to the max.
+	for i := 0; i < 20; i++ {
+		runBatch(10 * time.Millisecond)
+	}
+	assert.Equal(t, 50, l.Stats().Limit)
+
+	// Rising latencies shrink it again.
+	for i := 0; i < 5; i++ {
+		runBatch(100 * time.Millisecond)
+	}
+	stats := l.Stats()
+	assert.Less(t, stats.Limit, 20)
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, uint64(26), stats.Rejected)
+
+	// An idle limiter doesn't grow.
+	l = NewAdaptiveLimiter(AdaptiveLimitConfig{InitialLimit: 10})
+	for i := 0; i < 10; i++ {
+		release, err := l.acquire()
+		require.NoError(t, err)
+		release()
+	}
+	assert.Equal(t, 10, l.Stats().Limit)
+}
+
+func TestAdaptiveLimiterMiddleware(t *testing.T) {
+	limiter := NewAdaptiveLimiter(AdaptiveLimitConfig{Name: "slow", InitialLimit: 1})
+	r := TheUsual()
+	r.Use(NoLog)
+	started, finish := make(chan bool), make(chan bool)
+	r.Get("/slow", limiter, func() { started <- true; <-finish })
+	r.Get("/limits", ServeLimiterStats(limiter))
+	stats := NewStatsCollector()
+	stats.AddLimiters(limiter)
+	r.Get("/stats", ServeStats(stats))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	done := make(chan int)
+	go func() { done <- serve("/slow").Code }()
+	<-started
+	assert.Equal(t, http.StatusServiceUnavailable, serve("/slow").Code)
+
+	for _, path := range []string{"/limits", "/stats?limiters"} {
+		var served []LimiterStats
+		require.NoError(t, json.Unmarshal(serve(path).Body.Bytes(), &served), path)
+		require.Len(t, served, 1, path)
+		assert.Equal(t, "slow", served[0].Name, path)
+		assert.Equal(t, 1, served[0].InFlight, path)
+		assert.Equal(t, uint64(1), served[0].Rejected, path)
+	}
+	assert.Equal(t, []LimiterStats{limiter.Stats()}, stats.Limiters())
+
+	finish <- true
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, 0, limiter.Stats().InFlight)
+}
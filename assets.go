@@ -0,0 +1,120 @@
+package sandwich
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// fingerprintLen is the number of hex digits of the content hash in
+// fingerprinted file names.
+const fingerprintLen = 8
+
+// AssetURLs resolves the logical names of static files, e.g. "css/app.css", to
+// fingerprinted URLs that include a hash of their content, e.g.
+// "/static/css/app.3f2a9c1d.css". Since the URL changes whenever the content
+// does, browsers may cache the files indefinitely. The files must be served by
+// ServeFSWith with FSOptions.Fingerprinted, e.g.:
+//
+//	assets := sandwich.NewAssetURLs(files, "static", "/static/")
+//	tpl := template.Must(template.New("").Funcs(assets.FuncMap()).ParseFS(...))
+//	mux.Get("/static/:path*", sandwich.ServeFSWith(files, "static", "path",
+//	    sandwich.FSOptions{Fingerprinted: true, MaxAge: 5 * time.Minute}))
+//
+// and in the templates:
+//
+//	<link rel="stylesheet" href="{{asset "css/app.css"}}">
+//
+// The hashes are computed once and cached, so the filesystem shouldn't change
+// while serving, as with embed.FS.
+type AssetURLs struct {
+	prefix string
+	hashes *fileHashes
+}
+
+// NewAssetURLs returns an AssetURLs for the files in the fsRoot directory of f,
+// which are served at urlPrefix, e.g. "/static/". It panics if fsRoot is
+// invalid.
+func NewAssetURLs(f fs.FS, fsRoot, urlPrefix string) *AssetURLs {
+	sub, err := fs.Sub(f, fsRoot)
+	if err != nil {
+		panic(err)
+	}
+	if !strings.HasSuffix(urlPrefix, "/") {
+		urlPrefix += "/"
+	}
+	return &AssetURLs{urlPrefix, newFileHashes(sub)}
+}
+
+// URL returns the fingerprinted URL of the named file. If the file doesn't
+// exist, its URL is returned without a fingerprint.
+func (a *AssetURLs) URL(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	hash, ok := a.hashes.get(name)
+	if !ok {
+		return a.prefix + name
+	}
+	return a.prefix + fingerprintedName(name, hash[:fingerprintLen])
+}
+
+// FuncMap returns the template functions for using AssetURLs in html/template
+// or text/template templates: "asset" returns the URL of the named file.
+func (a *AssetURLs) FuncMap() template.FuncMap {
+	return template.FuncMap{"asset": a.URL}
+}
+
+// fingerprintedName inserts the hash before the extension of name, e.g.
+// "css/app.3f2a9c1d.css".
+func fingerprintedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// parseFingerprinted splits a fingerprinted file name into the original name
+// and the hash. It returns false if name isn't fingerprinted.
+func parseFingerprinted(name string) (orig, hash string, ok bool) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	hash = path.Ext(base)
+	if len(hash) != fingerprintLen+1 {
+		return "", "", false
+	}
+	hash = hash[1:]
+	if _, err := hex.DecodeString(hash); err != nil || strings.ToLower(hash) != hash {
+		return "", "", false
+	}
+	return strings.TrimSuffix(base, "."+hash) + ext, hash, true
+}
+
+// fileHashes computes and caches the content hashes of the files of fsys.
+type fileHashes struct {
+	fsys   fs.FS
+	mu     sync.Mutex
+	hashes map[string]string // hex SHA-256 by file name, "" if it isn't a regular file
+}
+
+func newFileHashes(fsys fs.FS) *fileHashes {
+	return &fileHashes{fsys: fsys, hashes: map[string]string{}}
+}
+
+func (h *fileHashes) get(name string) (string, bool) {
+	h.mu.Lock()
+	hash, cached := h.hashes[name]
+	h.mu.Unlock()
+	if !cached {
+		if info, err := fs.Stat(h.fsys, name); err == nil && info.Mode().IsRegular() {
+			if data, err := fs.ReadFile(h.fsys, name); err == nil {
+				sum := sha256.Sum256(data)
+				hash = hex.EncodeToString(sum[:])
+			}
+		}
+		h.mu.Lock()
+		h.hashes[name] = hash
+		h.mu.Unlock()
+	}
+	return hash, hash != ""
+}
@@ -0,0 +1,149 @@
+package sandwich
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy determines what an AsyncLogSink does when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until there's space in the buffer, so no entries
+	// are lost but requests may be delayed while the log is backed up.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the entry, see AsyncLogSink.Dropped.
+	OverflowDrop
+)
+
+// AsyncLogOptions configures NewAsyncLogSink.
+type AsyncLogOptions struct {
+	// BufferSize is the number of entries that may be waiting to be written.
+	// If zero, 1024 is used.
+	BufferSize int
+	// Overflow determines what happens when the buffer is full.
+	Overflow OverflowPolicy
+}
+
+// AsyncLogSink is a LogSink that writes entries to another sink in a
+// background goroutine, so that slow log destinations don't add latency to
+// requests. Entries are written in order. Use Flush to wait until the buffered
+// entries have been written, and Shutdown to stop the background goroutine.
+// Entries written after Shutdown are written synchronously.
+//
+// When an AsyncLogSink is set as a router's sink with SetLogSink, the router's
+// Shutdown shuts it down too, so that the remaining entries are written. For
+// example:
+//
+//	mux.SetLogSink(sandwich.NewAsyncLogSink(sandwich.NewLogSink(os.Stderr, nil),
+//	    sandwich.AsyncLogOptions{Overflow: sandwich.OverflowDrop}))
+//	...
+//	srv.Shutdown(ctx)
+//	mux.Shutdown(ctx)
+type AsyncLogSink struct {
+	sink     LogSink
+	overflow OverflowPolicy
+	queue    chan asyncLogItem
+	done     chan struct{}
+	dropped  atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// asyncLogItem is either an entry to write or, if flushed is non-nil, a marker
+// that is closed once all preceding entries have been written.
+type asyncLogItem struct {
+	entry   LogEntry
+	flushed chan struct{}
+}
+
+// NewAsyncLogSink returns a sink that writes entries to sink asynchronously.
+func NewAsyncLogSink(sink LogSink, opts AsyncLogOptions) *AsyncLogSink {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 1024
+	}
+	s := &AsyncLogSink{
+		sink:     sink,
+		overflow: opts.Overflow,
+		queue:    make(chan asyncLogItem, opts.BufferSize),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *AsyncLogSink) run() {
+	defer close(s.done)
+	for item := range s.queue {
+		if item.flushed != nil {
+			close(item.flushed)
+		} else {
+			s.sink.Write(item.entry)
+		}
+	}
+}
+
+// Write queues e to be written, see LogSink.
+func (s *AsyncLogSink) Write(e LogEntry) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.sink.Write(e)
+		return
+	}
+	if s.overflow == OverflowDrop {
+		select {
+		case s.queue <- asyncLogItem{entry: e}:
+		default:
+			s.dropped.Add(1)
+		}
+		return
+	}
+	s.queue <- asyncLogItem{entry: e}
+}
+
+// Dropped returns the number of entries that have been discarded because the
+// buffer was full.
+func (s *AsyncLogSink) Dropped() uint64 { return s.dropped.Load() }
+
+// Flush waits until the entries that were written before the call have been
+// written to the underlying sink, or until ctx is done.
+func (s *AsyncLogSink) Flush(ctx context.Context) error {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return s.wait(ctx, s.done)
+	}
+	flushed := make(chan struct{})
+	select {
+	case s.queue <- asyncLogItem{flushed: flushed}:
+		s.mu.RUnlock()
+	case <-ctx.Done():
+		s.mu.RUnlock()
+		return ctx.Err()
+	}
+	return s.wait(ctx, flushed)
+}
+
+// Shutdown stops accepting entries into the buffer and waits until the
+// buffered entries have been written, or until ctx is done. See Shutdowner.
+func (s *AsyncLogSink) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	return s.wait(ctx, s.done)
+}
+
+func (s *AsyncLogSink) wait(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,89 @@
+package sandwich
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingSink records entries, but waits for unblock before each write.
+type blockingSink struct {
+	mu      sync.Mutex
+	paths   []string
+	unblock chan bool
+}
+
+func (b *blockingSink) Write(e LogEntry) {
+	<-b.unblock
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paths = append(b.paths, e.Request.URL.Path)
+}
+
+func (b *blockingSink) Paths() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.paths...)
+}
+
+func TestAsyncLogSink(t *testing.T) {
+	entry := func(path string) LogEntry { return LogEntry{Request: httptest.NewRequest("GET", path, nil)} }
+	sink := &blockingSink{unblock: make(chan bool)}
+	async := NewAsyncLogSink(sink, AsyncLogOptions{BufferSize: 2, Overflow: OverflowDrop})
+
+	// The first entry is taken by the writer, the next 2 are buffered, and the
+	// rest are dropped.
+	async.Write(entry("/1"))
+	require.Eventually(t, func() bool { return len(async.queue) == 0 }, time.Second, time.Millisecond)
+	for _, path := range []string{"/2", "/3", "/4", "/5"} {
+		async.Write(entry(path))
+	}
+	assert.Equal(t, uint64(2), async.Dropped())
+	assert.Empty(t, sink.Paths())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, async.Flush(ctx))
+
+	close(sink.unblock)
+	require.NoError(t, async.Flush(context.Background()))
+	assert.Equal(t, []string{"/1", "/2", "/3"}, sink.Paths())
+
+	async.Write(entry("/6"))
+	require.NoError(t, async.Shutdown(context.Background()))
+	async.Write(entry("/7")) // written synchronously
+	assert.Equal(t, []string{"/1", "/2", "/3", "/6", "/7"}, sink.Paths())
+	require.NoError(t, async.Flush(context.Background()))
+	require.NoError(t, async.Shutdown(context.Background()))
+}
+
+func TestConfigAsyncLog(t *testing.T) {
+	var mu sync.Mutex
+	var logged, measured []string
+	r, err := NewWithConfig(Config{
+		Logger: func(e LogEntry) {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			logged = append(logged, e.Request.URL.Path)
+		},
+		Metrics:  func(e LogEntry) { measured = append(measured, e.Request.URL.Path) },
+		AsyncLog: &AsyncLogOptions{},
+	})
+	require.NoError(t, err)
+	r.Get("/:n", hello)
+	for _, path := range []string{"/1", "/2", "/3"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	assert.Equal(t, []string{"/1", "/2", "/3"}, measured)
+
+	require.NoError(t, r.Shutdown(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"/1", "/2", "/3"}, logged)
+}
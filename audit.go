@@ -0,0 +1,76 @@
+package sandwich
+
+// AuditConfig describes the middleware that routes are expected to use, for
+// use with Router.Audit. Each kind of middleware is identified by the types
+// that it provides to subsequent handlers, e.g. an authentication middleware
+// that provides a *User. Types are specified as with chain.Func.Arg: a value of
+// the type, or a pointer to an interface for interface types. A route passes a
+// check if any of the listed types is provided. Checks with no types listed
+// are skipped.
+type AuditConfig struct {
+	// AuthTypes are provided by authentication middleware.
+	AuthTypes []any
+	// BodyLimitTypes are provided by middleware that limits the size of request
+	// bodies. This is only checked for POST, PUT, PATCH, and Any routes.
+	BodyLimitTypes []any
+	// RateLimitTypes are provided by rate limiting middleware.
+	RateLimitTypes []any
+}
+
+// AuditIssue identifies a kind of problem found by Router.Audit.
+type AuditIssue string
+
+const (
+	AuditNoAuth        AuditIssue = "no_auth"
+	AuditUnboundedBody AuditIssue = "unbounded_body"
+	AuditNoRateLimit   AuditIssue = "no_rate_limit"
+)
+
+// AuditFinding is a single problem with a registered route.
+type AuditFinding struct {
+	Method  string     `json:"method"`
+	Pattern string     `json:"pattern"`
+	Issue   AuditIssue `json:"issue"`
+}
+
+// AuditReport lists all of the problems found by Router.Audit, sorted by route
+// pattern and then method. It's intended to be JSON-encoded for review.
+type AuditReport struct {
+	Findings []AuditFinding `json:"findings"`
+}
+
+// bodyMethods are the route methods that are expected to accept a request
+// body.
+var bodyMethods = map[string]bool{"POST": true, "PUT": true, "PATCH": true, "*": true}
+
+// Audit checks every route registered on the router and its sub-routers
+// against the expected middleware. This is typically called at startup after
+// all routes have been registered:
+//
+//	report := mux.Audit(sandwich.AuditConfig{
+//	  AuthTypes:      []any{(*User)(nil)},
+//	  BodyLimitTypes: []any{sandwich.BodyLimit(0)},
+//	})
+//	json.NewEncoder(os.Stdout).Encode(report)
+func (r *router) Audit(cfg AuditConfig) AuditReport {
+	report := AuditReport{Findings: []AuditFinding{}}
+	for _, h := range r.handlers() {
+		check := func(issue AuditIssue, types []any) {
+			if len(types) == 0 {
+				return
+			}
+			for _, t := range types {
+				if h.Func.Provides(t) {
+					return
+				}
+			}
+			report.Findings = append(report.Findings, AuditFinding{h.method, h.pattern, issue})
+		}
+		check(AuditNoAuth, cfg.AuthTypes)
+		if bodyMethods[h.method] {
+			check(AuditUnboundedBody, cfg.BodyLimitTypes)
+		}
+		check(AuditNoRateLimit, cfg.RateLimitTypes)
+	}
+	return report
+}
@@ -0,0 +1,45 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	type User struct{}
+	type Limited struct{}
+	type Limiter interface{ Allow() bool }
+
+	authenticate := func(r *http.Request) (*User, error) { return &User{}, nil }
+	limitBody := func(r *http.Request) Limited { return Limited{} }
+	rateLimit := func() Limiter { return nil }
+
+	r := BuildYourOwn()
+	r.Use(rateLimit)
+	r.Get("/", func() {})
+	r.Post("/login", limitBody, func() {})
+	api := r.SubRouter("/api")
+	api.Use(authenticate)
+	api.Get("/users/:id", func() {})
+	api.Put("/users/:id", func() {})
+	api.Patch("/users/:id", limitBody, func() {})
+
+	report := r.Audit(AuditConfig{
+		AuthTypes:      []any{&User{}},
+		BodyLimitTypes: []any{Limited{}},
+		RateLimitTypes: []any{(*Limiter)(nil)},
+	})
+	assert.Equal(t, []AuditFinding{
+		{"GET", "/", AuditNoAuth},
+		{"PUT", "/api/users/:id", AuditUnboundedBody},
+		{"POST", "/login", AuditNoAuth},
+	}, report.Findings)
+
+	data, err := json.Marshal(r.Audit(AuditConfig{}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"findings": []}`, string(data))
+}
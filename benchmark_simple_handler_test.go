@@ -124,3 +124,32 @@ func BenchmarkCalls(b *testing.B) {
 // // func Benchmark_SendJson_RawHTTP(b *testing.B)          { bench(b.N, Handler(sendjson)) }
 // // func Benchmark_SendJson_Dynamic_Bare(b *testing.B)     { bench(b.N, makeSendJson_Bare()) }
 // // func Benchmark_SendJson_Dynamic_TheUsual(b *testing.B) { bench(b.N, makeSendJson_TheUsual()) }
+
+// BenchmarkCompile compares running the chain of a route of TheUsual with and
+// without the FastCall adapters of Compile, see compile.go.
+func BenchmarkCompile(b *testing.B) {
+	base := usualRouter.(*router).base
+	for _, test := range []struct {
+		name    string
+		compile bool
+	}{{"reflect", false}, {"compiled", true}} {
+		c := apply(base, hello)
+		if test.compile {
+			c = c.Compile()
+		}
+		frozen, err := c.Freeze()
+		if err != nil {
+			b.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/hello", nil)
+		b.Run(test.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				if err := frozen.RunWith(nil, w, req, Params{}, defaultLogSink, ProdErrors); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package sandwich
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bind returns a handler that decodes the request into a T and provides it to
+// subsequent handlers. T must be a struct or a pointer to a struct. For
+// example:
+//
+//	type UpdateUser struct {
+//	    ID     int    `path:"id"`
+//	    DryRun bool   `query:"dry_run"`
+//	    Name   string `json:"name" form:"name"`
+//	}
+//
+//	mux.Put("/users/:id", sandwich.Bind[UpdateUser](), updateUser)
+//
+//	func updateUser(w http.ResponseWriter, u UpdateUser) error { ... }
+//
+// The sources of the values are applied in order, so later ones take
+// precedence:
+//
+//  1. A JSON request body is decoded into T with encoding/json, so the fields
+//     are matched according to their `json` tags.
+//  2. A form request body, either URL-encoded or multipart, sets the fields
+//     with `form` tags.
+//  3. The query parameters set the fields with `query` tags.
+//  4. The path parameters set the fields with `path` tags.
+//
+// Fields set from form, query, or path values may be strings, bools, numbers,
+// types that implement encoding.TextUnmarshaler such as time.Time, pointers to
+// these, or slices of these, which receive all values of the parameter.
+// Exported fields of embedded structs are bound as well. Bind panics if T has
+// a tagged field of any other type.
+//
+// Requests with a malformed body, or with a body of any other content type, fail
+// with a 400 or 415 Error. Values that can't be converted to the type of their
+// field fail with a ValidationError, which ToError converts to a 422, keyed by
+// the tag name of each invalid field. Finally, if T implements Validator, the
+// value is validated.
+func Bind[T any]() func(r *http.Request, p Params) (T, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	structType := typ
+	if typ.Kind() == reflect.Pointer {
+		structType = typ.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		panic(fmt.Errorf("Bind: %s is not a struct or pointer to a struct", typ))
+	}
+	fields := bindFields(structType, nil)
+	return func(r *http.Request, p Params) (T, error) {
+		var val T
+		v := reflect.ValueOf(&val).Elem()
+		if typ.Kind() == reflect.Pointer {
+			v.Set(reflect.New(structType))
+			v = v.Elem()
+		}
+		form, err := decodeBody(r, v.Addr().Interface())
+		if err != nil {
+			return val, err
+		}
+		var invalid ValidationError
+		for _, f := range fields {
+			var vals []string
+			switch f.source {
+			case "form":
+				vals = form[f.name]
+			case "query":
+				vals = r.URL.Query()[f.name]
+			case "path":
+				if s, ok := p[f.name]; ok {
+					vals = []string{s}
+				}
+			}
+			if len(vals) == 0 {
+				continue
+			}
+			if msg := setBindField(v.FieldByIndex(f.index), vals); msg != "" {
+				invalid.Add(f.name, msg)
+			}
+		}
+		if err := invalid.Err(); err != nil {
+			return val, err
+		}
+		return val, validate(&val)
+	}
+}
+
+// bindField is a struct field that Bind sets from the named form, query, or
+// path parameter.
+type bindField struct {
+	index  []int
+	source string // "form", "query", or "path"
+	name   string
+}
+
+// bindSources are the struct tags of the fields that Bind sets, in order of
+// precedence.
+var bindSources = []string{"form", "query", "path"}
+
+// bindFields returns the fields of t that Bind sets, sorted by source.
+func bindFields(t reflect.Type, index []int) []bindField {
+	var fields []bindField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		idx := append(append([]int(nil), index...), i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, bindFields(sf.Type, idx)...)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		for _, source := range bindSources {
+			name, _, _ := strings.Cut(sf.Tag.Get(source), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			if !bindable(sf.Type) {
+				panic(fmt.Errorf("Bind: field %s of %s has unsupported type %s", sf.Name, t, sf.Type))
+			}
+			fields = append(fields, bindField{idx, source, name})
+		}
+	}
+	order := map[string]int{}
+	for i, source := range bindSources {
+		order[source] = i
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return order[fields[i].source] < order[fields[j].source]
+	})
+	return fields
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// bindable reports whether setBindField supports fields of type t.
+func bindable(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Pointer:
+		return bindable(t.Elem())
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Slice && bindable(t.Elem())
+	}
+	return false
+}
+
+// setBindField sets v to the parsed value of vals, or of its last entry unless
+// v is a slice. It returns a client message if a value is invalid.
+func setBindField(v reflect.Value, vals []string) string {
+	if v.Kind() == reflect.Slice && !reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(v.Type(), len(vals), len(vals))
+		for i, s := range vals {
+			if msg := setBindValue(slice.Index(i), s); msg != "" {
+				return msg
+			}
+		}
+		v.Set(slice)
+		return ""
+	}
+	return setBindValue(v, vals[len(vals)-1])
+}
+
+func setBindValue(v reflect.Value, s string) string {
+	if v.Kind() == reflect.Pointer {
+		elem := reflect.New(v.Type().Elem())
+		if msg := setBindValue(elem.Elem(), s); msg != "" {
+			return msg
+		}
+		v.Set(elem)
+		return ""
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return "is invalid"
+		}
+		return ""
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return "must be true or false"
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return "must be an integer"
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return "must be a non-negative integer"
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return "must be a number"
+		}
+		v.SetFloat(f)
+	}
+	return ""
+}
+
+// decodeBody decodes a JSON request body into dst, or parses a form request
+// body and returns its values. Requests without a body are ignored.
+func decodeBody(r *http.Request, dst any) (form map[string][]string, err error) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headerContentType))
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if err := decodeJSON(r.Body, dst, JSONOptions{}); err != io.EOF {
+			return nil, err
+		}
+		return nil, nil
+	case mediaType == "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, formError(err)
+		}
+		return r.PostForm, nil
+	case mediaType == "multipart/form-data":
+		if err := r.ParseMultipartForm(defaultMaxBodyMemory); err != nil {
+			return nil, formError(err)
+		}
+		return r.PostForm, nil
+	}
+	return nil, Error{
+		Code:      http.StatusUnsupportedMediaType,
+		ClientMsg: http.StatusText(http.StatusUnsupportedMediaType),
+		LogMsg:    fmt.Sprintf("Unsupported request Content-Type %q", mediaType),
+	}
+}
+
+// formError converts a failure to parse a form body into a 400 Error, unless
+// the body was too large.
+func formError(err error) error {
+	if isBodyTooLarge(err) {
+		return err
+	}
+	return Error{
+		Code:      http.StatusBadRequest,
+		ClientMsg: "Malformed form body",
+		LogMsg:    "Malformed form body",
+		Cause:     err,
+	}
+}
+
+// jsonTypeName describes the JSON type that is decoded into t.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a " + t.String()
+}
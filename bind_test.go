@@ -0,0 +1,126 @@
+package sandwich
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bindPage struct {
+	Page    int      `query:"page"`
+	PerPage *uint    `query:"per_page"`
+	Tags    []string `query:"tag"`
+}
+
+type bindUser struct {
+	bindPage
+	ID      int64     `path:"id"`
+	Name    string    `json:"name" form:"name"`
+	Admin   bool      `json:"admin" form:"admin"`
+	Score   float64   `json:"score" query:"score"`
+	Since   time.Time `json:"since" query:"since"`
+	private string    `query:"private"`
+}
+
+func TestBind(t *testing.T) {
+	var got *bindUser
+	mux := TheUsual()
+	mux.Use(NoLog)
+	mux.OnErr(HandleErrorJson)
+	mux.Post("/users/:id", Bind[*bindUser](), func(u *bindUser) { got = u })
+	mux.Get("/page", Bind[bindPage](), func(w http.ResponseWriter, p bindPage) {
+		w.Write([]byte(strings.Join(p.Tags, ",")))
+	})
+
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		got = nil
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("POST", "/users/42?page=3&per_page=20&tag=a&tag=b&score=1.5&private=x",
+		"application/json", `{"name":"Ann","admin":true,"score":0.5,"since":"2024-01-02T03:04:05Z"}`)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	require.NotNil(t, got)
+	perPage := uint(20)
+	assert.Equal(t, &bindUser{
+		bindPage: bindPage{Page: 3, PerPage: &perPage, Tags: []string{"a", "b"}},
+		ID:       42,
+		Name:     "Ann",
+		Admin:    true,
+		Score:    1.5, // the query takes precedence
+		Since:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, got)
+
+	w = serve("POST", "/users/7?since=2020-05-06T00:00:00Z", "application/x-www-form-urlencoded",
+		"name=Bob&admin=1&page=9")
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	require.NotNil(t, got)
+	assert.Equal(t, &bindUser{
+		ID:    7,
+		Name:  "Bob",
+		Admin: true,
+		Since: time.Date(2020, 5, 6, 0, 0, 0, 0, time.UTC),
+	}, got)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("name", "Cy")
+	mw.Close()
+	w = serve("POST", "/users/8", mw.FormDataContentType(), buf.String())
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	require.NotNil(t, got)
+	assert.Equal(t, "Cy", got.Name)
+
+	w = serve("GET", "/page?tag=x&tag=y", "", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "x,y", w.Body.String())
+
+	// Failures
+	w = serve("POST", "/users/abc?page=1.5&per_page=-1&since=yesterday", "", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error":"Unprocessable Entity","errors":{
+		"id":"must be an integer",
+		"page":"must be an integer",
+		"per_page":"must be a non-negative integer",
+		"since":"is invalid"
+	}}`, w.Body.String())
+	assert.Nil(t, got)
+
+	w = serve("POST", "/users/1", "application/json", `{"name":5}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error":"Unprocessable Entity","errors":{"name":"must be a string"}}`, w.Body.String())
+
+	w = serve("POST", "/users/1", "application/json", `{"name":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"Malformed JSON body"}`, w.Body.String())
+
+	w = serve("POST", "/users/1", "text/plain", "hi")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = serve("POST", "/users/1?tag=a", "application/json", strings.Repeat(" ", 100))
+	assert.Equal(t, 200, w.Code, w.Body.String())
+
+	mux.Post("/limited", MaxBodySize(10), Bind[bindUser](), func() {})
+	w = serve("POST", "/limited", "application/json", `{"name":"a long name"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	assert.Panics(t, func() { Bind[string]() })
+	assert.Panics(t, func() {
+		Bind[struct {
+			M map[string]string `query:"m"`
+		}]()
+	})
+}
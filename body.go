@@ -0,0 +1,282 @@
+package sandwich
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// BodyLimit is the maximum request body size, in bytes, that is provided by
+// MaxBodySize to subsequent handlers. It may also be used as one of the
+// AuditConfig.BodyLimitTypes.
+type BodyLimit int64
+
+// MaxBodySize returns a middleware handler that limits the size of the request
+// body to maxBytes. Requests that declare a larger Content-Length are rejected
+// with a 413 without reading the body. Otherwise the body is limited so that
+// reading beyond maxBytes fails with an error that ToError converts to a 413,
+// so the response goes through the router's error handlers.
+//
+// A MaxBodySize replaces the limit of an earlier MaxBodySize rather than
+// nesting within it, so a router-wide limit may be raised as well as lowered
+// for specific routes:
+//
+//	mux.Use(sandwich.MaxBodySize(1 << 20))
+//	mux.Post("/upload", sandwich.MaxBodySize(100<<20), requireUser, handleUpload)
+//
+// This requires that the body isn't wrapped by other middleware, such as
+// BufferBody, in between. See also Config.MaxBodySize.
+//
+// A request that's rejected because of its Content-Length is rejected before
+// a client that sent "Expect: 100-continue" uploads the body, see
+// HoldContinue.
+func MaxBodySize(maxBytes int64) func(w http.ResponseWriter, r *http.Request) (BodyLimit, error) {
+	return func(w http.ResponseWriter, r *http.Request) (BodyLimit, error) {
+		if r.ContentLength > maxBytes {
+			return 0, errBodyTooLarge(r.ContentLength, maxBytes)
+		}
+		body := r.Body
+		if lb, ok := body.(*limitedBody); ok {
+			body = lb.orig
+		}
+		r.Body = &limitedBody{http.MaxBytesReader(w, body, maxBytes), body}
+		return BodyLimit(maxBytes), nil
+	}
+}
+
+// limitedBody is a request body limited by MaxBodySize. It retains the
+// original body so that a later MaxBodySize may replace the limit.
+type limitedBody struct {
+	io.ReadCloser
+	orig io.ReadCloser
+}
+
+func errBodyTooLarge(size, maxBytes int64) Error {
+	return Error{
+		Code:      http.StatusRequestEntityTooLarge,
+		ClientMsg: http.StatusText(http.StatusRequestEntityTooLarge),
+		LogMsg:    fmt.Sprintf("Request body too large: %d bytes exceeds %d", size, maxBytes),
+	}
+}
+
+// BufferedBody is a copy of the request body that may be read any number of
+// times. It is provided by BufferBody so that middleware that needs the entire
+// body, such as signature verification, idempotency checks, or mirroring, can
+// share a single copy rather than each buffering the body independently.
+type BufferedBody struct {
+	size  int64
+	mem   []byte
+	spill SpillFile
+	temp  *TempFiles // allocates the default spill file
+}
+
+// Len returns the size of the body in bytes.
+func (b *BufferedBody) Len() int64 { return b.size }
+
+// NewReader returns a new reader positioned at the start of the body. Readers
+// are independent of each other.
+func (b *BufferedBody) NewReader() io.ReadSeeker {
+	if b.spill != nil {
+		return io.NewSectionReader(b.spill, 0, b.size)
+	}
+	return bytes.NewReader(b.mem)
+}
+
+func (b *BufferedBody) release() {
+	if b.spill != nil {
+		b.spill.Close()
+		b.spill = nil
+	}
+	if b.temp != nil {
+		b.temp.cleanup()
+		b.temp = nil
+	}
+	b.mem = nil
+}
+
+// SpillFile stores request bodies that are too large to buffer in memory. It
+// is closed when the request completes.
+type SpillFile interface {
+	io.Writer
+	io.ReaderAt
+	io.Closer
+}
+
+// BodyBufferOptions configures BufferBodyWith.
+type BodyBufferOptions struct {
+	// MaxMemory is the number of bytes that may be buffered in memory. Larger
+	// bodies are written to a SpillFile instead. If zero, 1 MB is used.
+	MaxMemory int64
+	// MaxSize is the maximum size of the body. Larger bodies are rejected with
+	// a 413. If zero, the size is not limited.
+	MaxSize int64
+	// NewSpillFile creates the storage for bodies larger than MaxMemory. If nil,
+	// temporary files are allocated with TempFiles and removed when the request
+	// completes.
+	NewSpillFile func() (SpillFile, error)
+}
+
+const defaultMaxBodyMemory = 1 << 20
+
+// BufferBody is a middleware Wrap that reads the entire request body and
+// provides it as a *BufferedBody to subsequent handlers, using the default
+// BodyBufferOptions. The request's Body is replaced with a reader of the
+// buffered copy, so handlers that read the request body directly continue to
+// work. For example:
+//
+//	router.Post("/webhook", sandwich.BufferBody, verifySignature, handleWebhook)
+//
+//	func verifySignature(r *http.Request, body *sandwich.BufferedBody) error {
+//	  mac := hmac.New(sha256.New, secret)
+//	  io.Copy(mac, body.NewReader())
+//	  ...
+//	}
+var BufferBody = BufferBodyWith(BodyBufferOptions{})
+
+// BufferBodyWith is like BufferBody but allows configuring the memory limit,
+// maximum body size, and storage for large bodies.
+func BufferBodyWith(opts BodyBufferOptions) Wrap {
+	return Wrap{
+		Before: func(r *http.Request) (*BufferedBody, error) { return opts.buffer(r) },
+		After:  (*BufferedBody).release,
+	}
+}
+
+func (opts BodyBufferOptions) buffer(r *http.Request) (*BufferedBody, error) {
+	maxMem := opts.MaxMemory
+	if maxMem <= 0 {
+		maxMem = defaultMaxBodyMemory
+	}
+	var body io.Reader = r.Body
+	if opts.MaxSize > 0 {
+		if r.ContentLength > opts.MaxSize {
+			return nil, errBodyTooLarge(r.ContentLength, opts.MaxSize)
+		}
+		body = io.LimitReader(body, opts.MaxSize+1)
+	}
+
+	b := &BufferedBody{}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, body, maxMem+1)
+	if err != nil && err != io.EOF {
+		return nil, readBodyError(err)
+	}
+	b.size, b.mem = n, buf.Bytes()
+	if n > maxMem {
+		newSpill := opts.NewSpillFile
+		if newSpill == nil {
+			b.temp = NewTempFiles()
+			newSpill = func() (SpillFile, error) {
+				f, err := b.temp.File("sandwich-body-*")
+				if err != nil {
+					return nil, err
+				}
+				return f, nil
+			}
+		}
+		if b.spill, err = newSpill(); err != nil {
+			b.release()
+			return nil, err
+		}
+		b.mem = nil
+		if _, err := b.spill.Write(buf.Bytes()); err != nil {
+			b.release()
+			return nil, err
+		}
+		n, err := io.Copy(b.spill, body)
+		if err != nil {
+			b.release()
+			return nil, readBodyError(err)
+		}
+		b.size += n
+	}
+	if opts.MaxSize > 0 && b.size > opts.MaxSize {
+		b.release()
+		return nil, errBodyTooLarge(b.size, opts.MaxSize)
+	}
+	r.Body = io.NopCloser(b.NewReader())
+	r.ContentLength = b.size
+	return b, nil
+}
+
+// readBodyError converts a failure to read the request body into a 400 Error,
+// unless the body was too large.
+func readBodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return Error{
+		Code:      http.StatusBadRequest,
+		ClientMsg: "Failed to read request body",
+		LogMsg:    "Failed to read request body",
+		Cause:     err,
+	}
+}
+
+// CaptureBodyOnFailure returns a middleware for debugging that records up to
+// maxBytes of the request body in the LogEntry if the request fails with an
+// error or a 5xx status, so that the payload that caused a failure can be
+// investigated without logging every body. The body is recorded as the
+// "request_body" field, and the "request_body_truncated" field is set if the
+// body was longer than maxBytes. For example:
+//
+//	mux.Use(sandwich.CaptureBodyOnFailure(4 << 10))
+//
+// Only the part of the body that was read by the handlers is captured, so the
+// body isn't consumed if the handlers don't need it. Request bodies may contain
+// sensitive data, so this shouldn't be enabled for routes that accept
+// credentials or personal information. It must be added after LogRequests, as
+// TheUsual does.
+func CaptureBodyOnFailure(maxBytes int) ChainMutation { return bodyCapture(maxBytes) }
+
+type bodyCapture int
+
+func (b bodyCapture) Apply(c chain.Func) chain.Func {
+	return c.Then(b.tee).Defer((*capturedBody).record)
+}
+
+func (b bodyCapture) tee(r *http.Request) *capturedBody {
+	cb := &capturedBody{limit: int(b)}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, cb), r.Body}
+	}
+	return cb
+}
+
+// capturedBody retains the first bytes written to it, up to limit.
+type capturedBody struct {
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (cb *capturedBody) Write(p []byte) (int, error) {
+	if room := cb.limit - cb.buf.Len(); len(p) > room {
+		cb.buf.Write(p[:room])
+		cb.truncated = true
+	} else {
+		cb.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (cb *capturedBody) record(e *LogEntry, w *ResponseWriter, err error) {
+	final := e.snapshot(w, err)
+	if final.Error == nil && final.StatusCode < 500 {
+		return
+	}
+	if cb.buf.Len() > 0 || cb.truncated {
+		e.Set("request_body", cb.buf.String())
+	}
+	if cb.truncated {
+		e.Set("request_body_truncated", true)
+	}
+}
@@ -0,0 +1,228 @@
+package sandwich
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxBodySizeProvidesLimit(t *testing.T) {
+	var got string
+	var gotLimit BodyLimit
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Post("/", MaxBodySize(10), func(req *http.Request, limit BodyLimit) error {
+		gotLimit = limit
+		data, err := io.ReadAll(req.Body)
+		got = string(data)
+		return err
+	})
+
+	serve := func(body string, contentLength int64) *httptest.ResponseRecorder {
+		got, gotLimit = "", 0
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("hello", 5)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", got)
+	assert.Equal(t, BodyLimit(10), gotLimit)
+
+	// Rejected based on the declared length, without calling the handler.
+	w = serve("way too long body", 17)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, BodyLimit(0), gotLimit)
+
+	// Unknown length, rejected while reading.
+	w = serve("way too long body", -1)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, BodyLimit(10), gotLimit)
+	assert.Equal(t, "way too lo", got)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	r, err := NewWithConfig(Config{Logger: func(LogEntry) {}, MaxBodySize: 10})
+	require.NoError(t, err)
+	echo := func(w http.ResponseWriter, req *http.Request) error {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	r.Post("/default", echo)
+	r.Post("/raised", MaxBodySize(20), echo)
+	r.Post("/lowered", MaxBodySize(3), echo)
+
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, "0123456789", serve("/default", "0123456789").Body.String())
+	assert.Equal(t, 413, serve("/default", "0123456789ab").Code)
+	assert.Equal(t, "0123456789ab", serve("/raised", "0123456789ab").Body.String())
+	assert.Equal(t, 413, serve("/raised", strings.Repeat("x", 21)).Code)
+	assert.Equal(t, "abc", serve("/lowered", "abc").Body.String())
+	assert.Equal(t, 413, serve("/lowered", "abcd").Code)
+}
+
+type testSpillFile struct {
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (f *testSpillFile) Write(p []byte) (int, error) { return f.buf.Write(p) }
+func (f *testSpillFile) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(f.buf.Bytes()).ReadAt(p, off)
+}
+func (f *testSpillFile) Close() error { f.closed = true; return nil }
+
+func TestBufferBody(t *testing.T) {
+	var spills []*testSpillFile
+	var reads []string
+	readBody := func(b *BufferedBody) {
+		data, _ := io.ReadAll(b.NewReader())
+		reads = append(reads, string(data))
+	}
+	r := TheUsual()
+	r.Use(NoLog, BufferBodyWith(BodyBufferOptions{
+		MaxMemory: 8,
+		MaxSize:   20,
+		NewSpillFile: func() (SpillFile, error) {
+			spills = append(spills, &testSpillFile{})
+			return spills[len(spills)-1], nil
+		},
+	}))
+	r.Post("/", readBody, readBody, func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.Copy(w, req.Body)
+	})
+
+	serve := func(body string) *httptest.ResponseRecorder {
+		reads = nil
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("small")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "small", w.Body.String())
+	assert.Equal(t, []string{"small", "small"}, reads)
+	assert.Empty(t, spills)
+
+	w = serve("larger than memory")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "larger than memory", w.Body.String())
+	assert.Equal(t, []string{"larger than memory", "larger than memory"}, reads)
+	if assert.Len(t, spills, 1) {
+		assert.True(t, spills[0].closed)
+	}
+
+	w = serve("this is much too large to accept")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Empty(t, reads)
+	if assert.Len(t, spills, 2) {
+		assert.True(t, spills[1].closed)
+	}
+}
+
+func TestBufferBodyTempFile(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader("0123456789"))
+	b, err := BodyBufferOptions{MaxMemory: 4}.buffer(req)
+	require.NoError(t, err)
+	require.NotNil(t, b.spill)
+	name := b.spill.(*os.File).Name()
+
+	data, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	assert.Equal(t, int64(10), b.Len())
+
+	b.release()
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBufferBodyReadErrors(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Post("/", MaxBodySize(10), BufferBody, func() {})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("way too long body"))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	req = httptest.NewRequest("POST", "/", iotest.ErrReader(errors.New("connection reset")))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "Failed to read request body\n", w.Body.String())
+}
+
+func TestCaptureBodyOnFailure(t *testing.T) {
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	var logged LogEntry
+	WriteLog = func(e LogEntry) { logged = e }
+
+	r := TheUsual()
+	r.Use(CaptureBodyOnFailure(8))
+	r.Post("/", func(w http.ResponseWriter, req *http.Request) error {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		switch string(data) {
+		case "ok":
+			return nil
+		case "a very long body":
+			w.WriteHeader(http.StatusBadGateway)
+			return nil
+		}
+		return Error{Code: http.StatusBadRequest, LogMsg: "bad payload"}
+	})
+	r.Post("/unread", func() error { return errors.New("oops") })
+
+	serve := func(path, body string) {
+		logged = LogEntry{}
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, strings.NewReader(body)))
+	}
+
+	serve("/", "ok")
+	assert.Nil(t, logged.Fields, "nothing is recorded for successful requests")
+
+	serve("/", "nope")
+	assert.Equal(t, map[string]any{"request_body": "nope"}, logged.Fields)
+
+	serve("/", "a very long body")
+	assert.Equal(t, map[string]any{
+		"request_body":           "a very l",
+		"request_body_truncated": true,
+	}, logged.Fields)
+
+	serve("/unread", "ignored")
+	assert.Nil(t, logged.Fields, "the body isn't read if the handlers don't read it")
+	assert.Error(t, logged.Error)
+}
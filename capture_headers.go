@@ -0,0 +1,81 @@
+package sandwich
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// HeaderCapture configures CaptureHeaders.
+type HeaderCapture struct {
+	// Request and Response are the names of the request and response headers
+	// that are recorded, e.g. "User-Agent" or "Content-Type".
+	Request, Response []string
+	// Redact are the names of additional headers whose values are replaced
+	// with "[REDACTED]". Authorization, Proxy-Authorization, Cookie, and
+	// Set-Cookie are always redacted.
+	Redact []string
+}
+
+// redactedHeaders are the headers that are always redacted by CaptureHeaders.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// CaptureHeaders returns a middleware that records the values of the
+// configured request and response headers in the LogEntry, so that they are
+// available for debugging without packet captures. They are recorded as the
+// "request_headers" and "response_headers" Fields, which map the canonical
+// header names to their values, joined with ", " if there are several. Headers
+// that aren't present are omitted. For example:
+//
+//	mux.Use(sandwich.CaptureHeaders(sandwich.HeaderCapture{
+//	    Request:  []string{"User-Agent", "X-Forwarded-Proto", "Authorization"},
+//	    Response: []string{"Content-Type", "Cache-Control"},
+//	}))
+//
+// It must be added after LogRequests, as TheUsual does.
+func CaptureHeaders(cfg HeaderCapture) ChainMutation {
+	redact := map[string]bool{}
+	for _, name := range append(redactedHeaders, cfg.Redact...) {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+	return headerCapture{cfg, redact}
+}
+
+type headerCapture struct {
+	cfg    HeaderCapture
+	redact map[string]bool
+}
+
+func (h headerCapture) Apply(c chain.Func) chain.Func {
+	if len(h.cfg.Request) > 0 {
+		c = c.Then(func(r *http.Request, e *LogEntry) {
+			h.record(e, "request_headers", r.Header, h.cfg.Request)
+		})
+	}
+	if len(h.cfg.Response) > 0 {
+		c = c.Defer(func(w *ResponseWriter, e *LogEntry) {
+			h.record(e, "response_headers", w.Header(), h.cfg.Response)
+		})
+	}
+	return c
+}
+
+func (h headerCapture) record(e *LogEntry, key string, header http.Header, names []string) {
+	captured := map[string]string{}
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		vals := header.Values(name)
+		if len(vals) == 0 {
+			continue
+		}
+		if h.redact[name] {
+			captured[name] = "[REDACTED]"
+		} else {
+			captured[name] = strings.Join(vals, ", ")
+		}
+	}
+	if len(captured) > 0 {
+		e.Set(key, captured)
+	}
+}
@@ -0,0 +1,50 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureHeaders(t *testing.T) {
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	var logged LogEntry
+	WriteLog = func(e LogEntry) { logged = e }
+
+	r := TheUsual()
+	r.Use(CaptureHeaders(HeaderCapture{
+		Request:  []string{"user-agent", "Authorization", "X-Api-Key", "Accept", "X-Missing"},
+		Response: []string{"Content-Type", "Set-Cookie", "X-Missing"},
+		Redact:   []string{"x-api-key"},
+	}))
+	r.Get("/", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Add("Set-Cookie", "session=secret")
+		w.Header().Set("X-Other", "ignored")
+		_, _ = w.Write([]byte("hi"))
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "curl")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "*/*")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, map[string]any{
+		"request_headers": map[string]string{
+			"User-Agent":    "curl",
+			"Authorization": "[REDACTED]",
+			"X-Api-Key":     "[REDACTED]",
+			"Accept":        "text/html, */*",
+		},
+		"response_headers": map[string]string{
+			"Content-Type": "text/plain",
+			"Set-Cookie":   "[REDACTED]",
+		},
+	}, logged.Fields)
+}
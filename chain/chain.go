@@ -69,14 +69,23 @@ package chain
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"text/tabwriter"
+	"time"
 )
 
 var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var panicErrorType = reflect.TypeOf(PanicError{})
+var cleanupType = reflect.TypeOf(func() {})
+var closerType = reflect.TypeOf((*io.Closer)(nil)).Elem()
 
 // DefaultErrorHandler is called when an error in the chain occurs and no error
 // handler has been registered. Warning! The default error handler is not
@@ -98,6 +107,34 @@ type step struct {
 	// For tRESERVE steps, this must be non-nil to declare the reserved type.
 	// For t*_HANDLER steps, this is the function type.
 	valTyp reflect.Type
+	// For handler steps created by a wrapper such as Once, this describes the
+	// wrapped handler. See wrappedHandler.
+	info *FuncInfo
+	// For handler steps, this may optionally be non-nil to call val without
+	// using reflect. See Compile.
+	fast FastCaller
+	// For tERROR_HANDLER steps, this is the type of error that is handled, or
+	// nil if all errors are handled. See OnErrType.
+	errTyp reflect.Type
+	// For tPOST_HANDLER and tERROR_MAPPER steps, this determines the execution
+	// order. See DeferPriority and OnErrMapPriority.
+	priority int
+	// For tPOST_HANDLER steps, whether args that haven't been provided are
+	// passed as zero values. See DeferLate.
+	late bool
+	// For handler steps, this may optionally be non-nil to accumulate the cost
+	// of calling the handler. See Profile.
+	prof *profEntry
+	// For handler steps, this may optionally be non-nil to report each call of
+	// the handler. See Instrument.
+	inst *instrumentation
+	// For tLABEL steps, this is the name of the label.
+	label string
+	// For handler steps of a frozen chain, these are the arg types of the
+	// handler and the valueStore slots of its args and results. See Freeze.
+	in       []reflect.Type
+	inSlots  []int
+	outSlots []int
 }
 
 type stepType uint8
@@ -108,6 +145,11 @@ const (
 	tPRE_HANDLER  // PRE handlers are the normal handlers
 	tPOST_HANDLER // POST handlers are deferred handlers
 	tERROR_HANDLER
+	tLAZY_PROVIDER // LAZY providers are only called if their values are used
+	tLABEL         // LABELs mark the targets of SkipTo
+	tPANIC_HANDLER // PANIC handlers are called when handlers panic
+	tACCUMULATE    // ACCUMULATE steps provide all values of valTyp as a slice
+	tERROR_MAPPER  // ERROR MAPPERs transform errors before the error handler
 )
 
 // Clone this chain and add the extra steps to the clone.
@@ -127,7 +169,7 @@ func (c Func) Arg(typeOrInterfacePtr interface{}) Func {
 	if typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Interface {
 		typ = typ.Elem()
 	}
-	return c.with(step{tARG, reflect.Value{}, typ})
+	return c.with(step{typ: tARG, valTyp: typ})
 }
 
 // Set an immediate value. This cannot be used to provide an interface, instead
@@ -137,7 +179,7 @@ func (c Func) Set(value interface{}) Func {
 		panicf("Set(nil) is not allowed -- " +
 			"did you mean to use SetAs(val, (*IFace)(nil))?")
 	}
-	return c.with(step{tVALUE, reflect.ValueOf(value), reflect.TypeOf(value)})
+	return c.with(step{typ: tVALUE, val: reflect.ValueOf(value), valTyp: reflect.TypeOf(value)})
 }
 
 // SetAs provides an immediate value as the specified interface type.
@@ -157,7 +199,31 @@ func (c Func) SetAs(value, ifacePtr interface{}) Func {
 	if !val.Type().Implements(typ) {
 		panicf("%s doesn't implement %s", val.Type(), typ)
 	}
-	return c.with(step{tVALUE, val, typ})
+	return c.with(step{typ: tVALUE, val: val, valTyp: typ})
+}
+
+// Values returns the values provided by Set and SetAs, in the order that they
+// were registered.
+func (c Func) Values() []interface{} {
+	var vals []interface{}
+	for _, s := range c.steps {
+		if s.typ == tVALUE {
+			vals = append(vals, s.val.Interface())
+		}
+	}
+	return vals
+}
+
+// ValueTypes returns the types of the values provided by Set and SetAs, in the
+// order that they were registered. For SetAs, this is the interface type.
+func (c Func) ValueTypes() []reflect.Type {
+	var types []reflect.Type
+	for _, s := range c.steps {
+		if s.typ == tVALUE {
+			types = append(types, s.valTyp)
+		}
+	}
+	return types
 }
 
 // Compute what types are available from the reserved values, provide values,
@@ -176,52 +242,317 @@ func (c Func) typesAvailable() map[reflect.Type]bool {
 			for i := 0; i < s.valTyp.NumOut(); i++ {
 				m[s.valTyp.Out(i)] = true
 			}
-		case tPOST_HANDLER, tERROR_HANDLER:
-			// ignored, we don't allow any return values for these.
+		case tPOST_HANDLER, tERROR_HANDLER, tERROR_MAPPER:
+			// ignored, we don't allow any return values other than errors.
+		case tLAZY_PROVIDER:
+			// ignored, lazy values are only available to normal handlers.
+		case tLABEL:
+			// ignored, labels don't provide anything.
+		case tPANIC_HANDLER:
+			// ignored, panic handlers don't provide anything.
+		case tACCUMULATE:
+			m[reflect.SliceOf(s.valTyp)] = true
+		}
+	}
+	return m
+}
+
+// Compute the types available to normal handlers: this includes the values
+// returned by lazy providers in addition to typesAvailable.
+func (c Func) preHandlerTypesAvailable() map[reflect.Type]bool {
+	m := c.typesAvailable()
+	for _, s := range c.steps {
+		if s.typ == tLAZY_PROVIDER {
+			for i := 0; i < s.valTyp.NumOut(); i++ {
+				m[s.valTyp.Out(i)] = true
+			}
 		}
 	}
 	return m
 }
 
+// Provides reports whether a value of the specified type is available to
+// handlers added to the end of the chain, either because it is an Arg, has been
+// Set, or is returned by a handler or lazy provider. As with Arg, interface
+// types are specified using a pointer to the interface, e.g. (*error)(nil).
+func (c Func) Provides(typeOrInterfacePtr interface{}) bool {
+	typ := reflect.TypeOf(typeOrInterfacePtr)
+	if typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Interface {
+		typ = typ.Elem()
+	}
+	return c.preHandlerTypesAvailable()[typ]
+}
+
 // Then adds one or more handlers to the middleware chain. It may only accept
 // args of types that have already been provided.
+//
+// A handler may also accept a struct that hasn't been provided itself, in which
+// case a new struct value is created with each exported field populated from
+// the provided value of that field's type. This is convenient for handlers
+// that need many dependencies:
+//
+//	func Handle(deps struct {
+//	  DB   *sql.DB
+//	  Log  *LogEntry
+//	  User User
+//	}) error { ... }
+//
+// Unexported fields are left as zero values.
+//
+// A variadic handler receives all of the values of the variadic type that have
+// been provided so far, in order, or none. This allows accumulating values from
+// several handlers:
+//
+//	c = c.Then(
+//	  func() Note { return "a" },
+//	  func() Note { return "b" },
+//	  func(w http.ResponseWriter, notes ...Note) { ... }, // notes is [a b]
+//	)
+//
+// If a handler returns a func() or an io.Closer, it's automatically deferred
+// as a cleanup, as if it had been registered with Defer just after the
+// handler. The cleanup is deferred even if the handler returns an error,
+// unless the cleanup is nil. Errors from Close are ignored. This is convenient
+// for per-request resources:
+//
+//	func BeginTx(db *sql.DB) (*sql.Tx, func(), error) {
+//	  tx, err := db.Begin()
+//	  if err != nil {
+//	    return nil, nil, err
+//	  }
+//	  return tx, func() { tx.Rollback() }, nil // no-op if committed
+//	}
 func (c Func) Then(handlers ...interface{}) Func {
+	c, err := c.ThenE(handlers...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// ThenE is like Then, but returns an error instead of panicking if a handler
+// can't be added. If a handler requires a type that hasn't been provided, the
+// error is a *BuildError. The original chain is returned in that case.
+func (c Func) ThenE(handlers ...interface{}) (Func, error) {
 	steps := make([]step, len(handlers))
-	available := c.typesAvailable()
+	available := c.preHandlerTypesAvailable()
 	for i, handler := range handlers {
+		context := fmt.Sprintf("%s arg of With(...)", ordinalize(i+1))
 		fn, err := valueOfFunction(handler)
 		if err != nil {
-			panicf("%s arg of With(...) %v", ordinalize(i+1), err)
+			return c, withContext(err, context)
 		}
 		if err := checkCanCall(available, fn); err != nil {
-			panicf("%s arg of With(...) %v", ordinalize(i+1), err)
+			return c, withContext(err, context)
 		}
 		fnType := fn.Func.Type()
-		steps[i] = step{tPRE_HANDLER, fn.Func, fnType}
+		steps[i] = step{typ: tPRE_HANDLER, val: fn.Func, valTyp: fnType, info: wrappedInfo(handler)}
 		for i := 0; i < fnType.NumOut(); i++ {
 			available[fnType.Out(i)] = true
 		}
 	}
-	return c.with(steps...)
+	return c.with(steps...), nil
+}
+
+// Lazily adds a provider whose function is only called if a subsequent normal
+// handler actually consumes one of its return values. The provider is called at
+// most once per Run, just before the first handler that needs it. If it returns
+// a non-nil error, the chain is aborted as if that handler had failed.
+//
+// This is useful for expensive lookups that only some handlers need. Since it's
+// not known whether a lazy provider will run, the values it provides are only
+// available to normal handlers (and other lazy providers), not to error
+// handlers or deferred handlers.
+func (c Func) Lazily(provider interface{}) Func {
+	fn, err := valueOfFunction(provider)
+	if err != nil {
+		panicf("Lazily(...) arg %v", err)
+	}
+	if err := checkCanCall(c.preHandlerTypesAvailable(), fn); err != nil {
+		panic(withContext(err, "Lazily(...) arg"))
+	}
+	fnType := fn.Func.Type()
+	provides := 0
+	for i := 0; i < fnType.NumOut(); i++ {
+		if fnType.Out(i) != errorType {
+			provides++
+		}
+	}
+	if provides == 0 {
+		panicf("Lazy provider %s must return at least one value, signature is %s",
+			fn.Name, fnType)
+	}
+	return c.with(step{typ: tLAZY_PROVIDER, val: fn.Func, valTyp: fnType, info: wrappedInfo(provider)})
 }
 
 // OnErr registers an error handler to be called for failures of subsequent
 // handlers. It may only accept args of types that have already been provided.
+//
+// The error handler may optionally return an error. If it returns nil, the
+// error is considered handled and the chain resumes with the handler following
+// the one that failed. This is useful for falling back to defaults or alternate
+// data sources. If it returns a non-nil error, that error replaces the
+// original error and the chain is aborted as usual. If it returns NotHandled,
+// the error is passed to the previously registered error handler instead.
 func (c Func) OnErr(errorHandler interface{}) Func {
+	c, err := c.OnErrE(errorHandler)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// OnErrE is like OnErr, but returns an error instead of panicking if the error
+// handler can't be added. If the error handler requires a type that hasn't
+// been provided, the error is a *BuildError. The original chain is returned in
+// that case.
+func (c Func) OnErrE(errorHandler interface{}) (Func, error) {
 	fn, err := valueOfFunction(errorHandler)
 	if err != nil {
-		panicf("Error handler %v", err)
+		return c, withContext(err, "Error handler")
+	}
+	available := c.typesAvailable()
+	available[errorType] = true // Set internally by chain.
+	if err := checkCanCall(available, fn); err != nil {
+		return c, withContext(err, "Error handler")
+	}
+	if err := checkErrorHandlerReturns(fn); err != nil {
+		return c, err
+	}
+	return c.with(step{typ: tERROR_HANDLER, val: fn.Func, valTyp: fn.Func.Type(), info: wrappedInfo(errorHandler)}), nil
+}
+
+// OnPanic registers a panic handler to be called when any subsequent handler
+// panics, instead of only converting the panic to a PanicError for the error
+// handlers. Like an error handler, it may accept any types that have already
+// been provided as well as the PanicError and error types. This allows
+// emitting crash reports with request details, re-panicking, or converting the
+// panic to another error.
+//
+// The panic handler may optionally return an error, which replaces the
+// PanicError and is passed to the error handlers as usual. If it returns nil,
+// the panic is considered handled and the chain resumes with the handler
+// following the one that panicked. Otherwise, the PanicError is passed to the
+// error handlers.
+//
+// Passing nil disables panic recovery for subsequent handlers: panics
+// propagate out of Run with their original stack, e.g. to reach
+// crash-handling infrastructure. The deferred handlers are not run in that
+// case.
+func (c Func) OnPanic(panicHandler interface{}) Func {
+	if panicHandler == nil {
+		return c.with(step{typ: tPANIC_HANDLER})
+	}
+	fn, err := valueOfFunction(panicHandler)
+	if err != nil {
+		panicf("Panic handler %v", err)
 	}
 	available := c.typesAvailable()
 	available[errorType] = true // Set internally by chain.
+	available[panicErrorType] = true
 	if err := checkCanCall(available, fn); err != nil {
+		panic(withContext(err, "Panic handler"))
+	}
+	if err := checkErrorHandlerReturns(fn); err != nil {
+		panic(err)
+	}
+	return c.with(step{typ: tPANIC_HANDLER, val: fn.Func, valTyp: fn.Func.Type(), info: wrappedInfo(panicHandler)})
+}
+
+// OnErrType registers an error handler to be called for failures of subsequent
+// handlers only if the error matches the specified type, as determined by
+// errors.As. Otherwise, the error is handled by the most recently registered
+// error handler that does match. As with Arg, the error type is specified
+// using a value of the type or, for interfaces, a pointer to the interface.
+//
+// In addition to the types accepted by OnErr handlers, the error handler may
+// accept the matched error type. For example:
+//
+//	c = c.OnErr(handleAllErrors).
+//	  OnErrType(&ValidationError{}, func(w http.ResponseWriter, e *ValidationError) {...})
+func (c Func) OnErrType(typeOrInterfacePtr interface{}, errorHandler interface{}) Func {
+	errTyp := reflect.TypeOf(typeOrInterfacePtr)
+	if errTyp == nil {
+		panicf("OnErrType(nil, ...) is not allowed -- " +
+			"use a pointer to the interface for interface types")
+	}
+	if errTyp.Kind() == reflect.Ptr && errTyp.Elem().Kind() == reflect.Interface {
+		errTyp = errTyp.Elem()
+	}
+	if errTyp.Kind() != reflect.Interface && !errTyp.Implements(errorType) {
+		panicf("OnErrType(...) type %s does not implement error", errTyp)
+	}
+	fn, err := valueOfFunction(errorHandler)
+	if err != nil {
 		panicf("Error handler %v", err)
 	}
-	if fn.Func.Type().NumOut() > 0 {
-		panicf("Error handler %s may not have any return values, signature is %s",
-			fn.Name, fn.Func.Type())
+	available := c.typesAvailable()
+	available[errorType] = true // Set internally by chain.
+	available[errTyp] = true
+	if err := checkCanCall(available, fn); err != nil {
+		panic(withContext(err, "Error handler"))
+	}
+	if err := checkErrorHandlerReturns(fn); err != nil {
+		panic(err)
 	}
-	return c.with(step{tERROR_HANDLER, fn.Func, fn.Func.Type()})
+	return c.with(step{typ: tERROR_HANDLER, val: fn.Func, valTyp: fn.Func.Type(), errTyp: errTyp, info: wrappedInfo(errorHandler)})
+}
+
+// OnErrMap registers an error mapper that transforms the errors of subsequent
+// handlers before they're passed to the error handler. This allows wrapping,
+// classifying, or annotating errors in one place rather than in each error
+// handler, e.g. mapping sql.ErrNoRows to a not-found error:
+//
+//	c = c.OnErrMap(func(err error) error {
+//	  if errors.Is(err, sql.ErrNoRows) {
+//	    return NotFoundError{err}
+//	  }
+//	  return err
+//	})
+//
+// Like an error handler, the mapper may accept any types that have already
+// been provided as well as the error, and must return an error. If several
+// mappers apply, they're called in the order that they were registered, each
+// receiving the result of the previous one, unless reordered with
+// OnErrMapPriority. The error handler is then chosen
+// based on the final error. A mapper can't resolve an error: if it returns
+// nil, the error is left unchanged.
+func (c Func) OnErrMap(mapper interface{}) Func {
+	fn, err := valueOfFunction(mapper)
+	if err != nil {
+		panicf("Error mapper %v", err)
+	}
+	available := c.typesAvailable()
+	available[errorType] = true // Set internally by chain.
+	if err := checkCanCall(available, fn); err != nil {
+		panic(withContext(err, "Error mapper"))
+	}
+	if fnType := fn.Func.Type(); fnType.NumOut() != 1 || fnType.Out(0) != errorType {
+		panicf("Error mapper %s must return an error, signature is %s", fn.Name, fnType)
+	}
+	return c.with(step{typ: tERROR_MAPPER, val: fn.Func, valTyp: fn.Func.Type(), info: wrappedInfo(mapper)})
+}
+
+// OnErrMapPriority is like OnErrMap, but allows controlling the order that
+// error mappers are called regardless of the order that they are registered.
+// Mappers with a higher priority are called first. Mappers with equal
+// priorities are called in the order that they were registered, as with
+// OnErrMap, which uses a priority of 0. For example, a mapper that reports
+// errors might use a negative priority to see the errors as they're finally
+// mapped.
+func (c Func) OnErrMapPriority(priority int, mapper interface{}) Func {
+	c = c.OnErrMap(mapper)
+	c.steps[len(c.steps)-1].priority = priority
+	return c
+}
+
+func checkErrorHandlerReturns(fn FuncInfo) error {
+	if fnType := fn.Func.Type(); fnType.NumOut() > 1 ||
+		(fnType.NumOut() == 1 && fnType.Out(0) != errorType) {
+		return fmt.Errorf("Error handler %s may only return an error, signature is %s",
+			fn.Name, fnType)
+	}
+	return nil
 }
 
 // Defer adds a deferred handler to be executed after all normal handlers and
@@ -237,15 +568,177 @@ func (c Func) Defer(handler interface{}) Func {
 	available := c.typesAvailable()
 	available[errorType] = true // Set internally by chain.
 	if err := checkCanCall(available, fn); err != nil {
-		panicf("Defer(...) arg %v", err)
+		panic(withContext(err, "Defer(...) arg"))
+	}
+	if fn.Func.Type().NumOut() > 0 {
+		panicf("Defer'd handler %s may not have any return values, signature is %s",
+			fn.Name, fn.Func.Type())
+	}
+	return c.with(step{typ: tPOST_HANDLER, val: fn.Func, valTyp: fn.Func.Type(), info: wrappedInfo(handler)})
+}
+
+// DeferLate is like Defer, but the handler may also accept types that haven't
+// been provided when it's registered, such as a *User that's parsed by a later
+// handler. If a type still hasn't been provided when the deferred handlers are
+// executed, e.g. because a handler failed before it was provided, the zero
+// value is passed instead. This is useful for deferred handlers that are
+// registered early but report on the whole request, such as committing
+// metrics:
+//
+//	c = c.DeferLate(func(m *Metrics, u *User, err error) { m.Record(u, err) }).
+//	  Then(ParseUser)
+//
+// Note that Code passes the zero values for types that aren't provided by the
+// chain at all.
+func (c Func) DeferLate(handler interface{}) Func {
+	fn, err := valueOfFunction(handler)
+	if err != nil {
+		panicf("DeferLate(...) arg %v", err)
 	}
 	if fn.Func.Type().NumOut() > 0 {
 		panicf("Defer'd handler %s may not have any return values, signature is %s",
 			fn.Name, fn.Func.Type())
 	}
-	return c.with(step{tPOST_HANDLER, fn.Func, fn.Func.Type()})
+	return c.with(step{typ: tPOST_HANDLER, val: fn.Func, valTyp: fn.Func.Type(), late: true, info: wrappedInfo(handler)})
 }
 
+// DeferPriority is like Defer, but allows controlling the order that deferred
+// handlers are executed regardless of the order that they are registered.
+// Deferred handlers with a higher priority are executed first. Handlers with
+// equal priorities are executed in reverse order that they were registered, as
+// with Defer, which uses a priority of 0. For example, a handler that commits a
+// log entry might use a negative priority to ensure that it runs after any
+// deferred handlers that flush the response.
+func (c Func) DeferPriority(priority int, handler interface{}) Func {
+	c = c.Defer(handler)
+	c.steps[len(c.steps)-1].priority = priority
+	return c
+}
+
+// Append adds all of the steps of the other chain to the end of this chain,
+// re-validating that each handler's args can be provided. This allows building
+// reusable bundles of middleware independently and combining them later.
+//
+// Args declared by the other chain are not added: instead, they must already be
+// provided by this chain. For example:
+//
+//	auth := chain.Func{}.Arg((*http.Request)(nil)).Then(ParseUser, RequireLogin)
+//	c = c.Append(auth)
+//
+// will verify that c already provides *http.Request before appending
+// ParseUser and RequireLogin.
+func (c Func) Append(other Func) Func {
+	for _, s := range other.steps {
+		switch s.typ {
+		case tARG:
+			if !c.typesAvailable()[s.valTyp] {
+				panicf("Append(...): arg of type %s required by the appended "+
+					"chain has not been provided", s.valTyp)
+			}
+		case tVALUE:
+			c = c.with(s)
+		case tPRE_HANDLER:
+			c = c.Then(s.handler())
+		case tPOST_HANDLER:
+			if s.late {
+				c = c.DeferLate(s.handler())
+				c.steps[len(c.steps)-1].priority = s.priority
+			} else {
+				c = c.DeferPriority(s.priority, s.handler())
+			}
+		case tERROR_HANDLER:
+			if s.errTyp == nil {
+				c = c.OnErr(s.handler())
+			} else if s.errTyp.Kind() == reflect.Interface {
+				c = c.OnErrType(reflect.New(s.errTyp).Interface(), s.handler())
+			} else {
+				c = c.OnErrType(reflect.Zero(s.errTyp).Interface(), s.handler())
+			}
+		case tLAZY_PROVIDER:
+			c = c.Lazily(s.handler())
+		case tLABEL:
+			c = c.Label(s.label)
+		case tPANIC_HANDLER:
+			if s.val.IsValid() {
+				c = c.OnPanic(s.handler())
+			} else {
+				c = c.OnPanic(nil)
+			}
+		case tACCUMULATE:
+			c = c.accumulate(s.valTyp)
+		case tERROR_MAPPER:
+			c = c.OnErrMapPriority(s.priority, s.handler())
+		}
+	}
+	return c
+}
+
+// Accumulate makes all of the values of the specified type that are provided
+// during the chain available to subsequent handlers as a slice, in the order
+// that they were provided. Normally, when several handlers provide the same
+// type, later handlers only receive the most recent value. For example:
+//
+//	c = c.Accumulate(Warning("")).Then(
+//	  func() Warning { return "a" },
+//	  func() Warning { return "b" },
+//	  func(w http.ResponseWriter, warnings []Warning) { ... }, // warnings is [a b]
+//	)
+//
+// Values provided before Accumulate, including args, are also included. As
+// with Arg, interface types are specified using a pointer to the interface,
+// e.g. (*error)(nil). If a slice of the type is also provided directly, that
+// slice is used instead.
+func (c Func) Accumulate(typeOrInterfacePtr interface{}) Func {
+	typ := reflect.TypeOf(typeOrInterfacePtr)
+	if typ == nil {
+		panicf("Accumulate(nil) is not allowed -- " +
+			"did you mean to use Accumulate((*IFace)(nil))?")
+	}
+	if typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Interface {
+		typ = typ.Elem()
+	}
+	return c.accumulate(typ)
+}
+
+func (c Func) accumulate(typ reflect.Type) Func {
+	return c.with(step{typ: tACCUMULATE, valTyp: typ})
+}
+
+// Label marks a point in the chain that a handler may skip to by returning
+// SkipTo(name). Label names must be unique within a chain.
+func (c Func) Label(name string) Func {
+	for _, s := range c.steps {
+		if s.typ == tLABEL && s.label == name {
+			panicf("Label %q is already used in this chain", name)
+		}
+	}
+	return c.with(step{typ: tLABEL, label: name})
+}
+
+// skipTo is the error returned by SkipTo.
+type skipTo string
+
+func (s skipTo) Error() string { return fmt.Sprintf("skip to %q", string(s)) }
+
+// SkipTo returns a sentinel error that, when returned by a handler, skips all
+// subsequent handlers until the specified Label, and then resumes normally.
+// This is useful to short-circuit part of a chain, such as rendering a cached
+// response. Deferred handlers registered in the skipped section are not run,
+// but all other deferred handlers are.
+//
+// Handlers after the label may accept types that would have been provided by
+// the skipped handlers, in which case they receive the zero value of the type
+// unless it was provided earlier. If the label does not follow the handler in
+// the chain, an error is triggered instead.
+//
+// For example:
+//
+//	c = c.Then(loadFromCache).      // returns SkipTo("render") on a cache hit
+//	  Then(loadFromDB, computeStats).
+//	  Label("render").
+//	  Then(render)
+func SkipTo(label string) error { return skipTo(label) }
+
 // MustRun will function chain with the provided args and panic if the args
 // don't match the expected arg values.
 func (c Func) MustRun(argValues ...interface{}) {
@@ -262,98 +755,463 @@ func (c Func) MustRun(argValues ...interface{}) {
 // arguments do not exactly correspond to the declared args. Interface values
 // must be passed as pointers to the interface.
 //
+// If an arg is a context.Context or has a Context method, such as an
+// *http.Request, the chain stops before calling the next handler once that
+// context is done. If a handler provides a new value of the arg's type, such
+// as a request with a deadline from r.WithContext, its context is used
+// instead. In that case, the error handlers are skipped and the
+// deferred handlers receive an error wrapping the context's error, e.g.
+// context.Canceled.
+//
 // Important note: The returned error is NOT related to whether any the calls of
 // chain returns an error -- any errors returned by functions in the chain are
 // handled by the registered error handlers.
 func (c Func) Run(argValues ...interface{}) error {
-	data := map[reflect.Type]reflect.Value{}
-	postSteps := []step{} // collect post steps here
-	errHandler := step{   // Initialize using the default error handler.
-		tERROR_HANDLER,
-		reflect.ValueOf(DefaultErrorHandler),
-		reflect.TypeOf(DefaultErrorHandler),
+	return c.RunWith(nil, argValues...)
+}
+
+// RunWith is like Run, but substitutes the specified values for a single
+// execution. This is useful for tests or to direct specific requests to
+// alternate backends.
+//
+// Each key of overrides identifies a type the same way as Arg: either a value
+// of the type or, for interfaces, a pointer to the interface. Whenever a value
+// of that type is provided by Set, SetAs, or the return value of a handler, the
+// override value is used instead. Note that the handlers are still called.
+//
+//	c.RunWith(map[interface{}]interface{}{
+//	  (*UserDB)(nil): fakeUserDB,
+//	}, w, r)
+func (c Func) RunWith(overrides map[interface{}]interface{}, argValues ...interface{}) error {
+	return c.run(runPlan{args: c.argTypes(), accum: c.accumTypes()}, overrides, argValues)
+}
+
+// runPlan holds the information needed to start running a chain, which may be
+// precomputed by Freeze.
+type runPlan struct {
+	args   []reflect.Type       // types of the Run args, in order
+	accum  []reflect.Type       // see accumTypes
+	slotOf map[reflect.Type]int // see valueStore; only for frozen chains
+}
+
+// argTypes returns the types of the args of the chain, in order.
+func (c Func) argTypes() []reflect.Type {
+	var types []reflect.Type
+	for _, s := range c.steps {
+		if s.typ == tARG {
+			types = append(types, s.valTyp)
+		}
+	}
+	return types
+}
+
+func (c Func) run(p runPlan, overrides map[interface{}]interface{}, argValues []interface{}) error {
+	st := runStatePool.Get().(*runState)
+	if err := st.setOverrides(overrides); err != nil {
+		st.release()
+		return err
 	}
-	stack := []step{}
 
 	// 1: Apply all of the arguments to the available data. Make sure that the
 	// provided arguments match the Arg calls, otherwise we bomb.
-	if err := c.processRunArgs(data, argValues...); err != nil {
+	st.data.useSlots(p.slotOf)
+	if err := processRunArgs(&st.data, p.args, argValues...); err != nil {
+		st.release()
 		return err
 	}
+	st.ctx, st.ctxType = contextOf(p.args, argValues)
+	st.prepareAccum(p.accum)
 
 	// Start executing the function chain. First pass through is the normal call
 	// chain, so we skip execution of error handlers and deferred handlers,
 	// although we keep track of them.
 execution:
-	for _, step := range c.steps {
+	for i, step := range c.steps {
 		switch step.typ {
 		case tARG:
 			// ignored now, already handled during initialization above.
 		case tVALUE:
-			data[step.val.Type()] = step.val
-			data[step.valTyp] = step.val
+			st.set(step.val.Type(), step.val)
+			if step.valTyp != step.val.Type() {
+				st.set(step.valTyp, step.val)
+			}
 		case tPRE_HANDLER:
-			c.call(step, data, &stack)
-			// Check to see if there's an error. If so, abort the chain.
-			if errorVal := data[errorType]; errorVal.IsValid() && !errorVal.IsNil() {
+			if st.skipTo != "" {
+				st.provideZeros(step)
+				continue
+			}
+			// Stop if the context has been canceled, e.g. because the client
+			// disconnected. Only the deferred handlers are called.
+			st.updateContext()
+			if st.ctx != nil && st.ctx.Err() != nil {
+				st.abort(step)
+				break execution
+			}
+			// If there's an error, call the error handler and abort the chain
+			// unless the error handler has resolved the error.
+			for !c.resolveLazy(step, st) {
+				if !c.handleErr(st) {
+					break execution
+				}
+			}
+			c.call(step, st)
+			if st.failed() && !c.skip(i, st) && !c.handleErr(st) {
 				break execution
 			}
 		case tPOST_HANDLER:
-			postSteps = append(postSteps, step)
-		case tERROR_HANDLER:
-			errHandler = step
+			if st.skipTo == "" {
+				st.post = append(st.post, step)
+			}
+		case tERROR_HANDLER, tERROR_MAPPER:
+			st.errHandlers = append(st.errHandlers, step)
+		case tLAZY_PROVIDER:
+			for i := 0; i < step.valTyp.NumOut(); i++ {
+				if t := step.valTyp.Out(i); t != errorType {
+					st.lazy[t] = step
+				}
+			}
+		case tLABEL:
+			if step.label == st.skipTo {
+				st.skipTo = ""
+			}
+		case tPANIC_HANDLER:
+			st.panicHandler = &c.steps[i]
+		case tACCUMULATE:
+			// ignored now, already handled during initialization above.
 		}
 	}
 
-	// Execute the error handler if there is any error.
-	if errorVal := data[errorType]; errorVal.IsValid() && !errorVal.IsNil() {
-		c.call(errHandler, data, &stack)
-	} else {
-		data[errorType] = reflect.Zero(errorType)
+	if !st.failed() {
+		st.data.put(errorType, reflect.Zero(errorType))
 	}
 
 	// Finally, call any deferred functions that we've gotten to.
-	for i := len(postSteps) - 1; i >= 0; i-- {
-		c.call(postSteps[i], data, &stack)
+	orderDeferred(st.post)
+	for _, s := range st.post {
+		c.call(s, st)
+	}
+
+	st.release()
+	return nil
+}
+
+// orderDeferred sorts deferred steps into execution order: by descending
+// priority, and in reverse registration order for equal priorities.
+func orderDeferred(steps []step) {
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	// Insertion sort: stable, doesn't allocate, and there are few steps.
+	for i := 1; i < len(steps); i++ {
+		for j := i; j > 0 && steps[j].priority > steps[j-1].priority; j-- {
+			steps[j], steps[j-1] = steps[j-1], steps[j]
+		}
+	}
+}
+
+// runState is the state of a single execution of a chain. They are pooled to
+// avoid allocating a new state for each run.
+type runState struct {
+	data         valueStore                       // the most recent value of each type
+	lazy         map[reflect.Type]step            // lazy providers that haven't run yet
+	overrides    map[reflect.Type]reflect.Value   // see RunWith
+	stack        []step                           // the steps that have been called
+	post         []step                           // the deferred steps reached so far
+	errHandlers  []step                           // the error handlers and mappers reached so far
+	skipTo       string                           // the label being skipped to, if any
+	ctx          context.Context                  // the context of the run, if any
+	ctxType      reflect.Type                     // the type of the value that provides ctx
+	panicHandler *step                            // the most recent panic handler, if any
+	accum        map[reflect.Type][]reflect.Value // all values of variadic or accumulated types
+	args         []reflect.Value                  // scratch space for call args
+}
+
+var runStatePool = sync.Pool{
+	New: func() interface{} {
+		return &runState{
+			data: valueStore{m: map[reflect.Type]reflect.Value{}},
+			lazy: map[reflect.Type]step{},
+		}
+	},
+}
+
+// release clears st and returns it to the pool. st must not be used
+// afterwards.
+func (st *runState) release() {
+	st.data.reset()
+	for t := range st.lazy {
+		delete(st.lazy, t)
+	}
+	st.overrides = nil
+	st.stack = st.stack[:0]
+	st.post = st.post[:0]
+	st.errHandlers = st.errHandlers[:0]
+	st.skipTo = ""
+	st.ctx, st.ctxType = nil, nil
+	st.panicHandler = nil
+	for t := range st.accum {
+		delete(st.accum, t)
+	}
+	runStatePool.Put(st)
+}
+
+// valueStore holds the most recent value of each type during a run. The types
+// of a frozen chain are assigned slots in advance, so that the args and results
+// of its handlers are accessed by index rather than looked up by type. Other
+// types, and all types of chains that aren't frozen, are stored in a map.
+type valueStore struct {
+	m      map[reflect.Type]reflect.Value
+	slots  []reflect.Value
+	slotOf map[reflect.Type]int // shared by the runs of a frozen chain
+}
+
+// useSlots prepares the store for a run of a chain with the slots, which may
+// be nil.
+func (v *valueStore) useSlots(slotOf map[reflect.Type]int) {
+	v.slotOf = slotOf
+	if cap(v.slots) < len(slotOf) {
+		v.slots = make([]reflect.Value, len(slotOf))
+	}
+	v.slots = v.slots[:len(slotOf)]
+}
+
+func (v *valueStore) get(t reflect.Type) reflect.Value {
+	if i, ok := v.slotOf[t]; ok {
+		return v.slots[i]
+	}
+	return v.m[t]
+}
+
+func (v *valueStore) put(t reflect.Type, val reflect.Value) {
+	if i, ok := v.slotOf[t]; ok {
+		v.slots[i] = val
+		return
+	}
+	v.m[t] = val
+}
+
+// each calls f with each type that has a value.
+func (v *valueStore) each(f func(t reflect.Type, val reflect.Value)) {
+	for t, i := range v.slotOf {
+		if v.slots[i].IsValid() {
+			f(t, v.slots[i])
+		}
+	}
+	for t, val := range v.m {
+		f(t, val)
+	}
+}
+
+func (v *valueStore) reset() {
+	for t := range v.m {
+		delete(v.m, t)
+	}
+	for i := range v.slots {
+		v.slots[i] = reflect.Value{}
+	}
+	v.slots, v.slotOf = v.slots[:0], nil
+}
+
+// contextOf returns the context of the first arg that is a context.Context or
+// has a Context method, such as *http.Request, and the declared type of that
+// arg.
+func contextOf(types []reflect.Type, args []interface{}) (context.Context, reflect.Type) {
+	for i, arg := range args {
+		if ctx := contextOfValue(arg); ctx != nil && i < len(types) {
+			return ctx, types[i]
+		}
+	}
+	return nil, nil
+}
+
+func contextOfValue(arg interface{}) context.Context {
+	switch arg := arg.(type) {
+	case context.Context:
+		return arg
+	case interface{ Context() context.Context }:
+		if v := reflect.ValueOf(arg); v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil
+		}
+		return arg.Context()
+	}
+	return nil
+}
+
+// updateContext sets ctx to the context of the most recent value of the type
+// that provided it, in case a handler replaced that value.
+func (st *runState) updateContext() {
+	if st.ctxType == nil {
+		return
+	}
+	if v := st.data.get(st.ctxType); v.IsValid() && v.CanInterface() {
+		if ctx := contextOfValue(v.Interface()); ctx != nil {
+			st.ctx = ctx
+		}
 	}
+}
+
+// abort fails the run because its context is done before s is called.
+func (st *runState) abort(s step) {
+	name := s.funcInfo().Name
+	err := fmt.Errorf("aborted before %s: %w", name, st.ctx.Err())
+	st.data.put(errorType, reflect.ValueOf(&err).Elem())
+}
+
+// argsFor returns a slice to hold n args. The slice is reused by subsequent
+// calls, so it must not be retained.
+func (st *runState) argsFor(n int) []reflect.Value {
+	if cap(st.args) < n {
+		st.args = make([]reflect.Value, n)
+	}
+	return st.args[:n]
+}
+
+// set provides val as the current value of type t, unless t is overridden.
+func (st *runState) set(t reflect.Type, val reflect.Value) {
+	st.setSlot(t, -1, val)
+}
+
+// setSlot is like set, but stores the value in slot i of st.data, if i isn't
+// negative. The slot must belong to t.
+func (st *runState) setSlot(t reflect.Type, i int, val reflect.Value) {
+	if override, ok := st.overrides[t]; ok {
+		val = override
+	}
+	if i >= 0 {
+		st.data.slots[i] = val
+	} else {
+		st.data.put(t, val)
+	}
+	delete(st.lazy, t)
+	if vals, ok := st.accum[t]; ok {
+		st.accum[t] = append(vals, val)
+	}
+}
+
+// accumTypes returns the types that are accepted by a variadic handler or
+// declared by Accumulate.
+func (c Func) accumTypes() []reflect.Type {
+	var types []reflect.Type
+	for _, s := range c.steps {
+		switch s.typ {
+		case tPRE_HANDLER, tPOST_HANDLER, tERROR_HANDLER, tLAZY_PROVIDER, tPANIC_HANDLER, tERROR_MAPPER:
+			if s.val.IsValid() && s.valTyp.IsVariadic() {
+				types = append(types, s.valTyp.In(s.valTyp.NumIn()-1).Elem())
+			}
+		case tACCUMULATE:
+			types = append(types, s.valTyp)
+		}
+	}
+	return types
+}
 
+// prepareAccum starts accumulating the values of each of the types, including
+// the values of args.
+func (st *runState) prepareAccum(types []reflect.Type) {
+	for _, t := range types {
+		if st.accum == nil {
+			st.accum = map[reflect.Type][]reflect.Value{}
+		}
+		if _, ok := st.accum[t]; !ok {
+			st.accum[t] = nil
+			if arg := st.data.get(t); arg.IsValid() {
+				st.accum[t] = append(st.accum[t], arg)
+			}
+		}
+	}
+}
+
+// accumulated returns the slice of all values provided so far for a variadic
+// or accumulated arg of type sliceType.
+func (st *runState) accumulated(sliceType reflect.Type) reflect.Value {
+	vals := st.accum[sliceType.Elem()]
+	slice := reflect.MakeSlice(sliceType, len(vals), len(vals))
+	for i, val := range vals {
+		slice.Index(i).Set(val)
+	}
+	return slice
+}
+
+// provideZeros provides zero values for the outputs of a skipped handler that
+// haven't been provided already.
+func (st *runState) provideZeros(s step) {
+	for i := 0; i < s.valTyp.NumOut(); i++ {
+		t := s.valTyp.Out(i)
+		if _, pending := st.lazy[t]; t != errorType && !pending && !st.data.get(t).IsValid() {
+			// Not st.set: zero values aren't accumulated.
+			val := reflect.Zero(t)
+			if override, ok := st.overrides[t]; ok {
+				val = override
+			}
+			st.data.put(t, val)
+		}
+	}
+}
+
+// failed returns whether an error has been returned by a handler.
+func (st *runState) failed() bool {
+	errorVal := st.data.get(errorType)
+	return errorVal.IsValid() && !errorVal.IsNil()
+}
+
+func (st *runState) setOverrides(overrides map[interface{}]interface{}) error {
+	for typeOrInterfacePtr, val := range overrides {
+		typ := reflect.TypeOf(typeOrInterfacePtr)
+		if typ == nil {
+			return fmt.Errorf("bad override: key must not be nil")
+		}
+		if typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Interface {
+			typ = typ.Elem()
+		}
+		rv := reflect.ValueOf(val)
+		if !rv.IsValid() {
+			if typ.Kind() != reflect.Interface && typ.Kind() != reflect.Ptr {
+				return fmt.Errorf("bad override: nil is not a valid %s", typ)
+			}
+			rv = reflect.Zero(typ)
+		} else if !rv.Type().AssignableTo(typ) {
+			return fmt.Errorf("bad override: %s is not assignable to %s", rv.Type(), typ)
+		}
+		if st.overrides == nil {
+			st.overrides = map[reflect.Type]reflect.Value{}
+		}
+		st.overrides[typ] = rv
+	}
 	return nil
 }
 
-func (c Func) processRunArgs(
-	data map[reflect.Type]reflect.Value,
+func processRunArgs(
+	data *valueStore,
+	argTypes []reflect.Type,
 	argValues ...interface{},
 ) error {
 	argIndex := 0
 	expectedNumArgs := 0
 	var missingArgs []string
-	for _, step := range c.steps {
-		if step.typ != tARG {
-			continue
-		}
+	for _, typ := range argTypes {
 		expectedNumArgs++
 		if argIndex >= len(argValues) {
-			missingArgs = append(missingArgs, step.valTyp.String())
+			missingArgs = append(missingArgs, typ.String())
 			continue
 		}
 		val := argValues[argIndex]
 		argIndex++
 
 		if val == nil {
-			if step.valTyp.Kind() == reflect.Interface || step.valTyp.Kind() == reflect.Ptr {
-				data[step.valTyp] = reflect.New(step.valTyp).Elem()
+			if typ.Kind() == reflect.Interface || typ.Kind() == reflect.Ptr {
+				data.put(typ, reflect.New(typ).Elem())
 				continue
 			}
 			return fmt.Errorf("bad arg: %s arg of Run(...) should be a %s but is %v",
-				ordinalize(argIndex), step.valTyp, val)
+				ordinalize(argIndex), typ, val)
 		}
 
 		rv := reflect.ValueOf(val)
-		if !rv.CanConvert(step.valTyp) {
+		if !rv.CanConvert(typ) {
 			return fmt.Errorf("bad arg: %s arg of Run(...) should be a %s but is %s",
-				ordinalize(argIndex), step.valTyp, rv.Type())
+				ordinalize(argIndex), typ, rv.Type())
 		}
-		data[step.valTyp] = rv.Convert(step.valTyp)
+		data.put(typ, rv.Convert(typ))
 	}
 	if len(missingArgs) > 0 {
 		return fmt.Errorf("missing args of types: %s", missingArgs)
@@ -365,61 +1223,387 @@ func (c Func) processRunArgs(
 	return nil
 }
 
-func (c Func) call(s step, data map[reflect.Type]reflect.Value, stack *[]step) {
+// resolveLazy calls any pending lazy providers for the args of s, including
+// lazy providers needed by those providers. It returns false if a lazy provider
+// failed, in which case the error has been recorded.
+func (c Func) resolveLazy(s step, st *runState) bool {
+	for i := 0; i < s.valTyp.NumIn(); i++ {
+		if !c.resolveLazyType(s.valTyp.In(i), st) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Func) resolveLazyType(t reflect.Type, st *runState) bool {
+	provider, pending := st.lazy[t]
+	if !pending {
+		// Injected structs may need lazy values for their fields.
+		if !st.data.get(t).IsValid() {
+			for _, f := range injectableFields(t) {
+				if !c.resolveLazyType(t.Field(f).Type, st) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	// Remove the provider before resolving its own args: a provider may
+	// consume an earlier value of a type that it provides.
+	for j := 0; j < provider.valTyp.NumOut(); j++ {
+		delete(st.lazy, provider.valTyp.Out(j))
+	}
+	if !c.resolveLazy(provider, st) {
+		// The provider wasn't called, so it's still pending in case the error is
+		// resolved by the error handler.
+		for j := 0; j < provider.valTyp.NumOut(); j++ {
+			if t := provider.valTyp.Out(j); t != errorType {
+				st.lazy[t] = provider
+			}
+		}
+		return false
+	}
+	c.call(provider, st)
+	return !st.failed()
+}
+
+// skip checks whether the current error is a SkipTo from the i'th step and, if
+// so, clears the error and starts skipping to the label. If the label doesn't
+// follow the step, the error is replaced.
+func (c Func) skip(i int, st *runState) bool {
+	label, ok := st.data.get(errorType).Interface().(skipTo)
+	if !ok {
+		return false
+	}
+	for _, s := range c.steps[i+1:] {
+		if s.typ == tLABEL && s.label == string(label) {
+			st.data.put(errorType, reflect.Zero(errorType))
+			st.skipTo = string(label)
+			return true
+		}
+	}
+	err := fmt.Errorf("SkipTo(%q): no such label follows the handler", string(label))
+	st.data.put(errorType, reflect.ValueOf(&err).Elem())
+	return false
+}
+
+// NotHandled may be returned by an error handler to decline to handle an
+// error, so that the error is passed to the previously registered error
+// handler that matches it, or to DefaultErrorHandler if there's none. This
+// allows registering a sequence of fallbacks, e.g. a custom error page that
+// declines if its template fails to render, falling back to a plain error
+// response. An error handler that declines shouldn't have written a response.
+//
+// Note that Code does not reflect fallbacks.
+var NotHandled = errors.New("error not handled")
+
+// handleErr calls the error handler for the current error and returns whether
+// the error handler resolved it. The error is first transformed by the error
+// mappers, then the most recently registered error handler that matches the
+// error is used, or DefaultErrorHandler if none match. If the error handler
+// returns NotHandled, the next matching error handler is used instead.
+func (c Func) handleErr(st *runState) bool {
+	c.mapErr(st)
+	orig := st.data.get(errorType)
+	err := orig.Interface().(error)
+	for i := len(st.errHandlers) - 1; i >= 0; i-- {
+		h := st.errHandlers[i]
+		if h.typ == tERROR_MAPPER {
+			continue
+		}
+		if h.errTyp != nil {
+			target := reflect.New(h.errTyp)
+			if !errors.As(err, target.Interface()) {
+				continue
+			}
+			st.data.put(h.errTyp, target.Elem())
+		}
+		c.call(h, st)
+		if st.failed() && st.data.get(errorType).Interface() == NotHandled {
+			st.data.put(errorType, orig)
+			continue
+		}
+		return !st.failed()
+	}
+	c.call(step{
+		typ:    tERROR_HANDLER,
+		val:    reflect.ValueOf(DefaultErrorHandler),
+		valTyp: reflect.TypeOf(DefaultErrorHandler),
+	}, st)
+	return !st.failed()
+}
+
+// mapErr calls the error mappers reached so far to transform the current
+// error, by descending priority and in registration order for equal
+// priorities.
+func (c Func) mapErr(st *runState) {
+	// Each pass calls the mappers of one priority and finds the next lower
+	// priority, so that no sorted copy of the mappers needs to be allocated.
+	for prio, more := math.MaxInt, true; more; {
+		next := math.MinInt
+		more = false
+		for _, m := range st.errHandlers {
+			if m.typ != tERROR_MAPPER {
+				continue
+			}
+			if m.priority < prio {
+				if !more || m.priority > next {
+					next, more = m.priority, true
+				}
+				continue
+			}
+			if m.priority > prio {
+				continue
+			}
+			orig := st.data.get(errorType)
+			c.call(m, st)
+			if !st.failed() {
+				st.data.put(errorType, orig) // mappers can't resolve errors
+			}
+		}
+		prio = next
+	}
+}
+
+// inputs returns the args to call s with. The returned slice is reused, see
+// argsFor.
+func (c Func) inputs(s step, st *runState) []reflect.Value {
 	t := s.valTyp
-	in := make([]reflect.Value, t.NumIn())
+	in := st.argsFor(t.NumIn())
 	for i := range in {
-		in[i] = data[t.In(i)]
+		var argType reflect.Type
+		if s.in != nil {
+			argType = s.in[i]
+		} else {
+			argType = t.In(i)
+		}
+		if t.IsVariadic() && i == len(in)-1 {
+			in[i] = st.accumulated(argType)
+			continue
+		}
+		if s.inSlots != nil {
+			in[i] = st.data.slots[s.inSlots[i]]
+		} else {
+			in[i] = st.data.get(argType)
+		}
+		if !in[i].IsValid() && argType.Kind() == reflect.Slice {
+			if _, ok := st.accum[argType.Elem()]; ok {
+				in[i] = st.accumulated(argType)
+			}
+		}
+		if !in[i].IsValid() {
+			in[i] = injectStruct(argType, &st.data)
+		}
+		if !in[i].IsValid() && s.late {
+			in[i] = reflect.Zero(argType)
+		}
 		// This isn't supposed to happen if we've done all our checks right.
 		if !in[i].IsValid() {
-			name := runtime.FuncForPC(s.val.Pointer()).Name()
+			name := s.funcInfo().Name
 			panicf("Cannot inject %s arg of type %s into %s (%s). Data: %v",
-				ordinalize(i+1), t.In(i), name, t, data)
+				ordinalize(i+1), t.In(i), name, t, summarizeValues(&st.data))
 		}
 	}
+	return in
+}
+
+func (c Func) call(s step, st *runState) {
+	t := s.valTyp
+	in := c.inputs(s, st)
+	var start time.Time
+	completed := false
 	defer func() {
-		if err := c.wrapPanic(recover(), *stack); err != nil {
-			data[errorType] = reflect.ValueOf((*error)(&err)).Elem()
+		for i := range in {
+			in[i] = reflect.Value{} // don't retain values in the pooled state
+		}
+		if st.panicHandler != nil && !st.panicHandler.val.IsValid() {
+			// Recovery is disabled, let the panic propagate out of Run. A panic
+			// from another goroutine, see WithStepTimeout, propagates with its
+			// original value.
+			if !completed {
+				st.release()
+				if x := recover(); x != nil {
+					if p, ok := x.(goroutinePanic); ok {
+						x = p.val
+					}
+					panic(x)
+				}
+			}
+			return
+		}
+		if x := recover(); x != nil {
+			orig := st.data.get(errorType)
+			err := c.wrapPanic(x, st.stack, &st.data)
+			st.data.put(errorType, reflect.ValueOf((*error)(&err)).Elem())
+			if s.inst != nil && !start.IsZero() {
+				s.inst.finish(st.ctx, start, err)
+			}
+			// Subsequent handlers may still run if the error is handled.
+			st.provideZeros(s)
+			if st.panicHandler != nil {
+				c.handlePanicOrRelease(err.(PanicError), st)
+				// A panic while handling an error, or in a deferred handler,
+				// doesn't resolve the error that was being handled.
+				if !st.failed() && s.typ != tPRE_HANDLER && s.typ != tLAZY_PROVIDER {
+					st.data.put(errorType, orig)
+				}
+			}
 		}
 	}()
-	*stack = append(*stack, s)
-	out := s.val.Call(in)
+	st.stack = append(st.stack, s)
+	if s.inst != nil {
+		start = s.inst.begin(st.ctx)
+	}
+	var sample *profSample
+	if s.prof != nil {
+		sample = s.prof.begin()
+	}
+	var out []reflect.Value
+	if t.IsVariadic() {
+		out = s.val.CallSlice(in)
+	} else if s.fast != nil {
+		out = s.fast(in)
+		normalizeOutputs(t, out)
+	} else {
+		out = s.val.Call(in)
+	}
+	if sample != nil {
+		s.prof.end(sample)
+	}
+	if s.inst != nil {
+		s.inst.finish(st.ctx, start, outputError(out))
+	}
+	for i, val := range out {
+		if s.outSlots != nil {
+			st.setSlot(val.Type(), s.outSlots[i], val)
+		} else {
+			st.set(val.Type(), val)
+		}
+		st.deferCleanup(val)
+	}
+	completed = true
+}
+
+// outputError returns the non-nil error returned by a handler, if any.
+func outputError(out []reflect.Value) error {
 	for _, val := range out {
-		data[val.Type()] = val
+		if val.Type() == errorType && !val.IsNil() {
+			return val.Interface().(error)
+		}
+	}
+	return nil
+}
+
+// deferCleanup defers val if it's a cleanup function or io.Closer returned by a
+// handler. See Then.
+func (st *runState) deferCleanup(val reflect.Value) {
+	switch val.Type() {
+	case cleanupType:
+		if !val.IsNil() {
+			st.post = append(st.post, step{typ: tPOST_HANDLER, val: val, valTyp: cleanupType})
+		}
+	case closerType:
+		if !val.IsNil() {
+			closer := val.Interface().(io.Closer)
+			cleanup := func() { _ = closer.Close() }
+			st.post = append(st.post, step{typ: tPOST_HANDLER, val: reflect.ValueOf(cleanup), valTyp: cleanupType})
+		}
 	}
 }
 
-func (c Func) wrapPanic(x interface{}, steps []step) error {
+// handlePanicOrRelease calls handlePanic, but releases st if the panic handler
+// panics, since that panic propagates out of Run.
+func (c Func) handlePanicOrRelease(p PanicError, st *runState) {
+	handled := false
+	defer func() {
+		if !handled {
+			st.release()
+		}
+	}()
+	c.handlePanic(p, st)
+	handled = true
+}
+
+// handlePanic calls the panic handler for a recovered panic. It's called
+// directly rather than via c.call so that it may re-panic.
+func (c Func) handlePanic(p PanicError, st *runState) {
+	s := *st.panicHandler
+	st.data.put(panicErrorType, reflect.ValueOf(p))
+	in := c.inputs(s, st)
+	var out []reflect.Value
+	if s.valTyp.IsVariadic() {
+		out = s.val.CallSlice(in)
+	} else {
+		out = s.val.Call(in)
+	}
+	for i := range in {
+		in[i] = reflect.Value{}
+	}
+	if len(out) == 1 {
+		st.data.put(errorType, out[0])
+	}
+}
+
+func (c Func) wrapPanic(x interface{}, steps []step, data *valueStore) error {
 	if x == nil {
 		return nil
 	}
-	var stack [8192]byte
-	n := runtime.Stack(stack[:], false)
+	var rawStack string
+	if p, ok := x.(goroutinePanic); ok {
+		x, rawStack = p.val, string(p.stack)
+	} else {
+		var stack [8192]byte
+		n := runtime.Stack(stack[:], false)
+		rawStack = string(stack[:n])
+	}
 
 	N := len(steps)
 	mwStack := make([]FuncInfo, N)
 	for i := range steps {
 		step := steps[N-i-1]
-		info := runtime.FuncForPC(step.val.Pointer())
-		file, line := info.FileLine(step.val.Pointer())
-		mwStack[i] = FuncInfo{info.Name(), file, line, step.val}
+		mwStack[i] = step.funcInfo()
 	}
 
 	return PanicError{
 		Val:             x,
-		RawStack:        string(stack[:n]),
+		RawStack:        rawStack,
 		MiddlewareStack: mwStack,
+		Values:          summarizeValues(data),
 	}
 }
 
+// maxValueSummary limits the length of each summary of PanicError.Values.
+const maxValueSummary = 100
+
+// summarizeValues formats the injected values for PanicError.Values.
+func summarizeValues(data *valueStore) map[string]string {
+	values := map[string]string{}
+	data.each(func(t reflect.Type, v reflect.Value) {
+		if t == errorType || !v.IsValid() || !v.CanInterface() {
+			return
+		}
+		summary := fmt.Sprintf("%+v", v.Interface())
+		if len(summary) > maxValueSummary {
+			summary = summary[:maxValueSummary] + "..."
+		}
+		values[t.String()] = summary
+	})
+	return values
+}
+
 // PanicError is the error that is returned if a handler panics. It includes
-// the panic'd value (Val), the raw Go stack trace (RawStack), and the
-// middleware execution history (MiddlewareStack) that shows what middleware
-// functions have already been called.
+// the panic'd value (Val), the raw Go stack trace (RawStack), the middleware
+// execution history (MiddlewareStack) that shows what middleware functions
+// have already been called, and a summary of the injected values at the time
+// of the panic (Values).
 type PanicError struct {
 	Val             interface{}
 	RawStack        string
 	MiddlewareStack []FuncInfo
+	// Values are the injected values at the time of the panic formatted with
+	// %+v and truncated, keyed by type, e.g. "*http.Request". They may include
+	// sensitive data, so they're not included in Error.
+	Values map[string]string
 }
 
 // FuncInfo describes a registered middleware function.
@@ -2,8 +2,13 @@ package chain
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -208,6 +213,26 @@ func TestCatchesPanics(t *testing.T) {
 	assert.Contains(t, err.Error(), "chain.c")
 }
 
+func TestPanicValues(t *testing.T) {
+	type User struct{ Name string }
+	var err error
+	New().
+		Arg("").
+		OnErr(func(e error) { err = e }).
+		Then(func() *User { return &User{"bob"} }).
+		Then(func() []byte { return bytes.Repeat([]byte("x"), 200) }).
+		Then(func() { panic("oops") }).
+		MustRun("arg")
+
+	require.IsType(t, PanicError{}, err)
+	values := err.(PanicError).Values
+	assert.Equal(t, "arg", values["string"])
+	assert.Equal(t, "&{Name:bob}", values["*chain.User"])
+	assert.Len(t, values["[]uint8"], maxValueSummary+3)
+	assert.NotContains(t, values, "error")
+	assert.NotContains(t, err.Error(), "bob")
+}
+
 func TestDefersCanAcceptErrors(t *testing.T) {
 	var buf bytes.Buffer
 	onerr := func(err error) { fmt.Fprintf(&buf, "onerr[%v]:", err) }
@@ -387,3 +412,783 @@ func TestRunWithNilReservedInterface(t *testing.T) {
 	require.NoError(t, chain.Run(nil))
 	assert.Nil(t, capturedStringer)
 }
+
+func TestLazily(t *testing.T) {
+	type Profile string
+	type Geo string
+	var calls []string
+	loadProfile := func(name string) Profile {
+		calls = append(calls, "profile")
+		return Profile("profile:" + name)
+	}
+	loadGeo := func(p Profile) (Geo, error) {
+		calls = append(calls, "geo")
+		if p == "profile:nowhere" {
+			return "", errors.New("unknown location")
+		}
+		return Geo("geo:" + string(p)), nil
+	}
+	var out string
+	base := New().Arg("").
+		OnErr(func(err error) { out = "err:" + err.Error() }).
+		Lazily(loadProfile).
+		Lazily(loadGeo)
+
+	// Nothing consumes the lazy values, so they're never called.
+	calls, out = nil, ""
+	require.NoError(t, base.Then(func(s string) { out = s }).Run("bob"))
+	assert.Empty(t, calls)
+	assert.Equal(t, "bob", out)
+
+	// Consumers trigger the lazy providers (and their lazy dependencies)
+	// exactly once.
+	useGeo := base.Then(
+		func(g Geo) { out = string(g) },
+		func(p Profile, g Geo) { out += "|" + string(p) },
+	)
+	calls, out = nil, ""
+	require.NoError(t, useGeo.Run("bob"))
+	assert.Equal(t, []string{"profile", "geo"}, calls)
+	assert.Equal(t, "geo:profile:bob|profile:bob", out)
+
+	// Errors from lazy providers abort the chain.
+	calls, out = nil, ""
+	require.NoError(t, useGeo.Run("nowhere"))
+	assert.Equal(t, []string{"profile", "geo"}, calls)
+	assert.Equal(t, "err:unknown location", out)
+
+	// A value provided after the lazy provider supersedes it.
+	calls, out = nil, ""
+	require.NoError(t, base.Set(Profile("explicit")).
+		Then(func(p Profile) { out = string(p) }).Run("bob"))
+	assert.Empty(t, calls)
+	assert.Equal(t, "explicit", out)
+
+	// Lazy values aren't available to error or deferred handlers.
+	assert.Panics(t, func() { base.OnErr(func(Profile, error) {}) })
+	assert.Panics(t, func() { base.Defer(func(Profile) {}) })
+	// Lazy providers must provide something.
+	assert.Panics(t, func() { New().Lazily(func() error { return nil }) })
+}
+
+func TestAppend(t *testing.T) {
+	type User string
+	var out []string
+	record := func(s string) func() { return func() { out = append(out, s) } }
+
+	auth := New().
+		Arg("").
+		Then(func(name string) (User, error) {
+			if name == "" {
+				return "", errors.New("no user")
+			}
+			return User(name), nil
+		}).
+		Defer(record("auth-defer"))
+	observe := New().
+		OnErr(func(err error) { out = append(out, "err:"+err.Error()) }).
+		Defer(record("observe-defer"))
+
+	c := New().Arg("").Append(observe).Append(auth).
+		Then(func(u User) { out = append(out, "hi "+string(u)) })
+
+	require.NoError(t, c.Run("bob"))
+	assert.Equal(t, []string{"hi bob", "auth-defer", "observe-defer"}, out)
+
+	// auth's deferred handler is registered after the failure, so it's not
+	// reached.
+	out = nil
+	require.NoError(t, c.Run(""))
+	assert.Equal(t, []string{"err:no user", "observe-defer"}, out)
+
+	// The appended chain's args must be provided.
+	assert.Panics(t, func() { New().Append(auth) })
+	// Handlers are re-validated against the combined chain.
+	needsUser := New().Arg(User("")).Then(func(User) {})
+	assert.Panics(t, func() { New().Arg("").Append(needsUser) })
+	assert.NotPanics(t, func() { New().Arg("").Append(auth).Append(needsUser) })
+}
+
+func TestStructInjection(t *testing.T) {
+	type DB struct{ name string }
+	type User string
+	type Deps struct {
+		DB       *DB
+		U        User
+		Stringer fmt.Stringer
+		ignored  int
+	}
+	var got Deps
+	c := New().
+		Set(&DB{"main"}).
+		SetAs(Stringer{}, (*fmt.Stringer)(nil)).
+		Lazily(func() User { return "bob" }).
+		Then(func(deps Deps) { got = deps })
+	require.NoError(t, c.Run())
+	assert.Equal(t, Deps{&DB{"main"}, "bob", Stringer{}, 0}, got)
+
+	// Anonymous structs work too.
+	var name string
+	require.NoError(t, New().Set(&DB{"other"}).
+		Then(func(deps struct{ DB *DB }) { name = deps.DB.name }).Run())
+	assert.Equal(t, "other", name)
+
+	// A provided struct value is used directly.
+	require.NoError(t, New().Set(Deps{U: "explicit"}).
+		Then(func(deps Deps) { got = deps }).Run())
+	assert.Equal(t, Deps{U: "explicit"}, got)
+
+	// All exported fields must be provided.
+	func() {
+		defer func() {
+			assert.Contains(t, fmt.Sprint(recover()),
+				"type chain.User required for field U of 1st arg")
+		}()
+		New().Set(&DB{}).SetAs(nil, (*fmt.Stringer)(nil)).Then(func(Deps) {})
+	}()
+	// Structs without exported fields can't be injected.
+	type empty struct{ x int }
+	assert.Panics(t, func() { New().Then(func(empty) {}) })
+}
+
+func TestRunWith(t *testing.T) {
+	type DB struct{ name string }
+	var got []string
+	c := New().
+		Set(&DB{"prod"}).
+		SetAs(Stringer{}, (*fmt.Stringer)(nil)).
+		Then(func(db *DB, s fmt.Stringer) { got = append(got, db.name, fmt.Sprint(s)) }).
+		Then(func() int { return 1 }).
+		Then(func(n int) { got = append(got, fmt.Sprint(n)) })
+
+	require.NoError(t, c.Run())
+	assert.Equal(t, []string{"prod", "yup", "1"}, got)
+
+	got = nil
+	require.NoError(t, c.RunWith(map[interface{}]interface{}{
+		(*DB)(nil):           &DB{"staging"},
+		(*fmt.Stringer)(nil): time.Duration(0),
+		0:                    42,
+	}))
+	assert.Equal(t, []string{"staging", "0s", "42"}, got)
+
+	// Overrides only apply to a single run.
+	got = nil
+	require.NoError(t, c.Run())
+	assert.Equal(t, []string{"prod", "yup", "1"}, got)
+
+	// Bad overrides.
+	assert.Error(t, c.RunWith(map[interface{}]interface{}{0: "not an int"}))
+	assert.Error(t, c.RunWith(map[interface{}]interface{}{0: nil}))
+	assert.Error(t, c.RunWith(map[interface{}]interface{}{nil: 0}))
+	assert.NoError(t, c.RunWith(map[interface{}]interface{}{(*DB)(nil): nil}))
+}
+
+func TestProvides(t *testing.T) {
+	type User struct{}
+	c := New().Arg("").SetAs(nil, (*fmt.Stringer)(nil))
+	assert.True(t, c.Provides(""))
+	assert.True(t, c.Provides((*fmt.Stringer)(nil)))
+	assert.False(t, c.Provides(&User{}))
+	assert.False(t, c.Provides((*error)(nil)))
+
+	c = c.Lazily(func() (*User, error) { return &User{}, nil })
+	assert.True(t, c.Provides(&User{}))
+	assert.False(t, New().Then(func() int { return 1 }).Provides(""))
+}
+
+func TestRunStateIsReset(t *testing.T) {
+	var got []string
+	c := New().
+		Set("default").
+		Then(func(s string) { got = append(got, s) })
+	require.NoError(t, c.RunWith(map[interface{}]interface{}{"": "override"}))
+	require.NoError(t, c.Run())
+	assert.Equal(t, []string{"override", "default"}, got)
+
+	// Values from a previous run must not be visible to a later run.
+	fail := New().Arg(0).
+		OnErr(func(err error) { got = append(got, err.Error()) }).
+		Then(func(n int) error {
+			if n > 0 {
+				return errors.New("failed")
+			}
+			return nil
+		})
+	got = nil
+	require.NoError(t, fail.Run(1))
+	require.NoError(t, fail.Run(0))
+	assert.Equal(t, []string{"failed"}, got)
+}
+
+func TestRunAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool randomly drops items with -race")
+	}
+	c := New().Then(func() {}, func() error { return nil }).Compile()
+	allocs := testing.AllocsPerRun(100, func() { c.MustRun() })
+	assert.LessOrEqual(t, allocs, 1.0)
+}
+
+func TestErrorHandlerCanResume(t *testing.T) {
+	type Config struct{ Name string }
+	var log []string
+	errSoft := errors.New("soft")
+	c := New().
+		Arg(false).
+		Defer(func(err error) { log = append(log, fmt.Sprint("defer: ", err)) }).
+		OnErr(func(err error) error {
+			log = append(log, "handled: "+err.Error())
+			if err == errSoft {
+				return nil
+			}
+			return fmt.Errorf("wrapped: %w", err)
+		}).
+		Lazily(func() (*Config, error) { return nil, errSoft }).
+		Then(func(c *Config) { log = append(log, fmt.Sprint("config: ", c)) }).
+		Then(func(hard bool) error {
+			if hard {
+				return errors.New("hard")
+			}
+			return errSoft
+		}).
+		Then(func() { log = append(log, "done") })
+
+	require.NoError(t, c.Run(false))
+	assert.Equal(t, []string{
+		"handled: soft", // from the lazy provider
+		"config: <nil>",
+		"handled: soft",
+		"done",
+		"defer: <nil>",
+	}, log)
+
+	log = nil
+	require.NoError(t, c.Run(true))
+	assert.Equal(t, []string{
+		"handled: soft",
+		"config: <nil>",
+		"handled: hard",
+		"defer: wrapped: hard",
+	}, log)
+
+	assert.Panics(t, func() { New().OnErr(func(error) int { return 0 }) })
+	assert.Panics(t, func() { New().OnErr(func(error) (error, error) { return nil, nil }) })
+}
+
+type notFoundErr struct{ id int }
+
+func (e notFoundErr) Error() string { return fmt.Sprint("not found: ", e.id) }
+
+func TestOnErrType(t *testing.T) {
+	var log []string
+	errs := []error{fmt.Errorf("wrapped: %w", notFoundErr{5}), errors.New("boom"), timeoutErr{}}
+	c := New().
+		Arg(0).
+		OnErr(func(err error) { log = append(log, "generic: "+err.Error()) }).
+		OnErrType(notFoundErr{}, func(e notFoundErr) { log = append(log, fmt.Sprint("not found id: ", e.id)) }).
+		OnErrType((*interface{ Timeout() bool })(nil), func(err error) error {
+			log = append(log, "timeout: "+err.Error())
+			return nil // resume
+		}).
+		Then(func(i int) error { return errs[i] }).
+		Then(func() { log = append(log, "done") })
+
+	for _, chain := range []Func{c, New().Arg(0).Append(c)} {
+		log = nil
+		for i := range errs {
+			require.NoError(t, chain.Run(i))
+		}
+		assert.Equal(t, []string{
+			"not found id: 5",
+			"generic: boom",
+			"timeout: timed out", "done",
+		}, log)
+	}
+
+	assert.Panics(t, func() { New().OnErrType(5, func() {}) })
+	assert.Panics(t, func() { New().OnErrType(nil, func() {}) })
+	assert.Panics(t, func() { New().OnErrType(notFoundErr{}, func(string) {}) })
+}
+
+func TestNotHandled(t *testing.T) {
+	var log []string
+	c := New().
+		Arg("").
+		OnErr(func(err error) { log = append(log, "plain: "+err.Error()) }).
+		OnErrType(notFoundErr{}, func(e notFoundErr) error {
+			log = append(log, "not found page")
+			return nil
+		}).
+		OnErr(func(page string, err error) error {
+			if page == "" {
+				log = append(log, "no custom page")
+				return NotHandled
+			}
+			log = append(log, "custom page: "+page)
+			return nil
+		}).
+		Then(func() error { return fmt.Errorf("wrapped: %w", notFoundErr{3}) })
+
+	for _, chain := range []Func{c, New().Arg("").Append(c)} {
+		log = nil
+		require.NoError(t, chain.Run("oops.html"))
+		require.NoError(t, chain.Run(""))
+		assert.Equal(t, []string{
+			"custom page: oops.html",
+			"no custom page", "not found page",
+		}, log)
+	}
+
+	// If all handlers decline, the DefaultErrorHandler is used.
+	defer func(orig interface{}) { DefaultErrorHandler = orig }(DefaultErrorHandler)
+	DefaultErrorHandler = func(err error) { log = append(log, "default: "+err.Error()) }
+	log = nil
+	require.NoError(t, New().
+		OnErr(func() error { return NotHandled }).
+		Then(func() error { return errors.New("boom") }).
+		Run())
+	assert.Equal(t, []string{"default: boom"}, log)
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string { return "timed out" }
+func (timeoutErr) Timeout() bool { return true }
+
+func TestDeferPriority(t *testing.T) {
+	var log []string
+	logf := func(msg string) func() { return func() { log = append(log, msg) } }
+	c := New().
+		DeferPriority(-1, logf("commit log")).
+		Defer(logf("a")).
+		DeferPriority(5, logf("flush")).
+		Defer(logf("b")).
+		DeferPriority(5, logf("flush 2"))
+
+	for _, chain := range []Func{c, New().Append(c)} {
+		log = nil
+		chain.MustRun()
+		assert.Equal(t, []string{"flush 2", "flush", "b", "a", "commit log"}, log)
+	}
+}
+
+func TestOnErrMapPriority(t *testing.T) {
+	var mapped string
+	appendf := func(msg string) func(error) error {
+		return func(err error) error { return fmt.Errorf("%w %s", err, msg) }
+	}
+	c := New().
+		OnErrMapPriority(-1, appendf("report")).
+		OnErrMap(appendf("a")).
+		OnErrMapPriority(5, appendf("wrap")).
+		OnErrMap(appendf("b")).
+		OnErrMapPriority(5, appendf("wrap 2")).
+		OnErr(func(err error) { mapped = err.Error() }).
+		Then(func() error { return errors.New("err") })
+
+	for _, chain := range []Func{c, New().Append(c)} {
+		mapped = ""
+		chain.MustRun()
+		assert.Equal(t, "err wrap wrap 2 a b report", mapped)
+	}
+}
+
+func TestDeferLate(t *testing.T) {
+	type User string
+	var log []string
+	record := func(u User, n int, err error) {
+		log = append(log, fmt.Sprintf("user=%q n=%d err=%v", u, n, err))
+	}
+	c := New().
+		Arg(false).
+		OnErr(func(err error) {}).
+		DeferLate(record).
+		Then(func(fail bool) error {
+			if fail {
+				return errors.New("bad auth")
+			}
+			return nil
+		}).
+		Then(func() User { return "bob" }).
+		Set(3)
+
+	for _, chain := range []Func{c, New().Arg(false).Append(c)} {
+		log = nil
+		chain.MustRun(false)
+		chain.MustRun(true)
+		assert.Equal(t, []string{
+			`user="bob" n=3 err=<nil>`,
+			`user="" n=0 err=bad auth`,
+		}, log)
+	}
+
+	assert.Panics(t, func() { New().DeferLate(func() int { return 0 }) })
+	assert.Panics(t, func() { New().DeferLate(5) })
+}
+
+func TestValues(t *testing.T) {
+	var buf bytes.Buffer
+	c := New().Arg(0).Set("a").SetAs(&buf, (*fmt.Stringer)(nil)).Then(func() int { return 1 }).Set(5)
+	assert.Equal(t, []interface{}{"a", &buf, 5}, c.Values())
+	assert.Empty(t, New().Values())
+	assert.Equal(t, []reflect.Type{
+		reflect.TypeOf(""), reflect.TypeOf((*fmt.Stringer)(nil)).Elem(), reflect.TypeOf(0),
+	}, c.ValueTypes())
+}
+
+func TestSkipTo(t *testing.T) {
+	type Cached string
+	var log []string
+	logf := func(msg string) func() { return func() { log = append(log, msg) } }
+	c := New().
+		Arg(false).
+		Set(5).
+		OnErr(func(err error) { log = append(log, "error: "+err.Error()) }).
+		Defer(logf("outer defer")).
+		Then(func(hit bool) error {
+			if hit {
+				return SkipTo("render")
+			}
+			return nil
+		}).
+		Defer(logf("skipped defer")).
+		Then(func() (Cached, int) { return "computed", 7 }).
+		Label("render").
+		Then(func(c Cached, n int) { log = append(log, fmt.Sprintf("render %q %d", c, n)) })
+
+	for _, chain := range []Func{c, New().Arg(false).Append(c)} {
+		log = nil
+		require.NoError(t, chain.Run(true))
+		assert.Equal(t, []string{`render "" 5`, "outer defer"}, log)
+
+		log = nil
+		require.NoError(t, chain.Run(false))
+		assert.Equal(t, []string{`render "computed" 7`, "skipped defer", "outer defer"}, log)
+	}
+
+	log = nil
+	New().
+		OnErr(func(err error) { log = append(log, "error: "+err.Error()) }).
+		Label("before").
+		Then(func() error { return SkipTo("before") }).
+		Then(logf("not run")).
+		MustRun()
+	assert.Equal(t, []string{`error: SkipTo("before"): no such label follows the handler`}, log)
+
+	assert.Panics(t, func() { New().Label("a").Then(func() {}).Label("a") })
+}
+
+func TestAbortsOnContextCancellation(t *testing.T) {
+	var log []string
+	var gotErr error
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := New().
+		Arg((*context.Context)(nil)).
+		Set(cancel).
+		OnErr(func(err error) { log = append(log, "error handler") }).
+		Defer(func(err error) { gotErr = err }).
+		Then(func(ctx context.Context) { log = append(log, "first") }).
+		Then(func(cancel context.CancelFunc) { cancel() }).
+		Then(func() { log = append(log, "not called") })
+
+	require.NoError(t, c.Run(ctx))
+	assert.Equal(t, []string{"first"}, log)
+	assert.ErrorIs(t, gotErr, context.Canceled)
+	assert.Contains(t, gotErr.Error(), "aborted before")
+}
+
+func TestAbortsOnReplacedContext(t *testing.T) {
+	var log []string
+	var gotErr error
+	c := New().
+		Arg((*context.Context)(nil)).
+		Defer(func(err error) { gotErr = err }).
+		Then(func(ctx context.Context) context.Context {
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+			return ctx
+		}).
+		Then(func() { log = append(log, "not called") })
+
+	require.NoError(t, c.Run(context.Background()))
+	assert.Empty(t, log)
+	assert.ErrorIs(t, gotErr, context.Canceled)
+}
+
+func TestOnPanic(t *testing.T) {
+	var log []string
+	var gotErr error
+	boom := func(fail bool) int {
+		if fail {
+			panic("boom")
+		}
+		return 5
+	}
+	base := New().
+		Arg(false).
+		Set("req").
+		OnErr(func(err error) { gotErr = err }).
+		Defer(func() { log = append(log, "defer") })
+	after := func(n int) { log = append(log, fmt.Sprint("after: ", n)) }
+
+	// Convert the panic to another error.
+	c := base.OnPanic(func(p PanicError, s string) error {
+		return fmt.Errorf("%s: converted %v", s, p.Val)
+	}).Then(boom, after)
+	for _, chain := range []Func{c, New().Arg(false).Append(c)} {
+		log, gotErr = nil, nil
+		require.NoError(t, chain.Run(true))
+		assert.EqualError(t, gotErr, "req: converted boom")
+		assert.Equal(t, []string{"defer"}, log)
+	}
+
+	// Resume after the panic.
+	log, gotErr = nil, nil
+	c = base.OnPanic(func(err error) error { return nil }).Then(boom, after)
+	require.NoError(t, c.Run(true))
+	assert.NoError(t, gotErr)
+	assert.Equal(t, []string{"after: 0", "defer"}, log)
+
+	// Report the panic, but still handle it as an error.
+	log, gotErr = nil, nil
+	c = base.OnPanic(func(p PanicError) { log = append(log, fmt.Sprint("report: ", p.Val)) }).Then(boom, after)
+	require.NoError(t, c.Run(true))
+	assert.IsType(t, PanicError{}, gotErr)
+	assert.Equal(t, []string{"report: boom", "defer"}, log)
+
+	// Re-panic or disable recovery.
+	c = base.OnPanic(func(p PanicError) { panic(p) }).Then(boom, after)
+	assert.Panics(t, func() { _ = c.Run(true) })
+	log = nil
+	c = base.OnPanic(nil).Then(boom, after)
+	assert.PanicsWithValue(t, "boom", func() { _ = c.Run(true) })
+	assert.Empty(t, log)
+	for _, chain := range []Func{c, New().Arg(false).Append(c)} {
+		log = nil
+		require.NoError(t, chain.Run(false))
+		assert.Equal(t, []string{"after: 5", "defer"}, log)
+	}
+
+	assert.Panics(t, func() { New().OnPanic(func(int) {}) })
+	assert.Panics(t, func() { New().OnPanic(func(PanicError) int { return 0 }) })
+}
+
+func TestPanicWhileHandlingError(t *testing.T) {
+	var log []string
+	var gotErr error
+	c := New().
+		OnPanic(func(err error) error { return nil }).
+		Defer(func(err error) { gotErr = err }).
+		OnErr(func(err error) { panic("error handler broke") }).
+		Then(func() error { return errors.New("failed") }).
+		Then(func() { log = append(log, "not called") })
+
+	require.NoError(t, c.Run())
+	assert.Empty(t, log)
+	assert.EqualError(t, gotErr, "failed", "the original error isn't resolved by the panic handler")
+}
+
+func TestPanicHandlerPanicReleasesState(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool randomly drops items with -race")
+	}
+	c := New().
+		OnPanic(func(p PanicError) { panic(p) }).
+		Then(func() { panic("boom") })
+
+	st := runStatePool.Get().(*runState)
+	runStatePool.Put(st)
+	assert.Panics(t, func() { _ = c.Run() })
+	got := runStatePool.Get().(*runState)
+	defer runStatePool.Put(got)
+	assert.Same(t, st, got, "the run state is returned to the pool")
+	assert.Empty(t, got.data.m)
+	assert.Empty(t, got.stack)
+}
+
+func TestVariadicHandlers(t *testing.T) {
+	type Note string
+	var got [][]Note
+	collect := func(notes ...Note) { got = append(got, notes) }
+	c := New().
+		Arg(Note("")).
+		Then(collect).
+		Then(func() Note { return "a" }).
+		Then(func() (Note, int) { return "b", 5 }).
+		Defer(func(n int, notes ...Note) { got = append(got, append(notes, Note(fmt.Sprint(n)))) }).
+		Then(collect).
+		Then(func() error { return SkipTo("end") }).
+		Then(func() Note { return "skipped" }).
+		Label("end").
+		Then(collect)
+
+	for _, chain := range []Func{c, New().Arg(Note("")).Append(c)} {
+		got = nil
+		require.NoError(t, chain.Run(Note("arg")))
+		assert.Equal(t, [][]Note{
+			{"arg"},
+			{"arg", "a", "b"},
+			{"arg", "a", "b"},
+			{"arg", "a", "b", "5"},
+		}, got)
+	}
+
+	got = nil
+	New().Then(collect, Once(collect)).MustRun()
+	assert.Equal(t, [][]Note{{}, {}}, got)
+}
+
+type fakeCloser struct{ log *[]string }
+
+func (f fakeCloser) Close() error {
+	*f.log = append(*f.log, "closed")
+	return errors.New("ignored")
+}
+
+func TestCleanupReturns(t *testing.T) {
+	var log []string
+	var gotErr error
+	c := New().
+		Arg(false).
+		OnErr(func(error) {}).
+		Defer(func(err error) { gotErr = err }).
+		Then(func() func() { return func() { log = append(log, "cleanup 1") } }).
+		Defer(func() { log = append(log, "defer") }).
+		Then(func() (io.Closer, error) { return fakeCloser{&log}, nil }).
+		Then(func(fail bool) (func(), error) {
+			if fail {
+				return func() { log = append(log, "cleanup after error") }, errors.New("failed")
+			}
+			return nil, nil
+		}).
+		Then(func() { log = append(log, "handler") })
+
+	for _, chain := range []Func{c, New().Arg(false).Append(c)} {
+		log = nil
+		require.NoError(t, chain.Run(false))
+		assert.Equal(t, []string{"handler", "closed", "defer", "cleanup 1"}, log)
+		assert.NoError(t, gotErr)
+
+		log = nil
+		require.NoError(t, chain.Run(true))
+		assert.Equal(t, []string{"cleanup after error", "closed", "defer", "cleanup 1"}, log)
+		assert.EqualError(t, gotErr, "failed")
+	}
+}
+
+func TestAccumulate(t *testing.T) {
+	type Warning string
+	var got [][]Warning
+	collect := func(w []Warning) { got = append(got, w) }
+	c := New().
+		Arg(Warning("")).
+		Then(func() Warning { return "a" }).
+		Accumulate(Warning("")).
+		Then(collect).
+		Then(func() Warning { return "b" }).
+		Set(Warning("c")).
+		Then(collect).
+		Accumulate((*error)(nil)).
+		Then(func(errs []error) { assert.Empty(t, errs) })
+
+	for _, chain := range []Func{c, New().Arg(Warning("")).Append(c)} {
+		got = nil
+		require.NoError(t, chain.Run(Warning("arg")))
+		assert.Equal(t, [][]Warning{{"arg", "a"}, {"arg", "a", "b", "c"}}, got)
+	}
+
+	// Slices are only available after Accumulate, and directly provided slices
+	// take precedence.
+	assert.Panics(t, func() { New().Then(collect) })
+	assert.Panics(t, func() { New().Accumulate(nil) })
+	got = nil
+	New().
+		Accumulate(Warning("")).
+		Then(func() Warning { return "a" }).
+		Set([]Warning{"direct"}).
+		Then(collect).
+		MustRun()
+	assert.Equal(t, [][]Warning{{"direct"}}, got)
+}
+
+func needsInt(int) {}
+
+func TestBuildError(t *testing.T) {
+	_, _, line, _ := runtime.Caller(0)
+	needsIntLine := line - 3
+	c := New().Set("s").Set(false)
+
+	c2, err := c.ThenE(func() {}, needsInt)
+	var buildErr *BuildError
+	require.ErrorAs(t, err, &buildErr)
+	assert.Equal(t, "2nd arg of With(...)", buildErr.Context)
+	assert.Equal(t, "1st arg", buildErr.Arg)
+	assert.Equal(t, reflect.TypeOf(0), buildErr.Missing)
+	assert.Equal(t, []reflect.Type{reflect.TypeOf(false), reflect.TypeOf("")}, buildErr.Provided)
+	assert.True(t, strings.HasSuffix(buildErr.Func.Name, "chain.needsInt"), buildErr.Func.Name)
+	assert.True(t, strings.HasSuffix(buildErr.Func.File, "chain_test.go"), buildErr.Func.File)
+	assert.Equal(t, needsIntLine, buildErr.Func.Line)
+	assert.Contains(t, err.Error(), "2nd arg of With(...) can't be called: type int required")
+	assert.Contains(t, err.Error(), fmt.Sprintf("chain_test.go:%d", needsIntLine))
+	assert.Equal(t, c, c2)
+
+	_, err = c.ThenE("not a function")
+	assert.EqualError(t, err, "1st arg of With(...) should be a function, handler is string")
+
+	_, err = c.OnErrE(func(error, int) {})
+	require.ErrorAs(t, err, &buildErr)
+	assert.Equal(t, "Error handler", buildErr.Context)
+	assert.Equal(t, "2nd arg", buildErr.Arg)
+	_, err = c.OnErrE(func(error) int { return 0 })
+	assert.Error(t, err)
+
+	c2, err = c.OnErrE(func(error, string) {})
+	require.NoError(t, err)
+	c2, err = c2.ThenE(func(string) int { return 1 }, needsInt)
+	require.NoError(t, err)
+	assert.NoError(t, c2.Run())
+
+	// The panicking variants panic with the *BuildError.
+	defer func() {
+		assert.IsType(t, (*BuildError)(nil), recover())
+	}()
+	c.Then(needsInt)
+}
+
+func TestOnErrMap(t *testing.T) {
+	type notFound struct{ error }
+	errNoRows := errors.New("no rows")
+	var handled []string
+	c := New().
+		Arg("").
+		OnErrMap(func(err error) error {
+			if errors.Is(err, errNoRows) {
+				return notFound{err}
+			}
+			return err
+		}).
+		OnErr(func(err error) { handled = append(handled, "any: "+err.Error()) }).
+		OnErrType(notFound{}, func(e notFound) { handled = append(handled, "not found: "+e.Error()) }).
+		OnErrMap(func(id string, err error) error { return fmt.Errorf("%s: %w", id, err) }).
+		OnErrMap(func(error) error { return nil }). // can't resolve the error
+		Then(func(id string) error {
+			if id == "missing" {
+				return errNoRows
+			}
+			return errors.New("broken")
+		})
+
+	for _, chain := range []Func{c, New().Arg("").Append(c)} {
+		handled = nil
+		require.NoError(t, chain.Run("missing"))
+		require.NoError(t, chain.Run("other"))
+		assert.Equal(t, []string{"not found: no rows", "any: other: broken"}, handled)
+	}
+
+	// Mappers must return exactly an error.
+	assert.Panics(t, func() { New().OnErrMap(func(error) {}) })
+	assert.Panics(t, func() { New().OnErrMap(func(error) (int, error) { return 0, nil }) })
+	assert.Panics(t, func() { New().OnErrMap(func(int, error) error { return nil }) })
+}
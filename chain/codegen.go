@@ -5,16 +5,24 @@ import (
 	"io"
 	"path/filepath"
 	"reflect"
-	"runtime"
+	"sort"
 	"strings"
 )
 
 // Code writes the Go code for the current chain out to w assuming it lives in
 // package "pkg" with the specified handler function name.
+//
+// Accumulate, OnPanic, labels for SkipTo and Lazily are not supported by the
+// generated code. If the chain uses any of them, they're noted in
+// "// unsupported:" comments and the generated function panics when it's
+// called, rather than silently behaving differently than the chain.
 func (c Func) Code(name, pkg string, w io.Writer) {
 	vars := &nameMapper{}
 
 	for _, s := range c.steps {
+		if s.valTyp == nil {
+			continue
+		}
 		vars.Reserve(s.valTyp.Name())
 		vars.Reserve(filepath.Base(s.valTyp.PkgPath()))
 	}
@@ -42,14 +50,30 @@ func (c Func) Code(name, pkg string, w io.Writer) {
 	}
 	fmt.Fprintf(w, "\t) {\n")
 
-	errHandler := step{tERROR_HANDLER, reflect.ValueOf(DefaultErrorHandler), nil}
+	accum := variadicTypes(c.steps)
+	writeUnsupported(w, pkg, c.steps, accum)
+
+	queues := newDeferQueues(c.steps, vars)
+	queues.write(w)
+	writeAccum(w, pkg, vars, c.steps, accum)
+
+	var errHandlers []step
 	for _, s := range c.steps {
 		if s.typ == tARG || s.typ == tVALUE {
 			continue
 		}
 
-		if s.typ == tERROR_HANDLER {
-			errHandler = s
+		// These are reported by writeUnsupported, labels are only noted.
+		if s.typ == tLABEL {
+			fmt.Fprintf(w, "\t\t// label: %s\n\n", s.label)
+			continue
+		}
+		if s.typ == tPANIC_HANDLER || s.typ == tACCUMULATE {
+			continue
+		}
+
+		if s.typ == tERROR_HANDLER || s.typ == tERROR_MAPPER {
+			errHandlers = append(errHandlers, s)
 			continue
 		}
 
@@ -60,11 +84,24 @@ func (c Func) Code(name, pkg string, w io.Writer) {
 			}
 		}
 
-		if s.typ == tPOST_HANDLER {
+		// Late deferred handlers may consume values that are provided later, so
+		// those variables are declared first.
+		if s.typ == tPOST_HANDLER && s.late {
+			for i := 0; i < s.valTyp.NumIn(); i++ {
+				if t := s.valTyp.In(i); !vars.Has(t) {
+					fmt.Fprintf(w, "\t\tvar %s %s\n", vars.For(t), strip(pkg, t))
+				}
+			}
+		}
+
+		queue := queues[s.priority]
+		if s.typ == tPOST_HANDLER && queue != "" {
+			fmt.Fprintf(w, "\t\t%s = append(%s, func() {\n\t", queue, queue)
+		} else if s.typ == tPOST_HANDLER {
 			fmt.Fprintf(w, "\t\tdefer func() {\n\t")
 		}
 
-		name, inVars, outVars, returnsError := getArgNames(pkg, vars, s.val)
+		name, inVars, outVars, returnsError := getArgNames(pkg, vars, s)
 
 		fmt.Fprintf(w, "\t\t")
 		if len(outVars) > 0 {
@@ -72,15 +109,20 @@ func (c Func) Code(name, pkg string, w io.Writer) {
 		}
 		fmt.Fprintf(w, "%s(%s)\n", name, strings.Join(inVars, ", "))
 
+		if s.typ != tPOST_HANDLER {
+			for i := 0; i < s.valTyp.NumOut(); i++ {
+				writeAppend(w, vars, accum, s.valTyp.Out(i), vars.For(s.valTyp.Out(i)))
+			}
+			writeCleanups(w, vars, s.valTyp, queues[0])
+		}
+
 		if returnsError {
-			name, inVars, _, _ := getArgNames(pkg, vars, errHandler.val)
-			fmt.Fprintf(w, "\t\tif err != nil {\n")
-			fmt.Fprintf(w, "\t\t\t%s(%s)\n", name, strings.Join(inVars, ", "))
-			fmt.Fprintf(w, "\t\t\treturn\n")
-			fmt.Fprintf(w, "\t\t}\n")
+			writeErrHandling(w, pkg, vars, errHandlers)
 		}
 
-		if s.typ == tPOST_HANDLER {
+		if s.typ == tPOST_HANDLER && queue != "" {
+			fmt.Fprintf(w, "\t\t})\n")
+		} else if s.typ == tPOST_HANDLER {
 			fmt.Fprintf(w, "\t\t}()\n")
 		}
 		fmt.Fprintf(w, "\n")
@@ -89,18 +131,286 @@ func (c Func) Code(name, pkg string, w io.Writer) {
 	fmt.Fprintf(w, "}\n")
 }
 
+// writeUnsupported writes a comment for each step that the generated code
+// doesn't support followed by a panic, if there are any such steps. Lazy
+// providers are still written as normal handlers after that, to show the rest
+// of the chain.
+func writeUnsupported(w io.Writer, pkg string, steps []step, accum []reflect.Type) {
+	var unsupported []string
+	for _, t := range accum {
+		if providesType(steps, reflect.SliceOf(t)) {
+			unsupported = append(unsupported, fmt.Sprintf("[]%s provided with variadic ...%[1]s", strip(pkg, t)))
+		}
+	}
+	for _, s := range steps {
+		switch {
+		case s.typ == tACCUMULATE:
+			unsupported = append(unsupported, "Accumulate("+strip(pkg, s.valTyp)+")")
+		case s.typ == tPANIC_HANDLER && s.val.IsValid():
+			unsupported = append(unsupported, "OnPanic("+funcName(pkg, s)+")")
+		case s.typ == tLABEL:
+			unsupported = append(unsupported, fmt.Sprintf("Label(%q) for SkipTo", s.label))
+		case s.typ == tLAZY_PROVIDER:
+			unsupported = append(unsupported, "Lazily("+funcName(pkg, s)+")")
+		}
+	}
+	if len(unsupported) == 0 {
+		return
+	}
+	for _, u := range unsupported {
+		fmt.Fprintf(w, "\t\t// unsupported: %s\n", u)
+	}
+	fmt.Fprintf(w, "\t\tpanic(%q)\n\n", "generated code doesn't support: "+strings.Join(unsupported, ", "))
+}
+
+// variadicTypes returns the types of the variadic args of the handlers, which
+// receive all of the values of the type provided so far.
+func variadicTypes(steps []step) []reflect.Type {
+	var types []reflect.Type
+	seen := map[reflect.Type]bool{}
+	for _, s := range steps {
+		switch s.typ {
+		case tPRE_HANDLER, tPOST_HANDLER, tERROR_HANDLER, tLAZY_PROVIDER, tERROR_MAPPER:
+			if s.valTyp.IsVariadic() {
+				t := s.valTyp.In(s.valTyp.NumIn() - 1).Elem()
+				if !seen[t] {
+					seen[t] = true
+					types = append(types, t)
+				}
+			}
+		}
+	}
+	return types
+}
+
+// providesType returns whether an arg, value or handler of the steps provides
+// a value of type t.
+func providesType(steps []step, t reflect.Type) bool {
+	for _, s := range steps {
+		switch s.typ {
+		case tARG:
+			if s.valTyp == t {
+				return true
+			}
+		case tVALUE:
+			if s.valTyp == t || s.val.Type() == t {
+				return true
+			}
+		case tPRE_HANDLER, tLAZY_PROVIDER:
+			for i := 0; i < s.valTyp.NumOut(); i++ {
+				if s.valTyp.Out(i) == t {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// writeAccum declares the slices of the values of each of the accum types that
+// are passed to variadic handlers, starting with the values of the args and
+// values of the chain.
+func writeAccum(w io.Writer, pkg string, vars *nameMapper, steps []step, accum []reflect.Type) {
+	for _, t := range accum {
+		fmt.Fprintf(w, "\t\tvar %s %s\n", vars.For(reflect.SliceOf(t)), strip(pkg, reflect.SliceOf(t)))
+	}
+	for _, s := range steps {
+		if s.typ == tARG {
+			writeAppend(w, vars, accum, s.valTyp, vars.For(s.valTyp))
+		} else if s.typ == tVALUE {
+			writeAppend(w, vars, accum, s.valTyp, vars.For(s.valTyp))
+			if s.val.Type() != s.valTyp {
+				writeAppend(w, vars, accum, s.val.Type(), vars.For(s.valTyp)+".("+strip(pkg, s.val.Type())+")")
+			}
+		}
+	}
+	if len(accum) > 0 {
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// writeAppend appends the value of the variable v to the slice of its type t,
+// if t is one of the accum types.
+func writeAppend(w io.Writer, vars *nameMapper, accum []reflect.Type, t reflect.Type, v string) {
+	for _, a := range accum {
+		if a == t {
+			slice := vars.For(reflect.SliceOf(t))
+			fmt.Fprintf(w, "\t\t%s = append(%s, %s)\n", slice, slice, v)
+		}
+	}
+}
+
+// deferQueues are the names of the queues of the deferred calls of each
+// priority. Go's defer can't reorder calls, so if any deferred handler has a
+// priority other than 0, the generated code appends the deferred calls to the
+// queue of their priority instead, and runs the queues in the order of
+// orderDeferred. Otherwise, there are no queues.
+type deferQueues map[int]string
+
+func newDeferQueues(steps []step, vars *nameMapper) deferQueues {
+	queues := deferQueues{}
+	for _, s := range steps {
+		if s.typ == tPOST_HANDLER && s.priority != 0 {
+			queues[s.priority] = ""
+		}
+	}
+	if len(queues) == 0 {
+		return nil
+	}
+	queues[0] = "" // for cleanups
+	for priority := range queues {
+		name := "deferred" + strings.Replace(fmt.Sprint(priority), "-", "Minus", 1)
+		vars.Reserve(name)
+		queues[priority] = name
+	}
+	return queues
+}
+
+// write declares the queues, and defers running each of them from the lowest
+// priority up, so that the highest priority runs first.
+func (q deferQueues) write(w io.Writer) {
+	priorities := make([]int, 0, len(q))
+	for priority := range q {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+	for _, priority := range priorities {
+		fmt.Fprintf(w, "\t\tvar %s []func()\n", q[priority])
+	}
+	for _, priority := range priorities {
+		name := q[priority]
+		fmt.Fprintf(w, "\t\tdefer func() {\n")
+		fmt.Fprintf(w, "\t\t\tfor i := len(%s) - 1; i >= 0; i-- {\n", name)
+		fmt.Fprintf(w, "\t\t\t\t%s[i]()\n", name)
+		fmt.Fprintf(w, "\t\t\t}\n")
+		fmt.Fprintf(w, "\t\t}()\n")
+	}
+	if len(priorities) > 0 {
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// writeCleanups defers any cleanup funcs or io.Closers returned by a handler of
+// type fnType, or adds them to queue if it's set, see deferQueues.
+func writeCleanups(w io.Writer, vars *nameMapper, fnType reflect.Type, queue string) {
+	for i := 0; i < fnType.NumOut(); i++ {
+		t := fnType.Out(i)
+		var call string
+		switch {
+		case t == cleanupType && queue == "":
+			call = fmt.Sprintf("defer %s()", vars.For(t))
+		case t == cleanupType:
+			call = fmt.Sprintf("%s = append(%s, %s)", queue, queue, vars.For(t))
+		case t == closerType && queue == "":
+			call = fmt.Sprintf("defer %s.Close()", vars.For(t))
+		case t == closerType:
+			call = fmt.Sprintf("%s = append(%s, func() { %s.Close() })", queue, queue, vars.For(t))
+		default:
+			continue
+		}
+		fmt.Fprintf(w, "\t\tif %s != nil {\n\t\t\t%s\n\t\t}\n", vars.For(t), call)
+	}
+}
+
+// writeErrHandling writes the code to call the appropriate error handler if
+// err is non-nil, after calling any error mappers. The candidates are the most
+// recently registered error handlers up to and including the first one that
+// handles all errors.
+func writeErrHandling(w io.Writer, pkg string, vars *nameMapper, errHandlers []step) {
+	var mappers, candidates []step
+	for _, h := range errHandlers {
+		if h.typ == tERROR_MAPPER {
+			mappers = append(mappers, h)
+		}
+	}
+	// Mappers are called by descending priority, as in mapErr.
+	sort.SliceStable(mappers, func(i, j int) bool { return mappers[i].priority > mappers[j].priority })
+	seen := map[reflect.Type]bool{}
+	for i := len(errHandlers) - 1; i >= 0; i-- {
+		if h := errHandlers[i]; h.typ == tERROR_MAPPER {
+			continue
+		} else if h.errTyp == nil || !seen[h.errTyp] {
+			seen[h.errTyp] = true
+			candidates = append(candidates, h)
+		}
+		if errHandlers[i].errTyp == nil {
+			break
+		}
+	}
+	if len(candidates) == 0 || candidates[len(candidates)-1].errTyp != nil {
+		candidates = append(candidates, step{typ: tERROR_HANDLER, val: reflect.ValueOf(DefaultErrorHandler)})
+	}
+
+	fmt.Fprintf(w, "\t\tif err != nil {\n")
+	for _, m := range mappers {
+		name, inVars, _, _ := getArgNames(pkg, vars, m)
+		fmt.Fprintf(w, "\t\t\tif mapped := %s(%s); mapped != nil {\n", name, strings.Join(inVars, ", "))
+		fmt.Fprintf(w, "\t\t\t\terr = mapped\n")
+		fmt.Fprintf(w, "\t\t\t}\n")
+	}
+	if len(candidates) == 1 {
+		writeErrHandlerCall(w, "\t\t\t", pkg, vars, candidates[0])
+		fmt.Fprintf(w, "\t\t}\n")
+		return
+	}
+	for _, h := range candidates {
+		if h.errTyp != nil {
+			fmt.Fprintf(w, "\t\t\tvar %s %s\n", vars.For(h.errTyp), strip(pkg, h.errTyp))
+		}
+	}
+	for i, h := range candidates {
+		if i == 0 {
+			fmt.Fprintf(w, "\t\t\tif errors.As(err, &%s) {\n", vars.For(h.errTyp))
+		} else if h.errTyp != nil {
+			fmt.Fprintf(w, "\t\t\t} else if errors.As(err, &%s) {\n", vars.For(h.errTyp))
+		} else {
+			fmt.Fprintf(w, "\t\t\t} else {\n")
+		}
+		writeErrHandlerCall(w, "\t\t\t\t", pkg, vars, h)
+	}
+	fmt.Fprintf(w, "\t\t\t}\n")
+	fmt.Fprintf(w, "\t\t}\n")
+}
+
+func writeErrHandlerCall(w io.Writer, indent, pkg string, vars *nameMapper, h step) {
+	name, inVars, _, resumable := getArgNames(pkg, vars, h)
+	if resumable {
+		fmt.Fprintf(w, "%sif err = %s(%s); err != nil {\n", indent, name, strings.Join(inVars, ", "))
+		fmt.Fprintf(w, "%s\treturn\n", indent)
+		fmt.Fprintf(w, "%s}\n", indent)
+	} else {
+		fmt.Fprintf(w, "%s%s(%s)\n", indent, name, strings.Join(inVars, ", "))
+		fmt.Fprintf(w, "%sreturn\n", indent)
+	}
+}
+
 func strip(pkg string, t reflect.Type) string {
 	return stripStr(pkg, t.String())
 }
 func stripStr(pkg, s string) string {
-	pos := strings.IndexFunc(s, func(r rune) bool { return r != '*' })
+	pos := strings.IndexFunc(s, func(r rune) bool { return r != '*' && r != '[' && r != ']' })
 	s = s[:pos] + strings.TrimPrefix(s[pos:], pkg+".")
 	return s
 }
 
-func getArgNames(pkg string, vars *nameMapper, v reflect.Value) (name string, in, out []string, returnsError bool) {
-	name = runtime.FuncForPC(v.Pointer()).Name()
-	name = filepath.Base(name)
+// argName returns the expression used to pass an arg of type t, which is
+// either the variable holding that type or, for injected structs, a composite
+// literal populating the struct fields.
+func argName(pkg string, vars *nameMapper, t reflect.Type) string {
+	fields := injectableFields(t)
+	if vars.Has(t) || fields == nil {
+		return vars.For(t)
+	}
+	var vals []string
+	for _, f := range fields {
+		vals = append(vals, t.Field(f).Name+": "+vars.For(t.Field(f).Type))
+	}
+	return strip(pkg, t) + "{" + strings.Join(vals, ", ") + "}"
+}
+
+// funcName returns the expression used to call the handler of s.
+func funcName(pkg string, s step) string {
+	name := filepath.Base(s.funcInfo().Name)
 	name = strings.TrimPrefix(name, pkg+".")
 
 	if pos := strings.Index(name, ".(*"); pos > 0 {
@@ -108,8 +418,13 @@ func getArgNames(pkg string, vars *nameMapper, v reflect.Value) (name string, in
 		pkgName = strings.TrimPrefix(pkgName, pkg+".")
 		name = "(*" + pkgName + name[pos+3:]
 	}
+	return name
+}
+
+func getArgNames(pkg string, vars *nameMapper, s step) (name string, in, out []string, returnsError bool) {
+	name = funcName(pkg, s)
 
-	t := v.Type()
+	t := s.val.Type()
 	out = make([]string, t.NumOut())
 	for i := 0; i < t.NumOut(); i++ {
 		out[i] = vars.For(t.Out(i))
@@ -119,7 +434,11 @@ func getArgNames(pkg string, vars *nameMapper, v reflect.Value) (name string, in
 	}
 	in = make([]string, t.NumIn())
 	for i := 0; i < t.NumIn(); i++ {
-		in[i] = vars.For(t.In(i))
+		in[i] = argName(pkg, vars, t.In(i))
+	}
+	// The variadic arg is the slice of all values of its type, see writeAccum.
+	if t.IsVariadic() {
+		in[len(in)-1] = vars.For(t.In(len(in)-1)) + "..."
 	}
 	return name, in, out, returnsError
 }
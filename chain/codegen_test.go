@@ -2,6 +2,7 @@ package chain
 
 import (
 	"bytes"
+	"io"
 	"net/http"
 	"regexp"
 	"strings"
@@ -64,3 +65,353 @@ func TestCodeGen(t *testing.T) {
 			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
 	}
 }
+
+func fails() error                       { return nil }
+func resume(err error) error             { return nil }
+func afterResume(rw http.ResponseWriter) {}
+
+func TestCodeGenResumableErrorHandler(t *testing.T) {
+	var buf bytes.Buffer
+	New().
+		Arg((*http.ResponseWriter)(nil)).
+		OnErr(resume).
+		Then(fails, afterResume).
+		Code("foo", "chain", &buf)
+
+	const expected = `func foo(
+      ) func(
+        rw http.ResponseWriter,
+      ) {
+        return func(
+          rw http.ResponseWriter,
+        ) {
+          var err error
+          err = fails()
+          if err != nil {
+            if err = resume(err); err != nil {
+              return
+            }
+          }
+
+          afterResume(rw)
+
+        }
+      }`
+	if normalizeWhitespace(buf.String()) != normalizeWhitespace(expected) {
+		t.Errorf("Wrong code generated: %s\nExp: %q\nGot: %q", buf.String(),
+			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
+	}
+}
+
+func handleAny(err error)          {}
+func handleNotFound(e notFoundErr) {}
+
+func TestCodeGenTypedErrorHandlers(t *testing.T) {
+	var buf bytes.Buffer
+	New().
+		OnErr(handleAny).
+		OnErrType(notFoundErr{}, handleNotFound).
+		Then(fails).
+		Code("foo", "chain", &buf)
+
+	const expected = `func foo(
+      ) func(
+      ) {
+        return func(
+        ) {
+          var err error
+          err = fails()
+          if err != nil {
+            var notFoundErr notFoundErr
+            if errors.As(err, &notFoundErr) {
+              handleNotFound(notFoundErr)
+              return
+            } else {
+              handleAny(err)
+              return
+            }
+          }
+
+        }
+      }`
+	if normalizeWhitespace(buf.String()) != normalizeWhitespace(expected) {
+		t.Errorf("Wrong code generated: %s\nExp: %q\nGot: %q", buf.String(),
+			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
+	}
+}
+
+func openResource() (io.Closer, func(), error) { return nil, nil, nil }
+
+func TestCodeGenCleanups(t *testing.T) {
+	var buf bytes.Buffer
+	New().
+		OnErr(handleAny).
+		Then(openResource).
+		Code("foo", "chain", &buf)
+
+	const expected = `func foo(
+      ) func(
+      ) {
+        return func(
+        ) {
+          var closer io.Closer
+          var f func()
+          var err error
+          closer, f, err = openResource()
+          if closer != nil {
+            defer closer.Close()
+          }
+          if f != nil {
+            defer f()
+          }
+          if err != nil {
+            handleAny(err)
+            return
+          }
+
+        }
+      }`
+	if normalizeWhitespace(buf.String()) != normalizeWhitespace(expected) {
+		t.Errorf("Wrong code generated: %s\nExp: %q\nGot: %q", buf.String(),
+			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
+	}
+}
+
+func classifyErr(err error) error { return err }
+
+func TestCodeGenErrorMappers(t *testing.T) {
+	var buf bytes.Buffer
+	New().
+		OnErrMap(classifyErr).
+		OnErr(handleAny).
+		Then(fails).
+		Code("foo", "chain", &buf)
+
+	const expected = `func foo(
+      ) func(
+      ) {
+        return func(
+        ) {
+          var err error
+          err = fails()
+          if err != nil {
+            if mapped := classifyErr(err); mapped != nil {
+              err = mapped
+            }
+            handleAny(err)
+            return
+          }
+
+        }
+      }`
+	if normalizeWhitespace(buf.String()) != normalizeWhitespace(expected) {
+		t.Errorf("Wrong code generated: %s\nExp: %q\nGot: %q", buf.String(),
+			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
+	}
+}
+
+func recordUser(db *TestDb, err error) {}
+func openDb() *TestDb                  { return nil }
+
+func TestCodeGenDeferLate(t *testing.T) {
+	var buf bytes.Buffer
+	New().
+		DeferLate(recordUser).
+		Then(openDb).
+		Code("foo", "chain", &buf)
+
+	const expected = `func foo(
+      ) func(
+      ) {
+        return func(
+        ) {
+          var pTestDb *TestDb
+          var err error
+          defer func() {
+            recordUser(pTestDb, err)
+          }()
+
+          pTestDb = openDb()
+
+        }
+      }`
+	if normalizeWhitespace(buf.String()) != normalizeWhitespace(expected) {
+		t.Errorf("Wrong code generated: %s\nExp: %q\nGot: %q", buf.String(),
+			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
+	}
+}
+
+func flush()                    {}
+func commitLog()                {}
+func reportErr(err error) error { return nil }
+func wrapErr(err error) error   { return err }
+
+func TestCodeGenPriorities(t *testing.T) {
+	var buf bytes.Buffer
+	New().
+		DeferPriority(-1, commitLog).
+		OnErrMapPriority(-1, reportErr).
+		OnErrMap(classifyErr).
+		OnErrMapPriority(5, wrapErr).
+		OnErr(handleAny).
+		Then(openResource).
+		DeferPriority(5, flush).
+		Code("foo", "chain", &buf)
+
+	const expected = `func foo(
+      ) func(
+      ) {
+        return func(
+        ) {
+          var deferredMinus1 []func()
+          var deferred0 []func()
+          var deferred5 []func()
+          defer func() {
+            for i := len(deferredMinus1) - 1; i >= 0; i-- {
+              deferredMinus1[i]()
+            }
+          }()
+          defer func() {
+            for i := len(deferred0) - 1; i >= 0; i-- {
+              deferred0[i]()
+            }
+          }()
+          defer func() {
+            for i := len(deferred5) - 1; i >= 0; i-- {
+              deferred5[i]()
+            }
+          }()
+
+          deferredMinus1 = append(deferredMinus1, func() {
+            commitLog()
+          })
+
+          var closer io.Closer
+          var f func()
+          var err error
+          closer, f, err = openResource()
+          if closer != nil {
+            deferred0 = append(deferred0, func() { closer.Close() })
+          }
+          if f != nil {
+            deferred0 = append(deferred0, f)
+          }
+          if err != nil {
+            if mapped := wrapErr(err); mapped != nil {
+              err = mapped
+            }
+            if mapped := classifyErr(err); mapped != nil {
+              err = mapped
+            }
+            if mapped := reportErr(err); mapped != nil {
+              err = mapped
+            }
+            handleAny(err)
+            return
+          }
+
+          deferred5 = append(deferred5, func() {
+            flush()
+          })
+
+        }
+      }`
+	if normalizeWhitespace(buf.String()) != normalizeWhitespace(expected) {
+		t.Errorf("Wrong code generated: %s\nExp: %q\nGot: %q", buf.String(),
+			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
+	}
+}
+
+func loadNote() string       { return "" }
+func recoverPanic(err error) {}
+
+func TestCodeGenUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	New().
+		Accumulate(int(0)).
+		OnPanic(recoverPanic).
+		Lazily(loadNote).
+		Label("done").
+		Code("foo", "chain", &buf)
+
+	const expected = `func foo(
+      ) func(
+      ) {
+        return func(
+        ) {
+          // unsupported: Accumulate(int)
+          // unsupported: OnPanic(recoverPanic)
+          // unsupported: Lazily(loadNote)
+          // unsupported: Label("done") for SkipTo
+          panic("generated code doesn't support: Accumulate(int), OnPanic(recoverPanic), Lazily(loadNote), Label(\"done\") for SkipTo")
+
+          var str string
+          str = loadNote()
+
+          // label: done
+
+        }
+      }`
+	if normalizeWhitespace(buf.String()) != normalizeWhitespace(expected) {
+		t.Errorf("Wrong code generated: %s\nExp: %q\nGot: %q", buf.String(),
+			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
+	}
+}
+
+type Note string
+
+func noteA() Note                      { return "a" }
+func noteB() (Note, int)               { return "b", 0 }
+func collectNotes(notes ...Note)       {}
+func recordNotes(n int, notes ...Note) {}
+func provideNotes() []Note             { return nil }
+
+func TestCodeGenVariadic(t *testing.T) {
+	var buf bytes.Buffer
+	New().
+		Arg(Note("")).
+		Set(Note("x")).
+		Then(noteA).
+		Then(noteB).
+		Defer(recordNotes).
+		Then(collectNotes).
+		Code("foo", "chain", &buf)
+
+	const expected = `func foo(
+        note Note,
+      ) func(
+        note Note,
+      ) {
+        return func(
+          note Note,
+        ) {
+          var sliceOfNote []Note
+          sliceOfNote = append(sliceOfNote, note)
+          sliceOfNote = append(sliceOfNote, note)
+
+          note = noteA()
+          sliceOfNote = append(sliceOfNote, note)
+
+          var i int
+          note, i = noteB()
+          sliceOfNote = append(sliceOfNote, note)
+
+          defer func() {
+            recordNotes(i, sliceOfNote...)
+          }()
+
+          collectNotes(sliceOfNote...)
+
+        }
+      }`
+	if normalizeWhitespace(buf.String()) != normalizeWhitespace(expected) {
+		t.Errorf("Wrong code generated: %s\nExp: %q\nGot: %q", buf.String(),
+			normalizeWhitespace(expected), normalizeWhitespace(buf.String()))
+	}
+
+	buf.Reset()
+	New().Then(provideNotes, collectNotes).Code("foo", "chain", &buf)
+	if !strings.Contains(buf.String(), "// unsupported: []Note provided with variadic ...Note\n") {
+		t.Errorf("Directly provided slice isn't reported: %s", buf.String())
+	}
+}
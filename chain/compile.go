@@ -0,0 +1,110 @@
+package chain
+
+import (
+	"reflect"
+	"sync"
+)
+
+// FastCaller calls a handler function with the provided args directly, rather
+// than using reflect.Value.Call. The args and results are still passed as
+// reflect.Values, so this only avoids the overhead of reflect.Value.Call
+// itself, not of boxing the values. The returned values may be nil for nil
+// interfaces and may have the dynamic type of the value rather than the
+// declared return type; the chain will convert them as necessary.
+type FastCaller func(in []reflect.Value) []reflect.Value
+
+var (
+	fastCallMu sync.RWMutex
+	fastCalls  = map[reflect.Type]func(fn interface{}) FastCaller{}
+)
+
+// RegisterFastCall registers an adapter that creates FastCallers for functions
+// that have the same type as sample. This is typically called during init for
+// the handler signatures used most often. For example:
+//
+//	chain.RegisterFastCall((func(http.ResponseWriter, *http.Request))(nil),
+//	  func(fn any) chain.FastCaller {
+//	    f := fn.(func(http.ResponseWriter, *http.Request))
+//	    return func(in []reflect.Value) []reflect.Value {
+//	      f(chain.As[http.ResponseWriter](in[0]), chain.As[*http.Request](in[1]))
+//	      return nil
+//	    }
+//	  })
+//
+// Use Compile to make a chain use the registered adapters.
+func RegisterFastCall(sample interface{}, adapt func(fn interface{}) FastCaller) {
+	typ := reflect.TypeOf(sample)
+	if typ == nil || typ.Kind() != reflect.Func {
+		panicf("RegisterFastCall(...) sample must be a function, instead got %v", typ)
+	}
+	fastCallMu.Lock()
+	defer fastCallMu.Unlock()
+	fastCalls[typ] = adapt
+}
+
+// As returns the injected value v as a T. It's intended for use in FastCallers,
+// and correctly handles nil interface values.
+func As[T any](v reflect.Value) T {
+	t, _ := v.Interface().(T)
+	return t
+}
+
+// Compile returns a copy of the chain where handlers whose signatures have
+// registered FastCall adapters are called directly rather than via
+// reflect.Value.Call. Handlers with other signatures are unaffected. The
+// behavior of the compiled chain is otherwise identical.
+//
+// The gain is modest, since the chain still stores and injects the values via
+// reflection: for the routes of a sandwich.TheUsual router, a compiled chain
+// runs about 5-10% faster with the same allocations.
+func (c Func) Compile() Func {
+	steps := make([]step, len(c.steps))
+	copy(steps, c.steps)
+	fastCallMu.RLock()
+	defer fastCallMu.RUnlock()
+	for i, s := range steps {
+		switch s.typ {
+		case tPRE_HANDLER, tPOST_HANDLER, tERROR_HANDLER, tLAZY_PROVIDER, tERROR_MAPPER:
+			if adapt := fastCalls[s.valTyp]; adapt != nil {
+				steps[i].fast = adapt(s.val.Interface())
+			}
+		}
+	}
+	return Func{steps}
+}
+
+// normalizeOutputs converts the values returned by a FastCaller to the declared
+// return types of fnType.
+func normalizeOutputs(fnType reflect.Type, out []reflect.Value) {
+	for i, val := range out {
+		t := fnType.Out(i)
+		if !val.IsValid() {
+			out[i] = reflect.Zero(t)
+		} else if val.Type() != t {
+			out[i] = val.Convert(t)
+		}
+	}
+}
+
+func init() {
+	RegisterFastCall((func())(nil), func(fn interface{}) FastCaller {
+		f := fn.(func())
+		return func(in []reflect.Value) []reflect.Value {
+			f()
+			return nil
+		}
+	})
+	RegisterFastCall((func() error)(nil), func(fn interface{}) FastCaller {
+		f := fn.(func() error)
+		return func(in []reflect.Value) []reflect.Value {
+			return []reflect.Value{reflect.ValueOf(f())}
+		}
+	})
+	RegisterFastCall((func(error))(nil), func(fn interface{}) FastCaller {
+		f := fn.(func(error))
+		return func(in []reflect.Value) []reflect.Value {
+			f(As[error](in[0]))
+			return nil
+		}
+	})
+}
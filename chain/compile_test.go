@@ -0,0 +1,70 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type compileTestErr struct{ msg string }
+
+func (e compileTestErr) Error() string { return e.msg }
+
+func TestCompile(t *testing.T) {
+	var fastCalls int
+	RegisterFastCall((func(string) (fmt.Stringer, error))(nil), func(fn interface{}) FastCaller {
+		f := fn.(func(string) (fmt.Stringer, error))
+		return func(in []reflect.Value) []reflect.Value {
+			fastCalls++
+			s, err := f(As[string](in[0]))
+			return []reflect.Value{reflect.ValueOf(s), reflect.ValueOf(err)}
+		}
+	})
+
+	var log []string
+	c := New().
+		Arg("").
+		OnErr(func(err error) { log = append(log, "err: "+err.Error()) }).
+		Then(func(s string) (fmt.Stringer, error) {
+			if s == "fail" {
+				return nil, compileTestErr{"failed"}
+			}
+			return nil, nil
+		}).
+		Then(func(s fmt.Stringer) { log = append(log, fmt.Sprint("stringer: ", s)) }).
+		Then(func() error { return errors.New("done") })
+
+	compiled := c.Compile()
+	for _, chain := range []Func{c, compiled} {
+		log = nil
+		assert.NoError(t, chain.Run("ok"))
+		assert.Equal(t, []string{"stringer: <nil>", "err: done"}, log)
+		log = nil
+		assert.NoError(t, chain.Run("fail"))
+		assert.Equal(t, []string{"err: failed"}, log)
+	}
+	assert.Equal(t, 2, fastCalls)
+}
+
+func TestRegisterFastCallBadSample(t *testing.T) {
+	assert.Panics(t, func() { RegisterFastCall(5, nil) })
+	assert.Panics(t, func() { RegisterFastCall(nil, nil) })
+}
+
+func BenchmarkRun(b *testing.B) {
+	c := New().Then(func() {}).Then(func() error { return nil }).Then(func() {})
+	b.Run("reflect", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.MustRun()
+		}
+	})
+	b.Run("compiled", func(b *testing.B) {
+		compiled := c.Compile()
+		for i := 0; i < b.N; i++ {
+			compiled.MustRun()
+		}
+	})
+}
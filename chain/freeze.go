@@ -0,0 +1,136 @@
+package chain
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Frozen is a validated chain that has been prepared for repeated execution.
+// It's immutable and safe for concurrent use. See Func.Freeze.
+type Frozen struct {
+	orig Func // as passed to Freeze
+	fn   Func // with the precomputed arg types and slots of each step
+	plan runPlan
+}
+
+// Freeze validates the chain and returns an executable handle that avoids
+// re-walking the chain to find the args and accumulated types on each Run. It
+// also assigns each type that the handlers accept or return an index, so that
+// the args of each handler are taken from a slice by precomputed indexes
+// rather than looked up by type. This is intended for chains that are built
+// once and run many times, such as the handlers of a route.
+//
+// The chain is validated as a whole: each handler, error handler, and
+// deferred handler must accept only types that are provided before it. Chains
+// built with Then, OnErr, etc. are validated incrementally, so this only fails
+// if the chain was assembled in some other way, but it allows frameworks to
+// surface problems as errors at startup. If a type is missing, the error is a
+// *BuildError.
+func (c Func) Freeze() (*Frozen, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	slotOf := map[reflect.Type]int{}
+	slot := func(t reflect.Type) int {
+		i, ok := slotOf[t]
+		if !ok {
+			i = len(slotOf)
+			slotOf[t] = i
+		}
+		return i
+	}
+	slot(errorType)
+	steps := make([]step, len(c.steps))
+	copy(steps, c.steps)
+	for i, s := range steps {
+		switch s.typ {
+		case tPRE_HANDLER, tPOST_HANDLER, tERROR_HANDLER, tLAZY_PROVIDER, tPANIC_HANDLER,
+			tERROR_MAPPER:
+			if !s.val.IsValid() {
+				continue
+			}
+			in := make([]reflect.Type, s.valTyp.NumIn())
+			inSlots := make([]int, len(in))
+			for j := range in {
+				in[j] = s.valTyp.In(j)
+				inSlots[j] = slot(in[j])
+			}
+			outSlots := make([]int, s.valTyp.NumOut())
+			for j := range outSlots {
+				outSlots[j] = slot(s.valTyp.Out(j))
+			}
+			steps[i].in, steps[i].inSlots, steps[i].outSlots = in, inSlots, outSlots
+		}
+	}
+	fn := Func{steps}
+	return &Frozen{c, fn, runPlan{fn.argTypes(), fn.accumTypes(), slotOf}}, nil
+}
+
+// Func returns the chain that was frozen.
+func (f *Frozen) Func() Func { return f.orig }
+
+// Run executes the chain, see Func.Run.
+func (f *Frozen) Run(argValues ...interface{}) error {
+	return f.fn.run(f.plan, nil, argValues)
+}
+
+// MustRun executes the chain and panics if the args don't match the expected
+// arg values, see Func.MustRun.
+func (f *Frozen) MustRun(argValues ...interface{}) {
+	if err := f.Run(argValues...); err != nil {
+		panic(err)
+	}
+}
+
+// RunWith executes the chain with overrides, see Func.RunWith.
+func (f *Frozen) RunWith(overrides map[interface{}]interface{}, argValues ...interface{}) error {
+	return f.fn.run(f.plan, overrides, argValues)
+}
+
+// validate checks that the args of each step are provided by the preceding
+// steps.
+func (c Func) validate() error {
+	for i, s := range c.steps {
+		prefix := Func{c.steps[:i]}
+		var available map[reflect.Type]bool
+		var context string
+		switch s.typ {
+		case tPRE_HANDLER:
+			available, context = prefix.preHandlerTypesAvailable(), "Handler"
+		case tLAZY_PROVIDER:
+			available, context = prefix.preHandlerTypesAvailable(), "Lazily(...) arg"
+		case tPOST_HANDLER:
+			if s.late {
+				continue // missing args are passed as zero values
+			}
+			available, context = prefix.typesAvailable(), "Defer(...) arg"
+			available[errorType] = true
+		case tERROR_HANDLER:
+			available, context = prefix.typesAvailable(), "Error handler"
+			available[errorType] = true
+			if s.errTyp != nil {
+				available[s.errTyp] = true
+			}
+		case tERROR_MAPPER:
+			available, context = prefix.typesAvailable(), "Error mapper"
+			available[errorType] = true
+		case tPANIC_HANDLER:
+			if !s.val.IsValid() {
+				continue
+			}
+			available, context = prefix.typesAvailable(), "Panic handler"
+			available[errorType] = true
+			available[panicErrorType] = true
+		default:
+			continue
+		}
+		fn, err := valueOfFunction(s.handler())
+		if err != nil {
+			return fmt.Errorf("%s %v", context, err)
+		}
+		if err := checkCanCall(available, fn); err != nil {
+			return withContext(err, fmt.Sprintf("%s (step %d)", context, i+1))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package chain
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreeze(t *testing.T) {
+	type Note string
+	c := New().
+		Arg((*[]string)(nil)).
+		Arg("").
+		Then(func(s string) int { return len(s) }).
+		Then(func(n int, notes ...Note) Note { return Note(fmt.Sprintf("%d:%d", n, len(notes))) }).
+		Accumulate(Note("")).
+		Then(func(out *[]string, n Note, all []Note) { *out = append(*out, string(n), fmt.Sprint(all)) })
+
+	f, err := c.Freeze()
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var out []string
+			arg := fmt.Sprint(i * 100)
+			f.MustRun(&out, arg)
+			assert.Equal(t, []string{fmt.Sprintf("%d:0", len(arg)), fmt.Sprintf("[%d:0]", len(arg))}, out)
+		}(i)
+	}
+	wg.Wait()
+
+	var out []string
+	require.NoError(t, f.RunWith(map[interface{}]interface{}{0: 7}, &out, "x"))
+	assert.Equal(t, []string{"7:0", "[7:0]"}, out)
+	assert.EqualError(t, f.Run(&out), "missing args of types: [string]")
+
+	// The original chain is unaffected and the frozen chain can be extended.
+	out = nil
+	require.NoError(t, f.Func().Then(func(out *[]string) { *out = append(*out, "more") }).Run(&out, "ab"))
+	assert.Equal(t, []string{"2:0", "[2:0]", "more"}, out)
+}
+
+func TestFreezeValidates(t *testing.T) {
+	valid := New().Set(1).Then(func(int) {}).Defer(func(error, int) {})
+	_, err := valid.Freeze()
+	require.NoError(t, err)
+
+	// Dropping the step that provides the int invalidates the chain.
+	_, err = Func{valid.steps[1:]}.Freeze()
+	var buildErr *BuildError
+	require.ErrorAs(t, err, &buildErr)
+	assert.Equal(t, "Handler (step 1)", buildErr.Context)
+	assert.Equal(t, "int", buildErr.Missing.String())
+
+	_, err = Func{valid.steps[2:]}.Freeze()
+	require.ErrorAs(t, err, &buildErr)
+	assert.Equal(t, "Defer(...) arg (step 1)", buildErr.Context)
+}
+
+func TestFreezeSlots(t *testing.T) {
+	type User struct{ Name string }
+	var got []string
+	c := New().
+		Arg("").
+		Then(func(name string) (*User, error) { return &User{name}, nil }).
+		Defer(func(u *User, err error) { got = append(got, fmt.Sprint("defer: ", u.Name, " ", err)) }).
+		Then(func(u *User) { got = append(got, "hello "+u.Name) })
+
+	f, err := c.Freeze()
+	require.NoError(t, err)
+	for _, s := range f.fn.steps {
+		if s.typ == tPRE_HANDLER || s.typ == tPOST_HANDLER {
+			assert.Len(t, s.inSlots, s.valTyp.NumIn())
+			assert.Len(t, s.outSlots, s.valTyp.NumOut())
+		}
+	}
+	assert.Len(t, f.plan.slotOf, 3) // error, string, *User
+	assert.Equal(t, c, f.Func(), "the original chain doesn't use the slots")
+
+	f.MustRun("bob")
+	c.MustRun("alice")
+	assert.Equal(t, []string{"hello bob", "defer: bob <nil>", "hello alice", "defer: alice <nil>"}, got)
+}
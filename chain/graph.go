@@ -0,0 +1,168 @@
+package chain
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// GraphFormat selects the diagram syntax written by Graph.
+type GraphFormat int
+
+const (
+	DOT     GraphFormat = iota // Graphviz DOT
+	Mermaid                    // Mermaid flowchart
+)
+
+// graphEdge indicates that step `to` consumes a value of type typ that is
+// provided by step `from`.
+type graphEdge struct {
+	from, to int
+	typ      reflect.Type
+}
+
+// Graph writes a diagram of the chain's dependency injection to w. Each step
+// of the chain is a node, and each edge connects the step that provides a
+// value to a step that consumes it, labeled with the value's type. A consumed
+// value is attributed to the latest step registered before the consumer that
+// provides it. Since any handler may provide the error to error handlers and
+// deferred handlers, those error edges are omitted.
+//
+// For example, to render the chain using Graphviz:
+//
+//	c.Graph(os.Stdout, chain.DOT) // then: dot -Tsvg > chain.svg
+func (c Func) Graph(w io.Writer, format GraphFormat) error {
+	labels := make([]string, len(c.steps))
+	for i, s := range c.steps {
+		labels[i] = s.graphLabel()
+	}
+	edges := c.graphEdges()
+
+	switch format {
+	case DOT:
+		fmt.Fprintf(w, "digraph chain {\n")
+		for i, label := range labels {
+			shape := "box"
+			if c.steps[i].typ == tARG || c.steps[i].typ == tVALUE {
+				shape = "ellipse"
+			}
+			fmt.Fprintf(w, "  s%d [label=%s shape=%s];\n", i, dotQuote(label), shape)
+		}
+		for _, e := range edges {
+			fmt.Fprintf(w, "  s%d -> s%d [label=%s];\n", e.from, e.to, dotQuote(e.typ.String()))
+		}
+		fmt.Fprintf(w, "}\n")
+	case Mermaid:
+		fmt.Fprintf(w, "flowchart TD\n")
+		for i, label := range labels {
+			start, end := "[", "]"
+			if c.steps[i].typ == tARG || c.steps[i].typ == tVALUE {
+				start, end = "([", "])"
+			}
+			fmt.Fprintf(w, "  s%d%s%s%s\n", i, start, mermaidQuote(label), end)
+		}
+		for _, e := range edges {
+			fmt.Fprintf(w, "  s%d -->|%s| s%d\n", e.from, mermaidQuote(e.typ.String()), e.to)
+		}
+	default:
+		return fmt.Errorf("unknown graph format: %d", format)
+	}
+	return nil
+}
+
+func (c Func) graphEdges() []graphEdge {
+	var edges []graphEdge
+	providers := map[reflect.Type]int{}
+	var consume func(to int, t reflect.Type)
+	consume = func(to int, t reflect.Type) {
+		if from, ok := providers[t]; ok {
+			edges = append(edges, graphEdge{from, to, t})
+			return
+		}
+		// Injected structs consume their fields.
+		for _, f := range injectableFields(t) {
+			consume(to, t.Field(f).Type)
+		}
+	}
+	for i, s := range c.steps {
+		switch s.typ {
+		case tARG:
+			providers[s.valTyp] = i
+		case tVALUE:
+			providers[s.val.Type()] = i
+			providers[s.valTyp] = i
+		case tLABEL:
+			// labels don't consume or provide anything.
+		case tACCUMULATE:
+			providers[reflect.SliceOf(s.valTyp)] = i
+		case tPANIC_HANDLER:
+			if !s.val.IsValid() {
+				continue // recovery is disabled
+			}
+			for j := 0; j < s.valTyp.NumIn(); j++ {
+				if t := s.valTyp.In(j); t != errorType && t != panicErrorType {
+					consume(i, t)
+				}
+			}
+		default:
+			for j := 0; j < s.valTyp.NumIn(); j++ {
+				t := s.valTyp.In(j)
+				if t == errorType && (s.typ == tERROR_HANDLER || s.typ == tPOST_HANDLER ||
+					s.typ == tERROR_MAPPER) ||
+					t == s.errTyp {
+					continue
+				}
+				consume(i, t)
+			}
+			if s.typ == tPRE_HANDLER || s.typ == tLAZY_PROVIDER {
+				for j := 0; j < s.valTyp.NumOut(); j++ {
+					providers[s.valTyp.Out(j)] = i
+				}
+			}
+		}
+	}
+	return edges
+}
+
+func (s step) graphLabel() string {
+	switch s.typ {
+	case tARG:
+		return "arg: " + s.valTyp.String()
+	case tVALUE:
+		return "value: " + s.valTyp.String()
+	case tLABEL:
+		return "label: " + s.label
+	case tACCUMULATE:
+		return "accumulate: " + s.valTyp.String()
+	case tPANIC_HANDLER:
+		if !s.val.IsValid() {
+			return "no panic recovery"
+		}
+		return "on panic: " + filepath.Base(s.funcInfo().Name)
+	}
+	name := filepath.Base(s.funcInfo().Name)
+	switch s.typ {
+	case tPOST_HANDLER:
+		return "defer: " + name
+	case tERROR_HANDLER:
+		if s.errTyp != nil {
+			return "on " + s.errTyp.String() + ": " + name
+		}
+		return "on error: " + name
+	case tLAZY_PROVIDER:
+		return "lazy: " + name
+	case tERROR_MAPPER:
+		return "map error: " + name
+	}
+	return name
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
@@ -0,0 +1,65 @@
+package chain
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type graphUser struct{}
+
+func graphLoadUser(r *http.Request) (*graphUser, error) { return nil, nil }
+func graphServe(w http.ResponseWriter, u *graphUser)    {}
+func graphOnErr(w http.ResponseWriter, err error)       {}
+func graphLog(r *http.Request, err error)               {}
+
+func graphChain() Func {
+	return New().
+		Arg((*http.ResponseWriter)(nil)).
+		Arg((*http.Request)(nil)).
+		OnErr(graphOnErr).
+		Defer(graphLog).
+		Then(graphLoadUser).
+		Then(graphServe)
+}
+
+func TestGraphDOT(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, graphChain().Graph(&buf, DOT))
+	assert.Equal(t, `digraph chain {
+  s0 [label="arg: http.ResponseWriter" shape=ellipse];
+  s1 [label="arg: *http.Request" shape=ellipse];
+  s2 [label="on error: chain.graphOnErr" shape=box];
+  s3 [label="defer: chain.graphLog" shape=box];
+  s4 [label="chain.graphLoadUser" shape=box];
+  s5 [label="chain.graphServe" shape=box];
+  s0 -> s2 [label="http.ResponseWriter"];
+  s1 -> s3 [label="*http.Request"];
+  s1 -> s4 [label="*http.Request"];
+  s0 -> s5 [label="http.ResponseWriter"];
+  s4 -> s5 [label="*chain.graphUser"];
+}
+`, buf.String())
+}
+
+func TestGraphMermaid(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, graphChain().Graph(&buf, Mermaid))
+	assert.Equal(t, `flowchart TD
+  s0(["arg: http.ResponseWriter"])
+  s1(["arg: *http.Request"])
+  s2["on error: chain.graphOnErr"]
+  s3["defer: chain.graphLog"]
+  s4["chain.graphLoadUser"]
+  s5["chain.graphServe"]
+  s0 -->|"http.ResponseWriter"| s2
+  s1 -->|"*http.Request"| s3
+  s1 -->|"*http.Request"| s4
+  s0 -->|"http.ResponseWriter"| s5
+  s4 -->|"*chain.graphUser"| s5
+`, buf.String())
+
+	assert.Error(t, graphChain().Graph(&buf, GraphFormat(42)))
+}
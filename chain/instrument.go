@@ -0,0 +1,78 @@
+package chain
+
+import (
+	"context"
+	"time"
+)
+
+// StepStartFunc is called before each handler of an instrumented chain is
+// called. See Func.Instrument.
+type StepStartFunc func(ctx context.Context, handler FuncInfo)
+
+// StepEndFunc is called after each handler of an instrumented chain returns
+// with the duration of the call and the error that the handler returned, if
+// any. If the handler panicked, err is a PanicError. See Func.Instrument.
+type StepEndFunc func(ctx context.Context, handler FuncInfo, elapsed time.Duration, err error)
+
+type instrumentation struct {
+	info  FuncInfo
+	start StepStartFunc
+	end   StepEndFunc
+}
+
+// Instrument returns a copy of the chain where onStart and onEnd are called
+// around each handler call, including error handlers and deferred handlers.
+// Either may be nil. This allows measuring the latency of each middleware
+// handler, e.g. by recording a trace span for each:
+//
+//	c = c.Instrument(
+//	    func(ctx context.Context, h chain.FuncInfo) { ... start span ... },
+//	    func(ctx context.Context, h chain.FuncInfo, d time.Duration, err error) {
+//	        ... end span ...
+//	    })
+//
+// The ctx is the context of the run's args, if any, as used for cancellation
+// (see Func.Run), and otherwise context.Background(). The hooks are called
+// synchronously, so they add to the latency of the chain. Instrumenting a chain
+// replaces any previous instrumentation, and Instrument(nil, nil) removes it.
+//
+// Note that Code does not include instrumentation.
+func (c Func) Instrument(onStart StepStartFunc, onEnd StepEndFunc) Func {
+	steps := make([]step, len(c.steps))
+	copy(steps, c.steps)
+	for i, s := range steps {
+		if s.typ == tARG || s.typ == tVALUE || s.typ == tLABEL || s.typ == tACCUMULATE ||
+			!s.val.IsValid() {
+			continue
+		}
+		steps[i].inst = nil
+		if onStart != nil || onEnd != nil {
+			info, err := valueOfFunction(s.handler())
+			if err != nil {
+				continue
+			}
+			steps[i].inst = &instrumentation{info, onStart, onEnd}
+		}
+	}
+	return Func{steps}
+}
+
+func (in *instrumentation) begin(ctx context.Context) time.Time {
+	if in.start != nil {
+		in.start(contextOrBackground(ctx), in.info)
+	}
+	return time.Now()
+}
+
+func (in *instrumentation) finish(ctx context.Context, start time.Time, err error) {
+	if in.end != nil {
+		in.end(contextOrBackground(ctx), in.info, time.Since(start), err)
+	}
+}
+
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
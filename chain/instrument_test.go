@@ -0,0 +1,66 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func instrumentFails() error     { return errors.New("bad") }
+func instrumentHandle(err error) {}
+func instrumentDeferred()        {}
+func instrumentPanics()          { panic("boom") }
+
+func TestInstrument(t *testing.T) {
+	type key struct{}
+	var log []string
+	shortName := func(h FuncInfo) string { return h.Name[strings.LastIndex(h.Name, ".")+1:] }
+	onStart := func(ctx context.Context, h FuncInfo) {
+		log = append(log, fmt.Sprintf("start %s ctx=%v", shortName(h), ctx.Value(key{})))
+	}
+	onEnd := func(ctx context.Context, h FuncInfo, elapsed time.Duration, err error) {
+		assert.GreaterOrEqual(t, elapsed, time.Duration(0))
+		log = append(log, fmt.Sprintf("end %s err=%v", shortName(h), err))
+	}
+
+	c := New().
+		Arg((*context.Context)(nil)).
+		OnErr(instrumentHandle).
+		Defer(instrumentDeferred).
+		Then(instrumentFails)
+	instrumented := c.Instrument(onStart, onEnd)
+
+	ctx := context.WithValue(context.Background(), key{}, "req")
+	c.MustRun(ctx)
+	assert.Empty(t, log)
+
+	instrumented.MustRun(ctx)
+	assert.Equal(t, []string{
+		"start instrumentFails ctx=req",
+		"end instrumentFails err=bad",
+		"start instrumentHandle ctx=req",
+		"end instrumentHandle err=<nil>",
+		"start instrumentDeferred ctx=req",
+		"end instrumentDeferred err=<nil>",
+	}, log)
+
+	// Frozen chains are instrumented too, and panics are reported as errors.
+	log = nil
+	frozen, err := New().OnErr(instrumentHandle).Then(instrumentPanics).
+		Instrument(nil, onEnd).Freeze()
+	assert.NoError(t, err)
+	frozen.MustRun()
+	assert.Len(t, log, 2)
+	assert.True(t, strings.HasPrefix(log[0], "end instrumentPanics err=Panic executing middleware"), log[0])
+	assert.Equal(t, "end instrumentHandle err=<nil>", log[1])
+
+	// Instrumentation can be removed again.
+	log = nil
+	instrumented.Instrument(nil, nil).MustRun(ctx)
+	assert.Empty(t, log)
+}
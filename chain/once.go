@@ -0,0 +1,64 @@
+package chain
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Once wraps a provider function so that it's called at most once for the
+// lifetime of the returned handler, no matter how many times the chain is Run.
+// The results of the first successful call are cached and returned to all
+// subsequent calls. This is useful for lazy global initialization, such as
+// parsing templates or connecting to a database, without init-order problems:
+//
+//	mux.Use(chain.Once(func() (*TemplateCache, error) { ... }))
+//
+// Concurrent calls are serialized until the first call succeeds. If the
+// provider returns a non-nil error, the results are not cached and the next
+// call will try again. Any args required by the provider are injected as usual,
+// but only the args of the successful call matter.
+//
+// Since the results are shared by all runs, the provider must not return a
+// cleanup function or an io.Closer: the chain would call it at the end of the
+// first run, leaving later runs with a closed value. Once panics if it does.
+//
+// The returned handler can only be added to a chain, it can't be called
+// directly.
+func Once(provider interface{}) interface{} {
+	fn, err := valueOfFunction(provider)
+	if err != nil {
+		panicf("Once(...) arg %v", err)
+	}
+	for i := 0; i < fn.Func.Type().NumOut(); i++ {
+		if t := fn.Func.Type().Out(i); t == cleanupType || t == closerType {
+			panicf("Once(...) arg %s returns a %s, which would be cleaned up "+
+				"after the first run", fn.Name, t)
+		}
+	}
+	var (
+		mu      sync.Mutex
+		done    bool
+		results []reflect.Value
+	)
+	wrapper := reflect.MakeFunc(fn.Func.Type(), func(in []reflect.Value) []reflect.Value {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return results
+		}
+		var out []reflect.Value
+		if fn.Func.Type().IsVariadic() {
+			out = fn.Func.CallSlice(in)
+		} else {
+			out = fn.Func.Call(in)
+		}
+		for _, val := range out {
+			if val.Type() == errorType && !val.IsNil() {
+				return out
+			}
+		}
+		results, done = out, true
+		return results
+	})
+	return wrapHandler(wrapper, fn)
+}
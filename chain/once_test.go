@@ -0,0 +1,99 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnce(t *testing.T) {
+	type Cache struct{ n int }
+	calls := 0
+	failNext := true
+	load := func() (*Cache, error) {
+		calls++
+		if failNext {
+			failNext = false
+			return nil, errors.New("not yet")
+		}
+		return &Cache{calls}, nil
+	}
+
+	var got *Cache
+	var gotErr error
+	c := New().
+		OnErr(func(err error) { gotErr = err }).
+		Then(Once(load)).
+		Then(func(c *Cache) { got = c })
+
+	// The first call fails and isn't cached.
+	require.NoError(t, c.Run())
+	assert.EqualError(t, gotErr, "not yet")
+	assert.Nil(t, got)
+
+	// The next call succeeds and is cached for all subsequent runs.
+	gotErr = nil
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Run())
+		assert.NoError(t, gotErr)
+		require.NotNil(t, got)
+		assert.Equal(t, 2, got.n)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestOnceConcurrent(t *testing.T) {
+	calls := 0
+	c := New().Then(Once(func() int { calls++; return 42 }))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() { defer wg.Done(); c.MustRun() }()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, calls)
+}
+
+func TestOnceBadArg(t *testing.T) {
+	assert.Panics(t, func() { Once(5) })
+}
+
+func TestOnceRejectsCleanup(t *testing.T) {
+	assert.Panics(t, func() { Once(func() (func(), error) { return func() {}, nil }) })
+	assert.Panics(t, func() { Once(func() io.Closer { return nil }) })
+}
+
+func loadOnceConfig(path string) (*os.File, error) { panic("boom") }
+
+func TestOnceKeepsName(t *testing.T) {
+	once := Once(loadOnceConfig)
+
+	_, err := New().ThenE(once)
+	require.IsType(t, &BuildError{}, err)
+	assert.Contains(t, err.(*BuildError).Func.Name, "loadOnceConfig")
+	assert.NotContains(t, err.Error(), "makeFuncStub")
+
+	var caught error
+	c := New().Set("config.json").OnErr(func(e error) { caught = e }).Then(once)
+	var buf bytes.Buffer
+	require.NoError(t, c.Graph(&buf, Mermaid))
+	assert.Contains(t, buf.String(), "loadOnceConfig")
+	assert.NotContains(t, buf.String(), "makeFuncStub")
+
+	// The name is kept when the handler is appended to another chain.
+	buf.Reset()
+	require.NoError(t, New().Append(c).Graph(&buf, Mermaid))
+	assert.Contains(t, buf.String(), "loadOnceConfig")
+
+	require.NoError(t, c.Run())
+	require.IsType(t, PanicError{}, caught)
+	stack := caught.(PanicError).MiddlewareStack
+	require.NotEmpty(t, stack)
+	assert.Contains(t, stack[0].Name, "loadOnceConfig")
+}
@@ -0,0 +1,46 @@
+package chain
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var profileSink []byte
+
+func profileSlow()     { time.Sleep(5 * time.Millisecond) }
+func profileAllocs()   { profileSink = make([]byte, 1<<16) }
+func profileDeferred() {}
+
+func TestProfile(t *testing.T) {
+	p := NewProfiler()
+	c := New().Defer(profileDeferred).Then(profileSlow, profileAllocs)
+	unprofiled := c
+	c = c.Profile(p)
+	for i := 0; i < 3; i++ {
+		c.MustRun()
+		unprofiled.MustRun()
+	}
+
+	stats := p.Stats()
+	require.Len(t, stats, 3)
+	byName := map[string]StepStats{}
+	for _, s := range stats {
+		byName[s.Name[strings.LastIndex(s.Name, ".")+1:]] = s
+		assert.Equal(t, int64(3), s.Calls, s.Name)
+	}
+	assert.True(t, strings.HasSuffix(stats[0].Name, "profileSlow"), "slowest first: %v", stats)
+	assert.GreaterOrEqual(t, byName["profileSlow"].Duration, 15*time.Millisecond)
+	assert.GreaterOrEqual(t, byName["profileAllocs"].Bytes, uint64(3<<16))
+	assert.GreaterOrEqual(t, byName["profileAllocs"].Allocs, uint64(3))
+
+	p.Reset()
+	assert.Empty(t, p.Stats())
+
+	// Profiling can be removed again.
+	c.Profile(nil).MustRun()
+	assert.Empty(t, p.Stats())
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package chain
+
+// raceEnabled is set when testing with -race, which makes sync.Pool drop items
+// at random.
+const raceEnabled = true
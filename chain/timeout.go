@@ -0,0 +1,118 @@
+package chain
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"time"
+)
+
+// StepTimeoutError is the error returned by a handler wrapped with
+// WithStepTimeout that doesn't complete in time.
+type StepTimeoutError struct {
+	Handler string        // name of the handler function
+	Limit   time.Duration // the timeout that was exceeded
+}
+
+func (e StepTimeoutError) Error() string {
+	return fmt.Sprintf("%s did not complete within %v", e.Handler, e.Limit)
+}
+
+// Timeout reports that this error is a timeout, like net.Error.
+func (e StepTimeoutError) Timeout() bool { return true }
+
+// WithStepTimeout wraps a handler so that the chain fails with a
+// StepTimeoutError if the handler doesn't complete within d. This prevents a
+// single slow handler, such as a remote lookup, from stalling the whole chain
+// without bound:
+//
+//	c = c.Then(chain.WithStepTimeout(50*time.Millisecond, lookupGeoIP))
+//
+// The returned handler, which can only be added to a chain, accepts the same
+// args as the handler and returns the same values, plus an error if the
+// handler doesn't already return one. On a timeout, the zero values are
+// returned for all other results.
+//
+// The handler runs in a separate goroutine. Since it can't be forcibly
+// stopped, it keeps running after a timeout and its results are discarded, so
+// it must not write to args such as an http.ResponseWriter after the deadline.
+// Handlers that accept a context.Context should prefer to respect its
+// cancellation instead. If the handler panics before the deadline, the panic
+// is propagated to the chain with the handler's stack, or with its original
+// value if recovery is disabled with OnPanic(nil).
+func WithStepTimeout(d time.Duration, handler interface{}) interface{} {
+	fn, err := valueOfFunction(handler)
+	if err != nil {
+		panicf("WithStepTimeout(...) arg %v", err)
+	}
+	fnType := fn.Func.Type()
+	in := make([]reflect.Type, fnType.NumIn())
+	for i := range in {
+		in[i] = fnType.In(i)
+	}
+	out := make([]reflect.Type, 0, fnType.NumOut()+1)
+	errIndex := -1
+	for i := 0; i < fnType.NumOut(); i++ {
+		if fnType.Out(i) == errorType {
+			errIndex = i
+		}
+		out = append(out, fnType.Out(i))
+	}
+	addErr := errIndex < 0
+	if addErr {
+		errIndex = len(out)
+		out = append(out, errorType)
+	}
+
+	type result struct {
+		out      []reflect.Value
+		panicked *goroutinePanic
+	}
+	timeoutErr := error(StepTimeoutError{fn.Name, d})
+	wrapped := reflect.FuncOf(in, out, fnType.IsVariadic())
+	wrapper := reflect.MakeFunc(wrapped, func(args []reflect.Value) []reflect.Value {
+		done := make(chan result, 1) // buffered so that late handlers don't leak
+		go func() {
+			defer func() {
+				if x := recover(); x != nil {
+					done <- result{panicked: &goroutinePanic{x, debug.Stack()}}
+				}
+			}()
+			if fnType.IsVariadic() {
+				done <- result{out: fn.Func.CallSlice(args)}
+			} else {
+				done <- result{out: fn.Func.Call(args)}
+			}
+		}()
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case res := <-done:
+			if res.panicked != nil {
+				panic(*res.panicked)
+			}
+			if addErr {
+				res.out = append(res.out, reflect.Zero(errorType))
+			}
+			return res.out
+		case <-timer.C:
+			results := make([]reflect.Value, len(out))
+			for i, t := range out {
+				results[i] = reflect.Zero(t)
+			}
+			results[errIndex] = reflect.ValueOf(&timeoutErr).Elem()
+			return results
+		}
+	})
+	return wrapHandler(wrapper, fn)
+}
+
+// goroutinePanic is a panic recovered from a handler that ran in another
+// goroutine, such as by WithStepTimeout, and re-panicked in the chain's
+// goroutine. The stack is that of the handler's goroutine, which the
+// PanicError reports instead of the stack where it was re-panicked.
+type goroutinePanic struct {
+	val   interface{}
+	stack []byte
+}
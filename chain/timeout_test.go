@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithStepTimeout(t *testing.T) {
+	type Geo string
+	release := make(chan struct{})
+	defer close(release)
+	lookup := func(ip string) Geo {
+		if ip == "slow" {
+			<-release
+		}
+		return Geo("geo:" + ip)
+	}
+
+	var got Geo
+	var gotErr error
+	c := New().
+		Arg("").
+		OnErr(func(err error) { gotErr = err }).
+		Then(WithStepTimeout(20*time.Millisecond, lookup)).
+		Then(func(g Geo) { got = g })
+
+	require.NoError(t, c.Run("fast"))
+	assert.NoError(t, gotErr)
+	assert.Equal(t, Geo("geo:fast"), got)
+
+	got = ""
+	require.NoError(t, c.Run("slow"))
+	var timeout StepTimeoutError
+	require.True(t, errors.As(gotErr, &timeout), "%v", gotErr)
+	assert.Equal(t, 20*time.Millisecond, timeout.Limit)
+	assert.Contains(t, timeout.Handler, "TestWithStepTimeout")
+	assert.True(t, timeout.Timeout())
+	assert.Equal(t, Geo(""), got)
+
+	// Errors and panics from the handler are propagated.
+	gotErr = nil
+	c = New().
+		OnErr(func(err error) { gotErr = err }).
+		Then(WithStepTimeout(time.Second, func() (int, error) { return 0, errors.New("failed") }))
+	require.NoError(t, c.Run())
+	assert.EqualError(t, gotErr, "failed")
+
+	c = New().
+		Arg("").
+		OnErr(func(err error) { gotErr = err }).
+		Then(WithStepTimeout(time.Second, panicsInLookup))
+	require.NoError(t, c.Run("ip"))
+	var p PanicError
+	require.True(t, errors.As(gotErr, &p), "%v", gotErr)
+	assert.Equal(t, "boom", p.Val)
+	assert.Contains(t, p.MiddlewareStack[0].Name, "panicsInLookup")
+	assert.Contains(t, p.RawStack, "chain.panicsInLookup(",
+		"reports the stack of the handler's goroutine")
+
+	// Without recovery, the original value is propagated out of Run.
+	c = New().Arg("").OnPanic(nil).Then(WithStepTimeout(time.Second, panicsInLookup))
+	assert.PanicsWithValue(t, "boom", func() { _ = c.Run("ip") })
+
+	// Code uses the name of the wrapped handler.
+	var code bytes.Buffer
+	New().Arg("").Then(WithStepTimeout(time.Second, panicsInLookup)).Code("timeout", "chain", &code)
+	assert.Contains(t, code.String(), "err = panicsInLookup(str)")
+
+	assert.Panics(t, func() { WithStepTimeout(time.Second, 5) })
+}
+
+func panicsInLookup(ip string) (string, error) { panic("boom") }
@@ -13,6 +13,9 @@ func panicf(msgfmt string, args ...interface{}) {
 }
 
 func valueOfFunction(handler interface{}) (FuncInfo, error) {
+	if w, ok := handler.(wrappedHandler); ok {
+		return w.info, nil
+	}
 	if handler == nil {
 		return FuncInfo{}, fmt.Errorf("should be a function, handler is <nil>")
 	}
@@ -20,9 +23,54 @@ func valueOfFunction(handler interface{}) (FuncInfo, error) {
 	if !val.IsValid() || val.Kind() != reflect.Func {
 		return FuncInfo{}, fmt.Errorf("should be a function, handler is %s", val.Type())
 	}
+	return funcInfo(val), nil
+}
+
+// wrappedHandler is returned by wrappers such as Once instead of the function
+// that they create with reflect.MakeFunc, which is always named
+// reflect.makeFuncStub and so is useless for diagnostics. Its info describes
+// the wrapped handler, except that info.Func is the created function. The
+// chain keeps info on the step, see step.funcInfo.
+type wrappedHandler struct{ info FuncInfo }
+
+// wrapHandler returns the handler for wrapper, a function created by
+// reflect.MakeFunc that wraps the handler described by orig.
+func wrapHandler(wrapper reflect.Value, orig FuncInfo) interface{} {
+	return wrappedHandler{FuncInfo{orig.Name, orig.File, orig.Line, wrapper}}
+}
+
+// wrappedInfo returns the FuncInfo of handler if it was returned by a wrapper
+// such as Once, or nil.
+func wrappedInfo(handler interface{}) *FuncInfo {
+	if w, ok := handler.(wrappedHandler); ok {
+		return &w.info
+	}
+	return nil
+}
+
+// funcInfo describes the handler of s, using the name and location of the
+// wrapped handler if it was created by a wrapper such as Once.
+func (s step) funcInfo() FuncInfo {
+	if s.info != nil {
+		return *s.info
+	}
+	return funcInfo(s.val)
+}
+
+// handler returns the handler of s as it was provided, e.g. to add it to
+// another chain.
+func (s step) handler() interface{} {
+	if s.info != nil {
+		return wrappedHandler{*s.info}
+	}
+	return s.val.Interface()
+}
+
+// funcInfo describes the function val.
+func funcInfo(val reflect.Value) FuncInfo {
 	info := runtime.FuncForPC(val.Pointer())
 	file, line := info.FileLine(val.Pointer())
-	return FuncInfo{info.Name(), file, line, val}, nil
+	return FuncInfo{info.Name(), file, line, val}
 }
 
 func checkCanCall(available map[reflect.Type]bool, fn FuncInfo) error {
@@ -32,19 +80,38 @@ func checkCanCall(available map[reflect.Type]bool, fn FuncInfo) error {
 		if available[t] {
 			continue
 		}
+		// Variadic args accept all of the values of the type provided so far,
+		// which may be none.
+		if fn_typ.IsVariadic() && i == fn_typ.NumIn()-1 {
+			continue
+		}
+		argDesc := ordinalize(i+1) + " arg"
+
+		// Structs that aren't provided directly may have their exported fields
+		// injected instead.
+		if fields := injectableFields(t); fields != nil {
+			missing := -1
+			for _, f := range fields {
+				if !available[t.Field(f).Type] {
+					missing = f
+					break
+				}
+			}
+			if missing == -1 {
+				continue
+			}
+			argDesc = fmt.Sprintf("field %s of %s", t.Field(missing).Name, argDesc)
+			t = t.Field(missing).Type
+		}
 
 		// Un-oh, not available.  Let's see what we can do to make a helpful
 		// error message.
-		provided := []string{}
 		candidates := []string{}
 		for typ := range available {
-			provided = append(provided, typ.String())
 			if t.Kind() == reflect.Interface && typ.Implements(t) {
 				candidates = append(candidates, typ.String())
 			}
 		}
-		sort.Strings(provided)
-
 		suggestion := ""
 		if len(candidates) == 0 && t.Kind() == reflect.Interface {
 			suggestion = fmt.Sprintf(" Type %s is an interface, but not "+
@@ -62,9 +129,102 @@ func checkCanCall(available map[reflect.Type]bool, fn FuncInfo) error {
 				t, len(candidates), candidates)
 		}
 
-		return fmt.Errorf("can't be called: type %s required for %s arg "+
-			"of %s (%s) has not been provided.  Types that have been provided: %s. %s",
-			t, ordinalize(i+1), fn.Name, fn_typ, provided, suggestion)
+		providedTypes := make([]reflect.Type, 0, len(available))
+		for typ := range available {
+			providedTypes = append(providedTypes, typ)
+		}
+		sort.Slice(providedTypes, func(i, j int) bool {
+			return providedTypes[i].String() < providedTypes[j].String()
+		})
+		return &BuildError{
+			Func:       fn,
+			Arg:        argDesc,
+			Missing:    t,
+			Provided:   providedTypes,
+			Suggestion: suggestion,
+		}
 	}
 	return nil
 }
+
+// BuildError describes a handler that can't be added to a chain because a
+// type that it requires has not been provided. It's returned by the
+// non-panicking variants such as ThenE and OnErrE, and is the panic value of
+// Then, OnErr, etc. in that case. It includes enough detail for frameworks
+// that embed chains to report configuration errors.
+type BuildError struct {
+	// Context describes where the handler was being added, e.g. "1st arg of
+	// With(...)" or "Error handler".
+	Context string
+	// Func is the handler that can't be called, including its file and line.
+	Func FuncInfo
+	// Arg describes the arg that requires the missing type, e.g. "2nd arg" or
+	// "field DB of 1st arg".
+	Arg string
+	// Missing is the type that has not been provided.
+	Missing reflect.Type
+	// Provided are all of the types that have been provided, sorted by name.
+	Provided []reflect.Type
+	// Suggestion is a hint for fixing the problem, if any.
+	Suggestion string
+}
+
+func (e *BuildError) Error() string {
+	provided := make([]string, len(e.Provided))
+	for i, t := range e.Provided {
+		provided[i] = t.String()
+	}
+	msg := fmt.Sprintf("can't be called: type %s required for %s "+
+		"of %s (%s) at %s:%d has not been provided.  Types that have been provided: %s. %s",
+		e.Missing, e.Arg, e.Func.Name, e.Func.Func.Type(), e.Func.File, e.Func.Line,
+		provided, e.Suggestion)
+	if e.Context != "" {
+		msg = e.Context + " " + msg
+	}
+	return msg
+}
+
+// withContext sets the context of err if it's a *BuildError, or otherwise
+// prepends the context to the message.
+func withContext(err error, context string) error {
+	if e, ok := err.(*BuildError); ok {
+		e.Context = context
+		return e
+	}
+	return fmt.Errorf("%s %v", context, err)
+}
+
+// injectableFields returns the indexes of the exported fields of t if t is a
+// struct that can be injected field-by-field, or nil otherwise. A struct is
+// injectable if it has at least one exported field.
+func injectableFields(t reflect.Type) []int {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+
+// injectStruct creates a value of struct type t with its exported fields
+// populated from data. It returns an invalid value if t isn't injectable or any
+// of the field values are missing.
+func injectStruct(t reflect.Type, data *valueStore) reflect.Value {
+	fields := injectableFields(t)
+	if fields == nil {
+		return reflect.Value{}
+	}
+	v := reflect.New(t).Elem()
+	for _, f := range fields {
+		val := data.get(t.Field(f).Type)
+		if !val.IsValid() {
+			return reflect.Value{}
+		}
+		v.Field(f).Set(val)
+	}
+	return v
+}
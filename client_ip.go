@@ -0,0 +1,59 @@
+package sandwich
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP is the IP address of the client that made a request, e.g.
+// "203.0.113.7". It's provided by TrustedProxies and by the routers created
+// by NewWithConfig, including TheUsual.
+type ClientIP string
+
+// TrustedProxies returns a middleware handler that provides the ClientIP of
+// the request and records it as the LogEntry's RemoteIp. The proxies are the
+// IP addresses or CIDR ranges, e.g. "10.0.0.0/8", of the reverse proxies in
+// front of the server. The X-Forwarded-For and X-Real-IP headers are only
+// trusted if the request was received from one of them, since they're trivially
+// spoofed otherwise. The client is the right-most address in X-Forwarded-For
+// that isn't a trusted proxy, so that addresses added by the client itself are
+// ignored. For example:
+//
+//	mux := sandwich.BuildYourOwn()
+//	mux.Use(sandwich.WrapResponseWriter, sandwich.LogRequests)
+//	mux.Use(sandwich.TrustedProxies("10.0.0.0/8"))
+//	mux.Get("/", func(ip sandwich.ClientIP) { ... })
+//
+// With no proxies, the headers are never trusted. It panics if a proxy is
+// invalid. Routers created by NewWithConfig already provide the ClientIP as
+// configured by Config.TrustedProxies.
+func TrustedProxies(proxies ...string) func(r *http.Request, e *LogEntry) ClientIP {
+	p, err := parseTrustedProxies(proxies)
+	if err != nil {
+		panic(err)
+	}
+	if p == nil {
+		p = trustedProxies{}
+	}
+	return func(r *http.Request, e *LogEntry) ClientIP {
+		ip := p.clientIP(r)
+		e.RemoteIp = string(ip)
+		return ip
+	}
+}
+
+// clientIP returns the ClientIP of the request. If p is nil, all forwarding
+// headers are trusted, as with TheUsual.
+func (p trustedProxies) clientIP(r *http.Request) ClientIP {
+	addr := remoteIp(r)
+	if p != nil {
+		addr = p.remoteIp(r)
+	}
+	addr, _, _ = strings.Cut(addr, ",")
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return ClientIP(addr)
+}
@@ -0,0 +1,55 @@
+package sandwich
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	var got ClientIP
+	var logged LogEntry
+	r := BuildYourOwn()
+	r.SetLogSink(LogSinkFunc(func(e LogEntry) { logged = e }))
+	r.Use(WrapResponseWriter, LogRequests, TrustedProxies("10.0.0.0/8", "192.168.1.1"))
+	r.Get("/", func(ip ClientIP) { got = ip })
+	usual := TheUsual()
+	usual.Use(NoLog)
+	usual.Get("/", func(ip ClientIP) { got = ip })
+
+	tests := []struct {
+		remoteAddr, forwardedFor, realIP string
+		want                             ClientIP
+	}{
+		{"203.0.113.7:1234", "", "", "203.0.113.7"},
+		// Untrusted clients can't spoof their address.
+		{"203.0.113.7:1234", "1.2.3.4", "5.6.7.8", "203.0.113.7"},
+		{"10.1.2.3:1234", "198.51.100.1", "", "198.51.100.1"},
+		// Addresses prepended by the client are ignored.
+		{"10.1.2.3:1234", "1.2.3.4, 198.51.100.1, 10.9.9.9", "", "198.51.100.1"},
+		{"192.168.1.1:1234", "", "198.51.100.2", "198.51.100.2"},
+		{"[2001:db8::1]:443", "", "", "2001:db8::1"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if test.realIP != "" {
+			req.Header.Set("X-Real-IP", test.realIP)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, test.want, got, "%+v", test)
+		assert.Equal(t, string(test.want), logged.RemoteIp, "%+v", test)
+	}
+
+	// TheUsual trusts the headers of all requests.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 10.9.9.9")
+	usual.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, ClientIP("1.2.3.4"), got)
+
+	assert.Panics(t, func() { TrustedProxies("localhost") })
+}
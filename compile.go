@@ -0,0 +1,79 @@
+package sandwich
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// Handlers registered with a Router are compiled so that the common handler
+// signatures below are called directly instead of via reflect.Value.Call, see
+// chain.Func.Compile and BenchmarkCompile. Additional signatures may be
+// registered by using chain.RegisterFastCall.
+func init() {
+	chain.RegisterFastCall((func(http.ResponseWriter, *http.Request))(nil),
+		func(fn any) chain.FastCaller {
+			f := fn.(func(http.ResponseWriter, *http.Request))
+			return func(in []reflect.Value) []reflect.Value {
+				f(chain.As[http.ResponseWriter](in[0]), chain.As[*http.Request](in[1]))
+				return nil
+			}
+		})
+	chain.RegisterFastCall((func(http.ResponseWriter, *http.Request, Params))(nil),
+		func(fn any) chain.FastCaller {
+			f := fn.(func(http.ResponseWriter, *http.Request, Params))
+			return func(in []reflect.Value) []reflect.Value {
+				f(chain.As[http.ResponseWriter](in[0]), chain.As[*http.Request](in[1]), chain.As[Params](in[2]))
+				return nil
+			}
+		})
+	chain.RegisterFastCall((func(http.ResponseWriter, *http.Request, *LogEntry, error))(nil),
+		func(fn any) chain.FastCaller {
+			f := fn.(func(http.ResponseWriter, *http.Request, *LogEntry, error))
+			return func(in []reflect.Value) []reflect.Value {
+				f(chain.As[http.ResponseWriter](in[0]), chain.As[*http.Request](in[1]),
+					chain.As[*LogEntry](in[2]), chain.As[error](in[3]))
+				return nil
+			}
+		})
+	chain.RegisterFastCall((func(http.ResponseWriter) (http.ResponseWriter, *ResponseWriter))(nil),
+		func(fn any) chain.FastCaller {
+			f := fn.(func(http.ResponseWriter) (http.ResponseWriter, *ResponseWriter))
+			return func(in []reflect.Value) []reflect.Value {
+				w, rw := f(chain.As[http.ResponseWriter](in[0]))
+				return []reflect.Value{reflect.ValueOf(w), reflect.ValueOf(rw)}
+			}
+		})
+	chain.RegisterFastCall((func(*http.Request) *LogEntry)(nil),
+		func(fn any) chain.FastCaller {
+			f := fn.(func(*http.Request) *LogEntry)
+			return func(in []reflect.Value) []reflect.Value {
+				return []reflect.Value{reflect.ValueOf(f(chain.As[*http.Request](in[0])))}
+			}
+		})
+	chain.RegisterFastCall((func(*LogEntry))(nil),
+		func(fn any) chain.FastCaller {
+			f := fn.(func(*LogEntry))
+			return func(in []reflect.Value) []reflect.Value {
+				f(chain.As[*LogEntry](in[0]))
+				return nil
+			}
+		})
+	chain.RegisterFastCall((func(*LogEntry, *ResponseWriter))(nil),
+		func(fn any) chain.FastCaller {
+			f := fn.(func(*LogEntry, *ResponseWriter))
+			return func(in []reflect.Value) []reflect.Value {
+				f(chain.As[*LogEntry](in[0]), chain.As[*ResponseWriter](in[1]))
+				return nil
+			}
+		})
+	chain.RegisterFastCall((func(*LogEntry, *ResponseWriter, LogSink))(nil),
+		func(fn any) chain.FastCaller {
+			f := fn.(func(*LogEntry, *ResponseWriter, LogSink))
+			return func(in []reflect.Value) []reflect.Value {
+				f(chain.As[*LogEntry](in[0]), chain.As[*ResponseWriter](in[1]), chain.As[LogSink](in[2]))
+				return nil
+			}
+		})
+}
@@ -0,0 +1,71 @@
+package sandwich
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// If returns a ChainMutation that runs the handlers only if the predicate
+// returns true. The predicate is a function that returns a bool and, like any
+// other handler, may accept any types provided earlier in the chain. For
+// example, to only rate limit anonymous users:
+//
+//	mux.Use(LoadUser, sandwich.If(isAnonymous, rateLimit))
+//
+// where isAnonymous is a func(*User) bool. If the handlers are skipped, any
+// types that they would have provided are set to their zero values unless they
+// were already provided, and any defer'd handlers they register are not run.
+func If(predicate any, handlers ...any) ChainMutation {
+	return conditional{predicate, false, handlers}
+}
+
+// Unless is the opposite of If: it returns a ChainMutation that runs the
+// handlers only if the predicate returns false.
+func Unless(predicate any, handlers ...any) ChainMutation {
+	return conditional{predicate, true, handlers}
+}
+
+type conditional struct {
+	predicate any
+	negate    bool
+	handlers  []any
+}
+
+// numConditionals is used to generate unique labels for conditionals.
+var numConditionals int64
+
+func (m conditional) Apply(c chain.Func) chain.Func {
+	label := fmt.Sprintf("sandwich.If#%d", atomic.AddInt64(&numConditionals, 1))
+	c = c.Then(m.gate(label))
+	c = apply(c, m.handlers...)
+	return c.Label(label)
+}
+
+// gate returns a handler with the same args as the predicate that returns
+// SkipTo(label) if the handlers should not run.
+func (m conditional) gate(label string) any {
+	pred := reflect.ValueOf(m.predicate)
+	typ := pred.Type()
+	if typ.Kind() != reflect.Func || typ.NumOut() != 1 || typ.Out(0).Kind() != reflect.Bool {
+		panic(fmt.Errorf("If/Unless predicate must be a func that returns a bool, got %T",
+			m.predicate))
+	}
+	in := make([]reflect.Type, typ.NumIn())
+	for i := range in {
+		in[i] = typ.In(i)
+	}
+	errType := reflect.TypeOf((*error)(nil)).Elem()
+	skip := chain.SkipTo(label)
+	run := []reflect.Value{reflect.Zero(errType)}
+	dontRun := []reflect.Value{reflect.ValueOf(&skip).Elem()}
+	gateType := reflect.FuncOf(in, []reflect.Type{errType}, false)
+	return reflect.MakeFunc(gateType, func(args []reflect.Value) []reflect.Value {
+		if pred.Call(args)[0].Bool() != m.negate {
+			return run
+		}
+		return dontRun
+	}).Interface()
+}
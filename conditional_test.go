@@ -0,0 +1,37 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfUnless(t *testing.T) {
+	type User string
+	loadUser := func(r *http.Request) User { return User(r.URL.Query().Get("user")) }
+	isAnon := func(u User) bool { return u == "" }
+	write := func(msg string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) { _, _ = w.Write([]byte(msg)) }
+	}
+
+	r := TheUsual()
+	r.Use(NoLog, loadUser)
+	r.Get("/",
+		If(isAnon, write("anon "), func() int { return 1 }),
+		Unless(isAnon, write("user ")),
+		func(w http.ResponseWriter, n int, u User) { _, _ = w.Write([]byte(string(u) + "!")) },
+	)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "anon !", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/?user=bob", nil))
+	assert.Equal(t, "user bob!", w.Body.String())
+
+	assert.Panics(t, func() { r.Get("/bad", If(func() string { return "" })) })
+	assert.Panics(t, func() { r.Get("/bad", Unless(5)) })
+}
@@ -0,0 +1,302 @@
+package sandwich
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config configures the middleware of a router created by NewWithConfig. The
+// zero Config is the composition used by TheUsual, except that it doesn't
+// trust forwarding headers, see TrustForwardedHeaders.
+type Config struct {
+	// Logger writes the log entry of each request, see Router.SetLogSink. If
+	// nil, WriteLog is used. To disable logging while still providing
+	// *LogEntry to handlers, use a function that does nothing.
+	Logger func(LogEntry)
+	// ErrorHandler handles errors returned by handlers, see Router.OnErr. If
+	// nil, HandleError is used.
+	ErrorHandler any
+	// Compress enables gzip compression of responses for clients that accept
+	// it, see Gzip.
+	Compress bool
+	// TrustedProxies are the IP addresses or CIDR ranges, e.g. "10.0.0.0/8", of
+	// reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted to
+	// determine the client's address for LogEntry.RemoteIp and the ClientIP
+	// provided to handlers, see TrustedProxies. The headers are ignored unless
+	// the request was received from a trusted proxy.
+	TrustedProxies []string
+	// TrustForwardedHeaders trusts the X-Real-IP and X-Forwarded-For headers of
+	// all requests, as TheUsual does. This is only safe if the server can only
+	// be reached through a reverse proxy that sets them, since clients can
+	// spoof them otherwise. It can't be combined with TrustedProxies. If
+	// neither is set, the headers are ignored.
+	TrustForwardedHeaders bool
+	// Timeout, if positive, limits the duration of each request, see Timeout.
+	// When it elapses, the request's context is canceled, the client is sent
+	// a 503, and no further handlers are called.
+	Timeout time.Duration
+	// MaxBodySize, if positive, limits the size of the request body of all
+	// routes, see MaxBodySize. Routes may override it with their own
+	// MaxBodySize.
+	MaxBodySize int64
+	// AsyncLog, if set, writes log entries in a background goroutine using an
+	// AsyncLogSink with these options, so that writing logs doesn't add
+	// latency to requests. The router's Shutdown writes the remaining entries.
+	AsyncLog *AsyncLogOptions
+	// RequestID enables AssignRequestID for all routes, which provides a
+	// RequestID and records it in the log entry of each request.
+	RequestID bool
+	// Stats enables collecting the stats of all routes in DefaultStats, see
+	// Router.CollectStats.
+	Stats bool
+	// StatsEndpoint, if set, is the path of an endpoint that serves the stats
+	// collected in DefaultStats, e.g. "/debug/sandwich/stats". See ServeStats.
+	// It doesn't enable collection by itself.
+	StatsEndpoint string
+	// Metrics, if set, is called with the completed log entry of each request,
+	// including requests that are not logged because of NoLog. It's called
+	// synchronously after the entry is written.
+	Metrics func(LogEntry)
+	// DevErrors makes error responses include internal details, such as panic
+	// stacks, for debugging during development. See Router.SetErrorMode.
+	DevErrors bool
+	// QuietPaths are request paths, e.g. "/favicon.ico", whose requests are
+	// only logged if they fail, see QuietWhen.
+	QuietPaths []string
+	// HealthPaths are the paths of health check endpoints to register, e.g.
+	// "/healthz" and "/readyz". They respond with "ok" if HealthCheck succeeds
+	// and with a 503 otherwise, and are only logged if they fail, so that load
+	// balancer probes don't dominate the log.
+	HealthPaths []string
+	// HealthCheck, if set, is called by the HealthPaths endpoints to check
+	// whether the server is healthy.
+	HealthCheck func(ctx context.Context) error
+}
+
+// NewWithConfig returns a router initialized with the middleware described by
+// cfg, or an error if cfg is invalid. TheUsual is equivalent to
+// NewWithConfig(Config{TrustForwardedHeaders: true}). For example:
+//
+//	mux, err := sandwich.NewWithConfig(sandwich.Config{
+//	    ErrorHandler:   sandwich.HandleErrorJson,
+//	    Compress:       true,
+//	    TrustedProxies: []string{"10.0.0.0/8"},
+//	    Timeout:        30 * time.Second,
+//	})
+func NewWithConfig(cfg Config) (Router, error) {
+	if cfg.Timeout < 0 {
+		return nil, fmt.Errorf("Invalid config: negative Timeout %v", cfg.Timeout)
+	}
+	if cfg.MaxBodySize < 0 {
+		return nil, fmt.Errorf("Invalid config: negative MaxBodySize %d", cfg.MaxBodySize)
+	}
+	if cfg.TrustForwardedHeaders && len(cfg.TrustedProxies) > 0 {
+		return nil, fmt.Errorf("Invalid config: both TrustForwardedHeaders and TrustedProxies are set")
+	}
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config: %v", err)
+	}
+	if proxies == nil && !cfg.TrustForwardedHeaders {
+		proxies = trustedProxies{} // trust no one
+	}
+	errorHandler := cfg.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = HandleError
+	}
+
+	r := BuildYourOwn().(*router)
+	if sink := cfg.logSink(); sink != nil {
+		r.SetLogSink(sink)
+	}
+	r.Use(WrapResponseWriter, cfg.logRequests(proxies), proxies.clientIP, ProvideRespond)
+	base, err := r.base.OnErrE(toHandlerFunc(errorHandler))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config: ErrorHandler: %w", err)
+	}
+	r.setBase(base)
+	if cfg.DevErrors {
+		r.SetErrorMode(DevErrors)
+	}
+	if len(cfg.QuietPaths) > 0 {
+		r.Use(QuietWhen(quietPaths(cfg.QuietPaths)))
+	}
+	if cfg.RequestID {
+		r.Use(AssignRequestID)
+	}
+	if cfg.Timeout > 0 {
+		r.Use(Timeout(cfg.Timeout))
+	}
+	if cfg.MaxBodySize > 0 {
+		r.Use(MaxBodySize(cfg.MaxBodySize))
+	}
+	if cfg.Compress {
+		r.Use(Gzip)
+	}
+	if cfg.Stats {
+		r.CollectStats(DefaultStats)
+	}
+	if cfg.StatsEndpoint != "" {
+		if err := r.TryOn(http.MethodGet, cfg.StatsEndpoint, ServeStats(DefaultStats)); err != nil {
+			return nil, fmt.Errorf("Invalid config: StatsEndpoint: %w", err)
+		}
+	}
+	for _, path := range cfg.HealthPaths {
+		if err := r.TryOn(http.MethodGet, path, QuietWhen(Succeeded), healthCheck(cfg.HealthCheck)); err != nil {
+			return nil, fmt.Errorf("Invalid config: HealthPaths: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// quietPaths returns a QuietWhen func that suppresses the successful requests
+// of paths.
+func quietPaths(paths []string) func(e LogEntry) bool {
+	quiet := map[string]bool{}
+	for _, path := range paths {
+		quiet[path] = true
+	}
+	return func(e LogEntry) bool { return quiet[e.Request.URL.Path] && Succeeded(e) }
+}
+
+// healthCheck returns the handler of the health check endpoints.
+func healthCheck(check func(ctx context.Context) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if check != nil {
+			if err := check(r.Context()); err != nil {
+				return Error{
+					Code:      http.StatusServiceUnavailable,
+					ClientMsg: http.StatusText(http.StatusServiceUnavailable),
+					LogMsg:    "Health check failed",
+					Cause:     err,
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, err := w.Write([]byte("ok\n"))
+		return err
+	}
+}
+
+// logSink returns the LogSink for the config, or nil for the default.
+func (cfg Config) logSink() LogSink {
+	if cfg.Logger == nil && cfg.Metrics == nil && cfg.AsyncLog == nil {
+		return nil
+	}
+	var sink LogSink = defaultLogSink
+	if cfg.Logger != nil {
+		sink = LogSinkFunc(cfg.Logger)
+	}
+	if cfg.AsyncLog != nil {
+		sink = NewAsyncLogSink(sink, *cfg.AsyncLog)
+	}
+	if cfg.Metrics == nil {
+		return sink
+	}
+	return metricsLogSink{sink, cfg.Metrics}
+}
+
+// metricsLogSink writes entries to a LogSink and reports them to a metrics
+// function. It forwards Shutdown to the LogSink, see AsyncLogSink.
+type metricsLogSink struct {
+	LogSink
+	metrics func(LogEntry)
+}
+
+func (m metricsLogSink) Write(e LogEntry) {
+	m.LogSink.Write(e)
+	m.metrics(e)
+}
+
+func (m metricsLogSink) Shutdown(ctx context.Context) error {
+	if s, ok := m.LogSink.(Shutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	return nil
+}
+
+// logRequests returns the LogRequests wrap adjusted for the config.
+func (cfg Config) logRequests(proxies trustedProxies) ChainMutation {
+	if proxies == nil {
+		return LogRequests
+	}
+	return Prioritized(Wrap{
+		Before: func(r *http.Request) *LogEntry {
+			e := NewLogEntry(r)
+			e.RemoteIp = proxies.remoteIp(r)
+			return e
+		},
+		After: (*LogEntry).CommitTo,
+	}, logRequestsPriority)
+}
+
+// trustedProxies are the networks of reverse proxies whose forwarding headers
+// are trusted.
+type trustedProxies []*net.IPNet
+
+func parseTrustedProxies(addrs []string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", addr)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", addr, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) trusts(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIp is like the package-level remoteIp, but only trusts the forwarding
+// headers of requests received from a trusted proxy. The client is the
+// right-most address in X-Forwarded-For that isn't a trusted proxy.
+func (p trustedProxies) remoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !p.trusts(host) {
+		return r.RemoteAddr
+	}
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		addrs := strings.Split(strings.Join(fwd, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			if addr := strings.TrimSpace(addrs[i]); !p.trusts(addr) || i == 0 {
+				return addr
+			}
+		}
+	}
+	if addr := r.Header.Get("X-Real-IP"); addr != "" {
+		return addr
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,165 @@
+package sandwich
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWithConfig(t *testing.T) {
+	var logged, measured []LogEntry
+	r, err := NewWithConfig(Config{
+		Logger:         func(e LogEntry) { logged = append(logged, e) },
+		ErrorHandler:   HandleErrorJson,
+		Compress:       true,
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+		Timeout:        time.Minute,
+		Metrics:        func(e LogEntry) { measured = append(measured, e) },
+	})
+	require.NoError(t, err)
+	r.Get("/deadline", func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Context().Deadline()
+		assert.True(t, ok)
+		_, _ = w.Write([]byte("ok"))
+	})
+	r.Get("/fail", func() error { return errors.New("oops") })
+	r.Get("/quiet", NoLog)
+
+	req := httptest.NewRequest("GET", "/deadline", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8, 192.168.1.1")
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	req = httptest.NewRequest("GET", "/fail", nil)
+	req.RemoteAddr = "1.1.1.1:5000"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/quiet", nil))
+
+	require.Len(t, logged, 3)
+	assert.Equal(t, "5.6.7.8", logged[0].RemoteIp)
+	assert.Equal(t, "1.1.1.1:5000", logged[1].RemoteIp) // untrusted peer
+	assert.EqualError(t, logged[1].Error, "(500) Failure: oops")
+	assert.True(t, logged[2].Quiet)
+	assert.Equal(t, logged, measured)
+}
+
+func TestNewWithConfigTimeout(t *testing.T) {
+	r, err := NewWithConfig(Config{Logger: func(LogEntry) {}, Timeout: time.Millisecond})
+	require.NoError(t, err)
+	var ctxErr error
+	r.Get("/slow", func(r *http.Request) {
+		<-r.Context().Done()
+		ctxErr = r.Context().Err()
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	assert.Equal(t, context.DeadlineExceeded, ctxErr)
+
+	// Handlers that follow a slow one aren't called after the deadline.
+	var called []string
+	r.Get("/chain",
+		func() { called = append(called, "slow"); time.Sleep(10 * time.Millisecond) },
+		func() { called = append(called, "next") })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/chain", nil))
+	assert.Equal(t, []string{"slow"}, called)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestNewWithConfigForwardedHeaders(t *testing.T) {
+	var got ClientIP
+	var logged LogEntry
+	serve := func(cfg Config) {
+		cfg.Logger = func(e LogEntry) { logged = e }
+		r, err := NewWithConfig(cfg)
+		require.NoError(t, err)
+		r.Get("/", func(ip ClientIP) { got = ip })
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve(Config{})
+	assert.Equal(t, ClientIP("203.0.113.7"), got)
+	assert.Equal(t, "203.0.113.7:1234", logged.RemoteIp)
+
+	serve(Config{TrustForwardedHeaders: true})
+	assert.Equal(t, ClientIP("1.2.3.4"), got)
+	assert.Equal(t, "1.2.3.4", logged.RemoteIp)
+
+	_, err := NewWithConfig(Config{TrustForwardedHeaders: true, TrustedProxies: []string{"10.0.0.0/8"}})
+	assert.EqualError(t, err, "Invalid config: both TrustForwardedHeaders and TrustedProxies are set")
+}
+
+func TestNewWithConfigHealthPaths(t *testing.T) {
+	var logged []LogEntry
+	var unhealthy error
+	r, err := NewWithConfig(Config{
+		Logger:      func(e LogEntry) { logged = append(logged, e) },
+		QuietPaths:  []string{"/favicon.ico"},
+		HealthPaths: []string{"/healthz", "/readyz"},
+		HealthCheck: func(ctx context.Context) error { return unhealthy },
+	})
+	require.NoError(t, err)
+	r.Get("/favicon.ico", func(w http.ResponseWriter) {})
+	r.Get("/", func(w http.ResponseWriter) {})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := serve("GET", path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "ok\n", w.Body.String())
+	}
+	assert.Equal(t, http.StatusOK, serve("HEAD", "/healthz").Code)
+	serve("GET", "/favicon.ico")
+	serve("GET", "/")
+
+	unhealthy = errors.New("db down")
+	assert.Equal(t, http.StatusServiceUnavailable, serve("GET", "/readyz").Code)
+
+	var shown []string
+	for _, e := range logged {
+		if !e.Quiet {
+			shown = append(shown, e.Request.URL.Path)
+		}
+	}
+	assert.Equal(t, []string{"/", "/readyz"}, shown)
+	assert.Contains(t, logged[len(logged)-1].Error.Error(), "db down")
+
+	_, err = NewWithConfig(Config{HealthPaths: []string{"/healthz", "/healthz"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid config: HealthPaths: ")
+}
+
+func TestNewWithConfigErrors(t *testing.T) {
+	_, err := NewWithConfig(Config{Timeout: -time.Second})
+	assert.EqualError(t, err, "Invalid config: negative Timeout -1s")
+	_, err = NewWithConfig(Config{MaxBodySize: -1})
+	assert.EqualError(t, err, "Invalid config: negative MaxBodySize -1")
+	_, err = NewWithConfig(Config{TrustedProxies: []string{"10.0.0.0/33"}})
+	assert.EqualError(t, err, `Invalid config: invalid trusted proxy "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33`)
+	_, err = NewWithConfig(Config{TrustedProxies: []string{"localhost"}})
+	assert.EqualError(t, err, `Invalid config: invalid trusted proxy "localhost"`)
+	type User struct{}
+	_, err = NewWithConfig(Config{ErrorHandler: func(err error, u *User) {}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid config: ErrorHandler")
+}
@@ -0,0 +1,80 @@
+package sandwich
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ContinueGate holds back the "100 Continue" response to a request with
+// "Expect: 100-continue" until the request is approved, see HoldContinue.
+type ContinueGate struct {
+	mu       sync.Mutex
+	approved bool
+	expected bool
+}
+
+// HoldContinue is a middleware handler that defers the "100 Continue" response
+// to requests with "Expect: 100-continue" until ApproveContinue is reached, so
+// that clients don't upload a large body that the server is going to reject.
+// For example:
+//
+//	mux.Use(sandwich.HoldContinue)
+//	mux.Post("/upload", requireUser, sandwich.MaxBodySize(100<<20),
+//	    sandwich.ApproveContinue, handleUpload)
+//
+// Here, a request without valid credentials fails with a 401, or a request that
+// declares a larger Content-Length with a 413, before the client sends the
+// body. Once the request is approved, the "100 Continue" is sent when the body
+// is first read, which is when the Go http server sends it.
+//
+// Until then, reading the body fails with a 500 Error instead of sending the
+// "100 Continue", since it means that a handler before ApproveContinue needs
+// the body. Requests without "Expect: 100-continue" aren't affected.
+func HoldContinue(r *http.Request) *ContinueGate {
+	g := &ContinueGate{}
+	if strings.EqualFold(r.Header.Get("Expect"), "100-continue") && r.Body != nil && r.Body != http.NoBody {
+		g.expected = true
+		r.Body = &heldBody{r.Body, g}
+	}
+	return g
+}
+
+// ApproveContinue is a middleware handler that approves a request held by
+// HoldContinue, so that reading its body sends the "100 Continue".
+func ApproveContinue(g *ContinueGate) { g.Approve() }
+
+// Approve allows the body of the request to be read, see ApproveContinue.
+func (g *ContinueGate) Approve() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.approved = true
+}
+
+// Expected reports whether the client is waiting for a "100 Continue" before
+// sending the body.
+func (g *ContinueGate) Expected() bool { return g.expected }
+
+func (g *ContinueGate) isApproved() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.approved
+}
+
+// heldBody is a request body that can't be read until its gate is approved.
+type heldBody struct {
+	io.ReadCloser
+	gate *ContinueGate
+}
+
+func (b *heldBody) Read(p []byte) (int, error) {
+	if !b.gate.isApproved() {
+		return 0, Error{
+			Code:      http.StatusInternalServerError,
+			ClientMsg: http.StatusText(http.StatusInternalServerError),
+			LogMsg:    "Request body read before ApproveContinue",
+		}
+	}
+	return b.ReadCloser.Read(p)
+}
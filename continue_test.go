@@ -0,0 +1,91 @@
+package sandwich
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoldContinue(t *testing.T) {
+	requireUser := func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "secret" {
+			return Error{Code: http.StatusUnauthorized, ClientMsg: "Unauthorized"}
+		}
+		return nil
+	}
+	echo := func(w http.ResponseWriter, r *http.Request) error {
+		_, err := io.Copy(w, r.Body)
+		return err
+	}
+	mux := TheUsual()
+	mux.Use(NoLog, HoldContinue)
+	mux.Post("/upload", requireUser, MaxBodySize(10), ApproveContinue, echo)
+	mux.Post("/misordered", echo, ApproveContinue)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// send writes the request headers, then the body only if the server sends
+	// "100 Continue", and returns the status lines that it received.
+	send := func(path, auth, body string) []string {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.WriteString(conn, "POST "+path+" HTTP/1.1\r\nHost: test\r\n"+
+			"Authorization: "+auth+"\r\nExpect: 100-continue\r\n"+
+			"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n")
+		require.NoError(t, err)
+		br := bufio.NewReader(conn)
+		var statuses []string
+		for {
+			resp, err := http.ReadResponse(br, nil)
+			require.NoError(t, err)
+			statuses = append(statuses, resp.Status)
+			if resp.StatusCode != http.StatusContinue {
+				data, _ := io.ReadAll(resp.Body)
+				return append(statuses, strings.TrimSpace(string(data)))
+			}
+			_, err = io.WriteString(conn, body)
+			require.NoError(t, err)
+		}
+	}
+
+	assert.Equal(t, []string{"401 Unauthorized", "Unauthorized"}, send("/upload", "guess", "hello"))
+	assert.Equal(t, []string{"413 Request Entity Too Large", "Request Entity Too Large"},
+		send("/upload", "secret", "way too long body"))
+	assert.Equal(t, []string{"100 Continue", "200 OK", "hello"}, send("/upload", "secret", "hello"))
+	assert.Equal(t, []string{"500 Internal Server Error", "Internal Server Error"},
+		send("/misordered", "secret", "hello"))
+}
+
+func TestHoldContinueWithoutExpect(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	g := HoldContinue(req)
+	assert.False(t, g.Expected())
+	data, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Header.Set("Expect", "100-continue")
+	g = HoldContinue(req)
+	assert.True(t, g.Expected())
+	_, err = io.ReadAll(req.Body)
+	var e Error
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, http.StatusInternalServerError, e.Code)
+	g.Approve()
+	data, err = io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,261 @@
+package sandwich
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCookie is returned by Cookies.GetSigned and Cookies.GetEncrypted
+// if the cookie was tampered with, was created with an unknown key, or has
+// expired.
+var ErrInvalidCookie = errors.New("invalid or expired cookie")
+
+// CookieConfig configures SecureCookies.
+type CookieConfig struct {
+	// Keys are the secret keys that cookies are signed and encrypted with,
+	// newest first. Each key must have at least 32 random bytes. New cookies
+	// use the first key, but cookies with any of the keys are accepted, so
+	// that keys can be rotated by adding a new key at the front and removing
+	// the old one once its cookies have expired.
+	Keys [][]byte
+	// MaxAge is how long cookies are valid. If zero, 30 days are used. The
+	// expiry is included in the signed or encrypted value, so it's enforced
+	// even if the client keeps the cookie longer.
+	MaxAge time.Duration
+	// SameSite is the SameSite attribute of the cookies. If zero,
+	// http.SameSiteLaxMode is used.
+	SameSite http.SameSite
+	// Insecure allows the cookies to be sent over plain HTTP, e.g. for local
+	// development. By default, they're marked Secure.
+	Insecure bool
+	// Path and Domain are the scope of the cookies. If Path is empty, "/" is
+	// used.
+	Path, Domain string
+}
+
+// SecureCookies returns a middleware that provides *Cookies to subsequent
+// handlers, for reading and writing cookies that can't be forged or read by
+// the client. It panics if there are no keys or a key is too short. For
+// example:
+//
+//	mux.Use(sandwich.SecureCookies(sandwich.CookieConfig{Keys: keys}))
+//	mux.Post("/login", func(c *sandwich.Cookies, ...) error {
+//	    ...
+//	    c.SetSigned("user", user.ID)
+//	    ...
+//	})
+//
+// The cookies are HttpOnly.
+func SecureCookies(cfg CookieConfig) func(w http.ResponseWriter, r *http.Request) *Cookies {
+	if len(cfg.Keys) == 0 {
+		panic("SecureCookies requires at least one key")
+	}
+	keys := make([]cookieKeys, len(cfg.Keys))
+	for i, secret := range cfg.Keys {
+		if len(secret) < 32 {
+			panic(fmt.Errorf("SecureCookies: key %d has %d bytes, at least 32 are required", i, len(secret)))
+		}
+		keys[i] = newCookieKeys(secret)
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 30 * 24 * time.Hour
+	}
+	if cfg.SameSite == 0 {
+		cfg.SameSite = http.SameSiteLaxMode
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	return func(w http.ResponseWriter, r *http.Request) *Cookies {
+		return &Cookies{w: w, r: r, cfg: &cfg, keys: keys}
+	}
+}
+
+// Cookies reads and writes signed or encrypted cookies of a request, see
+// SecureCookies. Signed cookies can be read, but not modified, by the client.
+// Encrypted cookies can't be read either.
+type Cookies struct {
+	w    http.ResponseWriter
+	r    *http.Request
+	cfg  *CookieConfig
+	keys []cookieKeys
+}
+
+// cookieKeys are the keys derived from one of the CookieConfig.Keys.
+type cookieKeys struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+func newCookieKeys(secret []byte) cookieKeys {
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("sandwich cookie encryption"))
+	if err != nil {
+		panic(err) // can't happen: the key is 32 bytes
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return cookieKeys{sign: derive("sandwich cookie signing"), aead: aead}
+}
+
+// SetSigned sets the named cookie to value with a signature, so that the
+// client can't modify it. It replaces the cookie if it was already set during
+// this request, as do SetEncrypted and Delete.
+func (c *Cookies) SetSigned(name, value string) {
+	payload := c.payload(value)
+	sig := signCookie(c.keys[0].sign, name, payload)
+	c.set(name, encodeCookie(payload)+"."+encodeCookie(sig))
+}
+
+// GetSigned returns the value of the named cookie that was set with SetSigned.
+// It returns http.ErrNoCookie if the request doesn't have the cookie, or
+// ErrInvalidCookie if its signature is invalid or it has expired.
+func (c *Cookies) GetSigned(name string) (string, error) {
+	cookie, err := c.r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	encPayload, encSig, ok := strings.Cut(cookie.Value, ".")
+	payload, err1 := decodeCookie(encPayload)
+	sig, err2 := decodeCookie(encSig)
+	if !ok || err1 != nil || err2 != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, k := range c.keys {
+		if hmac.Equal(sig, signCookie(k.sign, name, payload)) {
+			return c.value(payload)
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+// SetEncrypted sets the named cookie to value encrypted, so that the client
+// can neither read nor modify it.
+func (c *Cookies) SetEncrypted(name, value string) error {
+	aead := c.keys[0].aead
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+8+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(nonce, nonce, c.payload(value), []byte(name))
+	c.set(name, encodeCookie(sealed))
+	return nil
+}
+
+// GetEncrypted returns the value of the named cookie that was set with
+// SetEncrypted. It returns http.ErrNoCookie if the request doesn't have the
+// cookie, or ErrInvalidCookie if it can't be decrypted or has expired.
+func (c *Cookies) GetEncrypted(name string) (string, error) {
+	cookie, err := c.r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := decodeCookie(cookie.Value)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, k := range c.keys {
+		n := k.aead.NonceSize()
+		if len(sealed) < n {
+			break
+		}
+		if payload, err := k.aead.Open(nil, sealed[:n], sealed[n:], []byte(name)); err == nil {
+			return c.value(payload)
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+// Delete removes the named cookie from the client.
+func (c *Cookies) Delete(name string) {
+	removeSetCookie(c.w.Header(), name)
+	http.SetCookie(c.w, &http.Cookie{
+		Name:     name,
+		Path:     c.cfg.Path,
+		Domain:   c.cfg.Domain,
+		MaxAge:   -1,
+		Secure:   !c.cfg.Insecure,
+		HttpOnly: true,
+		SameSite: c.cfg.SameSite,
+	})
+}
+
+func (c *Cookies) set(name, value string) {
+	removeSetCookie(c.w.Header(), name)
+	http.SetCookie(c.w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.cfg.Path,
+		Domain:   c.cfg.Domain,
+		Expires:  time_Now().Add(c.cfg.MaxAge),
+		MaxAge:   int(c.cfg.MaxAge / time.Second),
+		Secure:   !c.cfg.Insecure,
+		HttpOnly: true,
+		SameSite: c.cfg.SameSite,
+	})
+}
+
+// removeSetCookie removes the Set-Cookie headers of the named cookie, so that
+// a cookie that's set again during the same request is only sent once.
+func removeSetCookie(h http.Header, name string) {
+	var kept []string
+	for _, line := range h["Set-Cookie"] {
+		if !strings.HasPrefix(line, name+"=") {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == 0 {
+		h.Del("Set-Cookie")
+	} else {
+		h["Set-Cookie"] = kept
+	}
+}
+
+// payload prefixes value with its expiry, in Unix seconds.
+func (c *Cookies) payload(value string) []byte {
+	payload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(time_Now().Add(c.cfg.MaxAge).Unix()))
+	return append(payload, value...)
+}
+
+// value returns the value of an authenticated payload, unless it has expired.
+func (c *Cookies) value(payload []byte) (string, error) {
+	if len(payload) < 8 {
+		return "", ErrInvalidCookie
+	}
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if !time_Now().Before(expiry) {
+		return "", ErrInvalidCookie
+	}
+	return string(payload[8:]), nil
+}
+
+// signCookie signs the payload of the named cookie, so that it can't be used
+// as the value of another cookie.
+func signCookie(key []byte, name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func encodeCookie(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func decodeCookie(s string) ([]byte, error) { return base64.RawURLEncoding.DecodeString(s) }
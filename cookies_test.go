@@ -0,0 +1,102 @@
+package sandwich
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecureCookies(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(orig func() time.Time) { time_Now = orig }(time_Now)
+	time_Now = func() time.Time { return now }
+
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	cfg := CookieConfig{Keys: [][]byte{oldKey}, MaxAge: time.Hour}
+
+	mux := func(cfg CookieConfig) Router {
+		mux := TheUsual()
+		mux.Use(NoLog, SecureCookies(cfg))
+		mux.Post("/set", func(c *Cookies) error {
+			c.SetSigned("signed", "alice")
+			return c.SetEncrypted("secret", "bob")
+		})
+		mux.Get("/get", func(w http.ResponseWriter, c *Cookies) {
+			signed, err1 := c.GetSigned("signed")
+			secret, err2 := c.GetEncrypted("secret")
+			var errs []string
+			for _, err := range []error{err1, err2} {
+				if err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+			w.Write([]byte(signed + "," + secret + strings.Join(append([]string{""}, errs...), ";")))
+		})
+		mux.Post("/logout", func(c *Cookies) { c.Delete("secret") })
+		return mux
+	}
+	serve := func(mux Router, method, path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(mux(cfg), "POST", "/set", nil)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 2)
+	for _, c := range cookies {
+		assert.True(t, c.Secure)
+		assert.True(t, c.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+		assert.Equal(t, "/", c.Path)
+		assert.Equal(t, 3600, c.MaxAge)
+		assert.NotContains(t, c.Value, "bob")
+	}
+
+	w = serve(mux(cfg), "GET", "/get", cookies)
+	assert.Equal(t, "alice,bob", w.Body.String())
+
+	// Cookies with rotated keys are still accepted.
+	rotated := CookieConfig{Keys: [][]byte{newKey, oldKey}, MaxAge: time.Hour}
+	w = serve(mux(rotated), "GET", "/get", cookies)
+	assert.Equal(t, "alice,bob", w.Body.String())
+
+	w = serve(mux(CookieConfig{Keys: [][]byte{newKey}}), "GET", "/get", cookies)
+	assert.Equal(t, ",;invalid or expired cookie;invalid or expired cookie", w.Body.String())
+
+	// Tampering, swapping cookies, and expiry are detected.
+	tampered := []*http.Cookie{
+		{Name: "signed", Value: strings.Replace(cookies[0].Value, "A", "B", 1)},
+		{Name: "secret", Value: cookies[0].Value},
+	}
+	w = serve(mux(cfg), "GET", "/get", tampered)
+	assert.Equal(t, ",;invalid or expired cookie;invalid or expired cookie", w.Body.String())
+
+	now = now.Add(time.Hour)
+	w = serve(mux(cfg), "GET", "/get", cookies)
+	assert.Equal(t, ",;invalid or expired cookie;invalid or expired cookie", w.Body.String())
+
+	w = serve(mux(cfg), "GET", "/get", nil)
+	assert.Equal(t, ",;"+http.ErrNoCookie.Error()+";"+http.ErrNoCookie.Error(), w.Body.String())
+
+	w = serve(mux(CookieConfig{Keys: [][]byte{oldKey}, Insecure: true, SameSite: http.SameSiteStrictMode}), "POST", "/logout", nil)
+	cookies = w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+	assert.False(t, cookies[0].Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+
+	assert.Panics(t, func() { SecureCookies(CookieConfig{}) })
+	assert.Panics(t, func() { SecureCookies(CookieConfig{Keys: [][]byte{[]byte("short")}}) })
+}
@@ -0,0 +1,248 @@
+package sandwich
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	headerAvailableDictionary = "Available-Dictionary"
+	headerUseAsDictionary     = "Use-As-Dictionary"
+)
+
+// DictionaryEncoder compresses responses using a shared dictionary, as
+// described by Compression Dictionary Transport (RFC 9842). The standard
+// library doesn't include brotli or zstd, so implementations typically wrap a
+// third-party compression package.
+type DictionaryEncoder interface {
+	// Encoding returns the content coding produced by the encoder: "dcb" for
+	// dictionary-compressed brotli or "dcz" for dictionary-compressed zstd.
+	Encoding() string
+	// NewWriter returns a writer that compresses data written to it into w
+	// using dict. Close must write any remaining data, but not close w.
+	NewWriter(w io.Writer, dict []byte) (io.WriteCloser, error)
+}
+
+// dictionaryMagic is the header that precedes the dictionary hash in
+// dictionary-compressed responses for each known encoding.
+var dictionaryMagic = map[string][]byte{
+	"dcb": {0xff, 0x44, 0x43, 0x42},
+	"dcz": {0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00},
+}
+
+// DictionaryStore holds the most recent dictionaries that clients may have
+// cached, identified by their SHA-256 hashes. It's safe for concurrent use.
+type DictionaryStore struct {
+	mu    sync.Mutex
+	max   int
+	order [][sha256.Size]byte // oldest first
+	dicts map[[sha256.Size]byte][]byte
+}
+
+// NewDictionaryStore returns a store that keeps up to max dictionaries. When
+// full, adding a dictionary evicts the oldest one.
+func NewDictionaryStore(max int) *DictionaryStore {
+	if max < 1 {
+		max = 1
+	}
+	return &DictionaryStore{max: max, dicts: map[[sha256.Size]byte][]byte{}}
+}
+
+// Add adds dict to the store and returns its SHA-256 hash. This may be used to
+// add static dictionaries, but responses marked with UseAsDictionary are added
+// automatically by CompressWithDictionaries.
+func (s *DictionaryStore) Add(dict []byte) [sha256.Size]byte {
+	hash := sha256.Sum256(dict)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.dicts[hash]; exists {
+		return hash
+	}
+	if len(s.order) >= s.max {
+		delete(s.dicts, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.order = append(s.order, hash)
+	s.dicts[hash] = dict
+	return hash
+}
+
+// get returns the dictionary identified by an Available-Dictionary header,
+// which is the base64 SHA-256 hash formatted as a structured field byte
+// sequence, e.g. ":pZGm1Av0IEBKARczz7exkNYsZb8LzaMrV7J32a2fFG4=:".
+func (s *DictionaryStore) get(header string) ([sha256.Size]byte, []byte) {
+	var hash [sha256.Size]byte
+	header = strings.TrimSpace(header)
+	if len(header) < 2 || header[0] != ':' || header[len(header)-1] != ':' {
+		return hash, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(header[1 : len(header)-1])
+	if err != nil || len(raw) != sha256.Size {
+		return hash, nil
+	}
+	copy(hash[:], raw)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return hash, s.dicts[hash]
+}
+
+// UseAsDictionary marks the response as a dictionary that clients may use to
+// decompress subsequent responses for URLs that match the URL pattern, e.g.
+// "/api/feed*". It must be called before the response is written. When used
+// with CompressWithDictionaries, the response body is added to the store.
+func UseAsDictionary(w http.ResponseWriter, match string) {
+	match = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(match)
+	w.Header().Set(headerUseAsDictionary, `match="`+match+`"`)
+}
+
+// CompressWithDictionaries returns a middleware wrap that compresses the
+// output of subsequent handlers using a shared dictionary when the client
+// advertises a dictionary from store in the Available-Dictionary header and
+// accepts the encoding of one of the encoders. Encoders are preferred in the
+// order given. Responses that are marked with UseAsDictionary are added to the
+// store, so that large, frequently-updated payloads, such as JSON feeds, can be
+// sent as small deltas against the version that the client already has:
+//
+//	dicts := sandwich.NewDictionaryStore(4)
+//	router.Get("/api/feed", sandwich.CompressWithDictionaries(dicts, brotliEncoder),
+//	    func(w http.ResponseWriter) {
+//	        sandwich.UseAsDictionary(w, "/api/feed")
+//	        ...
+//	    })
+//
+// Responses that already have a Content-Encoding, such as precompressed files,
+// and responses without a body, such as a 304 or the response to a HEAD
+// request, aren't compressed. Only 2xx responses are added to the store.
+func CompressWithDictionaries(store *DictionaryStore, encoders ...DictionaryEncoder) Wrap {
+	return Wrap{
+		Before: func(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *dictWriter) {
+			dw := &dictWriter{ResponseWriter: w, store: store, head: r.Method == http.MethodHead}
+			accept := r.Header.Get(headerAcceptEncoding)
+			for _, e := range encoders {
+				if acceptsEncoding(accept, e.Encoding()) {
+					dw.encoder = e
+					break
+				}
+			}
+			if dw.encoder == nil {
+				return dw, dw
+			}
+			AddVary(w.Header(), headerAcceptEncoding, headerAvailableDictionary)
+			dw.hash, dw.dict = store.get(r.Header.Get(headerAvailableDictionary))
+			return dw, dw
+		},
+		After: (*dictWriter).Flush,
+	}
+}
+
+// prefixWriter writes prefix before anything else is written to w. Until it's
+// ready, which is once the response status has been sent, writes are added to
+// the prefix, since an encoder may write as soon as it's created.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	ready  bool
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	if !p.ready {
+		p.prefix = append(p.prefix, data...)
+		return len(data), nil
+	}
+	if p.prefix != nil {
+		prefix := p.prefix
+		p.prefix = nil
+		if _, err := p.w.Write(prefix); err != nil {
+			return 0, err
+		}
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	return p.w.Write(data)
+}
+
+// dictWriter compresses the response with dict, if the client has it, and
+// captures the uncompressed response if it's marked as a dictionary. Whether
+// to do either is decided when the response starts.
+type dictWriter struct {
+	http.ResponseWriter
+	store   *DictionaryStore
+	encoder DictionaryEncoder
+	hash    [sha256.Size]byte
+	dict    []byte
+	head    bool // whether the request is a HEAD request
+	enc     io.WriteCloser
+	out     *prefixWriter
+	started bool
+	capture *bytes.Buffer
+}
+
+func (d *dictWriter) start(code int) {
+	if d.started {
+		return
+	}
+	d.started = true
+	h := d.Header()
+	hasBody := bodyAllowed(code) && !d.head
+	if hasBody && code >= 200 && code < 300 && h.Get(headerUseAsDictionary) != "" {
+		d.capture = &bytes.Buffer{}
+	}
+	if d.dict == nil || !hasBody || h.Get(headerContentEncoding) != "" {
+		return
+	}
+	// The compressed stream is preceded by the magic header and the hash of
+	// the dictionary.
+	encoding := d.encoder.Encoding()
+	prefix := append(append([]byte{}, dictionaryMagic[encoding]...), d.hash[:]...)
+	out := &prefixWriter{w: d.ResponseWriter, prefix: prefix}
+	enc, err := d.encoder.NewWriter(out, d.dict)
+	if err != nil {
+		return // send the response uncompressed
+	}
+	d.enc, d.out = enc, out
+	h.Set(headerContentEncoding, encoding)
+	h.Del(headerContentLength)
+}
+
+func (d *dictWriter) WriteHeader(code int) {
+	if code >= 200 { // informational responses precede the actual response
+		d.start(code)
+	}
+	d.ResponseWriter.WriteHeader(code)
+	if d.out != nil {
+		d.out.ready = true
+	}
+}
+
+func (d *dictWriter) Write(p []byte) (int, error) {
+	if len(d.Header().Get(headerContentType)) == 0 {
+		d.Header().Set(headerContentType, http.DetectContentType(p))
+	}
+	d.start(http.StatusOK)
+	if d.capture != nil {
+		d.capture.Write(p)
+	}
+	if d.enc == nil {
+		return d.ResponseWriter.Write(p)
+	}
+	d.out.ready = true
+	return d.enc.Write(p)
+}
+
+func (d *dictWriter) Flush() {
+	d.start(http.StatusOK)
+	if d.enc != nil {
+		d.out.ready = true
+		d.enc.Close()
+		d.out.Write(nil) // write the prefix even if nothing else was written
+	}
+	if d.capture != nil {
+		d.store.Add(d.capture.Bytes())
+	}
+}
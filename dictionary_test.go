@@ -0,0 +1,140 @@
+package sandwich
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDictEncoder "compresses" by noting the dictionary size and copying the
+// data as-is.
+type fakeDictEncoder struct{ encoding string }
+
+func (f fakeDictEncoder) Encoding() string { return f.encoding }
+func (f fakeDictEncoder) NewWriter(w io.Writer, dict []byte) (io.WriteCloser, error) {
+	fmt.Fprintf(w, "[dict %d]", len(dict))
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestCompressWithDictionaries(t *testing.T) {
+	store := NewDictionaryStore(2)
+	version := "v1"
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/feed",
+		CompressWithDictionaries(store, fakeDictEncoder{"dcz"}, fakeDictEncoder{"dcb"}),
+		func(w http.ResponseWriter) {
+			UseAsDictionary(w, "/feed*")
+			_, _ = w.Write([]byte(`{"feed":"` + version + `"}`))
+		})
+
+	get := func(acceptEncoding, availableDict string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/feed", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if availableDict != "" {
+			req.Header.Set("Available-Dictionary", availableDict)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	sfHash := func(data string) string {
+		h := sha256.Sum256([]byte(data))
+		return ":" + base64.StdEncoding.EncodeToString(h[:]) + ":"
+	}
+
+	// The first response isn't compressed, but is stored as a dictionary.
+	w := get("gzip, dcb", "")
+	assert.Equal(t, `{"feed":"v1"}`, w.Body.String())
+	assert.Equal(t, `match="/feed*"`, w.Header().Get("Use-As-Dictionary"))
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding, Available-Dictionary", w.Header().Get("Vary"))
+
+	// The next one is compressed against the previous version.
+	version = "v2"
+	w = get("gzip, dcb", sfHash(`{"feed":"v1"}`))
+	assert.Equal(t, "dcb", w.Header().Get("Content-Encoding"))
+	h := sha256.Sum256([]byte(`{"feed":"v1"}`))
+	assert.Equal(t, "\xff\x44\x43\x42"+string(h[:])+`[dict 13]{"feed":"v2"}`, w.Body.String())
+
+	// Unknown dictionaries and unsupported encodings aren't compressed.
+	w = get("gzip, dcb", sfHash("unknown"))
+	assert.Equal(t, `{"feed":"v2"}`, w.Body.String())
+	w = get("gzip", sfHash(`{"feed":"v1"}`))
+	assert.Equal(t, `{"feed":"v2"}`, w.Body.String())
+	assert.Equal(t, "", w.Header().Get("Vary"))
+
+	// Old dictionaries are evicted.
+	version = "v3"
+	get("gzip", "")
+	w = get("dcz", sfHash(`{"feed":"v1"}`))
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	w = get("dcz", sfHash(`{"feed":"v3"}`))
+	assert.Equal(t, "dcz", w.Header().Get("Content-Encoding"))
+}
+
+func TestCompressWithDictionariesSkipsResponses(t *testing.T) {
+	store := NewDictionaryStore(4)
+	dict := []byte("shared")
+	store.Add(dict)
+	h := sha256.Sum256(dict)
+	available := ":" + base64.StdEncoding.EncodeToString(h[:]) + ":"
+
+	r := TheUsual()
+	r.Use(NoLog, CompressWithDictionaries(store, fakeDictEncoder{"dcb"}))
+	r.Get("/precompressed", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Encoding", "br")
+		_, _ = w.Write([]byte("brotli data"))
+	})
+	r.Get("/empty", func(w http.ResponseWriter) { w.WriteHeader(http.StatusNoContent) })
+	r.Get("/missing", func(w http.ResponseWriter) {
+		UseAsDictionary(w, "/missing")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("not found"))
+	})
+	r.Get("/feed", func(w http.ResponseWriter) { _, _ = w.Write([]byte("feed")) })
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept-Encoding", "dcb")
+		req.Header.Set("Available-Dictionary", available)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/precompressed")
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "brotli data", w.Body.String())
+
+	w = serve("GET", "/empty")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "", w.Body.String())
+
+	w = serve("HEAD", "/feed")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "", w.Body.String())
+
+	w = serve("GET", "/feed")
+	assert.Equal(t, "dcb", w.Header().Get("Content-Encoding"))
+
+	// Error responses aren't stored as dictionaries.
+	w = serve("GET", "/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	_, stored := store.get(":" + base64.StdEncoding.EncodeToString(func() []byte {
+		h := sha256.Sum256([]byte("not found"))
+		return h[:]
+	}()) + ":")
+	assert.Nil(t, stored)
+}
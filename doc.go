@@ -91,8 +91,24 @@
 // When a handler returns an error, sandwich aborts the middleware chain and
 // looks for the most recently registered error handler and calls that. Error
 // handlers may accept any types that have been provided so far in the
-// middleware stack as well as the error type. They must not have any return
-// values.
+// middleware stack as well as the error type. They may optionally return an
+// error: returning nil indicates that the error has been handled and resumes the
+// middleware chain after the handler that failed, while returning a non-nil
+// error replaces the original error and aborts the chain as usual. This allows
+// falling back to defaults or alternate data sources. OnErrFor registers error
+// handlers that are only called for a specific type of error.
+//
+// Router.OnErrMap registers error mappers that transform errors before the
+// error handler is chosen, e.g. to map sql.ErrNoRows to a 404 in one place
+// rather than in each error handler.
+//
+// Panics in handlers are recovered and passed to the error handlers as a
+// chain.PanicError. OnPanic registers a handler that is called first, e.g. to
+// emit a crash report, or disables recovery entirely.
+//
+// A handler may also return SkipTo(name) to skip the subsequent handlers up to
+// a Label(name) in the chain without triggering any error handlers, e.g. to
+// bypass rendering when a response is served from a cache.
 //
 // # Wrapping Handlers
 //
@@ -111,22 +127,24 @@
 //	  return &LogEntry{Start: time.Now(), ...}
 //	}
 //
-//	// Commit fills in the remaining *LogEntry fields and writes the entry out.
-//	func (entry *LogEntry) Commit(w *ResponseWriter) {
+//	// CommitTo fills in the remaining *LogEntry fields and writes the entry
+//	// to the router's LogSink.
+//	func (entry *LogEntry) CommitTo(w *ResponseWriter, sink LogSink) {
 //	  entry.Elapsed = time.Since(entry.Start)
 //	  ...
-//	  WriteLog(*entry)
+//	  sink.Write(*entry)
 //	}
 //
 // and are added to the chain using:
 //
-//	var LogRequests = Wrap{NewLogEntry, (*LogEntry).Commit}
+//	var LogRequests = Prioritized(Wrap{NewLogEntry, (*LogEntry).CommitTo}, -1)
 //
 // In this case, the `Wrap` executes NewLogEntry during middleware processing
 // that returns a *LogEntry which is provided to downstream handlers, including
-// the deferred Commit handler -- in this case a method expression
+// the deferred CommitTo handler -- in this case a method expression
 // (https://golang.org/ref/spec#Method_expressions) that takes the *LogEntry as
-// its value receiver.
+// its value receiver. The negative priority ensures that CommitTo runs after
+// other deferred handlers, regardless of the order they were registered.
 //
 // # Providing Interfaces
 //
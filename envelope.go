@@ -0,0 +1,150 @@
+package sandwich
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Envelope is the standard structure that API responses are wrapped in by the
+// Enveloped middleware:
+//
+//	{"data": ..., "error": {"code": 404, "message": "..."}, "meta": {...}}
+//
+// Exactly one of Data or Error is set.
+type Envelope struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *EnvelopeError  `json:"error,omitempty"`
+	Meta  *EnvelopeMeta   `json:"meta,omitempty"`
+}
+
+// EnvelopeError is the client-facing error description in an Envelope.
+type EnvelopeError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Fields are the messages of the invalid fields of a ValidationError.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// EnvelopeMeta is the metadata included in an Envelope. Handlers may accept
+// *EnvelopeMeta to add pagination information to the response.
+type EnvelopeMeta struct {
+	RequestID  string      `json:"requestID,omitempty"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Pagination describes which part of a larger result set is included in the
+// response.
+type Pagination struct {
+	Page    int `json:"page"`
+	PerPage int `json:"perPage"`
+	Total   int `json:"total"`
+}
+
+// Enveloped is a middleware wrap that buffers the response of subsequent
+// handlers and wraps it in an Envelope. JSON responses are included verbatim as
+// the envelope data, other successful responses are included as a JSON string.
+// Errors returned by handlers (or responses with a 4xx or 5xx status) are
+// reported in the envelope error instead.
+//
+// It's typically used on an API sub-router:
+//
+//	api := mux.SubRouter("/api")
+//	api.Use(sandwich.Enveloped)
+//	api.Get("/users", ListUsers)
+//
+// Handlers that want to include pagination may accept *EnvelopeMeta:
+//
+//	func ListUsers(w http.ResponseWriter, meta *sandwich.EnvelopeMeta) error {
+//	    meta.Pagination = &sandwich.Pagination{Page: 1, PerPage: 20, Total: 57}
+//	    ...
+//	}
+//
+// If a handler returns sandwich.Done, the buffered response is sent as-is.
+var Enveloped = Wrap{Before: newEnvelopeWriter, After: (*envelopeWriter).commit}
+
+func newEnvelopeWriter(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *envelopeWriter, *EnvelopeMeta) {
+	ew := &envelopeWriter{
+		ResponseWriter: w,
+		meta:           &EnvelopeMeta{RequestID: string(envelopeRequestID(r))},
+	}
+	return ew, ew, ew.meta
+}
+
+// envelopeRequestID returns the RequestID assigned by AssignRequestID, or else
+// the X-Request-ID header if it's valid.
+func envelopeRequestID(r *http.Request) RequestID {
+	if id, ok := RequestIDFromContext(r.Context()); ok {
+		return id
+	}
+	if id := RequestID(r.Header.Get(headerRequestID)); validRequestID(id) {
+		return id
+	}
+	return ""
+}
+
+type envelopeWriter struct {
+	http.ResponseWriter
+	code int
+	buf  bytes.Buffer
+	meta *EnvelopeMeta
+}
+
+func (e *envelopeWriter) WriteHeader(code int) {
+	if e.code == 0 {
+		e.code = code
+	}
+}
+
+func (e *envelopeWriter) Write(p []byte) (int, error) {
+	if e.code == 0 {
+		e.code = http.StatusOK
+	}
+	return e.buf.Write(p)
+}
+
+func (e *envelopeWriter) commit(err error) {
+	w := e.ResponseWriter
+	if e.code == 0 {
+		e.code = http.StatusOK
+	}
+	if err == Done || e.code == http.StatusNoContent || e.code == http.StatusNotModified {
+		w.WriteHeader(e.code)
+		_, _ = w.Write(e.buf.Bytes())
+		return
+	}
+
+	env := Envelope{Meta: e.meta}
+	if err != nil {
+		// The error handler has already written its own response into the
+		// buffer, but we replace that with the envelope error.
+		se := ToError(err)
+		se.setHeaders(w.Header())
+		e.code = se.Code
+		env.Error = &EnvelopeError{Code: se.Code, Message: se.ClientMsg}
+		var invalid ValidationError
+		if invalidFields(err, se, &invalid) {
+			env.Error.Fields = invalid.Fields
+		}
+	} else if e.code >= 400 {
+		env.Error = &EnvelopeError{
+			Code:    e.code,
+			Message: strings.TrimSpace(e.buf.String()),
+		}
+	} else if e.buf.Len() > 0 {
+		if json.Valid(e.buf.Bytes()) {
+			env.Data = json.RawMessage(e.buf.Bytes())
+		} else {
+			env.Data, _ = json.Marshal(e.buf.String())
+		}
+	}
+	if env.Meta.RequestID == "" && env.Meta.Pagination == nil {
+		env.Meta = nil
+	}
+
+	w.Header().Del(headerContentLength)
+	w.Header().Set(headerContentType, "application/json")
+	w.WriteHeader(e.code)
+	_ = json.NewEncoder(w).Encode(env)
+}
@@ -0,0 +1,81 @@
+package sandwich
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnveloped(t *testing.T) {
+	mux := TheUsual()
+	mux.Use(NoLog)
+	mux.OnErr(HandleErrorJson)
+	api := mux.SubRouter("/api")
+	api.Use(Enveloped)
+	api.Get("/json", func(w http.ResponseWriter) {
+		fmt.Fprint(w, `{"name":"bob"}`)
+	})
+	api.Get("/text", func(w http.ResponseWriter) {
+		fmt.Fprint(w, "hello")
+	})
+	api.Get("/paged", func(w http.ResponseWriter, meta *EnvelopeMeta) {
+		meta.Pagination = &Pagination{Page: 2, PerPage: 10, Total: 42}
+		fmt.Fprint(w, `[1,2,3]`)
+	})
+	api.Get("/fail", func() error {
+		return Error{Code: http.StatusTeapot, ClientMsg: "no coffee"}
+	})
+	api.Get("/notfound", func(w http.ResponseWriter) {
+		http.Error(w, "nope", http.StatusNotFound)
+	})
+	mux.Get("/raw", func(w http.ResponseWriter) { fmt.Fprint(w, "raw") })
+
+	testCases := []struct {
+		path     string
+		header   string
+		code     int
+		expected string
+	}{
+		{"/api/json", "", 200, `{"data":{"name":"bob"}}`},
+		{"/api/text", "", 200, `{"data":"hello"}`},
+		{"/api/text", "abc123", 200, `{"data":"hello","meta":{"requestID":"abc123"}}`},
+		{"/api/text", "bad\nid", 200, `{"data":"hello"}`},
+		{"/api/paged", "", 200, `{"data":[1,2,3],"meta":{"pagination":{"page":2,"perPage":10,"total":42}}}`},
+		{"/api/fail", "", 418, `{"error":{"code":418,"message":"no coffee"}}`},
+		{"/api/notfound", "", 404, `{"error":{"code":404,"message":"nope"}}`},
+	}
+	for _, test := range testCases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.header != "" {
+			req.Header.Set("X-Request-ID", test.header)
+		}
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.path)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"), test.path)
+		assert.JSONEq(t, test.expected, w.Body.String(), test.path)
+	}
+
+	// Routes outside of the sub-router aren't enveloped.
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/raw", nil))
+	assert.Equal(t, "raw", w.Body.String())
+}
+
+func TestEnvelopedAssignedRequestID(t *testing.T) {
+	mux := TheUsual()
+	mux.Use(NoLog, AssignRequestID, Enveloped)
+	mux.Get("/", func(w http.ResponseWriter) { fmt.Fprint(w, "hello") })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "bad id "+strings.Repeat("x", 200))
+	mux.ServeHTTP(w, req)
+	id := w.Header().Get("X-Request-ID")
+	assert.Len(t, id, 32, "a new ID is assigned")
+	assert.JSONEq(t, `{"data":"hello","meta":{"requestID":"`+id+`"}}`, w.Body.String())
+}
@@ -0,0 +1,199 @@
+package sandwich
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// ErrorMode determines how much detail about errors is sent to clients by the
+// standard error handlers: HandleError, HandleErrorJson, and HandleErrorHTML.
+// It's ProdErrors unless changed with Router.SetErrorMode, e.g.:
+//
+//	if *dev {
+//	    mux.SetErrorMode(sandwich.DevErrors)
+//	}
+//
+// The mode is provided to handlers, and is also available from the request's
+// context with ErrorModeOf for error handlers that don't accept it.
+type ErrorMode int
+
+const (
+	// ProdErrors only sends the sanitized client messages of errors.
+	ProdErrors ErrorMode = iota
+	// DevErrors also sends internal details, such as the log message, cause,
+	// and panic stacks, to help debugging during development. It must not be
+	// used in production, since the details may expose sensitive information.
+	DevErrors
+)
+
+// ErrorPage is the data that HandleErrorHTML passes to its template.
+type ErrorPage struct {
+	Code    int    // e.g. 404
+	Status  string // e.g. "Not Found"
+	Message string // the client message of the error
+	// Details are the internal details of the error in DevErrors mode, and nil
+	// otherwise.
+	Details *ErrorDetails
+}
+
+// ErrorDetails are the internal details of an error that are shown in
+// DevErrors mode.
+type ErrorDetails struct {
+	Error string `json:"error"` // the full error, including the log message and cause
+	// If the error is a panic, Panic is the panic value, Middleware lists the
+	// handlers that were called, most recent first, Stack is the filtered
+	// stack trace of the panic, and Values summarizes the injected values by
+	// type. See chain.PanicError.
+	Panic      string            `json:"panic,omitempty"`
+	Middleware []string          `json:"middleware,omitempty"`
+	Stack      []string          `json:"stack,omitempty"`
+	Values     map[string]string `json:"values,omitempty"`
+}
+
+// String formats the details as plain text.
+func (d *ErrorDetails) String() string {
+	var b strings.Builder
+	b.WriteString(d.Error + "\n")
+	if d.Panic == "" {
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\nPanic: %s\n", d.Panic)
+	section := func(title string, lines []string) {
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		for _, line := range lines {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	section("Middleware", d.Middleware)
+	section("Stack", d.Stack)
+	section("Values", d.sortedValues())
+	return b.String()
+}
+
+// sortedValues returns the Values as "type: summary" lines, sorted by type.
+func (d *ErrorDetails) sortedValues() []string {
+	lines := make([]string, 0, len(d.Values))
+	for typ, summary := range d.Values {
+		lines = append(lines, typ+": "+summary)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+type errorModeKey struct{}
+
+// ErrorModeOf returns the ErrorMode of the request, see Router.SetErrorMode.
+func ErrorModeOf(r *http.Request) ErrorMode {
+	mode, _ := r.Context().Value(errorModeKey{}).(ErrorMode)
+	return mode
+}
+
+// withErrorMode is a middleware handler that provides mode to handlers and
+// records it in the request's context for ErrorModeOf.
+func withErrorMode(mode ErrorMode) func(r *http.Request) (*http.Request, ErrorMode) {
+	return func(r *http.Request) (*http.Request, ErrorMode) {
+		return r.WithContext(context.WithValue(r.Context(), errorModeKey{}, mode)), mode
+	}
+}
+
+// DefaultErrorPage is the template used by HandleErrorHTML if none is given.
+var DefaultErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Code}} {{.Status}}</title>
+<style>
+body { font-family: sans-serif; margin: 3em auto; max-width: 60em; color: #333; }
+h1 { font-weight: normal; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Code}} {{.Status}}</h1>
+{{if ne .Message .Status}}<p>{{.Message}}</p>{{end}}
+{{with .Details}}
+<h2>Error</h2>
+<pre>{{.Error}}</pre>
+{{if .Panic}}
+<h2>Panic: {{.Panic}}</h2>
+<h3>Middleware</h3>
+<pre>{{range .Middleware}}{{.}}
+{{end}}</pre>
+<h3>Stack</h3>
+<pre>{{range .Stack}}{{.}}
+{{end}}</pre>
+<h3>Values</h3>
+<pre>{{range $type, $value := .Values}}{{$type}}: {{$value}}
+{{end}}</pre>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// HandleErrorHTML returns an error handler that responds with an HTML error
+// page rendered by the named template of tpl with an ErrorPage, or with
+// DefaultErrorPage if tpl is nil. Like HandleError, it responds with the status
+// code, headers, and client message of the error as determined by ToError and
+// adds the error to the request log. In DevErrors mode, the page also includes
+// the ErrorDetails. For example:
+//
+//	mux := sandwich.TheUsual()
+//	mux.OnErr(sandwich.HandleErrorHTML(templates, "error.html"))
+//
+// The page is rendered before anything is written, so if the template fails,
+// the error handler falls back to a plain text response and the template error
+// is noted in the log entry as "error_page".
+//
+// If the error is sandwich.Done, the handler does nothing.
+func HandleErrorHTML(tpl *template.Template, name string) func(w http.ResponseWriter, l *LogEntry, err error, mode ErrorMode) {
+	if tpl == nil {
+		tpl, name = DefaultErrorPage, DefaultErrorPage.Name()
+	}
+	return func(w http.ResponseWriter, l *LogEntry, err error, mode ErrorMode) {
+		if err == Done {
+			return
+		}
+		e := ToError(err)
+		e.LogIfMsg(l)
+		e.setHeaders(w.Header())
+		page := ErrorPage{Code: e.Code, Status: http.StatusText(e.Code), Message: e.ClientMsg}
+		if mode == DevErrors {
+			page.Details = errorDetails(err)
+		}
+		var buf bytes.Buffer
+		if tplErr := tpl.ExecuteTemplate(&buf, name, page); tplErr != nil {
+			l.Note["error_page"] = "Failed to render error page: " + tplErr.Error()
+			http.Error(w, e.ClientMsg, e.Code)
+			return
+		}
+		w.Header().Set(headerContentType, "text/html; charset=utf-8")
+		w.Header().Del(headerContentLength)
+		w.WriteHeader(e.Code)
+		_, _ = buf.WriteTo(w)
+	}
+}
+
+// errorDetails returns the internal details of err for DevErrors mode.
+func errorDetails(err error) *ErrorDetails {
+	d := &ErrorDetails{Error: ToError(err).Error()}
+	var p chain.PanicError
+	if errors.As(err, &p) {
+		d.Error, _, _ = strings.Cut(err.Error(), "\n") // the rest is the stack
+		d.Panic = fmt.Sprint(p.Val)
+		for _, fn := range p.MiddlewareStack {
+			d.Middleware = append(d.Middleware, fmt.Sprintf("%s (%s:%d)", fn.Name, fn.File, fn.Line))
+		}
+		d.Stack = p.FilteredStack()
+		d.Values = p.Values
+	}
+	return d
+}
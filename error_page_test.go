@@ -0,0 +1,129 @@
+package sandwich
+
+import (
+	"errors"
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleErrorHTML(t *testing.T) {
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	var logged LogEntry
+	WriteLog = func(e LogEntry) { logged = e }
+
+	tpl := template.Must(template.New("error.html").Parse(
+		`<p>{{.Code}} {{.Message}}{{with .Details}} [{{.Error}}]{{end}}</p>`))
+	tpl = template.Must(tpl.New("broken.html").Parse(`{{.Missing}}`))
+
+	r := TheUsual()
+	r.OnErr(HandleErrorHTML(tpl, "error.html"))
+	r.Get("/denied", func() error {
+		return Error{Code: 403, ClientMsg: "<Denied>", LogMsg: "Bad token", Cause: errors.New("expired")}
+	})
+	r.Get("/panic", func() { panic("kaboom") })
+
+	dev := r.SubRouter("/dev")
+	dev.SetErrorMode(DevErrors)
+	dev.Get("/denied", func() error { return Error{Code: 403, ClientMsg: "Denied", LogMsg: "Bad token"} })
+	dev.Get("/panic", func() { panic("kaboom") })
+	dev.OnErr(HandleErrorHTML(nil, ""))
+	dev.Get("/default", func() error { return errors.New("oops") })
+
+	broken := r.SubRouter("/broken")
+	broken.OnErr(HandleErrorHTML(tpl, "broken.html"))
+	broken.Get("/", func() error { return Error{Code: 404, ClientMsg: "Gone"} })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/denied")
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "<p>403 &lt;Denied&gt;</p>", w.Body.String())
+	assert.EqualError(t, logged.Error, "(403) Bad token: expired")
+
+	w = serve("/panic")
+	assert.Equal(t, 500, w.Code)
+	assert.Equal(t, "<p>500 Internal Server Error</p>", w.Body.String())
+
+	w = serve("/dev/denied")
+	assert.Equal(t, "<p>403 Denied [(403) Bad token]</p>", w.Body.String())
+
+	w = serve("/dev/panic")
+	assert.Contains(t, w.Body.String(), "[Panic executing middleware")
+	assert.Contains(t, w.Body.String(), "kaboom")
+
+	w = serve("/dev/default")
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "<title>500 Internal Server Error</title>")
+	assert.Contains(t, w.Body.String(), "<pre>(500) Failure: oops</pre>")
+
+	w = serve("/broken/")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "Gone\n", w.Body.String())
+	assert.Contains(t, logged.Note["error_page"], "Failed to render error page")
+}
+
+func TestErrorModes(t *testing.T) {
+	type User struct{ Name string }
+	r, err := NewWithConfig(Config{Logger: func(LogEntry) {}, DevErrors: true})
+	require.NoError(t, err)
+	r.Get("/panic", func() *User { return &User{"bob"} }, func() { panic("kaboom") })
+	r.Get("/fail", func() error { return Error{Code: 400, ClientMsg: "Bad", LogMsg: "Bad input"} })
+	api := r.SubRouter("/api")
+	api.OnErr(HandleErrorJson)
+	api.Get("/fail", func() error { return Error{Code: 400, ClientMsg: "Bad", LogMsg: "Bad input"} })
+	prod := r.SubRouter("/prod")
+	prod.SetErrorMode(ProdErrors)
+	prod.Get("/panic", func() { panic("kaboom") })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/panic")
+	assert.Equal(t, 500, w.Code)
+	body := w.Body.String()
+	assert.True(t, strings.HasPrefix(body, "Internal Server Error\n\nPanic executing middleware"), body)
+	assert.Contains(t, body, "\nPanic: kaboom\n")
+	assert.Contains(t, body, "\nMiddleware:\n  ")
+	assert.Contains(t, body, "\nStack:\n  ")
+	assert.Contains(t, body, "\nValues:\n")
+	assert.Contains(t, body, "  *sandwich.User: &{Name:bob}\n")
+
+	w = serve("/fail")
+	assert.Equal(t, "Bad\n\n(400) Bad input\n", w.Body.String())
+
+	w = serve("/api/fail")
+	assert.Equal(t, `{"error":"Bad","details":{"error":"(400) Bad input"}}`, w.Body.String())
+
+	w = serve("/prod/panic")
+	assert.Equal(t, "Internal Server Error\n", w.Body.String())
+}
+
+func TestErrorModeExtend(t *testing.T) {
+	r, err := NewWithConfig(Config{Logger: func(LogEntry) {}, DevErrors: true})
+	require.NoError(t, err)
+	r.Extend(BuildYourOwn())
+	var mode ErrorMode
+	r.Get("/fail", func(m ErrorMode) error {
+		mode = m
+		return Error{Code: 400, ClientMsg: "Bad", LogMsg: "Bad input"}
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/fail", nil))
+	assert.Equal(t, DevErrors, mode, "Extend doesn't replace the mode")
+	assert.Equal(t, "Bad\n\n(400) Bad input\n", w.Body.String())
+}
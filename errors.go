@@ -1,9 +1,21 @@
 package sandwich
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
 )
 
 // Error is an error implementation that provides the ability to specify three
@@ -17,13 +29,63 @@ import (
 // Note that Cause may be nil.
 //
 // The sandwich standard Error handlers (HandleError and HandleErrorJson) will
-// respect these Errors and respond with the appropriate status code and client
-// message. Additionally, the sandwich standard log handling will log LogMsg.
+// respect these Errors and respond with the appropriate status code, headers,
+// and client message. Additionally, the sandwich standard log handling will log
+// LogMsg.
 type Error struct {
 	Code      int
 	ClientMsg string
 	LogMsg    string
 	Cause     error
+	// header are additional response headers, see WithHeader. It's a pointer
+	// so that Errors remain comparable, e.g. with errors.Is, and it's never
+	// modified once it's set.
+	header *http.Header
+}
+
+// WithHeader returns a copy of e with the response header key set to value,
+// e.g.:
+//
+//	return sandwich.Error{Code: 401, ClientMsg: "Login required"}.
+//	    WithHeader("WWW-Authenticate", `Bearer realm="api"`)
+func (e Error) WithHeader(key, value string) Error {
+	h := e.Header()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set(key, value)
+	e.header = &h
+	return e
+}
+
+// Header returns a copy of the additional response headers of e, such as
+// Retry-After for a 429 or WWW-Authenticate for a 401, or nil if there are
+// none. See WithHeader.
+func (e Error) Header() http.Header {
+	if e.header == nil {
+		return nil
+	}
+	return e.header.Clone()
+}
+
+// WithRetryAfter returns a copy of e with the Retry-After response header set
+// to d, rounded up to whole seconds, e.g. for a 429 or 503.
+func (e Error) WithRetryAfter(d time.Duration) Error {
+	secs := (d + time.Second - 1) / time.Second
+	if secs < 0 {
+		secs = 0
+	}
+	return e.WithHeader("Retry-After", strconv.FormatInt(int64(secs), 10))
+}
+
+// setHeaders adds the response headers of e to h.
+func (e Error) setHeaders(h http.Header) {
+	if e.header == nil {
+		return
+	}
+	for k, v := range *e.header {
+		h[k] = append([]string(nil), v...)
+	}
 }
 
 func (e Error) Error() string {
@@ -45,17 +107,121 @@ func (e Error) LogIfMsg(l *LogEntry) {
 	}
 }
 
+// BadRequest returns a 400 Error with the client message msg, or the standard
+// status text if msg is empty, e.g.:
+//
+//	id, err := strconv.Atoi(p["id"])
+//	if err != nil {
+//	    return sandwich.BadRequest("Invalid user id")
+//	}
+func BadRequest(msg string) Error { return clientError(http.StatusBadRequest, msg) }
+
+// Unauthorized returns a 401 Error with the client message msg, or the standard
+// status text if msg is empty. See WithHeader to add a WWW-Authenticate header.
+func Unauthorized(msg string) Error { return clientError(http.StatusUnauthorized, msg) }
+
+// Forbidden returns a 403 Error with the client message msg, or the standard
+// status text if msg is empty.
+func Forbidden(msg string) Error { return clientError(http.StatusForbidden, msg) }
+
+// NotFoundErr returns a 404 Error with the standard "Not Found" client message.
+func NotFoundErr() Error { return clientError(http.StatusNotFound, "") }
+
+// Conflict returns a 409 Error with the client message msg, or the standard
+// status text if msg is empty.
+func Conflict(msg string) Error { return clientError(http.StatusConflict, msg) }
+
+// TooManyRequests returns a 429 Error with the standard client message and the
+// Retry-After header set to retryAfter. See WithRetryAfter.
+func TooManyRequests(retryAfter time.Duration) Error {
+	return clientError(http.StatusTooManyRequests, "").WithRetryAfter(retryAfter)
+}
+
+func clientError(code int, msg string) Error {
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	return Error{Code: code, ClientMsg: msg}
+}
+
+// Internal returns a 500 Error that logs err and responds with the standard
+// "Internal Server Error" client message, e.g.:
+//
+//	if err := db.Save(user); err != nil {
+//	    return sandwich.Internal(fmt.Errorf("saving user %d: %w", user.ID, err))
+//	}
+//
+// This is equivalent to returning err itself, but makes the intent explicit.
+func Internal(err error) Error {
+	return Error{
+		Code:      http.StatusInternalServerError,
+		ClientMsg: http.StatusText(http.StatusInternalServerError),
+		LogMsg:    "Internal error",
+		Cause:     err,
+	}
+}
+
+// IsClientError reports whether err would be responded to with a 4xx status
+// code, as determined by ToError. It returns false if err is nil or Done.
+func IsClientError(err error) bool {
+	if err == nil || err == Done {
+		return false
+	}
+	code := ToError(err).Code
+	return code >= 400 && code < 500
+}
+
+// IsServerError reports whether err would be responded to with a 5xx status
+// code, as determined by ToError. It returns false if err is nil or Done.
+func IsServerError(err error) bool {
+	if err == nil || err == Done {
+		return false
+	}
+	return ToError(err).Code >= 500
+}
+
 // Done is a sentinel error value that can be used to interrupt the middleware
 // chain without triggering the default error handling.  HandleError will not
 // attempt to write any status code or client message, nor will it add the error
 // to the log.
 var Done = errors.New("<done>")
 
+// NotHandled may be returned by an error handler to decline to handle an
+// error, which is then passed to the previously registered error handler. This
+// allows registering fallbacks, e.g. a custom error page that falls back to
+// HandleError if its template fails:
+//
+//	mux := sandwich.TheUsual() // uses HandleError
+//	mux.OnErr(func(w http.ResponseWriter, err error) error {
+//	    page, renderErr := renderErrorPage(err)
+//	    if renderErr != nil {
+//	        return sandwich.NotHandled
+//	    }
+//	    ...
+//	})
+//
+// An error handler that declines shouldn't have written a response. See
+// chain.NotHandled.
+var NotHandled = chain.NotHandled
+
 // ToError will convert a generic non-nil error to an explicit sandwich.Error
 // type.  If err is already a sandwich.Error, it will be returned.  Otherwise, a
 // generic 500 Error (internal server error) will be initialized and returned.
 // Note that if err is nil, it will still return a generic 500 Error.
+//
+// An Errors is converted to an Error with the highest status code of its
+// errors, see Errors, and a ValidationError is converted to a 422 Error.
+// Errors that are registered with MapError or MapErrorFunc are converted to an
+// Error with the registered status code. As a special case, an
+// *http.MaxBytesError from reading a request body that exceeds the limit set by
+// MaxBodySize (or http.MaxBytesReader) is converted to a 413 Error, and a
+// chain.StepTimeoutError from a handler wrapped with chain.WithStepTimeout is
+// converted to a 504 Error.
 func ToError(err error) Error {
+	var errs Errors
+	if errors.As(err, &errs) && len(errs) > 0 {
+		return errs.toError()
+	}
 	var e Error
 	if errors.As(err, &e) {
 		if e.Code == 0 {
@@ -66,6 +232,41 @@ func ToError(err error) Error {
 		}
 		return e
 	}
+	var invalid ValidationError
+	if errors.As(err, &invalid) {
+		return Error{
+			Code:      http.StatusUnprocessableEntity,
+			LogMsg:    "Validation failed",
+			Cause:     err,
+			ClientMsg: http.StatusText(http.StatusUnprocessableEntity),
+		}
+	}
+	if code, ok := mappedErrorCode(err); ok {
+		return Error{
+			Code:      code,
+			LogMsg:    http.StatusText(code),
+			Cause:     err,
+			ClientMsg: http.StatusText(code),
+		}
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return Error{
+			Code:      http.StatusRequestEntityTooLarge,
+			LogMsg:    "Request body too large",
+			Cause:     err,
+			ClientMsg: http.StatusText(http.StatusRequestEntityTooLarge),
+		}
+	}
+	var timeout chain.StepTimeoutError
+	if errors.As(err, &timeout) {
+		return Error{
+			Code:      http.StatusGatewayTimeout,
+			LogMsg:    "Handler timed out",
+			Cause:     err,
+			ClientMsg: http.StatusText(http.StatusGatewayTimeout),
+		}
+	}
 	return Error{
 		Code:      http.StatusInternalServerError,
 		LogMsg:    "Failure",
@@ -74,10 +275,221 @@ func ToError(err error) Error {
 	}
 }
 
+var errorMappings struct {
+	sync.RWMutex
+	funcs []func(err error) (code int, ok bool)
+}
+
+// MapError registers the HTTP status code of errors that match target, as
+// determined by errors.Is, so that ToError, and therefore HandleError and
+// HandleErrorJson, respond with that code. This allows errors of lower layers to
+// be mapped to the correct response globally rather than wrapped by each
+// handler. For example:
+//
+//	sandwich.MapError(sql.ErrNoRows, http.StatusNotFound)
+//	sandwich.MapError(context.DeadlineExceeded, http.StatusGatewayTimeout)
+//
+// Mappings are consulted in the order in which they were registered, and the
+// first match wins. Errors that are already a sandwich.Error are not mapped.
+// MapError should be called during initialization and panics if code isn't a
+// valid status code.
+func MapError(target error, code int) {
+	checkMappedCode(code)
+	MapErrorFunc(func(err error) (int, bool) { return code, errors.Is(err, target) })
+}
+
+// MapErrorFunc registers a function that determines the HTTP status code of
+// errors, e.g. for errors of a type:
+//
+//	sandwich.MapErrorFunc(func(err error) (int, bool) {
+//	    var notFound *store.NotFoundError
+//	    return http.StatusNotFound, errors.As(err, &notFound)
+//	})
+//
+// The code is ignored unless f returns true. See MapError.
+func MapErrorFunc(f func(err error) (code int, ok bool)) {
+	errorMappings.Lock()
+	defer errorMappings.Unlock()
+	errorMappings.funcs = append(errorMappings.funcs, f)
+}
+
+func checkMappedCode(code int) {
+	if code < 100 || code > 999 {
+		panic(fmt.Errorf("invalid status code %d for mapped error", code))
+	}
+}
+
+// mappedErrorCode returns the code of the first mapping that matches err.
+func mappedErrorCode(err error) (int, bool) {
+	errorMappings.RLock()
+	defer errorMappings.RUnlock()
+	for _, f := range errorMappings.funcs {
+		if code, ok := f(err); ok && code >= 100 && code <= 999 {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// Errors aggregates several errors, e.g. multiple validation failures, so that
+// they can be returned together. HandleError and HandleErrorJson respond with
+// the client messages of all of the errors, and with the highest status code of
+// the errors as determined by ToError. For example:
+//
+//	var errs sandwich.Errors
+//	if u.Name == "" {
+//	    errs.Add(sandwich.Error{Code: 422, ClientMsg: "name is required"})
+//	}
+//	if u.Age < 0 {
+//	    errs.Add(sandwich.Error{Code: 422, ClientMsg: "age must be positive"})
+//	}
+//	return errs.Err()
+type Errors []error
+
+// Add appends err, unless it's nil. If err is an Errors, its errors are
+// appended.
+func (e *Errors) Add(err error) {
+	if errs, ok := err.(Errors); ok {
+		for _, err := range errs {
+			e.Add(err)
+		}
+	} else if err != nil {
+		*e = append(*e, err)
+	}
+}
+
+// Err returns e as an error, or nil if it's empty.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error joins the messages of the errors with "; ".
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors, for errors.Is and errors.As.
+func (e Errors) Unwrap() []error { return e }
+
+func (e Errors) toError() Error {
+	if len(e) == 1 {
+		return ToError(e[0])
+	}
+	code := 0
+	var header http.Header
+	for _, err := range e {
+		se := ToError(err)
+		if se.Code > code {
+			code = se.Code
+		}
+		if se.header != nil {
+			if header == nil {
+				header = http.Header{}
+			}
+			se.setHeaders(header)
+		}
+	}
+	se := Error{
+		Code:      code,
+		LogMsg:    fmt.Sprintf("%d errors", len(e)),
+		Cause:     e,
+		ClientMsg: http.StatusText(code),
+	}
+	if header != nil {
+		se.header = &header
+	}
+	return se
+}
+
+// ValidationError reports invalid input, such as form or API request fields,
+// with a message for each invalid field. ToError converts it to a 422 Error,
+// and the standard error handlers include the field messages in the response,
+// also if it's the Cause of a 400 Error: HandleErrorJson renders them as an
+// "errors" object, e.g.:
+//
+//	{"error":"Unprocessable Entity","errors":{"age":"must be positive"}}
+//
+// For example:
+//
+//	var invalid sandwich.ValidationError
+//	if u.Age < 0 {
+//	    invalid.Add("age", "must be positive")
+//	}
+//	return invalid.Err()
+//
+// It must be returned as a value rather than a pointer.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+// Add sets the message of an invalid field.
+func (v *ValidationError) Add(field, msg string) {
+	if v.Fields == nil {
+		v.Fields = map[string]string{}
+	}
+	v.Fields[field] = msg
+}
+
+// Err returns v as an error, or nil if no fields are invalid.
+func (v ValidationError) Err() error {
+	if len(v.Fields) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Error lists the invalid fields and their messages, sorted by field.
+func (v ValidationError) Error() string {
+	return "invalid " + strings.Join(v.fieldMessages(), "; ")
+}
+
+// invalidFields finds the ValidationError in err, or in the Cause of e, if its
+// field messages should be sent to the client: unless err was converted to e
+// with a status other than 400 or 422, e.g. by wrapping it in a 500 Error.
+func invalidFields(err error, e Error, invalid *ValidationError) bool {
+	if e.Code != http.StatusBadRequest && e.Code != http.StatusUnprocessableEntity {
+		return false
+	}
+	return errors.As(err, invalid) || (e.Cause != nil && errors.As(e.Cause, invalid))
+}
+
+// fieldMessages returns "field: message" for each field, sorted by field.
+func (v ValidationError) fieldMessages() []string {
+	msgs := make([]string, 0, len(v.Fields))
+	for field, msg := range v.Fields {
+		msgs = append(msgs, field+": "+msg)
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+// clientMessages returns the client messages of each error of the Errors in
+// err, if any.
+func clientMessages(err error) []string {
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) < 2 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = ToError(err).ClientMsg
+	}
+	return msgs
+}
+
 // HandleError is the default error handler included in sandwich.TheUsual.
 // If the error is a sandwich.Error, it responds with the specified status code
 // and client message.  Otherwise, it responds with a 500.  In both cases, the
-// underlying error is added to the request log.
+// underlying error is added to the request log. The messages of the fields of a
+// ValidationError follow the client message, one per line. In DevErrors mode,
+// the ErrorDetails follow the client message, see Router.SetErrorMode.
 //
 // If the error is sandwich.Done, HandleError does nothing.
 func HandleError(w http.ResponseWriter, r *http.Request, l *LogEntry, err error) {
@@ -86,12 +498,25 @@ func HandleError(w http.ResponseWriter, r *http.Request, l *LogEntry, err error)
 	}
 	e := ToError(err)
 	e.LogIfMsg(l)
-	http.Error(w, e.ClientMsg, e.Code)
+	msg := e.ClientMsg
+	var invalid ValidationError
+	if msgs := clientMessages(err); msgs != nil {
+		msg = strings.Join(msgs, "\n")
+	} else if invalidFields(err, e, &invalid) {
+		msg += "\n" + strings.Join(invalid.fieldMessages(), "\n")
+	}
+	if ErrorModeOf(r) == DevErrors {
+		msg += "\n\n" + strings.TrimSuffix(errorDetails(err).String(), "\n")
+	}
+	e.setHeaders(w.Header())
+	http.Error(w, msg, e.Code)
 }
 
 // HandleErrorJson is identical to HandleError except that it responds to the
 // client as JSON instead of plain text.  Again, detailed error info is added
-// to the request log.
+// to the request log. The client messages of an Errors are also listed in an
+// "errors" array, the fields of a ValidationError in an "errors" object, and
+// in DevErrors mode, the ErrorDetails are included as "details".
 //
 // If the error is sandwich.Done, HandleErrorJson does nothing.
 func HandleErrorJson(w http.ResponseWriter, r *http.Request, l *LogEntry, err error) {
@@ -100,7 +525,184 @@ func HandleErrorJson(w http.ResponseWriter, r *http.Request, l *LogEntry, err er
 	}
 	e := ToError(err)
 	e.LogIfMsg(l)
+	e.setHeaders(w.Header())
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(e.Code)
-	fmt.Fprintf(w, `{"error":%q}`, e.ClientMsg)
+	fmt.Fprintf(w, `{"error":%q`, e.ClientMsg)
+	var invalid ValidationError
+	if msgs := clientMessages(err); msgs != nil {
+		list, _ := json.Marshal(msgs)
+		fmt.Fprintf(w, `,"errors":%s`, list)
+	} else if invalidFields(err, e, &invalid) {
+		fields, _ := json.Marshal(invalid.Fields)
+		fmt.Fprintf(w, `,"errors":%s`, fields)
+	}
+	if ErrorModeOf(r) == DevErrors {
+		details, _ := json.Marshal(errorDetails(err))
+		fmt.Fprintf(w, `,"details":%s`, details)
+	}
+	fmt.Fprint(w, `}`)
+}
+
+// HandleErrorXML is identical to HandleErrorJson except that it responds to
+// the client as XML, for APIs with clients that require it. The response looks
+// like:
+//
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<error><message>Unprocessable Entity</message><errors>...</errors></error>
+//
+// The client messages of an Errors are listed as <error> elements of
+// <errors>, the fields of a ValidationError as <field name="..."> elements of
+// <fields>, and in DevErrors mode, the ErrorDetails are included as <details>.
+//
+// If the error is sandwich.Done, HandleErrorXML does nothing.
+func HandleErrorXML(w http.ResponseWriter, r *http.Request, l *LogEntry, err error) {
+	if err == Done {
+		return
+	}
+	e := ToError(err)
+	e.LogIfMsg(l)
+	e.setHeaders(w.Header())
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(e.Code)
+	resp := xmlError{Message: e.ClientMsg}
+	var invalid ValidationError
+	if msgs := clientMessages(err); msgs != nil {
+		resp.Errors = &xmlList{Items: msgs}
+	} else if invalidFields(err, e, &invalid) {
+		resp.Fields = &xmlFields{}
+		for name, msg := range invalid.Fields {
+			resp.Fields.Fields = append(resp.Fields.Fields, xmlField{name, msg})
+		}
+		sort.Slice(resp.Fields.Fields, func(i, j int) bool {
+			return resp.Fields.Fields[i].Name < resp.Fields.Fields[j].Name
+		})
+	}
+	if ErrorModeOf(r) == DevErrors {
+		resp.Details = newXMLDetails(errorDetails(err))
+	}
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(resp)
+}
+
+// The lists are pointers since encoding/xml writes the parent elements of
+// empty "a>b" fields even with omitempty.
+type xmlError struct {
+	XMLName xml.Name    `xml:"error"`
+	Message string      `xml:"message"`
+	Errors  *xmlList    `xml:"errors"`
+	Fields  *xmlFields  `xml:"fields"`
+	Details *xmlDetails `xml:"details"`
+}
+
+type xmlList struct {
+	Items []string `xml:"error"`
+}
+
+type xmlFields struct {
+	Fields []xmlField `xml:"field"`
+}
+
+type xmlField struct {
+	Name    string `xml:"name,attr"`
+	Message string `xml:",chardata"`
+}
+
+// xmlDetails is the XML form of ErrorDetails, since encoding/xml can't encode
+// maps.
+type xmlDetails struct {
+	Error      string     `xml:"error"`
+	Panic      string     `xml:"panic,omitempty"`
+	Middleware *xmlFuncs  `xml:"middleware"`
+	Stack      *xmlFrames `xml:"stack"`
+	Values     *xmlFields `xml:"values"`
+}
+
+type xmlFuncs struct {
+	Funcs []string `xml:"func"`
+}
+
+type xmlFrames struct {
+	Frames []string `xml:"frame"`
+}
+
+func newXMLDetails(d *ErrorDetails) *xmlDetails {
+	x := &xmlDetails{Error: d.Error, Panic: d.Panic}
+	if len(d.Middleware) > 0 {
+		x.Middleware = &xmlFuncs{d.Middleware}
+	}
+	if len(d.Stack) > 0 {
+		x.Stack = &xmlFrames{d.Stack}
+	}
+	if len(d.Values) > 0 {
+		x.Values = &xmlFields{}
+		for _, v := range d.sortedValues() {
+			typ, summary, _ := strings.Cut(v, ": ")
+			x.Values.Fields = append(x.Values.Fields, xmlField{typ, summary})
+		}
+	}
+	return x
+}
+
+// OnErrFor returns a ChainMutation that registers an error handler for errors
+// of type T only, as determined by errors.As. Other errors continue to be
+// handled by the most recently registered error handler that matches them. In
+// addition to the usual error handler args, the handler may accept T. This
+// allows routing different kinds of errors to different handlers without a
+// large type switch. For example:
+//
+//	mux.OnErr(sandwich.HandleError)
+//	mux.Use(sandwich.OnErrFor[sandwich.ValidationError](renderValidationError))
+//	mux.Use(sandwich.OnErrFor[*AuthError](redirectToLogin))
+//
+// Like OnErr, the error handler applies to handlers registered after it.
+func OnErrFor[T error](handler any) ChainMutation {
+	var sample any = (*T)(nil)
+	if reflect.TypeOf(sample).Elem().Kind() != reflect.Interface {
+		var zero T
+		sample = zero
+	}
+	return onErrFor{sample, handler}
+}
+
+type onErrFor struct{ sample, handler any }
+
+func (o onErrFor) Apply(c chain.Func) chain.Func {
+	return c.OnErrType(o.sample, toHandlerFunc(o.handler))
+}
+
+// OnErrorReport returns a middleware that calls report for errors that result
+// in a 5xx response, including panics, before the error handler writes the
+// response. This allows sending errors to crash reporting services, such as
+// Sentry or Rollbar, along with the request and its log entry without replacing
+// the error handler. The err is the error as it's finally mapped by any error
+// mappers, even those registered after OnErrorReport, which is a
+// chain.PanicError that includes the middleware stack for panics, and e is the
+// Error that it's converted to by ToError. For example:
+//
+//	mux := sandwich.TheUsual()
+//	mux.Use(sandwich.OnErrorReport(func(err error, e sandwich.Error, l *sandwich.LogEntry, r *http.Request) {
+//	    sentry.CaptureException(err)
+//	}))
+//
+// It applies to errors of the handlers registered after it, and must be added
+// after LogRequests, as TheUsual does. The report is called synchronously, so
+// it should not block.
+func OnErrorReport(report func(err error, e Error, entry *LogEntry, r *http.Request)) ChainMutation {
+	return errorReport(report)
+}
+
+type errorReport func(err error, e Error, entry *LogEntry, r *http.Request)
+
+func (report errorReport) Apply(c chain.Func) chain.Func {
+	// The lowest priority runs the mapper after all others.
+	return c.OnErrMapPriority(math.MinInt, func(err error, entry *LogEntry, r *http.Request) error {
+		if err == Done || err == NotHandled {
+			return err
+		}
+		if e := ToError(err); e.Code >= 500 {
+			report(err, e, entry, r)
+		}
+		return err
+	})
 }
@@ -0,0 +1,435 @@
+package sandwich
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type validationError struct{ Field string }
+
+func (e *validationError) Error() string { return "invalid " + e.Field }
+
+type temporary interface {
+	error
+	Temporary() bool
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Temporary() bool { return true }
+
+func TestOnErrFor(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Use(OnErrFor[*validationError](func(w http.ResponseWriter, e *validationError) {
+		http.Error(w, "bad field: "+e.Field, http.StatusBadRequest)
+	}))
+	r.Use(OnErrFor[temporary](func(w http.ResponseWriter, err error) {
+		http.Error(w, "try again: "+err.Error(), http.StatusServiceUnavailable)
+	}))
+	r.Get("/validation", func() error { return fmt.Errorf("wrapped: %w", &validationError{"name"}) })
+	r.Get("/timeout", func() error { return timeoutError{} })
+	r.Get("/other", func() error { return errors.New("other") })
+
+	testCases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/validation", 400, "bad field: name\n"},
+		{"/timeout", 503, "try again: timeout\n"},
+		{"/other", 500, "Internal Server Error\n"},
+	}
+	for _, test := range testCases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		assert.Equal(t, test.code, w.Code, test.path)
+		assert.Equal(t, test.body, w.Body.String(), test.path)
+	}
+}
+
+func TestStepTimeoutResponse(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/", chain.WithStepTimeout(10*time.Millisecond, func() { <-release }))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func TestOnPanic(t *testing.T) {
+	var reports []string
+	r := TheUsual()
+	r.Use(NoLog)
+	r.OnPanic(func(req *http.Request, p chain.PanicError) error {
+		reports = append(reports, fmt.Sprintf("%s: %v", req.URL.Path, p.Val))
+		return Error{Code: http.StatusServiceUnavailable, Cause: p}
+	})
+	r.Get("/boom", func() { panic("boom") })
+	noRecovery := r.SubRouter("/raw")
+	noRecovery.OnPanic(nil)
+	noRecovery.Get("/boom", func() { panic("raw boom") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/boom", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, []string{"/boom: boom"}, reports)
+
+	assert.PanicsWithValue(t, "raw boom", func() {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/raw/boom", nil))
+	})
+}
+
+func TestOnErrMap(t *testing.T) {
+	errNoRows := errors.New("sql: no rows in result set")
+	r := TheUsual()
+	r.Use(NoLog)
+	r.OnErrMap(func(err error) error {
+		if errors.Is(err, errNoRows) {
+			return Error{Code: http.StatusNotFound, ClientMsg: "Not found", Cause: err}
+		}
+		return err
+	})
+	r.Get("/users/:id", func(p Params) error {
+		if p["id"] == "missing" {
+			return fmt.Errorf("loading user: %w", errNoRows)
+		}
+		return errors.New("db down")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/users/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Not found\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/users/other", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestMapError(t *testing.T) {
+	defer func() { errorMappings.funcs = nil }()
+	errNotFound := errors.New("not found")
+	errConflict := errors.New("conflict")
+	MapError(errNotFound, http.StatusNotFound)
+	MapErrorFunc(func(err error) (int, bool) {
+		var v *validationError
+		return http.StatusUnprocessableEntity, errors.As(err, &v)
+	})
+	MapError(errConflict, http.StatusConflict)
+	MapError(errConflict, http.StatusTeapot) // shadowed by the first mapping
+
+	assert.Equal(t, Error{Code: 404, LogMsg: "Not Found", ClientMsg: "Not Found", Cause: errNotFound},
+		ToError(errNotFound))
+	wrapped := fmt.Errorf("loading user: %w", errNotFound)
+	assert.Equal(t, 404, ToError(wrapped).Code)
+	assert.Equal(t, 422, ToError(&validationError{"name"}).Code)
+	assert.Equal(t, 409, ToError(errConflict).Code)
+	assert.Equal(t, 500, ToError(errors.New("other")).Code)
+	// Explicit Errors aren't mapped.
+	assert.Equal(t, 403, ToError(Error{Code: 403, Cause: errNotFound}).Code)
+
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/user", func() error { return wrapped })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/user", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Not Found\n", w.Body.String())
+
+	assert.Panics(t, func() { MapError(errNotFound, 0) })
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	assert.NoError(t, errs.Err())
+	errs.Add(nil)
+	assert.NoError(t, errs.Err())
+
+	errs.Add(Error{Code: 422, ClientMsg: "name is required"})
+	errs.Add(Errors{Error{Code: 400, ClientMsg: "age must be positive"}, &validationError{"email"}})
+	require.Len(t, errs, 3)
+	assert.Equal(t, "(422) name is required; (400) age must be positive; invalid email", errs.Error())
+	var v *validationError
+	assert.True(t, errors.As(errs.Err(), &v), "Errors unwraps to its errors")
+
+	// The highest code wins.
+	assert.Equal(t, 500, ToError(errs).Code)
+	assert.Equal(t, 422, ToError(errs[:2]).Code)
+	assert.Equal(t, Error{Code: 422, ClientMsg: "name is required"}, ToError(errs[:1]))
+
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/text", func() error { return errs[:2] })
+	r.OnErr(HandleErrorJson)
+	r.Get("/json", func() error { return fmt.Errorf("validating: %w", errs[:2]) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/text", nil))
+	assert.Equal(t, 422, w.Code)
+	assert.Equal(t, "name is required\nage must be positive\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/json", nil))
+	assert.Equal(t, 422, w.Code)
+	assert.Equal(t, `{"error":"Unprocessable Entity","errors":["name is required","age must be positive"]}`,
+		w.Body.String())
+}
+
+func TestErrorHeaders(t *testing.T) {
+	base := Error{Code: 429, ClientMsg: "Slow down"}
+	e := base.WithRetryAfter(1500 * time.Millisecond)
+	assert.Nil(t, base.Header(), "WithHeader doesn't modify the original")
+	assert.Equal(t, "2", e.Header().Get("Retry-After"))
+	e2 := e.WithHeader("X-Limit", "10")
+	assert.Equal(t, http.Header{"Retry-After": {"2"}}, e.Header())
+	assert.Equal(t, http.Header{"Retry-After": {"2"}, "X-Limit": {"10"}}, e2.Header())
+	e.Header().Set("Retry-After", "5")
+	assert.Equal(t, "2", e.Header().Get("Retry-After"), "Header returns a copy")
+
+	// Errors with headers remain comparable.
+	var err error = e
+	assert.True(t, err == e)
+	assert.False(t, err == e2)
+	assert.ErrorIs(t, fmt.Errorf("limited: %w", e), e)
+	assert.NotErrorIs(t, fmt.Errorf("limited: %w", e), e2)
+
+	auth := Error{Code: 401, ClientMsg: "Login required"}.WithHeader("WWW-Authenticate", `Bearer realm="api"`)
+
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/limited", func() error { return fmt.Errorf("limited: %w", e) })
+	r.OnErr(HandleErrorJson)
+	r.Get("/auth", func() error { return auth })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/limited", nil))
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/auth", nil))
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, `Bearer realm="api"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
+
+func TestNotHandled(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog)
+	r.OnErr(func(w http.ResponseWriter, r *http.Request, err error) error {
+		if r.URL.Query().Get("page") == "broken" {
+			return NotHandled
+		}
+		w.WriteHeader(ToError(err).Code)
+		_, _ = w.Write([]byte("<h1>Oops</h1>"))
+		return nil
+	})
+	r.Get("/", func() error { return Error{Code: 404, ClientMsg: "Nope"} })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "<h1>Oops</h1>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/?page=broken", nil))
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "Nope\n", w.Body.String(), "falls back to HandleError")
+}
+
+func TestOnErrorReport(t *testing.T) {
+	type report struct {
+		path string
+		code int
+		err  error
+	}
+	var reports []report
+	r := TheUsual()
+	r.Use(NoLog, OnErrorReport(func(err error, e Error, l *LogEntry, req *http.Request) {
+		assert.NotNil(t, l)
+		reports = append(reports, report{req.URL.Path, e.Code, err})
+	}))
+	r.Get("/ok", func() {})
+	r.Get("/notfound", func() error { return Error{Code: 404} })
+	r.Get("/fail", func() error { return errors.New("oops") })
+	r.Get("/done", func() error { return Done })
+	r.Get("/panic", func() { panic("kaboom") })
+
+	for _, path := range []string{"/ok", "/notfound", "/fail", "/done", "/panic"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	require.Len(t, reports, 2)
+	assert.Equal(t, report{"/fail", 500, errors.New("oops")}, reports[0])
+	assert.Equal(t, "/panic", reports[1].path)
+	assert.IsType(t, chain.PanicError{}, reports[1].err)
+
+	// Errors are reported as they're finally mapped, even by mappers that are
+	// registered after OnErrorReport.
+	errMissing := errors.New("missing")
+	errBusy := errors.New("busy")
+	mapped := r.SubRouter("/mapped")
+	mapped.OnErrMap(func(err error) error {
+		switch err {
+		case errMissing:
+			return Error{Code: 404, Cause: err}
+		case errBusy:
+			return Error{Code: 503, ClientMsg: "Try again later", Cause: err}
+		}
+		return err
+	})
+	mapped.Get("/missing", func() error { return errMissing })
+	mapped.Get("/busy", func() error { return errBusy })
+
+	reports = nil
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/mapped/missing", nil))
+	assert.Equal(t, 404, w.Code)
+	assert.Empty(t, reports)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/mapped/busy", nil))
+	assert.Equal(t, 503, w.Code)
+	require.Len(t, reports, 1)
+	assert.Equal(t, report{"/mapped/busy", 503, Error{Code: 503, ClientMsg: "Try again later", Cause: errBusy}}, reports[0])
+}
+
+func TestValidationError(t *testing.T) {
+	var invalid ValidationError
+	assert.NoError(t, invalid.Err())
+	invalid.Add("name", "is required")
+	invalid.Add("age", "must be positive")
+	assert.EqualError(t, invalid.Err(), "invalid age: must be positive; name: is required")
+	assert.Equal(t, 422, ToError(fmt.Errorf("creating user: %w", invalid)).Code)
+	// Explicit Errors take precedence.
+	assert.Equal(t, 400, ToError(Error{Code: 400, Cause: invalid}).Code)
+
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/text", func() error { return invalid })
+	api := r.SubRouter("/api")
+	api.OnErr(HandleErrorJson)
+	api.Get("/json", func() error { return invalid })
+	env := r.SubRouter("/env")
+	env.Use(Enveloped)
+	env.Get("/json", func() error { return invalid })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/text")
+	assert.Equal(t, 422, w.Code)
+	assert.Equal(t, "Unprocessable Entity\nage: must be positive\nname: is required\n", w.Body.String())
+
+	w = serve("/api/json")
+	assert.Equal(t, 422, w.Code)
+	assert.Equal(t, `{"error":"Unprocessable Entity","errors":{"age":"must be positive","name":"is required"}}`,
+		w.Body.String())
+
+	w = serve("/env/json")
+	assert.Equal(t, 422, w.Code)
+	assert.JSONEq(t, `{"error":{"code":422,"message":"Unprocessable Entity",`+
+		`"fields":{"age":"must be positive","name":"is required"}}}`, w.Body.String())
+}
+
+func TestHandleErrorXML(t *testing.T) {
+	var invalid ValidationError
+	invalid.Add("name", "is required")
+	invalid.Add("email", "must contain <@>")
+
+	r := TheUsual()
+	r.Use(NoLog)
+	r.OnErr(HandleErrorXML)
+	r.Get("/fail", func() error {
+		return Error{Code: 409, ClientMsg: `Conflict with "a" & <b>`}.WithHeader("X-Reason", "dup")
+	})
+	r.Get("/invalid", func() error { return invalid })
+	r.Get("/many", func() error { return Errors{Error{Code: 400, ClientMsg: "a"}, Error{Code: 400, ClientMsg: "b"}} })
+	r.Get("/done", func(w http.ResponseWriter) error { w.WriteHeader(204); return Done })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/fail")
+	assert.Equal(t, 409, w.Code)
+	assert.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "dup", w.Header().Get("X-Reason"))
+	assert.Equal(t, xml.Header+`<error><message>Conflict with &#34;a&#34; &amp; &lt;b&gt;</message></error>`,
+		w.Body.String())
+
+	w = serve("/invalid")
+	assert.Equal(t, 422, w.Code)
+	assert.Equal(t, xml.Header+`<error><message>Unprocessable Entity</message><fields>`+
+		`<field name="email">must contain &lt;@&gt;</field><field name="name">is required</field>`+
+		`</fields></error>`, w.Body.String())
+
+	w = serve("/many")
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, xml.Header+`<error><message>Bad Request</message>`+
+		`<errors><error>a</error><error>b</error></errors></error>`, w.Body.String())
+
+	w = serve("/done")
+	assert.Equal(t, 204, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	r.SetErrorMode(DevErrors)
+	r.Get("/panic", func() { panic("boom") })
+	w = serve("/panic")
+	assert.Equal(t, 500, w.Code)
+	var resp struct {
+		Message string `xml:"message"`
+		Panic   string `xml:"details>panic"`
+	}
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Internal Server Error", resp.Message)
+	assert.Equal(t, "boom", resp.Panic)
+}
+
+func TestErrorHelpers(t *testing.T) {
+	assert.Equal(t, Error{Code: 400, ClientMsg: "Invalid id"}, BadRequest("Invalid id"))
+	assert.Equal(t, Error{Code: 401, ClientMsg: "Unauthorized"}, Unauthorized(""))
+	assert.Equal(t, Error{Code: 403, ClientMsg: "Admins only"}, Forbidden("Admins only"))
+	assert.Equal(t, Error{Code: 404, ClientMsg: "Not Found"}, NotFoundErr())
+	assert.Equal(t, Error{Code: 409, ClientMsg: "Already exists"}, Conflict("Already exists"))
+	assert.Equal(t, "2", TooManyRequests(2*time.Second).Header().Get("Retry-After"))
+
+	cause := errors.New("db down")
+	e := Internal(cause)
+	assert.Equal(t, 500, e.Code)
+	assert.Equal(t, "Internal Server Error", e.ClientMsg)
+	assert.Equal(t, cause, e.Cause)
+
+	assert.True(t, IsClientError(fmt.Errorf("looking up: %w", NotFoundErr())))
+	assert.False(t, IsServerError(NotFoundErr()))
+	assert.True(t, IsServerError(e))
+	assert.True(t, IsServerError(cause), "plain errors are 500s")
+	assert.False(t, IsClientError(cause))
+	var invalid ValidationError
+	invalid.Add("name", "is required")
+	assert.True(t, IsClientError(invalid))
+	for _, err := range []error{nil, Done} {
+		assert.False(t, IsClientError(err))
+		assert.False(t, IsServerError(err))
+	}
+}
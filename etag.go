@@ -0,0 +1,296 @@
+package sandwich
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerETag            = "ETag"
+	headerLastModified    = "Last-Modified"
+	headerIfNoneMatch     = "If-None-Match"
+	headerIfModifiedSince = "If-Modified-Since"
+)
+
+// ETag is an entity tag that identifies a version of a response, see
+// Conditional.Check.
+type ETag struct {
+	Tag string // the opaque tag, without quotes
+	// Weak marks the tag as a weak validator: the response is semantically
+	// equivalent to other responses with the same tag, but not necessarily
+	// byte-for-byte identical, e.g. because it's compressed differently.
+	Weak bool
+}
+
+// String formats the tag as an ETag header value, e.g. `"abc"` or `W/"abc"`.
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Tag + `"`
+	}
+	return `"` + e.Tag + `"`
+}
+
+// ETagOptions configures ETagsWith.
+type ETagOptions struct {
+	// Weak makes the computed ETags weak validators. This is appropriate when
+	// the response is compressed afterwards, e.g. by Gzip.
+	Weak bool
+	// MaxBuffer is the maximum size, in bytes, of the responses that are
+	// buffered to compute their ETag. Larger responses, and responses that are
+	// flushed by the handler, are streamed without an ETag. If zero, 1 MB is
+	// used.
+	MaxBuffer int
+}
+
+// ETags is a middleware Wrap that adds an ETag to the successful responses of
+// GET and HEAD requests and responds with a 304 Not Modified if the client's
+// If-None-Match or If-Modified-Since header shows that its cached copy is
+// current. It uses the default ETagOptions.
+//
+// The ETag is the hash of the response, so the response is buffered. Handlers
+// that can identify the version of the response up front, e.g. from a database
+// row version, should use the provided *Conditional instead, which skips the
+// buffering and allows skipping the work of rendering the response:
+//
+//	router.Get("/users/:id", sandwich.ETags, loadUser, getUser)
+//
+//	func getUser(w http.ResponseWriter, c *sandwich.Conditional, u *User) error {
+//	    if c.Check(sandwich.ETag{Tag: strconv.Itoa(u.Version)}, u.Updated) {
+//	        return sandwich.Done
+//	    }
+//	    ...
+//	}
+//
+// Responses that already have an ETag header when they are written aren't
+// buffered either, and their ETag and Last-Modified headers are checked
+// against the request.
+var ETags = ETagsWith(ETagOptions{})
+
+// ETagsWith is like ETags but allows configuring the computed ETags.
+func ETagsWith(opts ETagOptions) Wrap {
+	if opts.MaxBuffer <= 0 {
+		opts.MaxBuffer = defaultMaxBodyMemory
+	}
+	return Wrap{
+		Before: func(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *etagWriter, *Conditional) {
+			ew := &etagWriter{ResponseWriter: w, r: r, opts: opts}
+			ew.streaming = r.Method != http.MethodGet && r.Method != http.MethodHead
+			return ew, ew, &Conditional{ew}
+		},
+		After: (*etagWriter).finish,
+	}
+}
+
+// Conditional is provided by ETags so that handlers can supply the validators
+// of the response themselves.
+type Conditional struct{ w *etagWriter }
+
+// Check sets the ETag header of the response to etag, if its Tag isn't empty,
+// and the Last-Modified header to modTime, if it isn't zero. It reports whether
+// the request's If-None-Match or If-Modified-Since header shows that the
+// client's cached copy is current, in which case a 304 Not Modified has been
+// sent and the handler should return without writing the body, e.g. by
+// returning Done. Otherwise the response is streamed to the client rather than
+// buffered.
+func (c *Conditional) Check(etag ETag, modTime time.Time) (notModified bool) {
+	ew := c.w
+	h := ew.Header()
+	if etag.Tag != "" {
+		h.Set(headerETag, etag.String())
+	}
+	if !modTime.IsZero() {
+		h.Set(headerLastModified, modTime.UTC().Format(http.TimeFormat))
+	}
+	if ew.streaming || ew.code != 0 {
+		return false
+	}
+	ew.streaming = true
+	if !notModifiedSince(ew.r, h) {
+		return false
+	}
+	ew.notModified = true
+	writeNotModified(ew.ResponseWriter)
+	return true
+}
+
+// etagWriter buffers the successful response of a GET or HEAD request to
+// compute its ETag, unless streaming is set.
+type etagWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	opts        ETagOptions
+	code        int // the pending status code, or 0 if not written yet
+	buf         bytes.Buffer
+	streaming   bool // whether the response is written through
+	notModified bool // whether a 304 was sent, so the body is discarded
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if ew.notModified {
+		return
+	}
+	if ew.streaming {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if ew.code == 0 {
+		ew.code = code
+		ew.checkPrecomputed()
+	}
+}
+
+// checkPrecomputed starts streaming the response if it's not a 200 or if the
+// handler set its ETag, responding with a 304 if the client's copy is current.
+func (ew *etagWriter) checkPrecomputed() {
+	h := ew.Header()
+	switch {
+	case ew.code != http.StatusOK:
+		ew.stream()
+	case h.Get(headerETag) != "":
+		ew.streaming = true
+		if notModifiedSince(ew.r, h) {
+			ew.notModified = true
+			writeNotModified(ew.ResponseWriter)
+		} else {
+			ew.ResponseWriter.WriteHeader(ew.code)
+		}
+	}
+}
+
+func (ew *etagWriter) Write(p []byte) (int, error) {
+	if ew.code == 0 && !ew.streaming && !ew.notModified {
+		ew.code = http.StatusOK
+		ew.checkPrecomputed()
+	}
+	if ew.notModified {
+		return len(p), nil
+	}
+	if ew.streaming {
+		return ew.ResponseWriter.Write(p)
+	}
+	if ew.buf.Len()+len(p) > ew.opts.MaxBuffer {
+		if err := ew.stream(); err != nil {
+			return 0, err
+		}
+		return ew.ResponseWriter.Write(p)
+	}
+	return ew.buf.Write(p)
+}
+
+// Flush streams the response, since the handler wants it to be sent
+// immediately.
+func (ew *etagWriter) Flush() {
+	if !ew.streaming && !ew.notModified {
+		ew.stream()
+	}
+	if flusher, ok := ew.ResponseWriter.(http.Flusher); ok && !ew.notModified {
+		flusher.Flush()
+	}
+}
+
+// stream writes the pending status code and buffered body, and writes the rest
+// of the response through.
+func (ew *etagWriter) stream() error {
+	ew.streaming = true
+	if ew.code != 0 {
+		ew.ResponseWriter.WriteHeader(ew.code)
+	}
+	if ew.buf.Len() == 0 {
+		return nil
+	}
+	_, err := ew.ResponseWriter.Write(ew.buf.Bytes())
+	ew.buf.Reset()
+	return err
+}
+
+// finish computes the ETag of the buffered response and sends it, or a 304 if
+// the client's copy is current.
+func (ew *etagWriter) finish() {
+	if ew.streaming || ew.notModified {
+		return
+	}
+	if ew.code == 0 {
+		ew.code = http.StatusOK
+	}
+	sum := sha256.Sum256(ew.buf.Bytes())
+	etag := ETag{Tag: base64.RawURLEncoding.EncodeToString(sum[:16]), Weak: ew.opts.Weak}
+	h := ew.Header()
+	h.Set(headerETag, etag.String())
+	if notModifiedSince(ew.r, h) {
+		ew.notModified = true
+		writeNotModified(ew.ResponseWriter)
+		return
+	}
+	if h.Get(headerContentEncoding) == "" {
+		h.Set(headerContentLength, strconv.Itoa(ew.buf.Len()))
+	}
+	ew.stream()
+}
+
+// notModifiedSince reports whether the request's conditional headers match
+// the validators in the response header h, so that a 304 should be sent. As
+// specified by RFC 9110, If-Modified-Since is ignored if the request has an
+// If-None-Match header.
+func notModifiedSince(r *http.Request, h http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get(headerIfNoneMatch); inm != "" {
+		return etagMatches(inm, h.Get(headerETag))
+	}
+	ims, err := http.ParseTime(r.Header.Get(headerIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	modTime, err := http.ParseTime(h.Get(headerLastModified))
+	return err == nil && !modTime.After(ims)
+}
+
+// etagMatches reports whether the If-None-Match header value, a list of ETags
+// or "*", matches etag using the weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for s := strings.TrimSpace(ifNoneMatch); s != ""; {
+		if s[0] == ',' || s[0] == ' ' || s[0] == '\t' {
+			s = s[1:]
+			continue
+		}
+		if s[0] == '*' {
+			return true
+		}
+		s = strings.TrimPrefix(s, "W/")
+		if len(s) < 2 || s[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 {
+			return false
+		}
+		if s[:end+2] == etag {
+			return true
+		}
+		s = s[end+2:]
+	}
+	return false
+}
+
+// writeNotModified sends a 304 without the headers that describe the body, as
+// http.ServeContent does.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del(headerContentType)
+	h.Del(headerContentLength)
+	h.Del(headerContentEncoding)
+	if h.Get(headerETag) != "" {
+		h.Del(headerLastModified)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,97 @@
+package sandwich
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETags(t *testing.T) {
+	updated := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rendered := 0
+	r := TheUsual()
+	r.Use(NoLog, ETags)
+	r.Get("/page", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "hello ")
+		fmt.Fprint(w, "world")
+	})
+	r.Get("/missing", func(w http.ResponseWriter) error { return NotFoundErr() })
+	r.Get("/big", func(w http.ResponseWriter) { fmt.Fprint(w, strings.Repeat("x", 2<<20)) })
+	r.Get("/user", func(w http.ResponseWriter, c *Conditional) error {
+		if c.Check(ETag{Tag: "v7", Weak: true}, updated) {
+			return Done
+		}
+		rendered++
+		fmt.Fprint(w, "user v7")
+		return nil
+	})
+	r.Post("/page", func(w http.ResponseWriter) { fmt.Fprint(w, "posted") })
+
+	serve := func(method, path string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/page")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "hello world", w.Body.String())
+	assert.Equal(t, "11", w.Header().Get("Content-Length"))
+	etag := w.Header().Get("ETag")
+	require.Regexp(t, `^"[\w-]{22}"$`, etag)
+
+	w = serve("GET", "/page", "If-None-Match", `"other", `+etag)
+	assert.Equal(t, 304, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("Content-Type"))
+
+	w = serve("GET", "/page", "If-None-Match", `"other"`)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "hello world", w.Body.String())
+
+	w = serve("GET", "/missing", "If-None-Match", "*")
+	assert.Equal(t, 404, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	w = serve("GET", "/big")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 2<<20, w.Body.Len())
+	assert.Empty(t, w.Header().Get("ETag"), "too large to buffer")
+
+	w = serve("POST", "/page")
+	assert.Equal(t, "posted", w.Body.String())
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	// Precomputed ETags skip rendering the response.
+	w = serve("GET", "/user")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `W/"v7"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Thu, 02 Jan 2020 03:04:05 GMT", w.Header().Get("Last-Modified"))
+	assert.Equal(t, "user v7", w.Body.String())
+	assert.Equal(t, 1, rendered)
+
+	w = serve("GET", "/user", "If-None-Match", `"v7"`)
+	assert.Equal(t, 304, w.Code)
+	assert.Equal(t, 1, rendered)
+
+	w = serve("GET", "/user", "If-Modified-Since", "Thu, 02 Jan 2020 03:04:05 GMT")
+	assert.Equal(t, 304, w.Code)
+	w = serve("GET", "/user", "If-Modified-Since", "Thu, 02 Jan 2020 03:04:04 GMT")
+	assert.Equal(t, 200, w.Code)
+	// If-None-Match takes precedence.
+	w = serve("GET", "/user", "If-None-Match", `"v6"`, "If-Modified-Since", "Thu, 02 Jan 2020 03:04:05 GMT")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 3, rendered)
+}
@@ -12,6 +12,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
 	"log"
 	"net/http"
@@ -37,6 +38,18 @@ func main() {
 	mux := sandwich.TheUsual()
 	// Inject config and user database; now available to all handlers.
 	mux.SetAs(udb, (*UserDb)(nil))
+	// The auth cookie is encrypted with a random key, so users have to log in
+	// again when the server restarts. A real server would load its keys from
+	// its config instead.
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal("Can't generate cookie key:", err)
+	}
+	mux.Use(sandwich.SecureCookies(sandwich.CookieConfig{
+		Keys:     [][]byte{key},
+		MaxAge:   time.Hour,
+		Insecure: true, // for local testing only
+	}))
 	// In this example, we'll always check to see if the user is logged in.
 	// If so, we'll add the user ID to the log entries.
 	mux.Use(ParseUserIfLoggedIn)
@@ -106,28 +119,25 @@ func ShowUserProfile(w http.ResponseWriter, u User) {
 	}
 }
 
-func Login(w http.ResponseWriter, r *http.Request, udb UserDb, e *sandwich.LogEntry) {
+func Login(w http.ResponseWriter, r *http.Request, udb UserDb, c *sandwich.Cookies, e *sandwich.LogEntry) error {
 	u, err := udb.Lookup(r.FormValue("id"))
 	if err != nil {
 		log.Printf("No such user id: %q", r.FormValue("id"))
-		http.SetCookie(w, &http.Cookie{Name: "auth", Value: "", Expires: time.Now()})
+		c.Delete("auth")
 		// Redirect to /
 		fmt.Fprintf(w, `<html><head>
 			<meta http-equiv="refresh" content="0;URL='/'"/>`)
-		return
+		return nil
 	}
 
 	e.Note["userId"] = u.Id
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth",
-		Value:    u.Id, // Encrypt cookie here, maybe include the whole user struct.
-		Expires:  time.Now().Add(time.Hour),
-		MaxAge:   int(time.Hour / time.Second),
-		HttpOnly: true,
-	})
+	if err := c.SetEncrypted("auth", u.Id); err != nil {
+		return err
+	}
 	// Redirect to /user/profile
 	fmt.Fprintf(w, `<html><head>
 			<meta http-equiv="refresh" content="0;URL='/user/profile'"/>`)
+	return nil
 }
 
 func FailIfNotAuthenticated(u *User) (User, error) {
@@ -141,17 +151,8 @@ func FailIfNotAuthenticated(u *User) (User, error) {
 	return *u, nil
 }
 
-func getAndParseCookie(r *http.Request) (string, error) {
-	c, err := r.Cookie("auth")
-	if err != nil {
-		return "", err
-	}
-	userid := c.Value // Decrypt cookie here, maybe getting a whole user struct.
-	return userid, nil
-}
-
-func ParseUserIfLoggedIn(r *http.Request, udb UserDb, e *sandwich.LogEntry) (*User, error) {
-	if user_id, err := getAndParseCookie(r); err != nil {
+func ParseUserIfLoggedIn(c *sandwich.Cookies, udb UserDb, e *sandwich.LogEntry) (*User, error) {
+	if user_id, err := c.GetEncrypted("auth"); err != nil {
 		return nil, nil // not logged in or expired or corrupt.  Ignore cookie.
 	} else if user, err := udb.Lookup(user_id); err != nil {
 		log.Printf("No such user: %q", user_id)
@@ -19,6 +19,7 @@
 package main
 
 import (
+	"bytes"
 	"embed"
 	"encoding/json"
 	"fmt"
@@ -67,6 +68,8 @@ func main() {
 	mux.Use(ParseUserCookie, LogUser)
 	mux.SetAs(taskDb, (*TaskDb)(nil))
 	mux.Set(tpl)
+	// Errors are rendered with our custom page, falling back to the plain
+	// sandwich.HandleError if the page can't be rendered.
 	mux.OnErr(CustomErrorPage)
 
 	// Don't log these requests since we don't have a favicon, it's just a
@@ -97,8 +100,8 @@ func main() {
 	api := mux.SubRouter("/api/")
 	api.OnErr(sandwich.HandleErrorJson)
 	api.Use(RequireLoggedIn)
-	api.Post("/task", TaskFromAddRequest, TaskDb.Add, SendTaskAsJson)
-	api.Post("/task/:id", TaskOpFromUpdateRequest, UpdateTask)
+	api.Post("/task", sandwich.JSONBody[*Task](taskJSON), CheckNewTask, TaskDb.Add, SendTaskAsJson)
+	api.Post("/task/:id", sandwich.JSONBody[TaskOp](taskJSON), CheckTaskOp, UpdateTask)
 
 	// Catch all remaining URLs and respond with not-found errors.  We
 	// explicitly use the error-return mechanism so that we get the JSON
@@ -178,16 +181,14 @@ func Home(
 	})
 }
 
-func TaskFromAddRequest(r *http.Request) (*Task, error) {
-	var t Task
-	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		return nil, sandwich.Error{Code: http.StatusBadRequest, Cause: err}
-	}
+// taskJSON configures the decoding of the task API requests.
+var taskJSON = sandwich.JSONOptions{DisallowUnknownFields: true, MaxBytes: 64 << 10}
+
+func CheckNewTask(t *Task) error {
 	if t.Desc == "" {
-		return nil, sandwich.Error{Code: http.StatusBadRequest,
-			ClientMsg: "Please include a task description"}
+		return sandwich.BadRequest("Please include a task description")
 	}
-	return &t, nil
+	return nil
 }
 
 func SendTaskAsJson(w http.ResponseWriter, t *Task) error {
@@ -199,18 +200,11 @@ type TaskOp struct {
 	Id     string
 }
 
-func TaskOpFromUpdateRequest(r *http.Request) (TaskOp, error) {
-	var op TaskOp
-	if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
-		return op, sandwich.Error{Code: http.StatusBadRequest, Cause: err}
-	}
+func CheckTaskOp(op TaskOp) error {
 	if op.Id == "" {
-		return op, sandwich.Error{
-			Code:      http.StatusBadRequest,
-			ClientMsg: "Invalid op: missing task id",
-		}
+		return sandwich.BadRequest("Invalid op: missing task id")
 	}
-	return op, nil
+	return nil
 }
 
 func UpdateTask(w http.ResponseWriter, r *http.Request, uid UserId, op TaskOp, db TaskDb) error {
@@ -249,30 +243,35 @@ func CustomErrorPage(
 	err error,
 	tpl *template.Template,
 	l *sandwich.LogEntry,
-) {
+) error {
 	// Make sure we actually have a real error:
 	if err == sandwich.Done {
-		return
+		return nil
 	}
 	// Convert the error to a sandwich.Error that has an error code.
 	e := sandwich.ToError(err)
-	// Always log the error and error details.
-	l.Error = e
 
-	w.WriteHeader(e.Code)
-	err = tpl.ExecuteTemplate(w, "error.tpl.html", map[string]interface{}{
+	// Render the page before writing anything so that we can still back out if
+	// the template fails.
+	var page bytes.Buffer
+	if tplErr := tpl.ExecuteTemplate(&page, "error.tpl.html", map[string]interface{}{
 		"Error": e,
-	})
-
-	// But... what if our fancy template rendering fails?  At this point, we
-	// fall back to the simplest possible thing: http.Error(...).  Maybe it'll
-	// work, but we'll also log the error so it doesn't disappear.
-	if err != nil {
+	}); tplErr != nil {
+		// But... what if our fancy template rendering fails?  At this point, we
+		// fall back to the error handler that was registered before this one,
+		// which is sandwich.HandleError from TheUsual, but we'll also note the
+		// template error so it doesn't disappear.
+		//
 		// Try putting a typo in the template name above, and you'll see this:
-		l.Error = fmt.Errorf("Failed to render error page: %v\nTriggering error: %v",
-			err, e)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		l.Note["error_page"] = "Failed to render error page: " + tplErr.Error()
+		return sandwich.NotHandled
 	}
+
+	// Always log the error and error details.
+	l.Error = e
+	w.WriteHeader(e.Code)
+	_, _ = page.WriteTo(w)
+	return nil
 }
 
 func CheckForFakeLogin(w http.ResponseWriter, r *http.Request) error {
@@ -298,11 +297,11 @@ func CheckForFakeLogin(w http.ResponseWriter, r *http.Request) error {
 // You could also use .Then(http.NotFound), but that wouldn't go through the
 // error-handlers.  The advantage of using the error handlers is that you
 // automatically get JSON vs HTML handling.
-func NotFound() error { return sandwich.Error{Code: http.StatusNotFound} }
+func NotFound() error { return sandwich.NotFoundErr() }
 
 func RequireLoggedIn(u auth.User) error {
 	if u == nil {
-		return sandwich.Error{Code: http.StatusUnauthorized}
+		return sandwich.Unauthorized("")
 	}
 	return nil
 }
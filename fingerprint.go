@@ -0,0 +1,119 @@
+package sandwich
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Fingerprint is a stable, opaque identifier of the client that made a
+// request, derived from its network address and headers. It's provided by
+// FingerprintRequest or a Fingerprinter and intended as a key for rate limiting
+// and for correlating suspicious requests in the logs. Unrelated clients may
+// share a fingerprint, e.g. behind the same NAT, so it shouldn't be used to
+// identify users.
+type Fingerprint string
+
+// FingerprintConfig configures the components of a Fingerprint. The zero value
+// uses the client's /24 IPv4 or /48 IPv6 network, User-Agent, and TLS
+// connection parameters.
+type FingerprintConfig struct {
+	// IPv4PrefixBits and IPv6PrefixBits are the number of leading bits of the
+	// client's address that are included. Smaller prefixes group more clients
+	// together and reveal less about each of them. If zero, 24 and 48 are used.
+	IPv4PrefixBits, IPv6PrefixBits int
+	// OmitIP, OmitUserAgent, and OmitTLS exclude the client's address,
+	// User-Agent header, and TLS connection parameters respectively.
+	OmitIP, OmitUserAgent, OmitTLS bool
+	// Headers are additional request headers that are included, e.g.
+	// "Accept-Language".
+	Headers []string
+	// Extra, if set, returns additional application-specific data to include,
+	// such as an API key id.
+	Extra func(r *http.Request) string
+	// Key is used to compute the fingerprint as an HMAC so that fingerprints
+	// can't be correlated with those of other deployments or reversed by
+	// guessing the inputs. If empty, a plain hash is used.
+	Key []byte
+}
+
+// FingerprintRequest is a middleware handler that provides the Fingerprint of
+// the request using the default FingerprintConfig. For example:
+//
+//	mux.Use(sandwich.FingerprintRequest, rateLimit)
+//
+//	func rateLimit(fp sandwich.Fingerprint) error {
+//	  if !limiter.Allow(string(fp)) {
+//	    return sandwich.Error{Code: http.StatusTooManyRequests}
+//	  }
+//	  return nil
+//	}
+var FingerprintRequest = Fingerprinter(FingerprintConfig{})
+
+// Fingerprinter returns a middleware handler that provides the Fingerprint of
+// each request as configured by cfg.
+func Fingerprinter(cfg FingerprintConfig) func(r *http.Request) Fingerprint {
+	if cfg.IPv4PrefixBits <= 0 || cfg.IPv4PrefixBits > 32 {
+		cfg.IPv4PrefixBits = 24
+	}
+	if cfg.IPv6PrefixBits <= 0 || cfg.IPv6PrefixBits > 128 {
+		cfg.IPv6PrefixBits = 48
+	}
+	return cfg.fingerprint
+}
+
+func (cfg FingerprintConfig) fingerprint(r *http.Request) Fingerprint {
+	var parts []string
+	if !cfg.OmitIP {
+		parts = append(parts, "ip="+cfg.ipPrefix(remoteIp(r)))
+	}
+	if !cfg.OmitUserAgent {
+		parts = append(parts, "ua="+r.UserAgent())
+	}
+	if !cfg.OmitTLS && r.TLS != nil {
+		parts = append(parts, "tls="+strings.Join([]string{
+			strconv.Itoa(int(r.TLS.Version)),
+			strconv.Itoa(int(r.TLS.CipherSuite)),
+			r.TLS.NegotiatedProtocol,
+		}, ","))
+	}
+	for _, h := range cfg.Headers {
+		parts = append(parts, strings.ToLower(h)+"="+strings.Join(r.Header.Values(h), ","))
+	}
+	if cfg.Extra != nil {
+		parts = append(parts, "extra="+cfg.Extra(r))
+	}
+	data := []byte(strings.Join(parts, "\x00"))
+
+	var sum []byte
+	if len(cfg.Key) > 0 {
+		mac := hmac.New(sha256.New, cfg.Key)
+		mac.Write(data)
+		sum = mac.Sum(nil)
+	} else {
+		h := sha256.Sum256(data)
+		sum = h[:]
+	}
+	return Fingerprint(hex.EncodeToString(sum[:16]))
+}
+
+// ipPrefix returns the network of the client address, which may include a port
+// or, if forwarded, a list of addresses of which the first is the client.
+func (cfg FingerprintConfig) ipPrefix(addr string) string {
+	addr = strings.TrimSpace(strings.Split(addr, ",")[0])
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(cfg.IPv4PrefixBits, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(cfg.IPv6PrefixBits, 128)).String()
+}
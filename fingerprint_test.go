@@ -0,0 +1,68 @@
+package sandwich
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	req := func(addr, ua string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = addr
+		r.Header.Set("User-Agent", ua)
+		return r
+	}
+
+	fp := FingerprintRequest
+	a := fp(req("1.2.3.4:1000", "curl"))
+	assert.Len(t, string(a), 32)
+	assert.Equal(t, a, fp(req("1.2.3.99:2000", "curl")), "same /24 network")
+	assert.NotEqual(t, a, fp(req("1.2.4.4:1000", "curl")))
+	assert.NotEqual(t, a, fp(req("1.2.3.4:1000", "firefox")))
+	assert.Equal(t,
+		fp(req("[2001:db8:1::1]:80", "curl")),
+		fp(req("[2001:db8:1:ffff::2]:80", "curl")), "same /48 network")
+
+	forwarded := req("10.0.0.1:80", "curl")
+	forwarded.Header.Set("X-Forwarded-For", "1.2.3.5, 10.0.0.1")
+	assert.Equal(t, a, fp(forwarded))
+
+	secure := req("1.2.3.4:1000", "curl")
+	secure.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256}
+	assert.NotEqual(t, a, fp(secure))
+	assert.Equal(t, a, Fingerprinter(FingerprintConfig{OmitTLS: true})(secure))
+
+	custom := Fingerprinter(FingerprintConfig{
+		IPv4PrefixBits: 16,
+		OmitUserAgent:  true,
+		Headers:        []string{"Accept-Language"},
+		Extra:          func(r *http.Request) string { return r.URL.Query().Get("key") },
+		Key:            []byte("secret"),
+	})
+	b := custom(req("1.2.3.4:1000", "curl"))
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, b, custom(req("1.2.200.4:1000", "firefox")))
+	lang := req("1.2.3.4:1000", "curl")
+	lang.Header.Set("Accept-Language", "fr")
+	assert.NotEqual(t, b, custom(lang))
+	keyed := req("1.2.3.4:1000", "curl")
+	keyed.URL.RawQuery = "key=abc"
+	assert.NotEqual(t, b, custom(keyed))
+	assert.NotEqual(t, b, Fingerprinter(FingerprintConfig{
+		IPv4PrefixBits: 16, OmitUserAgent: true, Headers: []string{"Accept-Language"},
+		Extra: func(r *http.Request) string { return "" },
+	})(req("1.2.3.4:1000", "curl")), "keyed differently")
+}
+
+func TestFingerprintRequestMiddleware(t *testing.T) {
+	var got Fingerprint
+	r := TheUsual()
+	r.Use(NoLog, FingerprintRequest)
+	r.Get("/", func(fp Fingerprint) { got = fp })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.NotEmpty(t, got)
+}
@@ -0,0 +1,85 @@
+package sandwich
+
+import "encoding/json"
+
+// flashCookie is the name of the cookie that stores the pending flash messages.
+const flashCookie = "flash"
+
+// FlashMessage is a message that's shown to the user once, see Flash.
+type FlashMessage struct {
+	Kind    string `json:"k"` // e.g. "success" or "error"
+	Message string `json:"m"`
+}
+
+// Flash stores messages for the user that are shown on the next page that
+// displays them, typically after a redirect, e.g. to confirm that a form was
+// submitted. It's provided by LoadFlash.
+type Flash struct {
+	c       *Cookies
+	pending []FlashMessage
+}
+
+// LoadFlash is a middleware handler that provides the *Flash of the request. It
+// requires SecureCookies, since the messages are stored in a signed cookie. For
+// example:
+//
+//	mux.Use(sandwich.SecureCookies(cookieConfig), sandwich.LoadFlash)
+//	mux.Post("/settings", func(w http.ResponseWriter, r *http.Request, f *sandwich.Flash) error {
+//	    ...
+//	    f.Add("success", "Your settings were saved.")
+//	    http.Redirect(w, r, "/settings", http.StatusSeeOther)
+//	    return nil
+//	})
+//	mux.Get("/settings", func(w http.ResponseWriter, f *sandwich.Flash) error {
+//	    return tpl.Execute(w, map[string]any{"Flash": f, ...})
+//	})
+//
+// and in the template:
+//
+//	{{range .Flash.Messages}}<div class="{{.Kind}}">{{.Message}}</div>{{end}}
+//
+// The messages of earlier requests are removed from the client as soon as
+// they're loaded, before any handler writes the response, so they're only
+// shown once even if Messages is called while the response is written.
+func LoadFlash(c *Cookies) *Flash {
+	f := &Flash{c: c}
+	if _, err := c.r.Cookie(flashCookie); err != nil {
+		return f
+	}
+	if value, err := c.GetSigned(flashCookie); err == nil {
+		_ = json.Unmarshal([]byte(value), &f.pending) // ignore invalid messages
+	}
+	c.Delete(flashCookie)
+	return f
+}
+
+// Add adds a message of the kind, e.g. "error", to be shown to the user. It
+// sets the cookie, so it must be called before the response is written, e.g.
+// before http.Redirect.
+func (f *Flash) Add(kind, msg string) {
+	f.pending = append(f.pending, FlashMessage{kind, msg})
+	f.save()
+}
+
+// Messages returns the pending messages, in the order they were added, and
+// clears them, so that they're only shown once. The messages include those
+// added by earlier requests and by this one. Messages added by this request
+// are only cleared from the client if it's called before the response is
+// written.
+func (f *Flash) Messages() []FlashMessage {
+	msgs := f.pending
+	if msgs != nil {
+		f.pending = nil
+		f.save()
+	}
+	return msgs
+}
+
+func (f *Flash) save() {
+	if len(f.pending) == 0 {
+		f.c.Delete(flashCookie)
+		return
+	}
+	data, _ := json.Marshal(f.pending)
+	f.c.SetSigned(flashCookie, string(data))
+}
@@ -0,0 +1,106 @@
+package sandwich
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlash(t *testing.T) {
+	tpl := template.Must(template.New("").Parse(
+		`{{range .Messages}}[{{.Kind}}: {{.Message}}]{{end}}`))
+	mux := TheUsual()
+	mux.Use(NoLog, SecureCookies(CookieConfig{Keys: [][]byte{bytes.Repeat([]byte("k"), 32)}}), LoadFlash)
+	mux.Post("/save", func(w http.ResponseWriter, r *http.Request, f *Flash) {
+		f.Add("success", "Saved")
+		f.Add("info", "<b>escaped</b>")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.Get("/", func(w http.ResponseWriter, f *Flash) error {
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, f); err != nil {
+			return err
+		}
+		_, err := buf.WriteTo(w)
+		return err
+	})
+
+	jar := map[string]*http.Cookie{}
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		for _, c := range jar {
+			req.AddCookie(c)
+		}
+		mux.ServeHTTP(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.MaxAge < 0 {
+				delete(jar, c.Name)
+			} else {
+				jar[c.Name] = c
+			}
+		}
+		return w
+	}
+
+	w := serve("POST", "/save")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	require.Len(t, w.Result().Cookies(), 1, "the cookie is only sent once")
+	require.Contains(t, jar, "flash")
+
+	w = serve("GET", "/")
+	assert.Equal(t, "[success: Saved][info: &lt;b&gt;escaped&lt;/b&gt;]", w.Body.String())
+	assert.NotContains(t, jar, "flash")
+
+	w = serve("GET", "/")
+	assert.Equal(t, "", w.Body.String())
+	assert.Empty(t, w.Result().Cookies())
+
+	// Forged messages are ignored.
+	jar["flash"] = &http.Cookie{Name: "flash", Value: `[{"k":"error","m":"forged"}]`}
+	w = serve("GET", "/")
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestFlashClearedWhileWriting(t *testing.T) {
+	tpl := template.Must(template.New("").Parse(
+		`{{range .Flash.Messages}}[{{.Kind}}: {{.Message}}]{{end}}`))
+	mux := TheUsual()
+	mux.Use(NoLog, SecureCookies(CookieConfig{
+		Keys:     [][]byte{bytes.Repeat([]byte("k"), 32)},
+		Insecure: true,
+	}), LoadFlash)
+	mux.Post("/save", func(w http.ResponseWriter, r *http.Request, f *Flash) {
+		f.Add("success", "Saved")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.Get("/", func(w http.ResponseWriter, f *Flash) error {
+		// The template writes to the response before calling Messages.
+		_, _ = w.Write([]byte("messages: "))
+		return tpl.Execute(w, map[string]any{"Flash": f})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	body := func(resp *http.Response, err error) string {
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	assert.Equal(t, "messages: [success: Saved]", body(client.PostForm(srv.URL+"/save", url.Values{})))
+	assert.Equal(t, "messages: ", body(client.Get(srv.URL+"/")))
+}
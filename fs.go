@@ -1,8 +1,16 @@
 package sandwich
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"io/fs"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // ServeFS is a simple helper that will serve static files from an fs.FS
@@ -21,13 +29,379 @@ func ServeFS(
 	fsRoot string,
 	pathParam string,
 ) func(w http.ResponseWriter, r *http.Request, p Params) {
+	serve := ServeFSWith(f, fsRoot, pathParam, FSOptions{})
+	return func(w http.ResponseWriter, r *http.Request, p Params) { serve(w, r, p) }
+}
+
+// FSOptions configures how ServeFSWith serves static files.
+type FSOptions struct {
+	// Precompressed enables serving precompressed variants of files. When a
+	// client accepts brotli or gzip encoding and the filesystem contains the
+	// requested file with a ".br" or ".gz" suffix, that variant is served
+	// instead with the appropriate Content-Encoding. Brotli is preferred. The
+	// Content-Type is always determined from the original file name and
+	// responses include "Vary: Accept-Encoding".
+	//
+	// This is typically used with a build step that compresses assets ahead of
+	// time, e.g. app.js, app.js.br, and app.js.gz.
+	Precompressed bool
+	// Localized enables serving language-specific variants of files. When the
+	// filesystem contains variants of the requested file with a language tag
+	// before the extension, e.g. index.en.html and index.de.html for
+	// index.html, the variant that best matches the client's Accept-Language
+	// is served with a Content-Language header. Requests for a directory are
+	// matched against the variants of its index.html. Language tags in file
+	// names must be lower-case, e.g. index.pt-br.html. A variant for "de" also
+	// matches clients that prefer "de-CH". If no variant matches, the requested
+	// file is served as usual. Responses include "Vary: Accept-Language".
+	Localized bool
+	// DefaultLanguage is the language tag of the variant that is served when no
+	// variant matches the client's Accept-Language, e.g. "en". If empty, the
+	// requested file itself is served instead.
+	DefaultLanguage string
+	// MaxAge, if positive, adds a "Cache-Control: public, max-age=..." header
+	// to the responses for existing files, so that browsers cache them for
+	// that long.
+	MaxAge time.Duration
+	// Fingerprinted enables serving files at the fingerprinted URLs returned by
+	// AssetURLs, which include a hash of the file's content, e.g.
+	// "app.3f2a9c1d.js" for "app.js". Since the content at such a URL never
+	// changes, it's served with "Cache-Control: public, max-age=31536000,
+	// immutable". If the hash doesn't match the current content, e.g. for a
+	// page that was rendered before a deploy, the current file is served with
+	// MaxAge instead. The hashes are cached, so the filesystem shouldn't change
+	// while serving, as with embed.FS.
+	Fingerprinted bool
+	// NoDirListing disables the listing of directories that don't have an
+	// index document. Requests for them are rejected with a 403 instead.
+	NoDirListing bool
+	// IndexNames are the names of the index documents that are served for
+	// requests for a directory, in order of preference, e.g. "index.html" and
+	// "index.htm". If empty, "index.html" is used.
+	IndexNames []string
+	// UseErrorHandler makes requests for missing files, and for directories
+	// when NoDirListing is set, fail with a 404 or 403 Error that's handled by
+	// the router's error handlers, e.g. to render the site's error page with
+	// HandleErrorHTML, rather than with the plain text of http.FileServer.
+	UseErrorHandler bool
+	// ModTime is the modification time of the files that don't have one, such
+	// as those of an embed.FS, e.g. the build time of the binary. If set, these
+	// files are served with a Last-Modified header, and requests with
+	// If-Modified-Since or If-Range headers are handled accordingly. Otherwise
+	// such files are only served with an ETag if ETags is set.
+	ModTime time.Time
+	// ETags enables serving files with a strong ETag computed from their
+	// content, so that requests with If-None-Match or If-Range headers are
+	// handled accordingly. Precompressed variants have their own ETags. The
+	// ETags are cached, so the filesystem shouldn't change while serving, as
+	// with embed.FS.
+	ETags bool
+}
+
+// immutableCacheControl is the Cache-Control of fingerprinted files.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// precompressedEncodings lists the supported precompressed variants in order of
+// preference.
+var precompressedEncodings = []struct{ coding, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// ServeFSWith is like ServeFS but allows configuring additional options. The
+// returned handler fails with an Error only if FSOptions.UseErrorHandler is
+// set.
+func ServeFSWith(
+	f fs.FS,
+	fsRoot string,
+	pathParam string,
+	opts FSOptions,
+) func(w http.ResponseWriter, r *http.Request, p Params) error {
 	sub, err := fs.Sub(f, fsRoot)
 	if err != nil {
 		panic(err)
 	}
+	if !opts.ModTime.IsZero() {
+		sub = modTimeFS{sub, opts.ModTime}
+	}
 	handler := http.FileServer(http.FS(sub))
-	return func(w http.ResponseWriter, r *http.Request, p Params) {
+	var hashes, etags *fileHashes
+	if opts.Fingerprinted || opts.ETags {
+		hashes = newFileHashes(sub)
+	}
+	if opts.ETags {
+		etags = hashes
+	}
+	maxAge := ""
+	if opts.MaxAge > 0 {
+		maxAge = "public, max-age=" + strconv.FormatInt(int64(opts.MaxAge/time.Second), 10)
+	}
+	checkDirs := opts.NoDirListing || len(opts.IndexNames) > 0 || opts.UseErrorHandler
+	return func(w http.ResponseWriter, r *http.Request, p Params) error {
 		r.URL.Path = p[pathParam]
+		cacheControl := maxAge
+		if hashes != nil {
+			if immutable := resolveFingerprint(sub, hashes, r); immutable {
+				cacheControl = immutableCacheControl
+			}
+		}
+		if cacheControl != "" && exists(sub, r.URL.Path) {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if opts.Localized {
+			localize(sub, w, r, opts.DefaultLanguage)
+		}
+		if checkDirs {
+			if served, err := opts.serveDir(sub, etags, w, r); served || err != nil {
+				return err
+			}
+		}
+		if opts.Precompressed && servePrecompressed(sub, etags, w, r) {
+			return nil
+		}
+		if etags != nil {
+			setETag(w, etags, indexFile(r.URL.Path))
+		}
 		handler.ServeHTTP(w, r)
+		return nil
+	}
+}
+
+// serveDir serves the index document of a requested directory, or rejects the
+// request if the directory can't be listed or the file doesn't exist, as
+// configured by opts. It returns false if the request should be served by
+// http.FileServer instead.
+func (opts FSOptions) serveDir(fsys fs.FS, etags *fileHashes, w http.ResponseWriter, r *http.Request) (bool, error) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		if !opts.UseErrorHandler {
+			return false, nil
+		}
+		if errors.Is(err, fs.ErrPermission) {
+			return true, Forbidden("")
+		}
+		return true, NotFoundErr()
+	}
+	if !info.IsDir() || (name != "." && !strings.HasSuffix(r.URL.Path, "/")) {
+		return false, nil // http.FileServer redirects directories to add the "/"
 	}
+	indexNames := opts.IndexNames
+	if len(indexNames) == 0 {
+		indexNames = []string{"index.html"}
+	}
+	for _, index := range indexNames {
+		if content, modtime, ok := openRegularFile(fsys, path.Join(name, index)); ok {
+			defer content.Close()
+			setETag(w, etags, path.Join(name, index))
+			http.ServeContent(w, r, index, modtime, content)
+			return true, nil
+		}
+	}
+	if !opts.NoDirListing {
+		return false, nil
+	}
+	if opts.UseErrorHandler {
+		return true, Forbidden("")
+	}
+	http.Error(w, "403 Forbidden", http.StatusForbidden)
+	return true, nil
+}
+
+// indexFile returns the name of the file that http.FileServer serves for the
+// request path p: the index.html of a directory, or the file itself.
+func indexFile(p string) string {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" || strings.HasSuffix(p, "/") {
+		return path.Join(name, "index.html")
+	}
+	return name
+}
+
+// setETag sets the ETag of the response to the content hash of the named file,
+// so that http.ServeContent checks it against the request's If-None-Match and
+// If-Range headers. It does nothing if etags is nil or the file doesn't exist.
+func setETag(w http.ResponseWriter, etags *fileHashes, name string) {
+	if etags == nil {
+		return
+	}
+	if hash, ok := etags.get(name); ok {
+		w.Header().Set(headerETag, ETag{Tag: hash[:32]}.String())
+	}
+}
+
+// resolveFingerprint rewrites the request path of a fingerprinted file name to
+// the original file name. It reports whether the fingerprint matches the
+// current content of the file.
+func resolveFingerprint(fsys fs.FS, hashes *fileHashes, r *http.Request) (matches bool) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if exists(fsys, name) {
+		return false
+	}
+	orig, hash, ok := parseFingerprinted(name)
+	if !ok {
+		return false
+	}
+	current, ok := hashes.get(orig)
+	if !ok {
+		return false
+	}
+	r.URL.Path = orig
+	return current[:fingerprintLen] == hash
 }
+
+// exists reports whether the named file or directory exists in fsys.
+func exists(fsys fs.FS, name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
+
+// servePrecompressed serves a precompressed variant of the requested file, if
+// the client accepts it and it exists. It returns false if nothing was served.
+func servePrecompressed(fsys fs.FS, etags *fileHashes, w http.ResponseWriter, r *http.Request) bool {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	AddVary(w.Header(), headerAcceptEncoding)
+	accept := r.Header.Get(headerAcceptEncoding)
+	for _, enc := range precompressedEncodings {
+		if !acceptsEncoding(accept, enc.coding) {
+			continue
+		}
+		content, modtime, ok := openRegularFile(fsys, name+enc.ext)
+		if !ok {
+			continue
+		}
+		defer content.Close()
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set(headerContentType, ctype)
+		w.Header().Set(headerContentEncoding, enc.coding)
+		setETag(w, etags, name+enc.ext)
+		http.ServeContent(w, r, name, modtime, content)
+		return true
+	}
+	return false
+}
+
+// localize rewrites the request path to the language variant of the requested
+// file that best matches the client's Accept-Language, if any exists.
+func localize(fsys fs.FS, w http.ResponseWriter, r *http.Request, defaultLang string) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	AddVary(w.Header(), headerAcceptLanguage)
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for _, lang := range preferredLanguages(r.Header.Get(headerAcceptLanguage), defaultLang) {
+		variant := base + "." + lang + ext
+		if info, err := fs.Stat(fsys, variant); err == nil && info.Mode().IsRegular() {
+			w.Header().Set(headerContentLanguage, lang)
+			r.URL.Path = variant
+			return
+		}
+	}
+}
+
+// openRegularFile opens the named file of fsys if it exists and isn't a
+// directory. The file is served as it's read if it can seek, as http.FS
+// requires, and is otherwise read into memory. The caller must close it.
+func openRegularFile(fsys fs.FS, name string) (io.ReadSeekCloser, time.Time, bool) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil, time.Time{}, false
+	}
+	// Files may implement Seek only to fail, such as those of a modTimeFS.
+	if rs, ok := f.(io.ReadSeekCloser); ok {
+		if _, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			return rs, info.ModTime(), true
+		}
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	return nopSeekCloser{bytes.NewReader(data)}, info.ModTime(), true
+}
+
+// nopSeekCloser is an in-memory file returned by openRegularFile.
+type nopSeekCloser struct{ io.ReadSeeker }
+
+func (nopSeekCloser) Close() error { return nil }
+
+// modTimeFS reports modTime as the modification time of the files of an fs.FS
+// that don't have one, such as those of an embed.FS.
+type modTimeFS struct {
+	fs.FS
+	modTime time.Time
+}
+
+func (m modTimeFS) Open(name string) (fs.File, error) {
+	f, err := m.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return modTimeFile{f, m.modTime}, nil
+}
+
+// The errors of a modTimeFile whose underlying file doesn't support seeking or
+// reading directories, as reported by http.FS.
+var (
+	errMissingSeek    = errors.New("io.File missing Seek method")
+	errMissingReadDir = errors.New("io.File directory missing ReadDir method")
+)
+
+// modTimeFile is a file of a modTimeFS. It supports seeking and reading
+// directories if the underlying file does, as http.FS requires.
+type modTimeFile struct {
+	fs.File
+	modTime time.Time
+}
+
+func (f modTimeFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil || !info.ModTime().IsZero() {
+		return info, err
+	}
+	return modTimeInfo{info, f.modTime}, nil
+}
+
+func (f modTimeFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, errMissingSeek
+	}
+	return s.Seek(offset, whence)
+}
+
+func (f modTimeFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	d, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, errMissingReadDir
+	}
+	return d.ReadDir(n)
+}
+
+type modTimeInfo struct {
+	fs.FileInfo
+	modTime time.Time
+}
+
+func (i modTimeInfo) ModTime() time.Time { return i.modTime }
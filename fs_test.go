@@ -2,9 +2,17 @@ package sandwich
 
 import (
 	"embed"
+	"html/template"
+	"io"
 	"io/fs"
+	"mime"
+	"net/http"
 	"net/http/httptest"
+	"path"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,3 +29,335 @@ func TestServeFS(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, string(contents), w.Body.String())
 }
+
+func TestServeFSPrecompressed(t *testing.T) {
+	files := fstest.MapFS{
+		"static/app.js":    {Data: []byte("plain js")},
+		"static/app.js.br": {Data: []byte("brotli js")},
+		"static/app.js.gz": {Data: []byte("gzip js")},
+		"static/style.css": {Data: []byte("plain css")},
+	}
+	serve := ServeFSWith(files, "static", "path", FSOptions{Precompressed: true})
+
+	testCases := []struct {
+		file, acceptEncoding  string
+		body, contentEncoding string
+	}{
+		{"app.js", "gzip, deflate, br", "brotli js", "br"},
+		{"app.js", "gzip, br;q=0", "gzip js", "gzip"},
+		{"app.js", "*", "brotli js", "br"},
+		{"app.js", "", "plain js", ""},
+		{"style.css", "gzip, br", "plain css", ""},
+	}
+	for _, test := range testCases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/static/"+test.file, nil)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		serve(w, req, Params{"path": test.file})
+		assert.Equal(t, test.body, w.Body.String(), "%+v", test)
+		assert.Equal(t, test.contentEncoding, w.Header().Get("Content-Encoding"), "%+v", test)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"), "%+v", test)
+		assert.Contains(t, w.Header().Get("Content-Type"), mime.TypeByExtension(path.Ext(test.file)))
+	}
+}
+
+func TestServeFSLocalized(t *testing.T) {
+	files := fstest.MapFS{
+		"site/index.html":          {Data: []byte("index")},
+		"site/index.en.html":       {Data: []byte("hello")},
+		"site/index.de.html":       {Data: []byte("hallo")},
+		"site/index.pt-br.html":    {Data: []byte("olá")},
+		"site/about.html":          {Data: []byte("about")},
+		"site/about.fr.html":       {Data: []byte("à propos")},
+		"site/about.fr.html.gz":    {Data: []byte("gzip à propos")},
+		"site/docs/index.de.html":  {Data: []byte("dokumente")},
+		"site/docs/index.html":     {Data: []byte("docs")},
+		"site/docs/guide.txt":      {Data: []byte("guide")},
+		"site/docs/guide.de.txt":   {Data: []byte("anleitung")},
+		"site/docs/untranslated.x": {Data: []byte("x")},
+	}
+	serve := ServeFSWith(files, "site", "path", FSOptions{Localized: true})
+	withDefault := ServeFSWith(files, "site", "path", FSOptions{
+		Localized: true, DefaultLanguage: "en", Precompressed: true,
+	})
+
+	testCases := []struct {
+		serve          func(http.ResponseWriter, *http.Request, Params) error
+		file, accept   string
+		body, language string
+	}{
+		{serve, "", "de-DE, en;q=0.8", "hallo", "de"},
+		{serve, "index.html", "fr, en;q=0.5", "hello", "en"},
+		{serve, "index.html", "pt-BR", "olá", "pt-br"},
+		{serve, "", "ja", "index", ""},
+		{serve, "", "de;q=0, *", "index", ""},
+		{serve, "about.html", "", "about", ""},
+		{serve, "docs/", "de", "dokumente", "de"},
+		{serve, "docs/guide.txt", "de-AT", "anleitung", "de"},
+		{serve, "docs/untranslated.x", "de", "x", ""},
+		{withDefault, "index.html", "ja", "hello", "en"},
+		{withDefault, "about.html", "ja", "about", ""},
+		{withDefault, "about.html", "fr", "gzip à propos", "fr"},
+	}
+	for _, test := range testCases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/"+test.file, nil)
+		req.Header.Set("Accept-Language", test.accept)
+		req.Header.Set("Accept-Encoding", "gzip")
+		test.serve(w, req, Params{"path": test.file})
+		assert.Equal(t, 200, w.Code, "%+v", test)
+		assert.Equal(t, test.body, w.Body.String(), "%+v", test)
+		assert.Equal(t, test.language, w.Header().Get("Content-Language"), "%+v", test)
+		assert.Contains(t, w.Header().Get("Vary"), "Accept-Language", "%+v", test)
+	}
+}
+
+func TestServeFSFingerprinted(t *testing.T) {
+	files := fstest.MapFS{
+		"static/css/app.css":        {Data: []byte("body{}")},
+		"static/js/lib.0123abcd.js": {Data: []byte("vendored with a hash-like name")},
+		"static/js/app.js":          {Data: []byte("plain js")},
+		"static/js/app.js.gz":       {Data: []byte("gzip js")},
+	}
+	assets := NewAssetURLs(files, "static", "/static")
+	cssURL := assets.URL("css/app.css")
+	assert.Regexp(t, `^/static/css/app\.[0-9a-f]{8}\.css$`, cssURL)
+	assert.Equal(t, cssURL, assets.URL("/css/app.css"))
+	assert.Equal(t, "/static/missing.css", assets.URL("missing.css"))
+
+	var buf strings.Builder
+	tpl := template.Must(template.New("").Funcs(assets.FuncMap()).Parse(`<link href="{{asset "css/app.css"}}">`))
+	require.NoError(t, tpl.Execute(&buf, nil))
+	assert.Equal(t, `<link href="`+cssURL+`">`, buf.String())
+
+	serve := ServeFSWith(files, "static", "path", FSOptions{
+		Fingerprinted: true,
+		Precompressed: true,
+		MaxAge:        5 * time.Minute,
+	})
+	get := func(file, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		serve(w, req, Params{"path": file})
+		return w
+	}
+
+	w := get(strings.TrimPrefix(cssURL, "/static/"), "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "body{}", w.Body.String())
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+
+	w = get("css/app.css", "")
+	assert.Equal(t, "body{}", w.Body.String())
+	assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"))
+
+	// Outdated fingerprints are served the current content, but not cached
+	// indefinitely.
+	w = get("css/app.00000000.css", "")
+	assert.Equal(t, "body{}", w.Body.String())
+	assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"))
+
+	jsURL := assets.URL("js/app.js")
+	w = get(strings.TrimPrefix(jsURL, "/static/"), "gzip")
+	assert.Equal(t, "gzip js", w.Body.String())
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+
+	// Files whose names look fingerprinted are served as is.
+	w = get("js/lib.0123abcd.js", "")
+	assert.Equal(t, "vendored with a hash-like name", w.Body.String())
+	assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"))
+
+	w = get("images/missing.png", "")
+	assert.Equal(t, 404, w.Code)
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+}
+
+// readOnlyFS hides the Seek method of the files of an fs.FS.
+type readOnlyFS struct{ fs.FS }
+
+func (r readOnlyFS) Open(name string) (fs.File, error) {
+	f, err := r.FS.Open(name)
+	return struct{ fs.File }{f}, err
+}
+
+func TestOpenRegularFile(t *testing.T) {
+	files := fstest.MapFS{
+		"app.js":  {Data: []byte("plain js")},
+		"dir/a.1": {Data: []byte("a")},
+	}
+
+	// Files that can seek are served as they're read.
+	f, _, ok := openRegularFile(files, "app.js")
+	require.True(t, ok)
+	assert.Implements(t, (*fs.File)(nil), f)
+	require.NoError(t, f.Close())
+
+	// Other files are read into memory.
+	for _, fsys := range []fs.FS{readOnlyFS{files}, modTimeFS{readOnlyFS{files}, time.Now()}} {
+		f, _, ok = openRegularFile(fsys, "app.js")
+		require.True(t, ok)
+		assert.IsType(t, nopSeekCloser{}, f)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "plain js", string(data))
+	}
+
+	_, _, ok = openRegularFile(files, "dir")
+	assert.False(t, ok)
+	_, _, ok = openRegularFile(files, "missing.js")
+	assert.False(t, ok)
+}
+
+func TestServeFSDirectories(t *testing.T) {
+	files := fstest.MapFS{
+		"site/index.htm":        {Data: []byte("old index")},
+		"site/docs/guide.txt":   {Data: []byte("guide")},
+		"site/blog/index.html":  {Data: []byte("blog")},
+		"site/blog/index.htm":   {Data: []byte("old blog")},
+		"site/blog/post-1.html": {Data: []byte("post")},
+	}
+	mux := TheUsual()
+	mux.Use(NoLog)
+	mux.OnErr(func(w http.ResponseWriter, err error) {
+		e := ToError(err)
+		http.Error(w, "custom "+e.ClientMsg, e.Code)
+	})
+	mux.Get("/listed/:path*", ServeFSWith(files, "site", "path", FSOptions{}))
+	mux.Get("/unlisted/:path*", ServeFSWith(files, "site", "path", FSOptions{
+		NoDirListing: true,
+		IndexNames:   []string{"index.htm", "index.html"},
+	}))
+	mux.Get("/errors/:path*", ServeFSWith(files, "site", "path", FSOptions{
+		NoDirListing:    true,
+		UseErrorHandler: true,
+	}))
+
+	testCases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/listed/docs/", 200, `<a href="guide.txt">guide.txt</a>`},
+		{"/listed/blog/", 200, "blog"},
+		{"/listed/missing.txt", 404, "404 page not found"},
+		{"/unlisted/", 200, "old index"},
+		{"/unlisted/blog/", 200, "old blog"},
+		{"/unlisted/blog/post-1.html", 200, "post"},
+		{"/unlisted/docs/", 403, "403 Forbidden"},
+		{"/unlisted/docs/guide.txt", 200, "guide"},
+		{"/errors/docs/", 403, "custom Forbidden"},
+		{"/errors/missing.txt", 404, "custom Not Found"},
+		{"/errors/blog/", 200, "blog"},
+	}
+	for _, test := range testCases {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		assert.Equal(t, test.code, w.Code, "%+v", test)
+		assert.Contains(t, w.Body.String(), test.body, "%+v", test)
+	}
+
+	// Directories are still redirected to add the trailing slash.
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/unlisted/docs", nil))
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+}
+
+func TestServeFSConditional(t *testing.T) {
+	files := fstest.MapFS{
+		"site/index.html":    {Data: []byte("<h1>index</h1>")},
+		"site/app.js":        {Data: []byte("0123456789")},
+		"site/app.js.gz":     {Data: []byte("gzipped")},
+		"site/old.txt":       {Data: []byte("old"), ModTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"site/docs/help.txt": {Data: []byte("help")},
+	}
+	built := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	serve := ServeFSWith(files, "site", "path", FSOptions{
+		ModTime:       built,
+		ETags:         true,
+		Precompressed: true,
+	})
+	get := func(file string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+file, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		serve(w, req, Params{"path": file})
+		return w
+	}
+
+	w := get("app.js")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.Equal(t, built.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	// Files with their own modification time keep it.
+	w = get("old.txt")
+	assert.Equal(t, "Wed, 01 Jan 2020 00:00:00 GMT", w.Header().Get("Last-Modified"))
+
+	w = get("app.js", "If-Modified-Since", built.Format(http.TimeFormat))
+	assert.Equal(t, 304, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = get("app.js", "If-None-Match", `"other", `+etag)
+	assert.Equal(t, 304, w.Code)
+
+	w = get("app.js", "If-None-Match", `"other"`, "If-Modified-Since", built.Format(http.TimeFormat))
+	assert.Equal(t, 200, w.Code)
+
+	w = get("app.js", "Range", "bytes=2-4")
+	assert.Equal(t, 206, w.Code)
+	assert.Equal(t, "234", w.Body.String())
+	assert.Equal(t, "bytes 2-4/10", w.Header().Get("Content-Range"))
+
+	w = get("app.js", "Range", "bytes=-3", "If-Range", etag)
+	assert.Equal(t, 206, w.Code)
+	assert.Equal(t, "789", w.Body.String())
+
+	w = get("app.js", "Range", "bytes=-3", "If-Range", `"stale"`)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+
+	w = get("app.js", "Range", "bytes=20-")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+	// Precompressed variants have their own ETag.
+	w = get("app.js", "Accept-Encoding", "gzip")
+	assert.Equal(t, "gzipped", w.Body.String())
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, w.Header().Get("ETag"))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// Directories are served with the ETag of their index.
+	w = get("")
+	assert.Equal(t, "<h1>index</h1>", w.Body.String())
+	indexETag := w.Header().Get("ETag")
+	assert.NotEmpty(t, indexETag)
+	w = get("", "If-None-Match", indexETag)
+	assert.Equal(t, 304, w.Code)
+
+	w = get("docs/")
+	assert.Contains(t, w.Body.String(), "help.txt")
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	// Without options, files of an embed.FS have no validators.
+	w = httptest.NewRecorder()
+	ServeFS(examples, "examples/0-helloworld", "path")(
+		w, httptest.NewRequest("GET", "/", nil), Params{"path": "main.go"})
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=0-6")
+	ServeFSWith(examples, "examples/0-helloworld", "path", FSOptions{ModTime: built, ETags: true})(
+		w, req, Params{"path": "main.go"})
+	assert.Equal(t, 206, w.Code)
+	assert.Equal(t, "package", w.Body.String())
+	assert.Equal(t, built.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+}
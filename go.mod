@@ -1,6 +1,6 @@
 module github.com/augustoroman/sandwich
 
-go 1.19
+go 1.20
 
 require (
 	github.com/bradrydzewski/go.auth v0.0.0-20130828171325-d0051b5cc538
@@ -0,0 +1,92 @@
+package sandwich
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+)
+
+// Goroutines is a middleware wrap that provides a *Group to subsequent
+// handlers for running concurrent work on behalf of the request. When the
+// request completes, the deferred handler cancels the group's context and waits
+// for any outstanding goroutines before the request log is committed, so that
+// they don't outlive the request. Goroutines should therefore respect the
+// cancellation of their context.
+//
+// For example:
+//
+//	mux.Use(sandwich.Goroutines)
+//	mux.Get("/dashboard", func(g *sandwich.Group, u *User) (*Dashboard, error) {
+//	    var d Dashboard
+//	    g.Go(func(ctx context.Context) (err error) { d.Feed, err = loadFeed(ctx, u); return })
+//	    g.Go(func(ctx context.Context) (err error) { d.Stats, err = loadStats(ctx, u); return })
+//	    return &d, g.Wait()
+//	}, renderDashboard)
+var Goroutines = Wrap{Before: NewGroup, After: (*Group).finish}
+
+// Group is a collection of goroutines working on behalf of a single request,
+// similar to errgroup.Group. The first goroutine that fails cancels the
+// group's context. Errors are handled by returning the result of Wait from a
+// handler, which passes the error to the error handler as usual.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	err    error
+}
+
+// NewGroup creates a Group whose context is derived from the request context.
+// It's typically used via Goroutines.
+func NewGroup(r *http.Request) *Group {
+	ctx, cancel := context.WithCancel(r.Context())
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the group's context, which is canceled when any goroutine
+// fails, the request is canceled, or the request has completed.
+func (g *Group) Context() context.Context { return g.ctx }
+
+// Go runs fn in a new goroutine with the group's context. A panic in fn is
+// recovered and reported as an error.
+func (g *Group) Go(fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if x := recover(); x != nil {
+				g.fail(fmt.Errorf("panic in goroutine: %v\n%s", x, debug.Stack()))
+			}
+		}()
+		if err := fn(g.ctx); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+// Wait blocks until all of the goroutines started so far have completed and
+// returns the first error, if any.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+func (g *Group) fail(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err == nil {
+		g.err = err
+		g.cancel()
+	}
+}
+
+// finish cancels the context, so that outstanding goroutines aren't left
+// waiting for a handler that returned without calling Wait, and waits for them.
+func (g *Group) finish() {
+	g.cancel()
+	g.wg.Wait()
+}
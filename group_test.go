@@ -0,0 +1,111 @@
+package sandwich
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup(t *testing.T) {
+	var finished int32
+	var log LogEntry
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	WriteLog = func(e LogEntry) {
+		log = e
+		// The outstanding goroutines finish before the log is committed.
+		assert.Equal(t, int32(2), atomic.LoadInt32(&finished))
+	}
+
+	r := TheUsual()
+	r.Use(Goroutines)
+	r.Get("/ok", func(w http.ResponseWriter, g *Group) error {
+		results := make([]string, 2)
+		for i, s := range []string{"a", "b"} {
+			i, s := i, s
+			g.Go(func(ctx context.Context) error {
+				results[i] = s
+				atomic.AddInt32(&finished, 1)
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		_, _ = w.Write([]byte(results[0] + results[1]))
+		return nil
+	})
+	r.Get("/fail", func(g *Group) error {
+		g.Go(func(ctx context.Context) error {
+			defer atomic.AddInt32(&finished, 1)
+			return Error{Code: http.StatusBadGateway, LogMsg: "upstream failed"}
+		})
+		g.Go(func(ctx context.Context) error {
+			defer atomic.AddInt32(&finished, 1)
+			<-ctx.Done() // canceled by the failure
+			return ctx.Err()
+		})
+		return g.Wait()
+	})
+	r.Get("/early", func(g *Group) error {
+		for i := 0; i < 2; i++ {
+			g.Go(func(ctx context.Context) error {
+				defer atomic.AddInt32(&finished, 1)
+				<-ctx.Done() // canceled when the request completes
+				return ctx.Err()
+			})
+		}
+		return Error{Code: http.StatusBadRequest, LogMsg: "returned without Wait"}
+	})
+	r.Get("/leak", func(g *Group) {
+		g.Go(func(ctx context.Context) error {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&finished, 1)
+			return nil
+		})
+		g.Go(func(ctx context.Context) error {
+			defer atomic.AddInt32(&finished, 1)
+			panic("oops")
+		})
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/ok", nil))
+	assert.Equal(t, "ab", w.Body.String())
+
+	atomic.StoreInt32(&finished, 0)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/fail", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, log.Error.Error(), "upstream failed")
+
+	atomic.StoreInt32(&finished, 0)
+	done := make(chan struct{})
+	w = httptest.NewRecorder()
+	go func() {
+		defer close(done)
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/early", nil))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request didn't complete: the group's context wasn't canceled")
+	}
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	atomic.StoreInt32(&finished, 0)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/leak", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	g := NewGroup(httptest.NewRequest("GET", "/", nil))
+	g.Go(func(ctx context.Context) error { panic("boom") })
+	assert.Contains(t, g.Wait().Error(), "panic in goroutine: boom")
+	assert.True(t, errors.Is(g.Context().Err(), context.Canceled))
+}
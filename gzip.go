@@ -2,12 +2,16 @@ package sandwich
 
 import (
 	"compress/gzip"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 const (
 	headerAcceptEncoding  = "Accept-Encoding"
+	headerAcceptLanguage  = "Accept-Language"
+	headerContentLanguage = "Content-Language"
 	headerContentEncoding = "Content-Encoding"
 	headerContentLength   = "Content-Length"
 	headerContentType     = "Content-Type"
@@ -15,7 +19,7 @@ const (
 )
 
 // Gzip wraps a sandwich.Middleware to add gzip compression to the output for
-// all subsequent handlers.
+// all subsequent handlers, using the default GzipOptions.
 //
 // For example, to gzip everything you could use:
 //
@@ -26,35 +30,193 @@ const (
 //
 //	router.Get("/foo/bar", sandwich.Gzip, MyHandleFooBar)
 //
-// Note that this does NOT auto-detect the content and disable compression for
-// already-compressed data (e.g. jpg images).
-var Gzip = Wrap{provideGZipWriter, (*gZipWriter).Flush}
+// Responses whose Content-Encoding is already set, such as precompressed
+// files, and responses with content types that are already compressed, such as
+// JPEG images, are sent as is. See GzipWith to customize this.
+var Gzip = GzipWith(GzipOptions{})
 
-func provideGZipWriter(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *gZipWriter) {
-	if !strings.Contains(r.Header.Get(headerAcceptEncoding), "gzip") {
-		return w, nil
-	}
-	headers := w.Header()
-	headers.Set(headerContentEncoding, "gzip")
-	headers.Set(headerVary, headerAcceptEncoding)
+// GzipOptions configures GzipWith.
+type GzipOptions struct {
+	// Level is the gzip compression level, see compress/gzip. If zero,
+	// gzip.DefaultCompression is used.
+	Level int
+	// MinSize is the minimum size of the response body, in bytes, that is
+	// compressed. Smaller responses are sent uncompressed, since compression
+	// doesn't pay off for them. Up to MinSize bytes of the response are
+	// buffered until the size is known, unless the handler sets the
+	// Content-Length. If zero, all responses are compressed.
+	MinSize int
+	// ContentTypes, if set, are the only content types that are compressed,
+	// e.g. "text/*" or "application/json". A trailing "/*" matches any subtype.
+	// Responses without a Content-Type are detected with
+	// http.DetectContentType.
+	ContentTypes []string
+	// ExcludeContentTypes are content types that are never compressed, in the
+	// same format as ContentTypes. If nil, DefaultGzipExclude is used. Use an
+	// empty, non-nil slice to compress all content types.
+	ExcludeContentTypes []string
+}
 
-	wr := &gZipWriter{w, gzip.NewWriter(w)}
-	return wr, wr
+// DefaultGzipExclude are the content types that aren't compressed by default,
+// since they're already compressed.
+var DefaultGzipExclude = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif",
+	"video/*", "audio/*", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/zstd", "application/x-7z-compressed", "application/x-rar-compressed",
+}
+
+// GzipWith is like Gzip but allows configuring which responses are compressed.
+// For example, to only compress large text responses:
+//
+//	router.Use(sandwich.GzipWith(sandwich.GzipOptions{
+//	    MinSize:      1024,
+//	    ContentTypes: []string{"text/*", "application/json"},
+//	}))
+func GzipWith(opts GzipOptions) Wrap {
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(nil, opts.Level); err != nil {
+		panic(fmt.Errorf("GzipWith: %w", err))
+	}
+	if opts.ExcludeContentTypes == nil {
+		opts.ExcludeContentTypes = DefaultGzipExclude
+	}
+	return Wrap{
+		Before: func(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *gZipWriter) {
+			if !strings.Contains(r.Header.Get(headerAcceptEncoding), "gzip") {
+				return w, nil
+			}
+			AddVary(w.Header(), headerAcceptEncoding)
+			wr := &gZipWriter{ResponseWriter: w, opts: &opts}
+			return wr, wr
+		},
+		After: (*gZipWriter).Flush,
+	}
 }
 
+// gZipWriter decides whether to compress the response when the response is
+// started, or once MinSize bytes have been written, and buffers the response
+// until then.
 type gZipWriter struct {
 	http.ResponseWriter
-	w *gzip.Writer
+	opts    *GzipOptions
+	w       *gzip.Writer // set if the response is compressed
+	code    int          // the pending status code, or 0 if not written yet
+	buf     []byte       // the body written before deciding
+	decided bool
+}
+
+func (g *gZipWriter) WriteHeader(code int) {
+	if g.decided {
+		g.ResponseWriter.WriteHeader(code)
+		return
+	}
+	g.code = code
+	h := g.Header()
+	if g.opts.MinSize == 0 || h.Get(headerContentLength) != "" || !bodyAllowed(code) ||
+		h.Get(headerContentEncoding) != "" {
+		g.decide()
+	}
 }
 
 func (g *gZipWriter) Write(p []byte) (int, error) {
-	if len(g.Header().Get(headerContentType)) == 0 {
-		g.Header().Set(headerContentType, http.DetectContentType(p))
+	if !g.decided {
+		if len(g.Header().Get(headerContentType)) == 0 {
+			g.Header().Set(headerContentType, http.DetectContentType(append(g.buf, p...)))
+		}
+		g.buf = append(g.buf, p...)
+		if len(g.buf) < g.opts.MinSize {
+			return len(p), nil
+		}
+		if err := g.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
 	}
-	return g.w.Write(p)
+	if g.w != nil {
+		return g.w.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
 }
 
+// decide starts the response, compressed or not, and writes the buffered body.
+func (g *gZipWriter) decide() error {
+	g.decided = true
+	if g.compress() {
+		g.Header().Set(headerContentEncoding, "gzip")
+		g.Header().Del(headerContentLength)
+		g.w, _ = gzip.NewWriterLevel(g.ResponseWriter, g.opts.Level)
+	}
+	if g.code != 0 {
+		g.ResponseWriter.WriteHeader(g.code)
+	}
+	if len(g.buf) == 0 {
+		return nil
+	}
+	buf := g.buf
+	g.buf = nil
+	var err error
+	if g.w != nil {
+		_, err = g.w.Write(buf)
+	} else {
+		_, err = g.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// compress reports whether the response should be compressed.
+func (g *gZipWriter) compress() bool {
+	h := g.Header()
+	if h.Get(headerContentEncoding) != "" || !bodyAllowed(g.code) {
+		return false
+	}
+	size := len(g.buf)
+	if n, err := strconv.Atoi(h.Get(headerContentLength)); err == nil {
+		size = n
+	}
+	if size < g.opts.MinSize {
+		return false
+	}
+	contentType := h.Get(headerContentType)
+	if len(g.opts.ContentTypes) > 0 && !matchesContentType(contentType, g.opts.ContentTypes) {
+		return false
+	}
+	return !matchesContentType(contentType, g.opts.ExcludeContentTypes)
+}
+
+// bodyAllowed reports whether a response with the status code may have a body.
+// A code of 0 means that the status hasn't been written, so 200 is implied.
+func bodyAllowed(code int) bool {
+	return !(code >= 100 && code < 200) && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// matchesContentType reports whether the media type of contentType matches
+// any of the patterns, e.g. "text/*".
+func matchesContentType(contentType string, patterns []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType {
+			return true
+		}
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush completes the response: it writes the body that's still buffered and
+// closes the gzip stream.
 func (g *gZipWriter) Flush() {
-	g.Header().Del(headerContentLength)
-	g.w.Close()
+	if !g.decided {
+		g.decide()
+	}
+	if g.w != nil {
+		g.Header().Del(headerContentLength)
+		g.w.Close()
+	}
 }
@@ -52,3 +52,106 @@ func TestGzip(t *testing.T) {
 		t.Errorf("Wrong response: %q", resp.Body.String())
 	}
 }
+
+func TestGzipBeforeLogCommit(t *testing.T) {
+	var log LogEntry
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	WriteLog = func(e LogEntry) { log = e }
+
+	// Gzip is registered before the log entry, but is still flushed first so
+	// that the logged size includes the gzip trailer.
+	r := BuildYourOwn()
+	r.Use(WrapResponseWriter, Gzip, LogRequests)
+	r.Get("/", func(w http.ResponseWriter) { _, _ = w.Write([]byte("hello hello hello")) })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if log.ResponseSize != w.Body.Len() {
+		t.Errorf("Logged size %d but wrote %d bytes", log.ResponseSize, w.Body.Len())
+	}
+}
+
+func TestGzipOptions(t *testing.T) {
+	r := BuildYourOwn()
+	r.Use(GzipWith(GzipOptions{MinSize: 10, ContentTypes: []string{"text/*", "application/json"}}))
+	r.Get("/small", func(w http.ResponseWriter) { fmt.Fprint(w, "tiny") })
+	r.Get("/large", func(w http.ResponseWriter) {
+		fmt.Fprint(w, "hello ")
+		fmt.Fprint(w, "world, hello world")
+	})
+	r.Get("/json", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", "20")
+		w.WriteHeader(201)
+		fmt.Fprint(w, `{"a":"bcdefghijklm"}`)
+	})
+	r.Get("/binary", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		fmt.Fprint(w, "0123456789abcdef")
+	})
+	r.Get("/encoded", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Encoding", "br")
+		fmt.Fprint(w, "already compressed")
+	})
+	jpeg := BuildYourOwn()
+	jpeg.Use(Gzip)
+	jpeg.Get("/img", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		fmt.Fprint(w, "\xff\xd8\xff not really a jpeg")
+	})
+
+	serve := func(h http.Handler, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(headerAcceptEncoding, "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	gunzip := func(w *httptest.ResponseRecorder) string {
+		if w.Header().Get(headerContentEncoding) != "gzip" {
+			t.Errorf("Not gzip'd: %q", w.Header())
+			return ""
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	uncompressed := []struct{ path, body, encoding string }{
+		{"/small", "tiny", ""},
+		{"/binary", "0123456789abcdef", ""},
+		{"/encoded", "already compressed", "br"},
+	}
+	for _, test := range uncompressed {
+		w := serve(r, test.path)
+		if enc := w.Header().Get(headerContentEncoding); enc != test.encoding {
+			t.Errorf("%s: wrong Content-Encoding %q", test.path, enc)
+		}
+		if w.Body.String() != test.body {
+			t.Errorf("%s: wrong response %q", test.path, w.Body.String())
+		}
+	}
+	if w := serve(jpeg, "/img"); w.Header().Get(headerContentEncoding) != "" {
+		t.Errorf("JPEG was gzip'd: %q", w.Header())
+	}
+
+	if body := gunzip(serve(r, "/large")); body != "hello world, hello world" {
+		t.Errorf("Wrong response: %q", body)
+	}
+	w := serve(r, "/json")
+	if w.Code != 201 || w.Header().Get(headerContentLength) != "" {
+		t.Errorf("Wrong status %d or Content-Length %q", w.Code, w.Header().Get(headerContentLength))
+	}
+	if body := gunzip(w); body != `{"a":"bcdefghijklm"}` {
+		t.Errorf("Wrong response: %q", body)
+	}
+}
@@ -0,0 +1,184 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONOptions configures how JSONBody decodes request bodies.
+type JSONOptions struct {
+	// DisallowUnknownFields rejects bodies with object keys that don't match
+	// any field of the destination, see json.Decoder.DisallowUnknownFields.
+	DisallowUnknownFields bool
+	// MaxDepth, if positive, is the maximum nesting depth of the objects and
+	// arrays in the body. Deeper bodies are rejected as soon as the limit is
+	// exceeded, without reading the rest of the body.
+	MaxDepth int
+	// MaxBytes, if positive, limits the size of the body as MaxBodySize does,
+	// replacing any earlier limit. Larger bodies are rejected with a 413.
+	MaxBytes int64
+}
+
+// JSONBody returns a handler that decodes the JSON request body into a T and
+// provides it to subsequent handlers. T may be a pointer, e.g.:
+//
+//	mux.Post("/tasks", sandwich.JSONBody[*Task](sandwich.JSONOptions{
+//	    DisallowUnknownFields: true,
+//	    MaxBytes:              64 << 10,
+//	}), db.AddTask, sendTask)
+//
+// The body must contain exactly one JSON value. It's decoded as it's read, so
+// bodies are only limited by MaxBytes or an earlier MaxBodySize. Requests whose
+// body is missing or malformed fail with a 400 Error, and a body with unknown
+// fields fails with a 400 Error whose Cause is a ValidationError naming them,
+// so that the standard error handlers include them in the response, e.g.:
+//
+//	{"error":"Invalid JSON body","errors":{"tags":"unknown field"}}
+//
+// As with Bind, values that don't match the type of their field fail with a
+// ValidationError, which ToError converts to a 422. Requests with a
+// Content-Type other than JSON fail with a 415. Requests without a
+// Content-Type are decoded anyway. If T implements Validator, the decoded
+// value is validated, so that invalid values fail with a 422 as well.
+func JSONBody[T any](opts JSONOptions) func(w http.ResponseWriter, r *http.Request) (T, error) {
+	return func(w http.ResponseWriter, r *http.Request) (T, error) {
+		var val T
+		if ct := r.Header.Get(headerContentType); ct != "" {
+			mediaType, _, _ := mime.ParseMediaType(ct)
+			if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+				return val, Error{
+					Code:      http.StatusUnsupportedMediaType,
+					ClientMsg: http.StatusText(http.StatusUnsupportedMediaType),
+					LogMsg:    fmt.Sprintf("Unsupported request Content-Type %q", mediaType),
+				}
+			}
+		}
+		if r.Body == nil {
+			return val, jsonBodyError("Missing JSON body", nil)
+		}
+		if opts.MaxBytes > 0 {
+			if _, err := MaxBodySize(opts.MaxBytes)(w, r); err != nil {
+				return val, err
+			}
+		}
+		if err := decodeJSON(r.Body, &val, opts); err == io.EOF {
+			return val, jsonBodyError("Missing JSON body", nil)
+		} else if err != nil {
+			return val, err
+		}
+		if rv := reflect.ValueOf(&val).Elem(); rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return val, jsonBodyError("Missing JSON body", nil) // the body is null
+		}
+		return val, validate(&val)
+	}
+}
+
+// decodeJSON decodes the single JSON value of body into dst. It's used by both
+// JSONBody and Bind. A body that's empty or only whitespace fails with io.EOF,
+// so that the caller may decide whether it's required. Values of the wrong type
+// fail with a ValidationError, a body that exceeds the limit of MaxBodySize
+// with its *http.MaxBytesError, and any other invalid body with a 400 Error.
+func decodeJSON(body io.Reader, dst any, opts JSONOptions) error {
+	var depth *jsonDepthReader
+	if opts.MaxDepth > 0 {
+		depth = &jsonDepthReader{r: body, max: opts.MaxDepth}
+		body = depth
+	}
+	dec := json.NewDecoder(body)
+	if opts.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(dst)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return nil
+		} else if !isBodyTooLarge(err) && !depth.exceeded() {
+			return jsonBodyError("Unexpected data after JSON body", err)
+		}
+	}
+	// The decoder may not return the read error as is, so the depth is
+	// checked directly.
+	if depth.exceeded() {
+		return jsonBodyError(fmt.Sprintf("JSON body is nested more than %d levels deep", opts.MaxDepth), nil)
+	}
+	var syntax *json.SyntaxError
+	var wrongType *json.UnmarshalTypeError
+	switch {
+	case err == io.EOF, isBodyTooLarge(err):
+		return err
+	case errors.As(err, &syntax):
+		return jsonBodyError(fmt.Sprintf("Malformed JSON body at offset %d", syntax.Offset), err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return jsonBodyError("Malformed JSON body", err)
+	case errors.As(err, &wrongType) && wrongType.Field != "":
+		var invalid ValidationError
+		invalid.Add(wrongType.Field, "must be "+jsonTypeName(wrongType.Type))
+		return invalid
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json doesn't have an error type for unknown fields.
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		if unquoted, err := strconv.Unquote(field); err == nil {
+			field = unquoted
+		}
+		var invalid ValidationError
+		invalid.Add(field, "unknown field")
+		return jsonBodyError("Invalid JSON body", invalid)
+	}
+	return jsonBodyError("Invalid JSON body", err)
+}
+
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func jsonBodyError(msg string, cause error) Error {
+	return Error{Code: http.StatusBadRequest, ClientMsg: msg, LogMsg: msg, Cause: cause}
+}
+
+var errJSONTooDeep = errors.New("JSON nested too deeply")
+
+// jsonDepthReader fails once the objects and arrays of the JSON read through it
+// are nested more than max levels deep, so that deep bodies are rejected
+// before they're decoded without buffering them.
+type jsonDepthReader struct {
+	r                 io.Reader
+	max, depth        int
+	inString, escaped bool
+}
+
+// exceeded reports whether the JSON read through d, if not nil, was nested
+// too deeply.
+func (d *jsonDepthReader) exceeded() bool { return d != nil && d.depth > d.max }
+
+func (d *jsonDepthReader) Read(p []byte) (int, error) {
+	if d.exceeded() {
+		return 0, errJSONTooDeep
+	}
+	n, err := d.r.Read(p)
+	for i, c := range p[:n] {
+		switch {
+		case d.escaped:
+			d.escaped = false
+		case d.inString && c == '\\':
+			d.escaped = true
+		case c == '"':
+			d.inString = !d.inString
+		case d.inString:
+		case c == '{' || c == '[':
+			if d.depth++; d.depth > d.max {
+				return i, errJSONTooDeep
+			}
+		case c == '}' || c == ']':
+			d.depth--
+		}
+	}
+	return n, err
+}
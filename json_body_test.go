@@ -0,0 +1,97 @@
+package sandwich
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jsonTask struct {
+	Desc string   `json:"desc"`
+	Tags []string `json:"tags"`
+	Sub  *struct {
+		Priority int `json:"priority"`
+	} `json:"sub"`
+}
+
+func TestJSONBody(t *testing.T) {
+	var got *jsonTask
+	mux := TheUsual()
+	mux.Use(NoLog)
+	mux.OnErr(HandleErrorJson)
+	mux.Post("/strict", JSONBody[*jsonTask](JSONOptions{
+		DisallowUnknownFields: true,
+		MaxDepth:              2,
+		MaxBytes:              64,
+	}), func(t *jsonTask) { got = t })
+	mux.Post("/lax", JSONBody[jsonTask](JSONOptions{}), func(t jsonTask) { got = &t })
+
+	serve := func(path, contentType, body string) *httptest.ResponseRecorder {
+		got = nil
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/strict", "application/json", `{"desc":"write docs","tags":["a"]}`)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	require.NotNil(t, got)
+	assert.Equal(t, "write docs", got.Desc)
+	assert.Equal(t, []string{"a"}, got.Tags)
+
+	w = serve("/lax", "", `{"desc":"x","extra":1,"sub":{"priority":3}}`)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	require.NotNil(t, got)
+	assert.Equal(t, 3, got.Sub.Priority)
+
+	testCases := []struct {
+		path, contentType, body string
+		code                    int
+		resp                    string
+	}{
+		{"/strict", "application/json", `{"desc":"x","extra":1}`, 400,
+			`{"error":"Invalid JSON body","errors":{"extra":"unknown field"}}`},
+		{"/strict", "application/json", `{"desc":5}`, 422,
+			`{"error":"Unprocessable Entity","errors":{"desc":"must be a string"}}`},
+		{"/strict", "application/json", `{"sub":{"priority":[[1]]}}`, 400,
+			`{"error":"JSON body is nested more than 2 levels deep"}`},
+		{"/strict", "application/json", `{"desc":"[[[{{{"}`, 200, ``},
+		{"/strict", "application/json", `{"desc":}`, 400,
+			`{"error":"Malformed JSON body at offset 9"}`},
+		{"/strict", "application/json", `{"desc":"x"`, 400,
+			`{"error":"Malformed JSON body"}`},
+		{"/strict", "application/json", `{} {}`, 400,
+			`{"error":"Unexpected data after JSON body"}`},
+		{"/strict", "application/json", `  `, 400,
+			`{"error":"Missing JSON body"}`},
+		{"/strict", "text/plain", `{}`, 415,
+			`{"error":"Unsupported Media Type"}`},
+		{"/strict", "application/json", `{"desc":"` + strings.Repeat("x", 100) + `"}`, 413,
+			`{"error":"Request Entity Too Large"}`},
+		{"/lax", "application/vnd.api+json; charset=utf-8", `{"desc":"x"}`, 200, ``},
+	}
+	for _, test := range testCases {
+		w := serve(test.path, test.contentType, test.body)
+		assert.Equal(t, test.code, w.Code, "%+v", test)
+		if test.resp != "" {
+			assert.JSONEq(t, test.resp, w.Body.String(), "%+v", test)
+		}
+	}
+	// Bodies are decoded as they're read rather than buffered, so a deep body is
+	// rejected without reading the rest of it.
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/strict",
+		io.MultiReader(strings.NewReader(`{"sub":{"x":[`), iotest.ErrReader(errors.New("read too far")))))
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error":"JSON body is nested more than 2 levels deep"}`, w.Body.String())
+}
@@ -0,0 +1,144 @@
+package sandwich
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ColorMode controls whether the default log format colors log lines. See
+// LogColor.
+type ColorMode int
+
+const (
+	// ColorAuto colors log lines only if the log is written to a terminal and
+	// the NO_COLOR environment variable isn't set. This is the default.
+	ColorAuto ColorMode = iota
+	// ColorAlways always colors log lines.
+	ColorAlways
+	// ColorNever never colors log lines, e.g. for logs collected by journald
+	// or docker that are written to a terminal anyway.
+	ColorNever
+)
+
+// LoggerConfig configures the default log format, LogText. See
+// ConfigureLogger.
+type LoggerConfig struct {
+	// Color controls whether log lines are colored: green for normal
+	// requests, yellow for slow requests, and red for failed requests.
+	Color ColorMode
+	// Slow is the duration beyond which requests are considered slow. If zero,
+	// 30ms is used. It may be overridden for specific routes with SlowAfter.
+	Slow time.Duration
+	// FailedStatus is the lowest status code of failed requests. Requests that
+	// return an error are always considered failed. If zero, 400 is used.
+	FailedStatus int
+}
+
+// loggerConfig is the *LoggerConfig set by ConfigureLogger, or nil for the
+// defaults. It's replaced rather than modified, since requests may be logged
+// concurrently.
+var loggerConfig atomic.Pointer[LoggerConfig]
+
+// ConfigureLogger configures the default log format, LogText. It should be
+// called during initialization, before serving requests.
+func ConfigureLogger(cfg LoggerConfig) {
+	cfg = cfg.withDefaults()
+	loggerConfig.Store(&cfg)
+}
+
+// CurrentLoggerConfig returns the LoggerConfig set by ConfigureLogger and
+// LogColor, with the defaults filled in. It may be used to classify requests
+// like the default log format does, see LogEntry.Class.
+func CurrentLoggerConfig() LoggerConfig {
+	if cfg := loggerConfig.Load(); cfg != nil {
+		return *cfg
+	}
+	return LoggerConfig{}.withDefaults()
+}
+
+// LogColor controls whether the default log format colors log lines, e.g.:
+//
+//	sandwich.LogColor(sandwich.ColorNever)
+//
+// It's a shortcut for changing the Color of the LoggerConfig.
+func LogColor(mode ColorMode) {
+	for {
+		old := loggerConfig.Load()
+		cfg := CurrentLoggerConfig()
+		if old != nil {
+			cfg = *old
+		}
+		cfg.Color = mode
+		if loggerConfig.CompareAndSwap(old, &cfg) {
+			return
+		}
+	}
+}
+
+func (cfg LoggerConfig) withDefaults() LoggerConfig {
+	if cfg.Slow <= 0 {
+		cfg.Slow = 30 * time.Millisecond
+	}
+	if cfg.FailedStatus <= 0 {
+		cfg.FailedStatus = 400
+	}
+	return cfg
+}
+
+// RequestClass classifies completed requests for logging and alerting. See
+// LogEntry.Class.
+type RequestClass int
+
+const (
+	// RequestFast is a request that succeeded within the slow threshold.
+	RequestFast RequestClass = iota
+	// RequestSlow is a request that succeeded but took longer than the slow
+	// threshold.
+	RequestSlow
+	// RequestFailed is a request that returned an error or a status code of at
+	// least LoggerConfig.FailedStatus, regardless of its duration.
+	RequestFailed
+)
+
+// String returns "fast", "slow", or "error".
+func (c RequestClass) String() string {
+	switch c {
+	case RequestFast:
+		return "fast"
+	case RequestSlow:
+		return "slow"
+	case RequestFailed:
+		return "error"
+	}
+	return "RequestClass(" + strconv.Itoa(int(c)) + ")"
+}
+
+// SlowAfter returns a middleware handler that overrides LoggerConfig.Slow for
+// the request, e.g. for routes that are expected to take longer than others:
+//
+//	mux.Get("/export", sandwich.SlowAfter(5*time.Second), exportData)
+func SlowAfter(d time.Duration) func(e *LogEntry) {
+	return func(e *LogEntry) { e.Slow = d }
+}
+
+// colorize reports whether log lines written to w should be colored.
+func (cfg LoggerConfig) colorize(w io.Writer) bool {
+	switch cfg.Color {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if _, noColor := os.LookupEnv("NO_COLOR"); noColor || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
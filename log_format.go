@@ -0,0 +1,192 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogFormat writes a LogEntry to w. The default WriteLog uses the format
+// selected by SetLogFormat.
+type LogFormat func(w io.Writer, e LogEntry)
+
+// LogText is the default LogFormat: a human-readable line that is colored
+// green for normal requests, yellow for slow requests, and red for errors,
+// followed by the error, if any. Colors are only used when writing to a
+// terminal by default, see ConfigureLogger.
+var LogText LogFormat = writeLogText
+
+// LogJSON is a LogFormat that writes each entry as a single-line JSON object
+// with stable field names for ingestion by log processors, e.g.:
+//
+//	{"time":"2001-02-03T04:05:06Z","remote_ip":"1.2.3.4","method":"GET",
+//	 "uri":"/users/1","status":500,"bytes":22,"elapsed_ns":13000000,
+//	 "class":"error","notes":{"user":"bob"},
+//	 "error":{"message":"(500) Failure: oops","code":500,"cause":"oops"}}
+//
+// The class is that of LogEntry.Class with the CurrentLoggerConfig. Fields
+// are written as a nested object with their JSON encoding, so e.g. durations
+// are numbers of nanoseconds, or with fmt.Sprint if they can't be encoded.
+// Notes, fields, and error are omitted if empty. The code of the error is that
+// of the sandwich Error, if any, see ToError.
+var LogJSON LogFormat = writeLogJSON
+
+// LogCommon is a LogFormat that writes each entry in the Common Log Format
+// used by Apache and nginx, e.g.:
+//
+//	1.2.3.4 - bob [03/Feb/2001:04:05:06 +0000] "GET /users/1 HTTP/1.1" 200 2326
+//
+// The user is that of the request's basic auth credentials, if any. Notes and
+// errors are not included.
+var LogCommon LogFormat = func(w io.Writer, e LogEntry) {
+	fmt.Fprintf(w, "%s\n", commonLogLine(e))
+}
+
+// LogCombined is a LogFormat that writes each entry in the Combined Log Format,
+// which is the Common Log Format followed by the Referer and User-Agent
+// headers, e.g.:
+//
+//	1.2.3.4 - - [03/Feb/2001:04:05:06 +0000] "GET / HTTP/1.1" 200 512 "https://example.com/" "curl/7.68.0"
+//
+// This is understood by most log processors, such as fail2ban and GoAccess.
+var LogCombined LogFormat = func(w io.Writer, e LogEntry) {
+	fmt.Fprintf(w, "%s \"%s\" \"%s\"\n", commonLogLine(e),
+		clfEscape(e.Request.Referer()), clfEscape(e.Request.UserAgent()))
+}
+
+var logFormat = LogText
+
+// SetLogFormat selects the format used by the default WriteLog, e.g.:
+//
+//	sandwich.SetLogFormat(sandwich.LogJSON)
+//
+// It should be called during initialization, before serving requests. It has
+// no effect if WriteLog has been replaced.
+func SetLogFormat(f LogFormat) {
+	if f == nil {
+		f = LogText
+	}
+	logFormat = f
+}
+
+func writeLogText(w io.Writer, e LogEntry) {
+	var col, reset string
+	if cfg := CurrentLoggerConfig(); cfg.colorize(w) {
+		col, reset = logColors(e, cfg)
+	}
+	fmt.Fprintf(w, "%s%s %s \"%s %s\" (%d %dB %s) %s%s\n",
+		col,
+		e.Start.Format(time.RFC3339), e.RemoteIp,
+		e.Request.Method, e.Request.RequestURI,
+		e.StatusCode, e.ResponseSize, e.Elapsed,
+		e.NotesAndError(),
+		reset)
+}
+
+type jsonLogEntry struct {
+	Time      string            `json:"time"`
+	RemoteIp  string            `json:"remote_ip"`
+	Method    string            `json:"method"`
+	URI       string            `json:"uri"`
+	Status    int               `json:"status"`
+	Bytes     int               `json:"bytes"`
+	ElapsedNs int64             `json:"elapsed_ns"`
+	Class     string            `json:"class"`
+	Notes     map[string]string `json:"notes,omitempty"`
+	Fields    map[string]any    `json:"fields,omitempty"`
+	Error     *jsonLogError     `json:"error,omitempty"`
+}
+
+type jsonLogError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code,omitempty"`
+	Cause   string `json:"cause,omitempty"`
+}
+
+func writeLogJSON(w io.Writer, e LogEntry) {
+	entry := jsonLogEntry{
+		Time:      e.Start.Format(time.RFC3339Nano),
+		RemoteIp:  e.RemoteIp,
+		Method:    e.Request.Method,
+		URI:       e.Request.RequestURI,
+		Status:    e.StatusCode,
+		Bytes:     e.ResponseSize,
+		ElapsedNs: int64(e.Elapsed),
+		Class:     e.Class(CurrentLoggerConfig()).String(),
+	}
+	if len(e.Note) > 0 {
+		entry.Notes = e.Note
+	}
+	if len(e.Fields) > 0 {
+		entry.Fields = make(map[string]any, len(e.Fields))
+		for k, v := range e.Fields {
+			if _, err := json.Marshal(v); err != nil {
+				v = fmt.Sprint(v) // e.g. channels or functions
+			}
+			entry.Fields[k] = v
+		}
+	}
+	if e.Error != nil {
+		entry.Error = &jsonLogError{Message: e.Error.Error()}
+		var sErr Error
+		if errors.As(e.Error, &sErr) {
+			entry.Error.Code = sErr.Code
+			if sErr.Cause != nil {
+				entry.Error.Cause = sErr.Cause.Error()
+			}
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(w, "{\"error\":{\"message\":%q}}\n", "cannot encode log entry: "+err.Error())
+		return
+	}
+	w.Write(append(data, '\n'))
+}
+
+func commonLogLine(e LogEntry) string {
+	host := strings.TrimSpace(strings.Split(e.RemoteIp, ",")[0])
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	user, _, _ := e.Request.BasicAuth()
+	size := "-"
+	if e.ResponseSize > 0 {
+		size = strconv.Itoa(e.ResponseSize)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		dashIfEmpty(host), dashIfEmpty(clfEscape(user)),
+		e.Start.Format("02/Jan/2006:15:04:05 -0700"),
+		clfEscape(e.Request.Method), clfEscape(e.Request.RequestURI), clfEscape(e.Request.Proto),
+		e.StatusCode, size)
+}
+
+// clfEscape escapes quotes, backslashes, and non-printable characters as
+// Apache does so that each entry is a single parseable line.
+func clfEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
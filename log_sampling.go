@@ -0,0 +1,116 @@
+package sandwich
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// LogSampling configures SampleLogs.
+type LogSampling struct {
+	// Rate is the fraction of successful requests that are logged, between 0
+	// and 1, e.g. 0.01 logs one of every 100 requests.
+	Rate float64
+	// Slow is the duration beyond which requests are always logged. If zero,
+	// slow requests are sampled like any other successful request.
+	Slow time.Duration
+	// FlushAfter, if positive, bounds how long suppressed requests may go
+	// unreported. If no request has been logged within FlushAfter of the first
+	// suppressed one, the last suppressed request is logged with the number of
+	// the others, so that routes that stop receiving traffic still report the
+	// requests they suppressed.
+	FlushAfter time.Duration
+}
+
+// SampleLogs returns a middleware that logs only a fraction of the successful
+// requests of high-traffic routes. Requests that fail, either with an error or
+// a status code of 400 or higher, and slow requests are always logged. The
+// number of requests that weren't logged since the previous logged request is
+// recorded in its "suppressed" note, so the true request volume can still be
+// derived from the logs. For example:
+//
+//	mux.Get("/health", sandwich.SampleLogs(sandwich.LogSampling{Rate: 0.01}), checkHealth)
+//
+// Each SampleLogs middleware samples the requests of all routes that it's
+// added to together, so it may be added to a single route or, using Use, to
+// all routes. It must be added after LogRequests, as TheUsual does.
+func SampleLogs(cfg LogSampling) ChainMutation { return &logSampler{cfg: cfg} }
+
+type logSampler struct {
+	cfg LogSampling
+
+	mu         sync.Mutex
+	credit     float64 // the accumulated Rate; a request is logged once it reaches 1
+	suppressed int
+
+	// For FlushAfter: the last suppressed request, the sink that it was
+	// committed to, and the timer that logs it.
+	last  LogEntry
+	sink  LogSink
+	flush *time.Timer
+}
+
+func (s *logSampler) Apply(c chain.Func) chain.Func { return c.Defer(s.sample) }
+
+func (s *logSampler) sample(e *LogEntry, w *ResponseWriter, err error, sink LogSink) {
+	if e.Quiet {
+		return
+	}
+	done := e.snapshot(w, err)
+	failed := done.Error != nil || done.StatusCode >= 400
+	slow := s.cfg.Slow > 0 && done.Elapsed >= s.cfg.Slow
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !failed && !slow {
+		s.credit += s.cfg.Rate
+		if s.credit < 1 {
+			s.suppressed++
+			e.Quiet = true
+			if s.cfg.FlushAfter > 0 {
+				s.last, s.sink = done, sink
+				if s.flush == nil {
+					s.flush = time.AfterFunc(s.cfg.FlushAfter, s.flushSuppressed)
+				}
+			}
+			return
+		}
+		s.credit--
+	}
+	if s.suppressed > 0 {
+		e.Note["suppressed"] = strconv.Itoa(s.suppressed)
+		s.reset()
+	}
+}
+
+// flushSuppressed logs the last suppressed request, if no request has been
+// logged since it was suppressed.
+func (s *logSampler) flushSuppressed() {
+	s.mu.Lock()
+	e, sink, n := s.last, s.sink, s.suppressed
+	s.reset()
+	s.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	note := make(map[string]string, len(e.Note)+1)
+	for k, v := range e.Note {
+		note[k] = v
+	}
+	if n > 1 {
+		note["suppressed"] = strconv.Itoa(n - 1)
+	}
+	e.Note = note
+	sink.Write(e)
+}
+
+func (s *logSampler) reset() {
+	s.suppressed = 0
+	s.last, s.sink = LogEntry{}, nil
+	if s.flush != nil {
+		s.flush.Stop()
+		s.flush = nil
+	}
+}
@@ -0,0 +1,77 @@
+package sandwich
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleLogs(t *testing.T) {
+	orig := WriteLog
+	defer func() { WriteLog = orig; time_Now = time.Now }()
+	var logged []string
+	WriteLog = func(e LogEntry) {
+		if !e.Quiet {
+			logged = append(logged, e.Request.URL.Path+" "+e.Note["suppressed"])
+		}
+	}
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	time_Now = func() time.Time { return now }
+
+	r := TheUsual()
+	r.Use(SampleLogs(LogSampling{Rate: 0.25, Slow: time.Second}))
+	r.Get("/ok", hello)
+	r.Get("/fail", func() error { return errors.New("oops") })
+	r.Get("/missing", func(w http.ResponseWriter) { w.WriteHeader(404) })
+	r.Get("/slow", func() { now = now.Add(time.Second) })
+	r.Get("/quiet", NoLog)
+	r.Get("/unsampled", NoLog, hello) // quiet requests aren't counted
+
+	serve := func(path string) { r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil)) }
+	for _, path := range []string{
+		"/ok", "/ok", "/ok", "/ok", // 1 of 4 is logged
+		"/ok", "/fail", "/missing", "/slow", "/quiet", "/unsampled",
+		"/ok", "/ok", "/ok",
+	} {
+		serve(path)
+	}
+	assert.Equal(t, []string{
+		"/ok 3",
+		"/fail 1",
+		"/missing ",
+		"/slow ",
+		"/ok 2",
+	}, logged)
+}
+
+func TestSampleLogsFlushAfter(t *testing.T) {
+	logged := make(chan LogEntry, 10)
+	r := TheUsual()
+	r.SetLogSink(LogSinkFunc(func(e LogEntry) {
+		if !e.Quiet {
+			logged <- e
+		}
+	}))
+	r.Use(SampleLogs(LogSampling{Rate: 0.1, FlushAfter: 10 * time.Millisecond}))
+	r.Get("/ok", hello)
+
+	for _, path := range []string{"/ok?n=1", "/ok?n=2", "/ok?n=3"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	select {
+	case e := <-logged:
+		assert.Equal(t, "n=3", e.Request.URL.RawQuery)
+		assert.Equal(t, "2", e.Note["suppressed"])
+	case <-time.After(time.Second):
+		t.Fatal("suppressed requests weren't flushed")
+	}
+	select {
+	case e := <-logged:
+		t.Errorf("unexpected log entry: %v", e.Request.URL)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,51 @@
+package sandwich
+
+import (
+	"io"
+	"sync"
+)
+
+// LogSink receives the completed log entry of each request, including entries
+// that are Quiet, which most sinks should ignore. Each router has its own
+// sink, see Router.SetLogSink. By default, entries are written by WriteLog.
+type LogSink interface {
+	Write(e LogEntry)
+}
+
+// LogSinkFunc adapts a function to a LogSink.
+type LogSinkFunc func(e LogEntry)
+
+// Write calls f(e).
+func (f LogSinkFunc) Write(e LogEntry) { f(e) }
+
+// defaultLogSink writes entries with WriteLog, so that replacing WriteLog
+// affects all routers that don't have their own sink.
+var defaultLogSink LogSink = LogSinkFunc(func(e LogEntry) { WriteLog(e) })
+
+// NewLogSink returns a LogSink that writes entries that aren't Quiet to w using
+// format. If format is nil, LogText is used. Writes are serialized, so w needn't
+// be safe for concurrent use. For example, to write the logs of an admin router
+// to a separate file in JSON:
+//
+//	admin.SetLogSink(sandwich.NewLogSink(adminLogFile, sandwich.LogJSON))
+func NewLogSink(w io.Writer, format LogFormat) LogSink {
+	if format == nil {
+		format = LogText
+	}
+	return &writerLogSink{w: w, format: format}
+}
+
+type writerLogSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format LogFormat
+}
+
+func (s *writerLogSink) Write(e LogEntry) {
+	if e.Quiet {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.format(s.w, e)
+}
@@ -0,0 +1,68 @@
+package sandwich
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLogSink(t *testing.T) {
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	var global []string
+	WriteLog = func(e LogEntry) { global = append(global, e.Request.URL.Path) }
+
+	var public, admin bytes.Buffer
+	pub := TheUsual()
+	pub.SetLogSink(NewLogSink(&public, LogCombined))
+	pub.Get("/", hello)
+	pub.Get("/quiet", NoLog, hello)
+	api := pub.SubRouter("/api")
+	api.Get("/items", hello)
+
+	adm := TheUsual()
+	var entries []LogEntry
+	adm.SetLogSink(LogSinkFunc(func(e LogEntry) { entries = append(entries, e) }))
+	adm.Get("/admin", hello)
+	adm.SetLogSink(NewLogSink(&admin, LogJSON))
+	adm.Get("/admin/json", hello)
+	adm.SetLogSink(nil)
+	adm.Get("/admin/default", hello)
+
+	for _, path := range []string{"/", "/quiet", "/api/items"} {
+		pub.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	for _, path := range []string{"/admin", "/admin/json", "/admin/default"} {
+		adm.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	lines := strings.Split(strings.TrimSpace(public.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"GET / HTTP/1.1" 200`)
+	assert.Contains(t, lines[1], `"GET /api/items HTTP/1.1" 200`)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "/admin", entries[0].Request.URL.Path)
+	}
+	assert.Contains(t, admin.String(), `"uri":"/admin/json"`)
+	assert.Equal(t, []string{"/admin/default"}, global)
+}
+
+func TestSetLogSinkExtend(t *testing.T) {
+	var entries []string
+	bundle := BuildYourOwn()
+	bundle.Use(func(w http.ResponseWriter) { w.Header().Set("X-Bundle", "1") })
+
+	r := TheUsual()
+	r.SetLogSink(LogSinkFunc(func(e LogEntry) { entries = append(entries, e.Request.URL.Path) }))
+	r.Extend(bundle)
+	r.Get("/", hello)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "1", w.Header().Get("X-Bundle"))
+	assert.Equal(t, []string{"/"}, entries, "Extend doesn't replace the sink")
+}
@@ -8,6 +8,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/augustoroman/sandwich/chain"
 )
 
 // Injected for testing
@@ -15,10 +17,11 @@ var time_Now = time.Now
 var os_Stderr io.Writer = os.Stderr
 
 // LogEntry is the information tracked on a per-request basis for the sandwich
-// Logger.  All fields other than Note are automatically filled in.  The Note
-// field is a generic key-value string map for adding additional per-request
-// metadata to the logs.  You can take *sandwich.LogEntry to your functions to
-// add fields to Note.
+// Logger.  All fields other than Note and Fields are automatically filled in.
+// The Note field is a generic key-value string map for adding additional
+// per-request metadata to the logs.  You can take *sandwich.LogEntry to your
+// functions to add fields to Note, or use Set to add typed values, such as
+// numbers, durations, or structs, that are preserved by LogJSON.
 //
 // For example:
 //
@@ -38,10 +41,35 @@ type LogEntry struct {
 	Elapsed      time.Duration
 	Error        error
 	Note         map[string]string
+	// Fields are typed metadata values, see Set and LogField.
+	Fields map[string]any
+	// Slow, if positive, overrides LoggerConfig.Slow for this request, see
+	// SlowAfter.
+	Slow time.Duration
 	// set to true to suppress logging this request
 	Quiet bool
 }
 
+// Set records a typed metadata value of the request in Fields. Unlike Note,
+// the value keeps its type in structured log formats such as LogJSON, e.g.:
+//
+//	e.Set("db_rows", len(rows))
+//	e.Set("db_time", elapsed)
+func (e *LogEntry) Set(key string, value any) {
+	if e.Fields == nil {
+		e.Fields = map[string]any{}
+	}
+	e.Fields[key] = value
+}
+
+// LogField returns the value of e.Fields[key] if it's set and has type T, e.g.:
+//
+//	rows, ok := sandwich.LogField[int](entry, "db_rows")
+func LogField[T any](e LogEntry, key string) (T, bool) {
+	v, ok := e.Fields[key].(T)
+	return v, ok
+}
+
 // NoLog is a middleware function that suppresses log output for this request.
 // For example:
 //
@@ -52,9 +80,47 @@ type LogEntry struct {
 // implementation does.
 func NoLog(e *LogEntry) { e.Quiet = true }
 
+// QuietWhen returns a middleware that suppresses log output for requests that
+// match quiet, which is called with the completed log entry just before it's
+// committed. Unlike NoLog, this allows suppressing requests based on their
+// outcome, so the rules for filtering noise from the logs can be declared along
+// with the routes. For example, to log health checks only when they fail:
+//
+//	mux.Get("/healthz", sandwich.QuietWhen(sandwich.Succeeded), checkHealth)
+//
+// or to apply rules to all routes:
+//
+//	mux.Use(sandwich.QuietWhen(func(e sandwich.LogEntry) bool {
+//	    return e.StatusCode == http.StatusNotModified
+//	}))
+//
+// It must be added after LogRequests, as TheUsual does.
+func QuietWhen(quiet func(e LogEntry) bool) ChainMutation { return quietWhen(quiet) }
+
+type quietWhen func(e LogEntry) bool
+
+func (q quietWhen) Apply(c chain.Func) chain.Func {
+	return c.Defer(func(e *LogEntry, w *ResponseWriter, err error) {
+		if !e.Quiet && q(e.snapshot(w, err)) {
+			e.Quiet = true
+		}
+	})
+}
+
+// Succeeded reports whether the request of a completed log entry succeeded
+// with a 2xx status code and no error. See QuietWhen.
+func Succeeded(e LogEntry) bool {
+	return e.StatusCode >= 200 && e.StatusCode < 300 && e.Error == nil
+}
+
 // LogRequests is a middleware wrap that creates a log entry during middleware
-// processing and then commits the log entry after the middleware has executed.
-var LogRequests = Wrap{NewLogEntry, (*LogEntry).Commit}
+// processing and then commits the log entry to the router's LogSink after the
+// middleware has executed. It has a negative priority so that the log entry
+// is committed after other defer'd handlers, such as Gzip, have finished
+// writing the response.
+var LogRequests = Prioritized(Wrap{NewLogEntry, (*LogEntry).CommitTo}, logRequestsPriority)
+
+const logRequestsPriority = -1
 
 // NewLogEntry creates a *LogEntry and initializes it with basic request
 // information.
@@ -67,12 +133,77 @@ func NewLogEntry(r *http.Request) *LogEntry {
 	}
 }
 
-// Commit fills in the remaining *LogEntry fields and writes the entry out.
+// Commit fills in the remaining *LogEntry fields and writes the entry out. If
+// the request was aborted because its context was canceled, e.g. because the
+// client disconnected, and no other error was recorded, the context's error is
+// recorded.
 func (entry *LogEntry) Commit(w *ResponseWriter) {
+	entry.finish(w)
+	entry.recordResponseBody(w)
+	WriteLog(*entry)
+}
+
+// CommitTo is like Commit, but writes the entry to sink, which is provided by
+// the router. See Router.SetLogSink.
+func (entry *LogEntry) CommitTo(w *ResponseWriter, sink LogSink) {
+	entry.finish(w)
+	entry.recordResponseBody(w)
+	sink.Write(*entry)
+}
+
+// CaptureResponseBody returns a middleware handler that records up to maxBytes
+// of the response body in the LogEntry if the response has a 5xx status, so
+// that the error that was actually sent to the client can be investigated. The
+// body is recorded as the "response_body" field, and the
+// "response_body_truncated" field is set if the body was longer than maxBytes.
+// For example:
+//
+//	mux.Use(sandwich.CaptureResponseBody(1 << 10))
+//
+// The body is captured as written to the *ResponseWriter, so it's compressed
+// if the response is compressed by Gzip. See ResponseWriter.CaptureBody.
+func CaptureResponseBody(maxBytes int) func(w *ResponseWriter) {
+	return func(w *ResponseWriter) { w.CaptureBody(maxBytes) }
+}
+
+// recordResponseBody records the body captured by w, if any, for 5xx
+// responses.
+func (entry *LogEntry) recordResponseBody(w *ResponseWriter) {
+	body, truncated := w.CapturedBody()
+	if entry.StatusCode < 500 || (len(body) == 0 && !truncated) {
+		return
+	}
+	entry.Set("response_body", string(body))
+	if truncated {
+		entry.Set("response_body_truncated", true)
+	}
+}
+
+// snapshot returns a copy of the entry as it would be committed now. It's used
+// by deferred handlers that run before Commit. The error returned by the
+// handlers is recorded if the error handler didn't record one.
+func (entry *LogEntry) snapshot(w *ResponseWriter, err error) LogEntry {
+	e := *entry
+	e.finish(w)
+	if e.Error == nil && err != nil && err != Done {
+		e.Error = err
+	}
+	if e.StatusCode == 0 && e.Error == nil {
+		e.StatusCode = http.StatusOK // the server sends a 200 if nothing was written
+	}
+	return e
+}
+
+// finish fills in the remaining *LogEntry fields without writing the entry.
+func (entry *LogEntry) finish(w *ResponseWriter) {
+	if entry.Error == nil && entry.Request != nil {
+		if err := entry.Request.Context().Err(); err != nil {
+			entry.Error = fmt.Errorf("request aborted: %w", err)
+		}
+	}
 	entry.Elapsed = time_Now().Sub(entry.Start)
 	entry.ResponseSize = w.Size
 	entry.StatusCode = w.Code
-	WriteLog(*entry)
 }
 
 // Some nice escape codes
@@ -84,29 +215,33 @@ const (
 )
 
 // WriteLog is called to actually write a LogEntry out to the log. By default,
-// it writes to stderr and colors normal requests green, slow requests yellow,
-// and errors red.  You can replace the function to adjust the formatting or use
-// whatever logging library you like.
+// it writes to stderr using the LogFormat selected by SetLogFormat, which
+// colors normal requests green, slow requests yellow, and errors red when
+// stderr is a terminal unless another format, such as LogJSON or LogCombined,
+// is selected.  You can replace
+// the function to adjust the formatting or use whatever logging library you
+// like.
 var WriteLog = func(e LogEntry) {
 	if e.Quiet {
 		return
 	}
-	col, reset := logColors(e)
-	fmt.Fprintf(os_Stderr, "%s%s %s \"%s %s\" (%d %dB %s) %s%s\n",
-		col,
-		e.Start.Format(time.RFC3339), e.RemoteIp,
-		e.Request.Method, e.Request.RequestURI,
-		e.StatusCode, e.ResponseSize, e.Elapsed,
-		e.NotesAndError(),
-		reset)
+	logFormat(os_Stderr, e)
 }
 
-// NotesAndError formats the Note values and error (if any) for logging.
+// NotesAndError formats the Note and Fields values and error (if any) for
+// logging.
 func (l LogEntry) NotesAndError() string {
 	pairs := make([]string, len(l.Note))
 	for k, v := range l.Note {
 		pairs = append(pairs, fmt.Sprintf("%s=%q", k, v))
 	}
+	for k, v := range l.Fields {
+		if str, ok := v.(string); ok {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", k, str))
+		} else {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
 	sort.Strings(pairs)
 	msg := strings.Join(pairs, " ")
 	if l.Error != nil {
@@ -115,15 +250,33 @@ func (l LogEntry) NotesAndError() string {
 	return msg
 }
 
-func logColors(e LogEntry) (start, reset string) {
-	col, reset := _GREEN, _RESET
-	if e.Elapsed > 30*time.Millisecond {
-		col = _YELLOW
+// Class classifies the completed request as failed, slow, or fast according to
+// the thresholds of cfg and the Slow threshold of the request, if set. Zero
+// thresholds are replaced by their defaults. Use CurrentLoggerConfig to
+// classify requests like the default log format does.
+func (l LogEntry) Class(cfg LoggerConfig) RequestClass {
+	cfg = cfg.withDefaults()
+	if l.StatusCode >= cfg.FailedStatus || l.Error != nil {
+		return RequestFailed
+	}
+	slow := cfg.Slow
+	if l.Slow > 0 {
+		slow = l.Slow
+	}
+	if l.Elapsed > slow {
+		return RequestSlow
 	}
-	if e.StatusCode >= 400 || e.Error != nil {
-		col, reset = _RED, _RESET // high-intensity red + reset
+	return RequestFast
+}
+
+func logColors(e LogEntry, cfg LoggerConfig) (start, reset string) {
+	switch e.Class(cfg) {
+	case RequestFailed:
+		return _RED, _RESET // high-intensity red + reset
+	case RequestSlow:
+		return _YELLOW, _RESET
 	}
-	return col, reset
+	return _GREEN, _RESET
 }
 
 // remoteIp extracts the remote IP from the request.  Adapted from code in
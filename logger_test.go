@@ -2,7 +2,9 @@ package sandwich
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/augustoroman/sandwich/chain"
+	"github.com/stretchr/testify/assert"
 )
 
 type fakeClock struct {
@@ -51,11 +54,12 @@ func validateLogMessage(t *testing.T, logs, expectedColor, expectedMsg string) {
 func TestLogger(t *testing.T) {
 	// Restore the world from insanity when we're done:
 	orig := WriteLog
-	defer func() { time_Now = time.Now; os_Stderr = os.Stderr; WriteLog = orig }()
+	defer func() { time_Now = time.Now; os_Stderr = os.Stderr; WriteLog = orig; LogColor(ColorAuto) }()
 
 	// Setup our fake world.
 	var logBuf bytes.Buffer
 	os_Stderr = &logBuf
+	LogColor(ColorAlways) // the buffer isn't a terminal
 	clk := &fakeClock{time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC), 13 * time.Millisecond}
 	time_Now = clk.Now
 
@@ -152,3 +156,271 @@ func TestLogger(t *testing.T) {
 		t.Errorf("Incorrect client response: %q", resp.Body.String())
 	}
 }
+
+func TestLoggerRecordsAbortedRequests(t *testing.T) {
+	var log LogEntry
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	WriteLog = func(e LogEntry) { log = e }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	called := false
+	mux := TheUsual()
+	mux.Get("/", func() { cancel() }, func() { called = true })
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+	if called {
+		t.Errorf("Handler should not be called after the request is canceled")
+	}
+	if !errors.Is(log.Error, context.Canceled) {
+		t.Errorf("Log should record the canceled request, but error is: %v", log.Error)
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	orig := WriteLog
+	defer func() { time_Now = time.Now; os_Stderr = os.Stderr; WriteLog = orig; SetLogFormat(nil) }()
+	var logBuf bytes.Buffer
+	os_Stderr = &logBuf
+	clk := &fakeClock{time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC), 13 * time.Millisecond}
+	time_Now = clk.Now
+	SetLogFormat(LogJSON)
+
+	mux := TheUsual()
+	mux.Get("/ok", func(w http.ResponseWriter) { _, _ = w.Write([]byte("Hi")) })
+	mux.Get("/fail", func(e *LogEntry) error {
+		e.Note["user"] = "bob"
+		return Error{Code: 403, ClientMsg: "No", LogMsg: "Denied", Cause: errors.New("bad token")}
+	})
+
+	for _, path := range []string{"/ok", "/fail"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "1.2.3.4:5"
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t,
+		`{"time":"2001-02-03T04:05:06Z","remote_ip":"1.2.3.4:5","method":"GET","uri":"/ok",`+
+			`"status":200,"bytes":2,"elapsed_ns":13000000,"class":"fast"}`+"\n"+
+			`{"time":"2001-02-03T04:05:06.026Z","remote_ip":"1.2.3.4:5","method":"GET","uri":"/fail",`+
+			`"status":403,"bytes":3,"elapsed_ns":13000000,"class":"error","notes":{"user":"bob"},`+
+			`"error":{"message":"(403) Denied: bad token","code":403,"cause":"bad token"}}`+"\n",
+		logBuf.String())
+}
+
+func TestLogCombined(t *testing.T) {
+	e := LogEntry{
+		RemoteIp:     "[::1]:56596",
+		Start:        time.Date(2001, 2, 3, 4, 5, 6, 0, time.FixedZone("", -7*3600)),
+		Request:      httptest.NewRequest("GET", "/search?q=%22x%22", nil),
+		StatusCode:   200,
+		ResponseSize: 2326,
+	}
+	e.Request.Header.Set("Referer", "https://example.com/")
+	e.Request.Header.Set("User-Agent", `evil "agent"`+"\n")
+	e.Request.SetBasicAuth("bob", "secret")
+
+	var buf bytes.Buffer
+	LogCommon(&buf, e)
+	e.RemoteIp, e.ResponseSize = "1.2.3.4, 10.0.0.1", 0
+	e.Request.Header.Del("Authorization")
+	LogCombined(&buf, e)
+	assert.Equal(t,
+		`::1 - bob [03/Feb/2001:04:05:06 -0700] "GET /search?q=%22x%22 HTTP/1.1" 200 2326`+"\n"+
+			`1.2.3.4 - - [03/Feb/2001:04:05:06 -0700] "GET /search?q=%22x%22 HTTP/1.1" 200 - `+
+			`"https://example.com/" "evil \"agent\"\x0a"`+"\n",
+		buf.String())
+}
+
+func TestQuietWhen(t *testing.T) {
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	var logged []string
+	WriteLog = func(e LogEntry) {
+		if !e.Quiet {
+			logged = append(logged, e.Request.URL.Path)
+		}
+	}
+
+	healthy := true
+	r := TheUsual()
+	r.Use(QuietWhen(func(e LogEntry) bool { return e.StatusCode == http.StatusNotModified }))
+	r.Get("/healthz", QuietWhen(Succeeded), func() error {
+		if !healthy {
+			return errors.New("unhealthy")
+		}
+		return nil
+	})
+	r.Get("/cached", func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotModified) })
+	r.Get("/created", func(w http.ResponseWriter) { w.WriteHeader(http.StatusCreated) })
+	r.Get("/done", QuietWhen(Succeeded), func(w http.ResponseWriter) error {
+		w.WriteHeader(http.StatusAccepted)
+		return Done
+	})
+
+	serve := func(path string) { r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil)) }
+	serve("/healthz")
+	healthy = false
+	serve("/healthz")
+	serve("/cached")
+	serve("/created")
+	serve("/done")
+	assert.Equal(t, []string{"/healthz", "/created"}, logged)
+}
+
+func TestLoggerConfig(t *testing.T) {
+	defer ConfigureLogger(LoggerConfig{})
+	e := LogEntry{
+		RemoteIp:   "1.2.3.4",
+		Start:      time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC),
+		Request:    httptest.NewRequest("GET", "/", nil),
+		StatusCode: 404,
+		Elapsed:    50 * time.Millisecond,
+	}
+	e.Request.RequestURI = "/"
+	format := func() string {
+		var buf bytes.Buffer
+		LogText(&buf, e)
+		return buf.String()
+	}
+	const line = `2001-02-03T04:05:06Z 1.2.3.4 "GET /" (404 0B 50ms) `
+
+	// Buffers aren't terminals.
+	assert.Equal(t, line+"\n", format())
+	LogColor(ColorAlways)
+	assert.Equal(t, _RED+line+_RESET+"\n", format())
+
+	ConfigureLogger(LoggerConfig{Color: ColorAlways, Slow: 100 * time.Millisecond, FailedStatus: 500})
+	assert.Equal(t, _GREEN+line+_RESET+"\n", format())
+	e.Elapsed = 200 * time.Millisecond
+	assert.Equal(t, _YELLOW+strings.Replace(line, "50ms", "200ms", 1)+_RESET+"\n", format())
+
+	ConfigureLogger(LoggerConfig{Color: ColorNever})
+	assert.Equal(t, strings.Replace(line, "50ms", "200ms", 1)+"\n", format())
+}
+
+func TestRequestClass(t *testing.T) {
+	defer ConfigureLogger(LoggerConfig{})
+	var defaults LoggerConfig
+	e := LogEntry{StatusCode: 200, Elapsed: 20 * time.Millisecond}
+	assert.Equal(t, RequestFast, e.Class(defaults))
+	e.Elapsed = 40 * time.Millisecond
+	assert.Equal(t, RequestSlow, e.Class(defaults))
+	assert.Equal(t, "slow", e.Class(defaults).String())
+	e.StatusCode = 404
+	assert.Equal(t, RequestFailed, e.Class(defaults))
+	e.StatusCode, e.Error = 200, errors.New("oops")
+	assert.Equal(t, RequestFailed, e.Class(defaults))
+
+	e.Error = nil
+	assert.Equal(t, RequestFast, e.Class(LoggerConfig{Slow: time.Second}))
+	ConfigureLogger(LoggerConfig{Slow: time.Second})
+	assert.Equal(t, RequestFast, e.Class(CurrentLoggerConfig()))
+
+	// Per-route overrides.
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	var logged LogEntry
+	WriteLog = func(e LogEntry) { logged = e }
+	clk := &fakeClock{time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC), 50 * time.Millisecond}
+	defer func() { time_Now = time.Now }()
+	time_Now = clk.Now
+
+	r := TheUsual()
+	r.Get("/", func() {})
+	r.Get("/strict", SlowAfter(10*time.Millisecond), func() {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, RequestFast, logged.Class(CurrentLoggerConfig()))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/strict", nil))
+	assert.Equal(t, RequestSlow, logged.Class(CurrentLoggerConfig()))
+}
+
+func TestConfigureLoggerConcurrently(t *testing.T) {
+	defer ConfigureLogger(LoggerConfig{})
+	e := LogEntry{StatusCode: 200, Elapsed: 50 * time.Millisecond, Request: httptest.NewRequest("GET", "/", nil)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ConfigureLogger(LoggerConfig{Slow: time.Duration(i) * time.Millisecond})
+			LogColor(ColorNever)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		LogText(io.Discard, e)
+		_ = e.Class(CurrentLoggerConfig())
+	}
+	<-done
+	assert.Equal(t, ColorNever, CurrentLoggerConfig().Color)
+	assert.Equal(t, 99*time.Millisecond, CurrentLoggerConfig().Slow)
+}
+
+func TestLogEntryFields(t *testing.T) {
+	type stats struct {
+		Rows int `json:"rows"`
+	}
+	e := LogEntry{
+		RemoteIp:   "1.2.3.4",
+		Start:      time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC),
+		Request:    httptest.NewRequest("GET", "/", nil),
+		StatusCode: 200,
+		Note:       map[string]string{"user": "bob"},
+	}
+	e.Set("rows", 3)
+	e.Set("db_time", 1500*time.Microsecond)
+	e.Set("db", stats{3})
+	e.Set("query", "a b")
+	e.Set("bad", make(chan int))
+
+	rows, ok := LogField[int](e, "rows")
+	assert.True(t, ok)
+	assert.Equal(t, 3, rows)
+	_, ok = LogField[string](e, "rows")
+	assert.False(t, ok)
+	_, ok = LogField[int](e, "missing")
+	assert.False(t, ok)
+
+	delete(e.Fields, "bad")
+	assert.Equal(t, ` db={3} db_time=1.5ms query="a b" rows=3 user="bob"`, e.NotesAndError())
+
+	e.Set("bad", make(chan int))
+	var buf bytes.Buffer
+	LogJSON(&buf, e)
+	assert.Contains(t, buf.String(), `"notes":{"user":"bob"},"fields":{"bad":"0x`)
+	assert.Contains(t, buf.String(), `"db":{"rows":3},"db_time":1500000,"query":"a b","rows":3}`)
+}
+
+func TestCaptureResponseBody(t *testing.T) {
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	var logged LogEntry
+	WriteLog = func(e LogEntry) { logged = e }
+
+	r := TheUsual()
+	r.Use(CaptureResponseBody(10))
+	r.Get("/ok", func(w http.ResponseWriter) { w.Write([]byte("all good here")) })
+	r.Get("/short", func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("upstream"))
+	})
+	r.Get("/err", func() error { return errors.New("a long secret error") })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		logged = LogEntry{}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	serve("/ok")
+	assert.Nil(t, logged.Fields, "only 5xx responses are recorded")
+
+	serve("/short")
+	assert.Equal(t, map[string]any{"response_body": "upstream"}, logged.Fields)
+
+	w := serve("/err")
+	assert.Equal(t, "Internal Server Error\n", w.Body.String())
+	assert.Equal(t, map[string]any{
+		"response_body":           "Internal S",
+		"response_body_truncated": true,
+	}, logged.Fields)
+}
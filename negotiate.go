@@ -0,0 +1,227 @@
+package sandwich
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const headerAccept = "Accept"
+
+// Format is the name of a response format, e.g. "json" or "html", as chosen by
+// Negotiate.
+type Format string
+
+// The well-known Formats. Other formats are named by their file extension, e.g.
+// "csv", see Negotiate.
+const (
+	FormatJSON Format = "json"
+	FormatHTML Format = "html"
+	FormatXML  Format = "xml"
+	FormatText Format = "text"
+)
+
+// formatMediaTypes are the media types of the well-known Formats.
+var formatMediaTypes = map[Format][]string{
+	FormatJSON: {"application/json"},
+	FormatHTML: {"text/html", "application/xhtml+xml"},
+	FormatXML:  {"application/xml", "text/xml"},
+	FormatText: {"text/plain"},
+}
+
+// mediaTypes returns the media types of f, or nil if f is unknown.
+func (f Format) mediaTypes() []string {
+	if types, ok := formatMediaTypes[f]; ok {
+		return types
+	}
+	mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension("." + string(f)))
+	if err != nil {
+		return nil
+	}
+	return []string{mediaType}
+}
+
+// HandleError responds to err with HandleErrorJson, HandleErrorXML, or
+// HandleError, depending on f. It's an error handler for routes that use
+// Negotiate, e.g.:
+//
+//	api.Use(sandwich.Negotiate("json", "xml"))
+//	api.OnErr(sandwich.Format.HandleError)
+func (f Format) HandleError(w http.ResponseWriter, r *http.Request, l *LogEntry, err error) {
+	switch f {
+	case FormatJSON:
+		HandleErrorJson(w, r, l, err)
+	case FormatXML:
+		HandleErrorXML(w, r, l, err)
+	default:
+		HandleError(w, r, l, err)
+	}
+}
+
+// Negotiate returns a middleware that chooses the format of the response from
+// the offered formats according to the request's Accept header, and provides
+// it as a Format to the subsequent handlers and error handlers. For example:
+//
+//	mux.Use(sandwich.Negotiate("html", "json"))
+//	mux.Get("/users/:id", loadUser, func(w http.ResponseWriter, f sandwich.Format, u *User) error {
+//	    if f == sandwich.FormatJSON {
+//	        ...
+//	    }
+//	    ...
+//	})
+//
+// The offers are in order of preference, which decides between formats that
+// the client accepts equally, e.g. for "Accept: */*". Requests without an
+// Accept header get the first offer. The formats "json", "html", "xml", and
+// "text" are built in, other formats are named by the file extension of their
+// media type, e.g. "csv", see mime.TypeByExtension.
+//
+// If the client accepts none of the offers, it fails with a 406 Not Acceptable
+// and provides the first offer, so that the error handlers can still respond
+// in a supported format. Responses include "Vary: Accept". Negotiate panics if
+// there are no offers or a format is unknown.
+func Negotiate(offers ...Format) func(w http.ResponseWriter, r *http.Request) (Format, error) {
+	if len(offers) == 0 {
+		panic("Negotiate requires at least one format")
+	}
+	for _, f := range offers {
+		if f.mediaTypes() == nil {
+			panic(fmt.Errorf("Negotiate: unknown format %q", f))
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) (Format, error) {
+		AddVary(w.Header(), headerAccept)
+		accept := r.Header.Get(headerAccept)
+		if accept == "" {
+			return offers[0], nil
+		}
+		f, ok := negotiateFormat(parseQualityList(accept), offers)
+		if !ok {
+			return offers[0], Error{
+				Code:      http.StatusNotAcceptable,
+				ClientMsg: http.StatusText(http.StatusNotAcceptable),
+				LogMsg:    fmt.Sprintf("No acceptable format for Accept: %q", accept),
+			}
+		}
+		return f, nil
+	}
+}
+
+// negotiateFormat returns the offer with the highest quality in the parsed
+// Accept header, preferring earlier offers. It returns false if the client
+// accepts none of them.
+func negotiateFormat(accepted []qualityValue, offers []Format) (Format, bool) {
+	var best Format
+	bestQ := 0.0
+	for _, f := range offers {
+		for _, mediaType := range f.mediaTypes() {
+			if q := mediaTypeQuality(accepted, mediaType); q > bestQ {
+				best, bestQ = f, q
+			}
+		}
+	}
+	return best, bestQ > 0
+}
+
+// mediaTypeQuality returns the quality of the most specific media range in the
+// parsed Accept header that matches mediaType, e.g. "text/html", "text/*", or
+// "*/*", or 0 if none matches.
+func mediaTypeQuality(accepted []qualityValue, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, 0
+	for _, v := range accepted {
+		s := 0
+		switch v.Value {
+		case mediaType:
+			s = 3
+		case typ + "/*":
+			s = 2
+		case "*/*":
+			s = 1
+		}
+		if s > specificity {
+			q, specificity = v.Q, s
+		}
+	}
+	return q
+}
+
+// qualityValue is a single entry of a header such as Accept or
+// Accept-Encoding, e.g. "gzip;q=0.8".
+type qualityValue struct {
+	Value string
+	Q     float64
+}
+
+// parseQualityList parses a comma-separated header value with optional
+// q-values, as used by Accept, Accept-Encoding, and Accept-Language. The
+// result is sorted by descending quality, preserving the header order for
+// equal qualities. Values are lower-cased. Entries that can't be parsed are
+// skipped.
+func parseQualityList(header string) []qualityValue {
+	var vals []qualityValue
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		v := qualityValue{strings.ToLower(strings.TrimSpace(fields[0])), 1}
+		if v.Value == "" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			v.Q = q
+		}
+		vals = append(vals, v)
+	}
+	sort.SliceStable(vals, func(i, j int) bool { return vals[i].Q > vals[j].Q })
+	return vals
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header value allows the
+// specified content coding, either explicitly or via "*".
+func acceptsEncoding(acceptEncoding, coding string) bool {
+	wildcard := false
+	for _, v := range parseQualityList(acceptEncoding) {
+		if v.Value == coding {
+			return v.Q > 0
+		}
+		if v.Value == "*" {
+			wildcard = v.Q > 0
+		}
+	}
+	return wildcard
+}
+
+// preferredLanguages returns the language tags accepted by the Accept-Language
+// header value in order of preference, followed by defaultLang, if any. Each
+// tag with a subtag, e.g. "de-ch", is followed by its primary language "de".
+func preferredLanguages(acceptLanguage, defaultLang string) []string {
+	var langs []string
+	seen := map[string]bool{}
+	add := func(lang string) {
+		if lang != "" && !seen[lang] {
+			seen[lang] = true
+			langs = append(langs, lang)
+		}
+	}
+	for _, v := range parseQualityList(acceptLanguage) {
+		if v.Q <= 0 || v.Value == "*" {
+			continue
+		}
+		add(v.Value)
+		if i := strings.Index(v.Value, "-"); i > 0 {
+			add(v.Value[:i])
+		}
+	}
+	add(strings.ToLower(defaultLang))
+	return langs
+}
@@ -0,0 +1,80 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQualityList(t *testing.T) {
+	assert.Equal(t, []qualityValue{
+		{"br", 1}, {"gzip", 0.8}, {"identity", 0.5}, {"*", 0},
+	}, parseQualityList("gzip;q=0.8, br, *;q=0, identity; q=0.5"))
+	assert.Equal(t, []qualityValue{{"a", 1}, {"b", 0}},
+		parseQualityList("a, b;q=nope, ,"))
+	assert.Empty(t, parseQualityList(""))
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	assert.True(t, acceptsEncoding("gzip, br", "br"))
+	assert.True(t, acceptsEncoding("GZIP", "gzip"))
+	assert.False(t, acceptsEncoding("gzip, br;q=0", "br"))
+	assert.True(t, acceptsEncoding("*", "br"))
+	assert.False(t, acceptsEncoding("*, br;q=0", "br"))
+	assert.False(t, acceptsEncoding("", "gzip"))
+}
+
+func TestNegotiate(t *testing.T) {
+	mux := TheUsual()
+	mux.Use(NoLog, Negotiate("html", "json", "xml", "csv"))
+	mux.OnErr(Format.HandleError)
+	mux.Get("/", func(w http.ResponseWriter, f Format) {
+		w.Write([]byte(f))
+	})
+	mux.Get("/fail", func() error { return Conflict("Already exists") })
+
+	testCases := []struct {
+		accept string
+		format string
+	}{
+		{"", "html"},
+		{"*/*", "html"},
+		{"application/json", "json"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "html"},
+		{"application/json;q=0.5, application/xml", "xml"},
+		{"text/xml", "xml"},
+		{"text/*;q=0.5, application/json;q=0.4", "html"},
+		{"text/*, text/html;q=0", "xml"},
+		{"text/csv, application/*;q=0.5", "csv"},
+		{"*/*;q=0.1, application/json", "json"},
+		{"TEXT/CSV", "csv"},
+	}
+	for _, test := range testCases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", test.accept)
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, "%+v", test)
+		assert.Equal(t, test.format, w.Body.String(), "%+v", test)
+		assert.Equal(t, "Accept", w.Header().Get("Vary"), "%+v", test)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "image/png, */*;q=0")
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Equal(t, "Not Acceptable\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/fail", nil)
+	req.Header.Set("Accept", "application/json")
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, `{"error":"Already exists"}`, w.Body.String())
+
+	assert.Panics(t, func() { Negotiate() })
+	assert.Panics(t, func() { Negotiate("json", "nope") })
+}
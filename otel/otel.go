@@ -0,0 +1,239 @@
+// Package otel records OpenTelemetry traces of the requests served by a
+// sandwich router. Each request gets a server span that's named by its route
+// pattern, e.g. "GET /users/:id", and records the status of the response and
+// any error. Optionally, each middleware handler gets a child span too:
+//
+//	mux := sandwich.TheUsual()
+//	otel.Install(mux, otel.Options{Steps: true})
+//	mux.Get("/users/:id", loadUser, showUser)
+//
+// The spans continue the W3C trace context of the incoming request, which is
+// parsed by sandwich.PropagateTrace, and the trace and span IDs of the server
+// span replace those of the sandwich.TraceContext. The sandwich.TracedClient,
+// the "trace_id" and "span_id" notes of the sandwich.LogEntry, and the
+// context of the request therefore all refer to the recorded span.
+//
+// This package is a separate module so that the sandwich module doesn't
+// depend on OpenTelemetry.
+package otel
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/augustoroman/sandwich"
+	"github.com/augustoroman/sandwich/chain"
+	otelapi "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer, as recommended by the
+// OpenTelemetry specification.
+const instrumentationName = "github.com/augustoroman/sandwich/otel"
+
+// Options configures New and Install.
+type Options struct {
+	// TracerProvider creates the tracer of the spans. If nil, the global
+	// TracerProvider is used, see go.opentelemetry.io/otel.SetTracerProvider.
+	TracerProvider trace.TracerProvider
+	// Client is used for the outgoing requests of the sandwich.TracedClient.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Steps records a child span of the server span for each handler of the
+	// chain, including error handlers and deferred handlers. See Install.
+	Steps bool
+}
+
+// Tracer records a server span for each request. It's a
+// sandwich.ChainMutation that starts the span, which must be added to a router
+// with Use after sandwich.PropagateTrace, and a sandwich.RouteMetrics that
+// names the span by its route, which must be added with ReportMetrics. Install
+// does both.
+type Tracer struct {
+	tracer trace.Tracer
+	client *http.Client
+	// active maps the Request of the LogEntry of each request to its
+	// *requestSpan, so that RecordRequest can find it.
+	active sync.Map
+}
+
+// New returns a Tracer that records spans with the tracer of
+// opts.TracerProvider. Options.Steps is ignored, see Install.
+func New(opts Options) *Tracer {
+	tp := opts.TracerProvider
+	if tp == nil {
+		tp = otelapi.GetTracerProvider()
+	}
+	return &Tracer{tracer: tp.Tracer(instrumentationName), client: opts.Client}
+}
+
+// Install records the traces of the requests of the routes of r that are
+// registered afterwards, and returns the Tracer. It adds
+// sandwich.PropagateTrace and the Tracer to r with Use, so r must provide a
+// *sandwich.LogEntry, e.g. with sandwich.LogRequests as TheUsual does. If
+// opts.Steps is set, the step spans are recorded with r.InstrumentSteps, which
+// replaces any previous step instrumentation of r.
+func Install(r sandwich.Router, opts Options) *Tracer {
+	t := New(opts)
+	r.Use(sandwich.PropagateTrace(opts.Client), t)
+	r.ReportMetrics(t)
+	if opts.Steps {
+		r.InstrumentSteps(t.StepStart, t.StepEnd)
+	}
+	return t
+}
+
+// requestSpan is the server span of a request.
+type requestSpan struct {
+	trace.Span
+	ctx context.Context // the context of the span
+	key *http.Request   // the key of the span in Tracer.active
+
+	mu       sync.Mutex
+	steps    []trace.Span // the spans of the handlers that are running
+	recorded bool         // whether RecordRequest has annotated the span
+}
+
+type spanKey struct{}
+
+// Apply starts the server span of each request before the subsequent handlers
+// and ends it after they complete.
+func (t *Tracer) Apply(c chain.Func) chain.Func {
+	return sandwich.Wrap{Before: t.start, After: t.end}.Apply(c)
+}
+
+func (t *Tracer) start(
+	r *http.Request, tc sandwich.TraceContext, e *sandwich.LogEntry,
+) (*http.Request, sandwich.TraceContext, sandwich.TracedClient, *requestSpan) {
+	ctx := r.Context()
+	if tc.ParentID.IsValid() {
+		state, _ := trace.ParseTraceState(tc.State)
+		ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID(tc.TraceID),
+			SpanID:     trace.SpanID(tc.ParentID),
+			TraceFlags: trace.TraceFlags(tc.Flags),
+			TraceState: state,
+			Remote:     true,
+		}))
+	}
+	ctx, span := t.tracer.Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		))
+	// Without an SDK, the span is the remote parent, or invalid if there's
+	// none, and the IDs generated by PropagateTrace are kept.
+	if sc := span.SpanContext(); sc.IsValid() && !sc.IsRemote() {
+		tc.TraceID = sandwich.TraceID(sc.TraceID())
+		tc.SpanID = sandwich.SpanID(sc.SpanID())
+		tc.Flags = byte(sc.TraceFlags())
+		e.Note["trace_id"] = tc.TraceID.String()
+		e.Note["span_id"] = tc.SpanID.String()
+	}
+
+	rs := &requestSpan{Span: span, ctx: ctx, key: e.Request}
+	if rs.key != nil {
+		t.active.Store(rs.key, rs)
+	}
+	ctx = sandwich.ContextWithTrace(context.WithValue(ctx, spanKey{}, rs), tc)
+	return r.WithContext(ctx), tc, tc.Client(t.client), rs
+}
+
+func (t *Tracer) end(rs *requestSpan, w *sandwich.ResponseWriter, err error) {
+	if rs.key != nil {
+		t.active.Delete(rs.key)
+	}
+	rs.mu.Lock()
+	recorded := rs.recorded
+	rs.mu.Unlock()
+	if !recorded {
+		code := w.Code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		rs.annotate(code, err)
+	}
+	rs.End()
+}
+
+// RecordRequest names the server span of the request of e by its route and
+// records the status and error of the response.
+func (t *Tracer) RecordRequest(route string, e sandwich.LogEntry) {
+	if e.Request == nil {
+		return
+	}
+	v, ok := t.active.Load(e.Request)
+	if !ok {
+		return
+	}
+	rs := v.(*requestSpan)
+	rs.mu.Lock()
+	rs.recorded = true
+	rs.mu.Unlock()
+	_, pattern, _ := strings.Cut(route, " ")
+	rs.SetName(route)
+	rs.SetAttributes(attribute.String("http.route", pattern))
+	rs.annotate(e.StatusCode, e.Error)
+}
+
+// annotate records the status and error of the response. As the OpenTelemetry
+// semantic conventions require for server spans, only 5xx responses are
+// errors.
+func (rs *requestSpan) annotate(code int, err error) {
+	rs.SetAttributes(attribute.Int("http.response.status_code", code))
+	if err != nil && err != sandwich.Done {
+		rs.RecordError(err)
+	}
+	if code >= 500 {
+		rs.SetStatus(codes.Error, http.StatusText(code))
+	}
+}
+
+// StepStart starts the span of a handler as a child of the server span of the
+// request in ctx, if any. It's a chain.StepStartFunc, see Install.
+func (t *Tracer) StepStart(ctx context.Context, handler chain.FuncInfo) {
+	rs, _ := ctx.Value(spanKey{}).(*requestSpan)
+	if rs == nil {
+		return
+	}
+	_, span := t.tracer.Start(rs.ctx, path.Base(handler.Name),
+		trace.WithAttributes(
+			attribute.String("code.function", handler.Name),
+			attribute.String("code.filepath", handler.File),
+			attribute.Int("code.lineno", handler.Line),
+		))
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.steps = append(rs.steps, span)
+}
+
+// StepEnd ends the span started by StepStart. It's a chain.StepEndFunc, see
+// Install.
+func (t *Tracer) StepEnd(ctx context.Context, handler chain.FuncInfo, elapsed time.Duration, err error) {
+	rs, _ := ctx.Value(spanKey{}).(*requestSpan)
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	if len(rs.steps) == 0 {
+		// The handler started before the server span, e.g. the Tracer
+		// itself.
+		rs.mu.Unlock()
+		return
+	}
+	span := rs.steps[len(rs.steps)-1]
+	rs.steps = rs.steps[:len(rs.steps)-1]
+	rs.mu.Unlock()
+	if err != nil && err != sandwich.Done {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,115 @@
+package otel
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/augustoroman/sandwich"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func loadUser(p sandwich.Params) (string, error) {
+	if p["id"] == "0" {
+		return "", sandwich.Error{Code: http.StatusServiceUnavailable, Cause: errors.New("db down")}
+	}
+	return "user" + p["id"], nil
+}
+
+func TestInstall(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	var outgoing string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = r.Header.Get("Traceparent")
+	}))
+	defer backend.Close()
+
+	var logged sandwich.LogEntry
+	mux := sandwich.TheUsual()
+	mux.SetLogSink(sandwich.LogSinkFunc(func(e sandwich.LogEntry) { logged = e }))
+	Install(mux, Options{TracerProvider: tp, Steps: true})
+	mux.Get("/users/:id", loadUser, func(w http.ResponseWriter, user string, c sandwich.TracedClient) error {
+		resp, err := c.Get(backend.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		_, err = w.Write([]byte(user))
+		return err
+	})
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, "user42", w.Body.String())
+
+	ended := spans.Ended()
+	var server sdktrace.ReadOnlySpan
+	var steps []string
+	for _, s := range ended {
+		if s.SpanKind() == trace.SpanKindServer {
+			server = s
+		} else {
+			steps = append(steps, s.Name())
+		}
+	}
+	require.NotNil(t, server)
+	assert.Equal(t, "GET /users/:id", server.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.True(t, server.Parent().IsRemote())
+	assert.Contains(t, server.Attributes(), attribute.String("http.route", "/users/:id"))
+	assert.Contains(t, server.Attributes(), attribute.Int("http.response.status_code", 200))
+	assert.Equal(t, codes.Unset, server.Status().Code)
+	assert.Contains(t, steps, "otel.loadUser")
+	for _, s := range ended {
+		if s.SpanKind() != trace.SpanKindServer {
+			assert.Equal(t, server.SpanContext().SpanID(), s.Parent().SpanID(), s.Name())
+		}
+	}
+
+	// The logs and outgoing requests refer to the recorded server span.
+	spanID := server.SpanContext().SpanID().String()
+	assert.Equal(t, spanID, logged.Note["span_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", logged.Note["trace_id"])
+	assert.True(t, strings.Contains(outgoing, "-"+spanID+"-"), outgoing)
+}
+
+func TestInstallError(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	var logged sandwich.LogEntry
+	mux := sandwich.TheUsual()
+	mux.SetLogSink(sandwich.LogSinkFunc(func(e sandwich.LogEntry) { logged = e }))
+	Install(mux, Options{TracerProvider: tp})
+	mux.Get("/users/:id", loadUser, func(string) {})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/users/0", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// Without Steps, only the server span is recorded.
+	ended := spans.Ended()
+	require.Len(t, ended, 1)
+	server := ended[0]
+	assert.Equal(t, "GET /users/:id", server.Name())
+	assert.False(t, server.Parent().IsValid())
+	assert.Equal(t, codes.Error, server.Status().Code)
+	assert.Contains(t, server.Attributes(), attribute.Int("http.response.status_code", 503))
+	require.Len(t, server.Events(), 1)
+	assert.Equal(t, "exception", server.Events()[0].Name)
+	// A new trace is started by the server span.
+	assert.Equal(t, server.SpanContext().TraceID().String(), logged.Note["trace_id"])
+}
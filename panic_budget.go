@@ -0,0 +1,93 @@
+package sandwich
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// PanicBudget configures Router.LimitPanics.
+type PanicBudget struct {
+	// Max is the number of panics that a route may have within Window. The
+	// route is disabled when it panics more often. If zero, routes are never
+	// disabled.
+	Max int
+	// Window is the period over which panics are counted. If zero, 1 minute is
+	// used.
+	Window time.Duration
+	// Cooldown is how long a route stays disabled, after which it's enabled
+	// again with a fresh budget. If zero, Window is used.
+	Cooldown time.Duration
+	// OnTrip, if set, is called when a route is disabled with the route, e.g.
+	// "GET /users/:id", and the panic that exceeded the budget. It's intended
+	// for alerting, and is called synchronously as part of the request that
+	// panicked.
+	OnTrip func(route string, p chain.PanicError)
+}
+
+// panicTracker counts the panics of a single route and disables it when it
+// exceeds the budget.
+type panicTracker struct {
+	budget PanicBudget
+	route  string
+
+	mu            sync.Mutex
+	panics        []time.Time // within the window, oldest first
+	disabledUntil time.Time
+}
+
+func newPanicTracker(budget PanicBudget, route string) *panicTracker {
+	if budget.Window <= 0 {
+		budget.Window = time.Minute
+	}
+	if budget.Cooldown <= 0 {
+		budget.Cooldown = budget.Window
+	}
+	return &panicTracker{budget: budget, route: route}
+}
+
+// check is a middleware handler that fails with a 503 while the route is
+// disabled.
+func (t *panicTracker) check() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time_Now().Before(t.disabledUntil) {
+		return Error{
+			Code:      http.StatusServiceUnavailable,
+			ClientMsg: http.StatusText(http.StatusServiceUnavailable),
+			LogMsg: fmt.Sprintf("Route %s disabled until %s after more than %d panics in %v",
+				t.route, t.disabledUntil.Format(time.RFC3339), t.budget.Max, t.budget.Window),
+		}
+	}
+	return nil
+}
+
+// record is a deferred handler that counts the request's panic, if any.
+func (t *panicTracker) record(err error) {
+	var p chain.PanicError
+	if !errors.As(err, &p) {
+		return
+	}
+	now := time_Now()
+	t.mu.Lock()
+	cutoff := now.Add(-t.budget.Window)
+	i := 0
+	for i < len(t.panics) && !t.panics[i].After(cutoff) {
+		i++
+	}
+	t.panics = append(t.panics[i:], now)
+	tripped := len(t.panics) > t.budget.Max
+	if tripped {
+		t.panics = nil
+		t.disabledUntil = now.Add(t.budget.Cooldown)
+	}
+	t.mu.Unlock()
+
+	if tripped && t.budget.OnTrip != nil {
+		t.budget.OnTrip(t.route, p)
+	}
+}
@@ -0,0 +1,60 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitPanics(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	time_Now = func() time.Time { return now }
+	defer func() { time_Now = time.Now }()
+
+	var trips []string
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/before", func() { panic("not limited") })
+	r.LimitPanics(PanicBudget{
+		Max:      2,
+		Window:   time.Minute,
+		Cooldown: time.Hour,
+		OnTrip: func(route string, p chain.PanicError) {
+			trips = append(trips, route+": "+p.Val.(string))
+		},
+	})
+	api := r.SubRouter("/api")
+	api.Get("/crash/:id", func() { panic("boom") })
+	api.Get("/ok", hello)
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	// Panics outside of the window aren't counted.
+	assert.Equal(t, 500, get("/api/crash/1"))
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, 500, get("/api/crash/1"))
+	assert.Equal(t, 500, get("/api/crash/2"))
+	assert.Empty(t, trips)
+
+	// The 3rd panic within the window disables the route.
+	assert.Equal(t, 500, get("/api/crash/3"))
+	assert.Equal(t, []string{"GET /api/crash/:id: boom"}, trips)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/api/crash/4"))
+	assert.Equal(t, 200, get("/api/ok"))
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, 500, get("/before"))
+	}
+
+	// It's enabled again after the cooldown.
+	now = now.Add(time.Hour)
+	assert.Equal(t, 500, get("/api/crash/5"))
+	assert.Len(t, trips, 1)
+}
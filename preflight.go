@@ -0,0 +1,115 @@
+package sandwich
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PreflightConfig configures Router.Preflight.
+type PreflightConfig struct {
+	// AllowOrigins are the origins that may make cross-origin requests, e.g.
+	// "https://app.example.com", or "*" to allow any origin.
+	AllowOrigins []string
+	// AllowHeaders are the request headers that cross-origin requests may
+	// include in addition to the CORS-safelisted headers, e.g. "Authorization".
+	AllowHeaders []string
+	// AllowCredentials allows cross-origin requests to include credentials,
+	// such as cookies. In that case, the origin is always echoed rather than
+	// responding with "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache the preflight response. If zero,
+	// 24 hours is used. Browsers may cap it further.
+	MaxAge time.Duration
+}
+
+const (
+	defaultPreflightMaxAge = 24 * time.Hour
+	// maxPreflightCacheSize limits the number of cached responses when any
+	// origin is allowed. Responses are computed without caching beyond that.
+	maxPreflightCacheSize = 4096
+)
+
+// preflightCache serves CORS preflight requests from precomputed responses,
+// keyed by origin and route.
+type preflightCache struct {
+	cfg       PreflightConfig
+	anyOrigin bool
+	origins   map[string]bool
+	mu        sync.RWMutex
+	responses map[string]http.Header
+}
+
+func newPreflightCache(cfg PreflightConfig) *preflightCache {
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = defaultPreflightMaxAge
+	}
+	p := &preflightCache{cfg: cfg, origins: map[string]bool{}, responses: map[string]http.Header{}}
+	for _, o := range cfg.AllowOrigins {
+		if o == "*" {
+			p.anyOrigin = true
+		}
+		p.origins[o] = true
+	}
+	return p
+}
+
+// serve responds to req if it's a preflight request from an allowed origin for
+// a registered route, and returns whether it did.
+func (p *preflightCache) serve(r *router, w http.ResponseWriter, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+	if req.Method != http.MethodOptions || origin == "" || method == "" {
+		return false
+	}
+	if !p.anyOrigin && !p.origins[origin] {
+		return false
+	}
+	h, ok := r.match(method, req.URL.Path, Params{}).(handler)
+	if !ok {
+		return false
+	}
+
+	allowOrigin := origin
+	if p.anyOrigin && !p.cfg.AllowCredentials {
+		allowOrigin = "*"
+	}
+	key := allowOrigin + "\x00" + method + "\x00" + h.pattern
+	p.mu.RLock()
+	resp := p.responses[key]
+	p.mu.RUnlock()
+	if resp == nil {
+		resp = p.response(allowOrigin, method)
+		p.mu.Lock()
+		if len(p.responses) < maxPreflightCacheSize {
+			p.responses[key] = resp
+		}
+		p.mu.Unlock()
+	}
+
+	for k, v := range resp {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// response computes the headers of a preflight response.
+func (p *preflightCache) response(allowOrigin, method string) http.Header {
+	resp := http.Header{}
+	resp.Set("Access-Control-Allow-Origin", allowOrigin)
+	resp.Set("Access-Control-Allow-Methods", method)
+	if len(p.cfg.AllowHeaders) > 0 {
+		resp.Set("Access-Control-Allow-Headers", strings.Join(p.cfg.AllowHeaders, ", "))
+	}
+	if p.cfg.AllowCredentials {
+		resp.Set("Access-Control-Allow-Credentials", "true")
+	}
+	resp.Set("Access-Control-Max-Age", strconv.Itoa(int(p.cfg.MaxAge/time.Second)))
+	if allowOrigin != "*" {
+		resp.Set(headerVary, "Origin")
+	}
+	return resp
+}
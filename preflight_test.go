@@ -0,0 +1,64 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreflight(t *testing.T) {
+	calls := 0
+	r := TheUsual()
+	r.Use(NoLog, func() { calls++ })
+	r.Preflight(PreflightConfig{
+		AllowOrigins:     []string{"https://app.example.com"},
+		AllowHeaders:     []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           2 * time.Hour,
+	})
+	api := r.SubRouter("/api")
+	api.Put("/items/:id", hello)
+	r.On("OPTIONS", "/legacy", hello)
+
+	preflight := func(origin, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, id := range []string{"1", "2"} {
+		w := preflight("https://app.example.com", "PUT", "/api/items/"+id)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, http.Header{
+			"Access-Control-Allow-Origin":      {"https://app.example.com"},
+			"Access-Control-Allow-Methods":     {"PUT"},
+			"Access-Control-Allow-Headers":     {"Authorization, Content-Type"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Max-Age":           {"7200"},
+			"Vary":                             {"Origin"},
+		}, w.Header())
+	}
+	assert.Equal(t, 0, calls, "middleware should not run for preflights")
+	assert.Len(t, r.(*router).preflight.responses, 1)
+
+	// Other origins and unregistered routes are routed as usual.
+	assert.Equal(t, http.StatusNotFound, preflight("https://evil.example.com", "PUT", "/api/items/1").Code)
+	assert.Equal(t, http.StatusNotFound, preflight("https://app.example.com", "DELETE", "/api/items/1").Code)
+	assert.Equal(t, http.StatusOK, preflight("https://evil.example.com", "GET", "/legacy").Code)
+	assert.Equal(t, 1, calls)
+
+	// Any origin without credentials is answered with "*".
+	r = TheUsual()
+	r.Preflight(PreflightConfig{AllowOrigins: []string{"*"}})
+	r.Get("/feed", hello)
+	w := preflight("https://a.example.com", "GET", "/feed")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "86400", w.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, w.Header().Get("Vary"))
+}
@@ -0,0 +1,30 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// ServeProfile returns a handler that responds with the handler stats collected
+// by p as JSON, slowest first. It's intended for a debug endpoint, along with
+// Router.ProfileSteps:
+//
+//	prof := chain.NewProfiler()
+//	mux.ProfileSteps(prof)
+//	... register routes ...
+//	debug.Get("/steps", sandwich.ServeProfile(prof))
+//
+// If the request has a "reset" query parameter, the stats are reset after they
+// are reported.
+func ServeProfile(p *chain.Profiler) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		stats := p.Stats()
+		if _, reset := r.URL.Query()["reset"]; reset {
+			p.Reset()
+		}
+		w.Header().Set(headerContentType, "application/json")
+		return json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,79 @@
+package sandwich
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileSteps(t *testing.T) {
+	prof := chain.NewProfiler()
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/unprofiled", hello)
+	r.ProfileSteps(prof)
+	r.Get("/hello", hello)
+	r.Get("/debug/steps", ServeProfile(prof))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	serve("/hello")
+	serve("/hello")
+	serve("/unprofiled")
+
+	w := serve("/debug/steps?reset")
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var stats []chain.StepStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	calls := map[string]int64{}
+	for _, s := range stats {
+		calls[s.Name[strings.LastIndex(s.Name, ".")+1:]] = s.Calls
+	}
+	assert.Equal(t, int64(2), calls["hello"])
+	assert.Equal(t, int64(2), calls["CommitTo"])
+	// Includes the debug request itself.
+	assert.Equal(t, int64(3), calls["WrapResponseWriter"])
+
+	// The stats were reset, except for the debug request itself.
+	w = serve("/debug/steps")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	for _, s := range stats {
+		assert.Equal(t, int64(1), s.Calls, s.Name)
+		assert.NotContains(t, s.Name, "hello")
+	}
+}
+
+func TestInstrumentSteps(t *testing.T) {
+	type key struct{}
+	var steps []string
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/uninstrumented", hello)
+	r.InstrumentSteps(nil, func(ctx context.Context, h chain.FuncInfo, d time.Duration, err error) {
+		steps = append(steps, fmt.Sprintf("%s %v %v", h.Name[strings.LastIndex(h.Name, ".")+1:], ctx.Value(key{}), err))
+	})
+	api := r.SubRouter("/api")
+	api.Get("/hello", hello)
+
+	serve := func(path string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), key{}, "traced"))
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve("/uninstrumented")
+	assert.Empty(t, steps)
+	serve("/api/hello")
+	assert.Contains(t, steps, "hello traced <nil>")
+	assert.Contains(t, steps, "CommitTo traced <nil>")
+}
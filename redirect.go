@@ -0,0 +1,135 @@
+package sandwich
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CanonicalConfig configures CanonicalRedirect.
+type CanonicalConfig struct {
+	// Host is the canonical host, such as "www.example.com" or "example.com".
+	// Requests for any other host are redirected to it. If empty, the host is
+	// not changed.
+	Host string
+	// HTTPS redirects plain HTTP requests to HTTPS.
+	HTTPS bool
+	// TrustForwardedProto determines whether the request was made over HTTPS
+	// using the X-Forwarded-Proto header. This should only be enabled when the
+	// server is behind a proxy that terminates TLS and always sets the header,
+	// otherwise clients could spoof it.
+	TrustForwardedProto bool
+	// TrustedProxies, if set, are the IP addresses or CIDR ranges of the
+	// proxies whose X-Forwarded-Proto header is trusted, as in
+	// Config.TrustedProxies. The header of requests received from other
+	// addresses is ignored. It implies TrustForwardedProto.
+	TrustedProxies []string
+	// HSTSMaxAge, if non-zero, adds a Strict-Transport-Security header with the
+	// specified max-age to responses to HTTPS requests.
+	HSTSMaxAge time.Duration
+	// HSTSIncludeSubdomains adds the includeSubDomains directive to the
+	// Strict-Transport-Security header.
+	HSTSIncludeSubdomains bool
+	// HSTSPreload adds the preload directive to the Strict-Transport-Security
+	// header.
+	HSTSPreload bool
+}
+
+// CanonicalRedirect returns a middleware handler that permanently redirects
+// requests to the canonical host and scheme. GET and HEAD requests are
+// redirected with a 301, other methods with a 308 so that clients repeat the
+// request with the same method and body. After redirecting, the middleware
+// chain is aborted by returning Done. For example:
+//
+//	mux := sandwich.TheUsual()
+//	mux.Use(sandwich.CanonicalRedirect(sandwich.CanonicalConfig{
+//	  Host:       "www.example.com",
+//	  HTTPS:      true,
+//	  HSTSMaxAge: 365 * 24 * time.Hour,
+//	}))
+//
+// It panics if one of the TrustedProxies is invalid.
+func CanonicalRedirect(cfg CanonicalConfig) func(w http.ResponseWriter, r *http.Request) error {
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	hsts := fmt.Sprintf("max-age=%d", int64(cfg.HSTSMaxAge/time.Second))
+	if cfg.HSTSIncludeSubdomains {
+		hsts += "; includeSubDomains"
+	}
+	if cfg.HSTSPreload {
+		hsts += "; preload"
+	}
+	return func(w http.ResponseWriter, r *http.Request) error {
+		// The forwarded protocol may only upgrade the request to secure, so
+		// that requests received over TLS directly are always secure.
+		secure := r.TLS != nil
+		forwardedHTTPS := strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+		if proxies != nil {
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && proxies.trusts(host) {
+				secure = secure || forwardedHTTPS
+			}
+		} else if cfg.TrustForwardedProto {
+			secure = secure || forwardedHTTPS
+		}
+		scheme, host := "http", r.Host
+		if secure || cfg.HTTPS {
+			scheme = "https"
+		}
+		if cfg.Host != "" && !strings.EqualFold(host, cfg.Host) {
+			host = cfg.Host
+		}
+		if host != r.Host || scheme == "https" && !secure {
+			code := http.StatusPermanentRedirect
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				code = http.StatusMovedPermanently
+			}
+			http.Redirect(w, r, scheme+"://"+host+r.URL.RequestURI(), code)
+			return Done
+		}
+		if secure && cfg.HSTSMaxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", hsts)
+		}
+		return nil
+	}
+}
+
+// HTTPSOptions configures RequireHTTPS.
+type HTTPSOptions struct {
+	// TrustedProxies are the IP addresses or CIDR ranges, e.g. "10.0.0.0/8",
+	// of the load balancers or proxies that terminate TLS. Requests received
+	// from them are considered secure if their X-Forwarded-Proto header is
+	// "https". Other requests are only secure if they were received over TLS.
+	TrustedProxies []string
+	// HSTSMaxAge, if non-zero, adds a Strict-Transport-Security header with the
+	// specified max-age to responses to HTTPS requests.
+	HSTSMaxAge time.Duration
+	// HSTSIncludeSubdomains and HSTSPreload add the includeSubDomains and
+	// preload directives to the Strict-Transport-Security header.
+	HSTSIncludeSubdomains, HSTSPreload bool
+}
+
+// RequireHTTPS returns a middleware handler that permanently redirects plain
+// HTTP requests to HTTPS, as CanonicalRedirect does, e.g. for a server behind
+// a load balancer:
+//
+//	mux.Use(sandwich.RequireHTTPS(sandwich.HTTPSOptions{
+//	  TrustedProxies: []string{"10.0.0.0/8"},
+//	  HSTSMaxAge:     365 * 24 * time.Hour,
+//	}))
+//
+// Routes that must remain reachable over plain HTTP, such as the load
+// balancer's health checks, should be registered before it. It panics if one
+// of the TrustedProxies is invalid.
+func RequireHTTPS(opts HTTPSOptions) func(w http.ResponseWriter, r *http.Request) error {
+	return CanonicalRedirect(CanonicalConfig{
+		HTTPS:                 true,
+		TrustedProxies:        opts.TrustedProxies,
+		HSTSMaxAge:            opts.HSTSMaxAge,
+		HSTSIncludeSubdomains: opts.HSTSIncludeSubdomains,
+		HSTSPreload:           opts.HSTSPreload,
+	})
+}
@@ -0,0 +1,116 @@
+package sandwich
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalRedirect(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog, CanonicalRedirect(CanonicalConfig{
+		Host:                  "www.example.com",
+		HTTPS:                 true,
+		HSTSMaxAge:            time.Hour,
+		HSTSIncludeSubdomains: true,
+	}))
+	r.Any("/:path*", func(w http.ResponseWriter) { _, _ = w.Write([]byte("ok")) })
+
+	testCases := []struct {
+		method, url string
+		tls         bool
+		code        int
+		location    string
+	}{
+		{"GET", "http://www.example.com/a?b=c", false, 301, "https://www.example.com/a?b=c"},
+		{"GET", "https://example.com/a", true, 301, "https://www.example.com/a"},
+		{"POST", "http://example.com/a", false, 308, "https://www.example.com/a"},
+		{"GET", "https://WWW.example.com/a", true, 200, ""},
+	}
+	for _, test := range testCases {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		if !test.tls {
+			req.TLS = nil
+		} else if req.TLS == nil {
+			req.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, "%s %s", test.method, test.url)
+		assert.Equal(t, test.location, w.Header().Get("Location"), "%s %s", test.method, test.url)
+		if test.code == 200 {
+			assert.Equal(t, "max-age=3600; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+		} else {
+			assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+		}
+	}
+}
+
+func TestCanonicalRedirectBehindProxy(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog, CanonicalRedirect(CanonicalConfig{HTTPS: true, TrustForwardedProto: true}))
+	r.Get("/", func(w http.ResponseWriter) { _, _ = w.Write([]byte("ok")) })
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 301, w.Code)
+	assert.Equal(t, "https://example.com/", w.Header().Get("Location"))
+
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	// Requests received over TLS directly are secure without the header.
+	req = httptest.NewRequest("GET", "https://example.com/", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog, RequireHTTPS(HTTPSOptions{TrustedProxies: []string{"10.0.0.0/8"}, HSTSMaxAge: time.Hour}))
+	r.Get("/", func(w http.ResponseWriter) { _, _ = w.Write([]byte("ok")) })
+
+	serve := func(remoteAddr, proto string, secure bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/?a=b", nil)
+		req.RemoteAddr = remoteAddr
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("10.1.2.3:1234", "http", false)
+	assert.Equal(t, 301, w.Code)
+	assert.Equal(t, "https://example.com/?a=b", w.Header().Get("Location"))
+
+	w = serve("10.1.2.3:1234", "https", false)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "max-age=3600", w.Header().Get("Strict-Transport-Security"))
+
+	// The header of untrusted clients is ignored.
+	w = serve("203.0.113.7:1234", "https", false)
+	assert.Equal(t, 301, w.Code)
+
+	w = serve("203.0.113.7:1234", "", true)
+	assert.Equal(t, 200, w.Code)
+
+	// TLS connections from trusted proxies are secure without the header.
+	w = serve("10.1.2.3:1234", "", true)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "max-age=3600", w.Header().Get("Strict-Transport-Security"))
+
+	assert.Panics(t, func() { RequireHTTPS(HTTPSOptions{TrustedProxies: []string{"nope"}}) })
+}
@@ -0,0 +1,126 @@
+package sandwich
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestID identifies a request in the logs of this and other services. It's
+// provided by AssignRequestID.
+type RequestID string
+
+const (
+	headerRequestID = "X-Request-ID"
+	// maxRequestIDLen limits the size of request IDs accepted from clients.
+	maxRequestIDLen = 128
+)
+
+// AssignRequestID is a middleware handler that provides the RequestID of the
+// request to subsequent handlers. The ID is taken from the X-Request-ID request
+// header, e.g. as set by a load balancer or an upstream service, or a random ID
+// is generated if the header is missing or isn't a printable ASCII string of at
+// most 128 characters. The ID is also set as the X-Request-ID response header
+// and recorded as the "request_id" note of the LogEntry so that all of the log
+// lines of a request can be correlated. For example:
+//
+//	mux := sandwich.TheUsual()
+//	mux.Use(sandwich.AssignRequestID)
+//	mux.Get("/orders/:id", func(id sandwich.RequestID, ...) {
+//	    log.Printf("[%s] Looking up order", id)
+//	    ...
+//	})
+//
+// The ID is also added to the context of the request, so that it's propagated
+// to outgoing requests made with CorrelatedClient. See RequestIDFromContext.
+// Config.RequestID adds it to routers created by NewWithConfig.
+func AssignRequestID(w http.ResponseWriter, r *http.Request, e *LogEntry) (RequestID, *http.Request) {
+	id := RequestID(r.Header.Get(headerRequestID))
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(headerRequestID, string(id))
+	e.Note["request_id"] = string(id)
+	return id, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the RequestID assigned by AssignRequestID to the
+// request with the context ctx, or a derived context.
+func RequestIDFromContext(ctx context.Context) (RequestID, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(RequestID)
+	return id, ok
+}
+
+func validRequestID(id RequestID) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() RequestID {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return RequestID(hex.EncodeToString(buf[:]))
+}
+
+// CorrelatedClient returns a copy of client, or of http.DefaultClient if nil,
+// that adds the correlation headers of the current request to its outgoing
+// requests: the X-Request-ID header, if the request was assigned a RequestID
+// by AssignRequestID, and the trace headers, if its TraceContext was provided
+// by PropagateTrace. The current request is identified by the context of the
+// outgoing request, so the client may be shared by all requests, e.g.:
+//
+//	mux.Use(sandwich.AssignRequestID)
+//	mux.Set(sandwich.CorrelatedClient(nil))
+//	mux.Get("/orders/:id", func(r *http.Request, client *http.Client) error {
+//	    req, _ := http.NewRequestWithContext(r.Context(), "GET", inventoryURL, nil)
+//	    resp, err := client.Do(req) // sends the X-Request-ID header
+//	    ...
+//	})
+//
+// Headers that are already set on the outgoing request aren't replaced.
+func CorrelatedClient(client *http.Client) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	correlated := *client
+	correlated.Transport = correlationTransport{client.Transport}
+	return &correlated
+}
+
+// correlationTransport adds the correlation headers of the request in the
+// context of outgoing requests.
+type correlationTransport struct{ base http.RoundTripper }
+
+func (t correlationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	id, hasID := RequestIDFromContext(req.Context())
+	hasID = hasID && req.Header.Get(headerRequestID) == ""
+	tc, hasTrace := TraceFromContext(req.Context())
+	hasTrace = hasTrace && req.Header.Get(headerTraceparent) == ""
+	if !hasID && !hasTrace {
+		return base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context()) // a RoundTripper must not modify the request
+	if hasID {
+		req.Header.Set(headerRequestID, string(id))
+	}
+	if hasTrace {
+		tc.Inject(req.Header)
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,108 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignRequestID(t *testing.T) {
+	var logged []LogEntry
+	r, err := NewWithConfig(Config{Logger: func(e LogEntry) { logged = append(logged, e) }, RequestID: true})
+	require.NoError(t, err)
+	var got RequestID
+	r.Get("/", func(id RequestID) { got = id })
+
+	serve := func(header string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set("X-Request-ID", header)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("abc-123")
+	assert.Equal(t, RequestID("abc-123"), got)
+	assert.Equal(t, "abc-123", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "abc-123", logged[0].Note["request_id"])
+
+	for _, invalid := range []string{"", "has space", strings.Repeat("x", 129), "bad\x01"} {
+		w = serve(invalid)
+		assert.Len(t, string(got), 32, "%q", invalid)
+		assert.NotEqual(t, RequestID(invalid), got)
+		assert.Equal(t, string(got), w.Header().Get("X-Request-ID"))
+		assert.Equal(t, string(got), logged[len(logged)-1].Note["request_id"])
+	}
+
+	// Generated IDs are unique.
+	first := got
+	serve("")
+	assert.NotEqual(t, first, got)
+
+	// It's opt-in.
+	plain := TheUsual()
+	plain.Use(NoLog)
+	plain.Get("/", hello)
+	w = httptest.NewRecorder()
+	plain.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, w.Header().Get("X-Request-ID"))
+}
+
+func TestCorrelatedClient(t *testing.T) {
+	var downstream http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = r.Header
+	}))
+	defer backend.Close()
+
+	call := func(r *http.Request, client *http.Client) error {
+		req, err := http.NewRequestWithContext(r.Context(), "GET", backend.URL, nil)
+		if err != nil {
+			return err
+		}
+		if r.URL.Query().Get("override") != "" {
+			req.Header.Set("X-Request-ID", "explicit")
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	var trace TraceContext
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Set(CorrelatedClient(nil))
+	r.Get("/plain", call)
+	r.Get("/id", AssignRequestID, call)
+	r.Get("/traced", AssignRequestID, PropagateTrace(nil), func(tc TraceContext) { trace = tc }, call)
+
+	serve := func(path string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Request-ID", "abc-123")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, 200, w.Code, w.Body.String())
+	}
+
+	serve("/plain")
+	assert.Empty(t, downstream.Get("X-Request-ID"))
+	assert.Empty(t, downstream.Get("traceparent"))
+
+	serve("/id")
+	assert.Equal(t, "abc-123", downstream.Get("X-Request-ID"))
+	assert.Empty(t, downstream.Get("traceparent"))
+
+	serve("/id?override=1")
+	assert.Equal(t, "explicit", downstream.Get("X-Request-ID"))
+
+	serve("/traced")
+	assert.Equal(t, "abc-123", downstream.Get("X-Request-ID"))
+	assert.Equal(t, trace.Traceparent(), downstream.Get("traceparent"))
+}
@@ -0,0 +1,58 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRequestTime(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	time_Now = func() time.Time { return now }
+	defer func() { time_Now = time.Now }()
+
+	var got RequestTime
+	r := TheUsual()
+	r.Use(NoLog, ValidateRequestTime(RequestTimeConfig{MaxSkew: time.Minute}))
+	r.Get("/", func(t RequestTime) { got = t })
+
+	testCases := []struct {
+		header, val string
+		code        int
+		expected    time.Time
+	}{
+		{"X-Timestamp", "1577934245", 200, now},
+		{"X-Timestamp", "2020-01-02T03:04:35Z", 200, now.Add(30 * time.Second)},
+		{"Date", "Thu, 02 Jan 2020 03:05:00 GMT", 200, now.Add(55 * time.Second)},
+		{"X-Timestamp", "1577934000", 401, time.Time{}},
+		{"Date", "Thu, 02 Jan 2020 03:06:00 GMT", 401, time.Time{}},
+		{"X-Timestamp", "yesterday", 400, time.Time{}},
+		{"X-Other", "1577934245", 400, time.Time{}},
+	}
+	for _, test := range testCases {
+		got = RequestTime{}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(test.header, test.val)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, "%s: %s", test.header, test.val)
+		assert.True(t, test.expected.Equal(time.Time(got)), "%s: %s -> %v", test.header, test.val, time.Time(got))
+	}
+}
+
+func TestValidateRequestTimeHeaderOrder(t *testing.T) {
+	now := time.Unix(1000, 0)
+	time_Now = func() time.Time { return now }
+	defer func() { time_Now = time.Now }()
+
+	validate := ValidateRequestTime(RequestTimeConfig{Headers: []string{"X-Signed-At"}})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Date", http.TimeFormat) // ignored
+	req.Header.Set("X-Signed-At", "1010")
+	got, err := validate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1010), time.Time(got).Unix())
+}
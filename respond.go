@@ -0,0 +1,69 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// Respond writes common kinds of responses with the appropriate headers. It's
+// provided by TheUsual, so handlers can accept it instead of encoding responses
+// by hand:
+//
+//	func GetUser(respond sandwich.Respond, u *User) error {
+//	    return respond.JSON(http.StatusOK, u)
+//	}
+//
+// Respond writes to the http.ResponseWriter that's current when the first
+// handler that needs it runs, so it respects middleware that replaces the
+// writer, such as Gzip, ETags, or Enveloped, if they run before that.
+type Respond struct{ w http.ResponseWriter }
+
+// ProvideRespond is a middleware that provides Respond to subsequent handlers,
+// as TheUsual does. It's only needed for routers created with BuildYourOwn.
+var ProvideRespond ChainMutation = provideRespond{}
+
+type provideRespond struct{}
+
+func (provideRespond) Apply(c chain.Func) chain.Func {
+	return c.Lazily(func(w http.ResponseWriter) Respond { return Respond{w} })
+}
+
+// JSON sends v encoded as JSON with the status code. If v can't be encoded,
+// nothing is written and the error is returned, so that it's handled by the
+// error handlers.
+func (r Respond) JSON(code int, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Internal(err)
+	}
+	r.w.Header().Set(headerContentType, "application/json; charset=utf-8")
+	r.w.WriteHeader(code)
+	_, err = r.w.Write(append(data, '\n'))
+	return err
+}
+
+// Text sends s as plain text with the status code.
+func (r Respond) Text(code int, s string) error {
+	r.w.Header().Set(headerContentType, "text/plain; charset=utf-8")
+	r.w.WriteHeader(code)
+	_, err := io.WriteString(r.w, s)
+	return err
+}
+
+// NoContent sends an empty 204 No Content response.
+func (r Respond) NoContent() error {
+	r.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Stream sends the contents of body with the status code, without buffering
+// it. The Content-Type should be set beforehand, otherwise it's detected from
+// the start of the body. Stream doesn't close body.
+func (r Respond) Stream(code int, body io.Reader) error {
+	r.w.WriteHeader(code)
+	_, err := io.Copy(r.w, body)
+	return err
+}
@@ -0,0 +1,82 @@
+package sandwich
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespond(t *testing.T) {
+	mux := TheUsual()
+	mux.Use(NoLog)
+	mux.Get("/json", func(respond Respond) error {
+		return respond.JSON(http.StatusCreated, map[string]int{"id": 7})
+	})
+	mux.Get("/bad-json", func(respond Respond) error {
+		return respond.JSON(http.StatusOK, func() {})
+	})
+	mux.Get("/text", func(respond Respond) error {
+		return respond.Text(http.StatusAccepted, "queued")
+	})
+	mux.Get("/none", func(respond Respond) error {
+		return respond.NoContent()
+	})
+	mux.Get("/stream", func(w http.ResponseWriter, respond Respond) error {
+		w.Header().Set("Content-Type", "text/csv")
+		return respond.Stream(http.StatusOK, strings.NewReader("a,b\n1,2\n"))
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/json")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"id\":7}\n", w.Body.String())
+
+	w = serve("/bad-json")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Header().Get("Content-Type"), "json")
+
+	w = serve("/text")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "queued", w.Body.String())
+
+	w = serve("/none")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = serve("/stream")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "a,b\n1,2\n", w.Body.String())
+}
+
+func TestRespondUsesCurrentWriter(t *testing.T) {
+	mux := BuildYourOwn()
+	mux.Use(ProvideRespond, GzipWith(GzipOptions{MinSize: 1}))
+	mux.Get("/", func(respond Respond) error {
+		return respond.Text(http.StatusOK, "compressed")
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "compressed", string(body))
+}
@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 )
 
 // WrapResponseWriter creates a ResponseWriter and returns it as both an
 // http.ResponseWriter and a *ResponseWriter.  The double return is redundant
 // for native Go code, but is a necessary hint to the dependency injection.
 func WrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *ResponseWriter) {
-	rw := &ResponseWriter{w, 0, 0}
+	rw := &ResponseWriter{ResponseWriter: w}
 	return rw, rw
 }
 
@@ -21,6 +22,25 @@ type ResponseWriter struct {
 	http.ResponseWriter
 	Size int // The size of the response written so far, in bytes.
 	Code int // The status code of the response, or 0 if not written yet.
+
+	body *capturedBody // the start of the response body, see CaptureBody
+}
+
+// CaptureBody retains up to maxBytes of the response body that is written from
+// now on, so that it's available from CapturedBody. The LogEntry records the
+// captured body of 5xx responses, see CaptureResponseBody.
+func (w *ResponseWriter) CaptureBody(maxBytes int) {
+	w.body = &capturedBody{limit: maxBytes}
+}
+
+// CapturedBody returns the response body that was retained since CaptureBody
+// was called, and whether it was truncated because more than the maximum
+// bytes were written. It returns nil if CaptureBody wasn't called.
+func (w *ResponseWriter) CapturedBody() (body []byte, truncated bool) {
+	if w.body == nil {
+		return nil, false
+	}
+	return w.body.buf.Bytes(), w.body.truncated
 }
 
 func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
@@ -38,9 +58,17 @@ func (w *ResponseWriter) Flush() {
 	}
 }
 
+// Unwrap returns the underlying http.ResponseWriter. This allows
+// http.ResponseController to access optional features of the original
+// ResponseWriter.
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func (w *ResponseWriter) WriteHeader(code int) {
 	if w.Code == 0 {
 		w.Code = code
+		AddVary(w.Header())
 	}
 	w.ResponseWriter.WriteHeader(code)
 }
@@ -48,8 +76,62 @@ func (w *ResponseWriter) WriteHeader(code int) {
 func (w *ResponseWriter) Write(p []byte) (int, error) {
 	if w.Code == 0 {
 		w.Code = 200
+		AddVary(w.Header())
 	}
 	n, err := w.ResponseWriter.Write(p)
 	w.Size += n
+	if w.body != nil {
+		w.body.Write(p[:n])
+	}
 	return n, err
 }
+
+// headResponseWriter is used for HEAD requests: body bytes are counted but not
+// sent. The status code is held until finish so that the Content-Length can be
+// set to the size that the body would have been, matching the GET response.
+type headResponseWriter struct {
+	http.ResponseWriter
+	code int   // the pending status code, or 0 if not written yet
+	size int64 // the size of the discarded body
+	sent bool  // whether the header has been sent
+}
+
+func (w *headResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *headResponseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+// Flush sends the header immediately. The Content-Length can't be set
+// afterwards.
+func (w *headResponseWriter) Flush() {
+	w.finish()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends the header, if it hasn't been sent yet.
+func (w *headResponseWriter) finish() {
+	if w.sent || w.code == 0 {
+		return
+	}
+	w.sent = true
+	h := w.Header()
+	if w.size > 0 && h.Get(headerContentLength) == "" && h.Get("Transfer-Encoding") == "" {
+		h.Set(headerContentLength, strconv.FormatInt(w.size, 10))
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
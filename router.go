@@ -1,10 +1,14 @@
 package sandwich
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/augustoroman/sandwich/chain"
 )
@@ -35,8 +39,17 @@ type Router interface {
 	// adding the specified middelwareHandlers to each registered route.
 	Use(middlewareHandlers ...any)
 
-	// On will register a handler for the given method and path.
+	// On will register a handler for the given method and path. Any method may
+	// be used, including extension methods such as WebDAV's PROPFIND and MKCOL.
+	// Methods are case-insensitive and must be valid HTTP tokens.
 	On(method, path string, handlers ...any)
+	// TryOn is like On, but returns an error instead of panicking if the route
+	// can't be registered, e.g. because the path conflicts with another route
+	// or a handler requires a type that hasn't been provided. In the latter
+	// case, the error is a *chain.BuildError that identifies the missing type
+	// and the handler's location. This allows frameworks that embed sandwich to
+	// report configuration errors rather than crash.
+	TryOn(method, path string, handlers ...any) error
 
 	// Get registers handlers for the specified path for the 'GET' HTTP method.
 	// Get is shorthand for `On("GET", ...)`.
@@ -59,12 +72,135 @@ type Router interface {
 	// will be handled by the Get(...) and Put(...) registrations, but DELETE,
 	// CONNECT, or HEAD would be handled by the Any(...) registration. Any is a
 	// shortcut for `On("*", ...)`.
+	//
+	// HEAD requests that don't match any HEAD or Any registration are handled
+	// by the matching GET registration, if any. For all HEAD requests, the
+	// response body written by the handlers is counted but discarded, and the
+	// Content-Length is set to the size that the body would have been.
 	Any(path string, handlers ...any)
 
 	// OnErr uses the specified error handler to handle any errors that occur on
 	// any routes in this router.
 	OnErr(handler any)
 
+	// OnErrMap registers an error mapper that transforms errors on any routes in
+	// this router before they're passed to the error handler, e.g. to map
+	// sql.ErrNoRows to a 404 Error. The mapper is a func that accepts the error
+	// and any provided types and returns an error. See chain.Func.OnErrMap for
+	// details.
+	OnErrMap(mapper any)
+
+	// OnPanic uses the specified handler when any route in this router panics.
+	// It may accept the chain.PanicError and any provided types, e.g. to emit a
+	// crash report with request details, and may return an error to replace the
+	// panic error that's passed to the error handler. Passing nil disables
+	// recovery so that panics propagate to the http.Server or other
+	// crash-handling infrastructure. See chain.Func.OnPanic for details.
+	OnPanic(handler any)
+
+	// Extend adds all of the middleware (values, handlers, and error handlers)
+	// of the other router to this router. This allows building middleware
+	// bundles independently and combining them. Routes and sub-routers
+	// registered on the other router are not added. The other router must have
+	// been created by this package.
+	Extend(other Router)
+
+	// SuggestRoutes enables or disables route suggestions for requests that
+	// don't match any route. When enabled, near-miss routes (a different
+	// method, a trailing slash, a case difference, or a small typo) are included
+	// in the 404 response and the request log. The 404 is handled by the error
+	// handler of the most specific sub-router. This is intended for development
+	// since it reveals the registered routes to clients.
+	SuggestRoutes(enabled bool)
+
+	// MethodNotAllowed enables or disables responding with a 405 (Method Not
+	// Allowed) instead of a 404 when a request doesn't match any route but the
+	// path is registered for other methods. The response includes an Allow
+	// header listing those methods. The 405 is handled by the error handler of
+	// the most specific sub-router. Sub-routers created afterwards inherit the
+	// setting.
+	MethodNotAllowed(enabled bool)
+
+	// Preflight enables responding to CORS preflight requests for registered
+	// routes from a precomputed cache, keyed by origin and route, without
+	// running the routes' middleware. This is intended for high-volume browser
+	// APIs, together with a long cfg.MaxAge so that browsers cache the
+	// responses too. Preflight requests from origins that aren't allowed, or
+	// for unregistered routes, are routed as usual. Allowed preflight requests
+	// are answered before routing, even if an OPTIONS route is registered. This
+	// only has an effect on the root router.
+	Preflight(cfg PreflightConfig)
+
+	// GreedyMatching sets the policy used to choose between routes on this
+	// router when a request matches several routes that follow a greedy param.
+	// Sub-routers created afterwards inherit the policy. The default is
+	// LongestMatch.
+	GreedyMatching(policy GreedyMatchPolicy)
+
+	// Audit reports the registered routes that don't use the middleware
+	// described by cfg, such as authentication or body size limits. See
+	// AuditConfig for details.
+	Audit(cfg AuditConfig) AuditReport
+
+	// GenerateTests writes skeleton tests for each registered route to w. See
+	// TestGenConfig for details.
+	GenerateTests(w io.Writer, cfg TestGenConfig) error
+
+	// Shutdown closes the values provided by Set and SetAs that implement
+	// Shutdowner or io.Closer, in reverse order of registration.
+	Shutdown(ctx context.Context) error
+
+	// LimitPanics enables crash-loop protection for routes that are
+	// subsequently registered on this router or sub-routers created afterwards:
+	// each route that panics more than budget.Max times within budget.Window
+	// is disabled and responds with a 503 until the cooldown has passed. This
+	// contains the damage of a bad deploy to the affected routes. Only panics
+	// that reach the error handlers are counted, so panics that are handled by
+	// an OnPanic handler are not. See PanicBudget for details.
+	LimitPanics(budget PanicBudget)
+
+	// SetLogSink sets the destination of the log entries committed by
+	// LogRequests for routes that are subsequently registered on this router or
+	// sub-routers created afterwards. By default, entries are written by
+	// WriteLog. This allows routers in the same process to log to different
+	// destinations.
+	SetLogSink(sink LogSink)
+
+	// ProfileSteps enables measuring the time and allocations of each handler of
+	// routes that are subsequently registered on this router or sub-routers
+	// created afterwards. Use ServeProfile to expose the results. Passing nil
+	// disables profiling for subsequent routes.
+	ProfileSteps(p *chain.Profiler)
+
+	// CollectStats enables aggregating the latency, response size, and status
+	// codes of routes that are subsequently registered on this router or
+	// sub-routers created afterwards in c. Use ServeStats to expose the
+	// results. This requires LogRequests, as TheUsual does. Passing nil
+	// disables collection for subsequent routes.
+	CollectStats(c *StatsCollector)
+
+	// ReportMetrics adds m to the RouteMetrics that receive the completed log
+	// entry of each request of routes that are subsequently registered on this
+	// router or sub-routers created afterwards, e.g. to emit metrics with
+	// StatsD. This requires LogRequests, as TheUsual does. Passing nil removes
+	// all RouteMetrics for subsequent routes.
+	ReportMetrics(m RouteMetrics)
+
+	// InstrumentSteps enables calling onStart and onEnd around each handler
+	// call of routes that are subsequently registered on this router or
+	// sub-routers created afterwards, e.g. to record a trace span for each
+	// middleware handler. The hooks receive the request's context. Passing nil
+	// for both disables instrumentation for subsequent routes. See
+	// chain.Func.Instrument.
+	InstrumentSteps(onStart chain.StepStartFunc, onEnd chain.StepEndFunc)
+
+	// SetErrorMode sets the ErrorMode for handlers that are subsequently
+	// registered on this router or sub-routers created afterwards. In
+	// DevErrors mode, the standard error handlers include the ErrorDetails,
+	// such as the stack of panics, in their responses. The default is
+	// ProdErrors, which only sends the sanitized client messages.
+	SetErrorMode(mode ErrorMode)
+
 	// SubRouter derives a router that will called for all suffixes (and methods)
 	// for the specified path. For example, `sub := root.SubRouter("/api")` will
 	// create a router that will handle `/api/`, `/api/foo`.
@@ -72,6 +208,20 @@ type Router interface {
 
 	// ServeHTTP implements the http.Handler interface for the router.
 	ServeHTTP(w http.ResponseWriter, r *http.Request)
+	// ServeHTTPWith is like ServeHTTP, but substitutes the specified values for
+	// this request only. This is especially useful in tests, or to direct
+	// canary requests to a staging backend. See chain.Func.RunWith for details.
+	//
+	// Example:
+	//    mux.ServeHTTPWith(map[any]any{(*UserDB)(nil): fakeDB}, w, r)
+	ServeHTTPWith(overrides map[any]any, w http.ResponseWriter, r *http.Request)
+	// ServeError responds to the request with err as if it had been returned by
+	// a handler of the most specific sub-router for the request path: the
+	// middleware is run, then the error handlers and deferred handlers. The
+	// overrides are applied as with ServeHTTPWith and may be nil. This allows
+	// testing error handlers without constructing failing routes, see the
+	// sandwichtest package.
+	ServeError(overrides map[any]any, w http.ResponseWriter, r *http.Request, err error)
 }
 
 // BuildYourOwn returns a minimal router that has no initial middleware
@@ -81,37 +231,119 @@ func BuildYourOwn() Router {
 	r.base = r.base.Arg((*http.ResponseWriter)(nil))
 	r.base = r.base.Arg((*http.Request)(nil))
 	r.base = r.base.Arg((Params)(nil))
+	// The default LogSink and ErrorMode are args rather than values so that
+	// they're not re-added by Extend, replacing those set by SetLogSink and
+	// SetErrorMode. Their values are passed by handler.serveWith and ServeError.
+	r.base = r.base.Arg((*LogSink)(nil))
+	r.base = r.base.Arg(ProdErrors)
 	return r
 }
 
-// TheUsual returns a router initialized with useful middleware.
+// TheUsual returns a router initialized with useful middleware: it wraps the
+// response writer, logs requests, provides Respond, and handles errors with
+// HandleError. It trusts the X-Real-IP and X-Forwarded-For headers of all
+// requests to determine the client's address. Use NewWithConfig to adjust any
+// of these.
 func TheUsual() Router {
-	r := BuildYourOwn()
-	r.Use(WrapResponseWriter, LogRequests)
-	r.OnErr(HandleError)
+	r, err := NewWithConfig(Config{TrustForwardedHeaders: true})
+	if err != nil {
+		panic(err)
+	}
 	return r
 }
 
 type router struct {
-	base       chain.Func
-	subRouters map[string]*router
-	byMethod   map[string]*mux
-	anyMethod  *mux
-	notFound   http.Handler
+	base        chain.Func
+	errHandler  atomic.Pointer[handler] // see errorHandler
+	prefix      string                  // full path prefix of this router, e.g. "/api/users"
+	subRouters  map[string]*router
+	byMethod    map[string]*mux
+	anyMethod   *mux
+	notFound    http.Handler
+	suggest     bool
+	allow405    bool
+	profiler    *chain.Profiler
+	onStepStart chain.StepStartFunc
+	onStepEnd   chain.StepEndFunc
+	panics      PanicBudget
+	stats       *StatsCollector
+	metrics     []RouteMetrics
+	preflight   *preflightCache
+	greedy      GreedyMatchPolicy
 }
 
 func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.ServeHTTPWith(nil, w, req)
+}
+
+func (r *router) ServeHTTPWith(overrides map[any]any, w http.ResponseWriter, req *http.Request) {
+	if r.preflight != nil && r.preflight.serve(r, w, req) {
+		return
+	}
 	params := Params{}
 	h := r.match(req.Method, req.URL.Path, params)
-	if h != nil {
+	if rh, ok := h.(handler); ok && req.Method == http.MethodHead {
+		hw := &headResponseWriter{ResponseWriter: w}
+		rh.serveWith(overrides, hw, req, params)
+		hw.finish()
+	} else if ok {
+		rh.serveWith(overrides, w, req, params)
+	} else if h != nil {
 		h.ServeHTTP(w, req, params)
+	} else if r.allow405 && r.serveMethodNotAllowed(w, req) {
+		// responded with a 405
 	} else if r.notFound != nil {
 		r.notFound.ServeHTTP(w, req)
+	} else if r.suggest {
+		r.serveNotFoundWithSuggestions(w, req)
 	} else {
 		http.Error(w, "Not found", http.StatusNotFound)
 	}
 }
 
+func (r *router) SuggestRoutes(enabled bool) { r.suggest = enabled }
+
+func (r *router) MethodNotAllowed(enabled bool) { r.allow405 = enabled }
+
+func (r *router) Preflight(cfg PreflightConfig) { r.preflight = newPreflightCache(cfg) }
+
+func (r *router) SetLogSink(sink LogSink) {
+	if sink == nil {
+		sink = defaultLogSink
+	}
+	r.setBase(r.base.SetAs(sink, (*LogSink)(nil)))
+}
+
+func (r *router) ProfileSteps(p *chain.Profiler) { r.profiler = p }
+
+func (r *router) CollectStats(c *StatsCollector) { r.stats = c }
+
+func (r *router) SetErrorMode(mode ErrorMode) { r.setBase(r.base.Then(withErrorMode(mode))) }
+
+func (r *router) ReportMetrics(m RouteMetrics) {
+	if m == nil {
+		r.metrics = nil
+		return
+	}
+	r.metrics = append(r.metrics[:len(r.metrics):len(r.metrics)], m)
+}
+
+func (r *router) InstrumentSteps(onStart chain.StepStartFunc, onEnd chain.StepEndFunc) {
+	r.onStepStart, r.onStepEnd = onStart, onEnd
+}
+
+func (r *router) LimitPanics(budget PanicBudget) { r.panics = budget }
+
+func (r *router) GreedyMatching(policy GreedyMatchPolicy) {
+	r.greedy = policy
+	for _, m := range r.byMethod {
+		m.policy = policy
+	}
+	if r.anyMethod != nil {
+		r.anyMethod.policy = policy
+	}
+}
+
 func (r *router) SubRouter(prefix string) Router {
 	if r.subRouters == nil {
 		r.subRouters = map[string]*router{}
@@ -126,12 +358,76 @@ func (r *router) SubRouter(prefix string) Router {
 		}
 	}
 	r.subRouters[prefix] = &router{
-		base:     r.base,
-		notFound: r.notFound,
+		base:        r.base,
+		prefix:      r.prefix + strings.TrimSuffix(prefix, "/"),
+		notFound:    r.notFound,
+		suggest:     r.suggest,
+		allow405:    r.allow405,
+		profiler:    r.profiler,
+		onStepStart: r.onStepStart,
+		onStepEnd:   r.onStepEnd,
+		panics:      r.panics,
+		stats:       r.stats,
+		metrics:     r.metrics,
+		greedy:      r.greedy,
 	}
 	return r.subRouters[prefix]
 }
 
+// routerFor returns the most specific sub-router that handles uri.
+func (r *router) routerFor(uri string) *router {
+	for prefix, sub := range r.subRouters {
+		if strings.HasPrefix(uri, prefix) {
+			return sub.routerFor(strings.TrimPrefix(uri, prefix))
+		}
+	}
+	return r
+}
+
+// route describes a registered route.
+type route struct {
+	Method  string // HTTP method, or "*" for any method
+	Pattern string // full path pattern, including sub-router prefixes
+}
+
+// String formats the route for display, e.g. "GET /users/:id".
+func (rt route) String() string { return rt.Method + " " + rt.Pattern }
+
+// routes returns all of the routes registered on this router and its
+// sub-routers, sorted by pattern and method.
+func (r *router) routes() []route {
+	var all []route
+	for _, h := range r.handlers() {
+		all = append(all, route{h.method, h.pattern})
+	}
+	return all
+}
+
+// handlers returns the handlers of all registered routes, including those of
+// sub-routers, sorted by pattern and then method.
+func (r *router) handlers() []handler {
+	var all []handler
+	for _, sub := range r.subRouters {
+		all = append(all, sub.handlers()...)
+	}
+	collect := func(h httpHandlerWithParams) {
+		if h, ok := h.(handler); ok {
+			all = append(all, h)
+		}
+	}
+	for _, m := range r.byMethod {
+		m.walk(collect)
+	}
+	r.anyMethod.walk(collect)
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].pattern != all[j].pattern {
+			return all[i].pattern < all[j].pattern
+		}
+		return all[i].method < all[j].method
+	})
+	return all
+}
+
 func (r *router) match(method, uri string, params Params) httpHandlerWithParams {
 	method = strings.ToUpper(method)
 	for prefix, sub := range r.subRouters {
@@ -145,33 +441,173 @@ func (r *router) match(method, uri string, params Params) httpHandlerWithParams
 	if h := r.anyMethod.Match(uri, params); h != nil {
 		return h
 	}
+	if method == http.MethodHead {
+		if h := r.byMethod[http.MethodGet].Match(uri, params); h != nil {
+			return h
+		}
+	}
 	return nil
 }
 
+// allowedMethods returns the sorted methods that have a route matching uri. HEAD
+// is included if GET is.
+func (r *router) allowedMethods(uri string) []string {
+	for prefix, sub := range r.subRouters {
+		if strings.HasPrefix(uri, prefix) {
+			return sub.allowedMethods(strings.TrimPrefix(uri, prefix))
+		}
+	}
+	var allowed []string
+	hasGet, hasHead := false, false
+	for method, m := range r.byMethod {
+		if m.Match(uri, Params{}) != nil {
+			allowed = append(allowed, method)
+			hasGet = hasGet || method == http.MethodGet
+			hasHead = hasHead || method == http.MethodHead
+		}
+	}
+	// match falls back from HEAD to GET, even if other paths have HEAD routes.
+	if hasGet && !hasHead {
+		allowed = append(allowed, http.MethodHead)
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+// serveMethodNotAllowed responds with a 405 if the request path matches routes
+// for other methods. It returns false if there are no such routes.
+func (r *router) serveMethodNotAllowed(w http.ResponseWriter, req *http.Request) bool {
+	allowed := r.allowedMethods(req.URL.Path)
+	if len(allowed) == 0 {
+		return false
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	e := Error{
+		Code:      http.StatusMethodNotAllowed,
+		ClientMsg: http.StatusText(http.StatusMethodNotAllowed),
+		LogMsg:    "Method not allowed, allowed: " + strings.Join(allowed, ", "),
+	}
+	r.ServeError(nil, w, req, e)
+	return true
+}
+
+func (r *router) ServeError(overrides map[any]any, w http.ResponseWriter, req *http.Request, err error) {
+	h := r.routerFor(req.URL.Path).errorHandler()
+	var runErr error
+	if h.frozen != nil {
+		runErr = h.frozen.RunWith(overrides, w, req, Params{}, defaultLogSink, ProdErrors, servedError{err})
+	} else {
+		runErr = h.Func.RunWith(overrides, w, req, Params{}, defaultLogSink, ProdErrors, servedError{err})
+	}
+	if runErr != nil {
+		panic(runErr)
+	}
+}
+
+// servedError is the error passed to ServeError.
+type servedError struct{ error }
+
+func (e servedError) fail() error { return e.error }
+
+// errorHandler returns the handler that ServeError runs with the middleware of
+// r. It's built the first time it's needed after the middleware changes.
+func (r *router) errorHandler() *handler {
+	if h := r.errHandler.Load(); h != nil {
+		return h
+	}
+	c := r.base.Arg(servedError{}).Then(servedError.fail).Compile()
+	frozen, _ := c.Freeze() // without a frozen chain, the error is reported when it runs
+	h := &handler{Func: c, frozen: frozen}
+	r.errHandler.Store(h)
+	return h
+}
+
+// setBase replaces the middleware of r.
+func (r *router) setBase(c chain.Func) {
+	r.base = c
+	r.errHandler.Store(nil)
+}
+
 func (r *router) Set(vals ...any) {
 	for _, val := range vals {
-		r.base = r.base.Set(val)
+		r.setBase(r.base.Set(val))
 	}
 }
 
 func (r *router) SetAs(val, ifacePtr any) {
-	r.base = r.base.SetAs(val, ifacePtr)
+	r.setBase(r.base.SetAs(val, ifacePtr))
 }
 
 func (r *router) Use(middlewareHandlers ...any) {
-	r.base = apply(r.base, middlewareHandlers...)
+	r.setBase(apply(r.base, middlewareHandlers...))
 }
 
 func (r *router) OnErr(errorHandler any) {
-	r.base = r.base.OnErr(errorHandler)
+	r.setBase(r.base.OnErr(errorHandler))
+}
+
+func (r *router) OnErrMap(mapper any) {
+	r.setBase(r.base.OnErrMap(mapper))
+}
+
+func (r *router) OnPanic(panicHandler any) {
+	r.setBase(r.base.OnPanic(panicHandler))
+}
+
+func (r *router) Extend(other Router) {
+	o, ok := other.(*router)
+	if !ok {
+		panic(fmt.Errorf("Cannot extend router with %T", other))
+	}
+	r.setBase(r.base.Append(o.base))
 }
 
 func (r *router) On(method, path string, handlers ...any) {
+	if err := r.TryOn(method, path, handlers...); err != nil {
+		panic(err)
+	}
+}
+
+func (r *router) TryOn(method, path string, handlers ...any) error {
 	method = strings.ToUpper(method)
-	m := r.getOrAllocateMux(method)
-	if err := m.Register(path, handler{apply(r.base, handlers...)}); err != nil {
-		panic(fmt.Errorf("Cannot register route: %v", err))
+	if !validMethod(method) {
+		return fmt.Errorf("Cannot register route: invalid method %q", method)
+	}
+	base := r.base
+	if r.panics.Max > 0 {
+		t := newPanicTracker(r.panics, route{method, r.prefix + path}.String())
+		base = base.Then(t.check).Defer(t.record)
+	}
+	var metrics []any
+	if r.stats != nil {
+		metrics = append(metrics, collect{r.stats, route{method, r.prefix + path}.String()})
+	}
+	for _, m := range r.metrics {
+		metrics = append(metrics, collect{m, route{method, r.prefix + path}.String()})
+	}
+	if len(metrics) > 0 {
+		handlers = append(metrics, handlers...)
+	}
+	c, err := tryApply(base, handlers...)
+	if err != nil {
+		return err
+	}
+	c = c.Compile()
+	if r.profiler != nil {
+		c = c.Profile(r.profiler)
+	}
+	if r.onStepStart != nil || r.onStepEnd != nil {
+		c = c.Instrument(r.onStepStart, r.onStepEnd)
 	}
+	frozen, err := c.Freeze()
+	if err != nil {
+		return err
+	}
+	h := handler{c, method, r.prefix + path, frozen}
+	if err := r.getOrAllocateMux(method).Register(path, h); err != nil {
+		return fmt.Errorf("Cannot register route: %v", err)
+	}
+	return nil
 }
 
 func (r *router) Any(path string, handlers ...any)    { r.On("*", path, handlers...) }
@@ -181,10 +617,27 @@ func (r *router) Post(path string, handlers ...any)   { r.On("POST", path, handl
 func (r *router) Patch(path string, handlers ...any)  { r.On("PATCH", path, handlers...) }
 func (r *router) Delete(path string, handlers ...any) { r.On("DELETE", path, handlers...) }
 
+// validMethod reports whether method is "*" or a valid HTTP token, which
+// allows extension methods such as PROPFIND.
+func validMethod(method string) bool {
+	if method == "*" {
+		return true
+	}
+	if method == "" {
+		return false
+	}
+	for _, c := range method {
+		if c > '~' || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *router) getOrAllocateMux(method string) *mux {
 	if method == "*" {
 		if r.anyMethod == nil {
-			r.anyMethod = &mux{}
+			r.anyMethod = &mux{policy: r.greedy}
 		}
 		return r.anyMethod
 	}
@@ -193,16 +646,33 @@ func (r *router) getOrAllocateMux(method string) *mux {
 	}
 	m := r.byMethod[method]
 	if m == nil {
-		m = &mux{}
+		m = &mux{policy: r.greedy}
 		r.byMethod[method] = m
 	}
 	return m
 }
 
-type handler struct{ chain.Func }
+type handler struct {
+	chain.Func
+	method  string
+	pattern string        // full path pattern, including sub-router prefixes
+	frozen  *chain.Frozen // if set, used to run Func
+}
 
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request, p Params) {
-	h.Func.MustRun(w, r, p)
+	h.serveWith(nil, w, r, p)
+}
+
+func (h handler) serveWith(overrides map[any]any, w http.ResponseWriter, r *http.Request, p Params) {
+	var err error
+	if h.frozen != nil {
+		err = h.frozen.RunWith(overrides, w, r, p, defaultLogSink, ProdErrors)
+	} else {
+		err = h.Func.RunWith(overrides, w, r, p, defaultLogSink, ProdErrors)
+	}
+	if err != nil {
+		panic(err)
+	}
 }
 
 type Params map[string]string
@@ -211,8 +681,29 @@ type mux struct {
 	static  map[string]*mux
 	params  []muxParam
 	handler httpHandlerWithParams
+	seq     int // registration order of handler, starting at 1
+
+	// These are only used on the root mux.
+	numRoutes int
+	policy    GreedyMatchPolicy
 }
 
+// GreedyMatchPolicy determines which route is used when a request path
+// matches several routes that follow a greedy param. For example, the path
+// "/files/a/edit/raw" matches both "/files/:path*/raw" and
+// "/files/:path*/edit/raw".
+type GreedyMatchPolicy int
+
+const (
+	// LongestMatch uses the route that matches the most segments after the
+	// greedy param, so the greedy param captures as little of the path as
+	// possible. In the example above, "/files/:path*/edit/raw" is used with
+	// path "a". This is the default.
+	LongestMatch GreedyMatchPolicy = iota
+	// RegistrationOrder uses the matching route that was registered first.
+	RegistrationOrder
+)
+
 type muxParam struct {
 	paramName string
 	greedy    bool
@@ -231,6 +722,7 @@ func (m *mux) Register(pattern string, h httpHandlerWithParams) error {
 	reg := registerInfo{
 		seenParams: map[string]bool{},
 		seenGreedy: false,
+		seq:        m.numRoutes + 1,
 	}
 	if m.static == nil {
 		m.static = map[string]*mux{}
@@ -238,12 +730,14 @@ func (m *mux) Register(pattern string, h httpHandlerWithParams) error {
 	if err := reg.registerSegments(m, segments, h); err != nil {
 		return fmt.Errorf("%#q: bad pattern: %w", pattern, err)
 	}
+	m.numRoutes++
 	return nil
 }
 
 type registerInfo struct {
 	seenParams map[string]bool
 	seenGreedy bool
+	seq        int
 }
 
 func (r *registerInfo) registerSegments(m *mux, segments []string, h httpHandlerWithParams) error {
@@ -252,6 +746,7 @@ func (r *registerInfo) registerSegments(m *mux, segments []string, h httpHandler
 			return fmt.Errorf("repeated entry")
 		}
 		m.handler = h
+		m.seq = r.seq
 		return nil
 	}
 	next, remaining := segments[0], segments[1:]
@@ -347,6 +842,22 @@ func (m *mux) checkAmbiguous(segments []string) error {
 	return nil
 }
 
+// walk calls fn with each handler registered in m.
+func (m *mux) walk(fn func(h httpHandlerWithParams)) {
+	if m == nil {
+		return
+	}
+	if m.handler != nil {
+		fn(m.handler)
+	}
+	for _, sub := range m.static {
+		sub.walk(fn)
+	}
+	for _, p := range m.params {
+		p.mux.walk(fn)
+	}
+}
+
 func entryToInfo(entry string) (static string, isStatic bool, paramName string, greedy bool) {
 	if strings.HasPrefix(entry, "::") {
 		// double colon prefix escapes to single colon static path name.
@@ -360,74 +871,80 @@ func entryToInfo(entry string) (static string, isStatic bool, paramName string,
 }
 
 func (m *mux) Match(uri string, params Params) httpHandlerWithParams {
+	if m == nil {
+		return nil
+	}
 	uri = strings.TrimPrefix(uri, "/")
 	segments := strings.Split(uri, "/")
-	matched := m.matchPrefix(segments, params)
+	matched := m.matchPrefix(segments, params, m.policy)
 	if matched == nil {
 		return nil
 	}
-	return matched
+	return matched.handler
 }
 
-func (m *mux) matchPrefix(segments []string, params Params) httpHandlerWithParams {
+// matchPrefix returns the mux of the route that matches segments, if any.
+// Params are only written if a match is found.
+func (m *mux) matchPrefix(segments []string, params Params, policy GreedyMatchPolicy) *mux {
 	if m == nil {
 		return nil
 	}
 	if len(segments) == 0 {
-		return m.handler
+		if m.handler == nil {
+			return nil
+		}
+		return m
 	}
 	path, remaining := segments[0], segments[1:]
 	if sub := m.static[path]; sub != nil {
-		match := sub.matchPrefix(remaining, params)
+		match := sub.matchPrefix(remaining, params, policy)
 		if match != nil {
 			return match
 		}
 	}
 	for _, param := range m.params {
 		if !param.greedy {
-			matched := param.mux.matchPrefix(remaining, params)
+			matched := param.mux.matchPrefix(remaining, params, policy)
 			if matched != nil {
 				params[param.paramName] = path
 				return matched
 			}
-		} else {
-			matched, used := param.mux.matchSuffix(remaining, params)
-			if matched != nil {
-				N := len(segments)
-				params[param.paramName] = strings.Join(segments[:N-used], "/")
-				return matched
-			}
+		} else if matched, n := param.mux.matchGreedy(segments, params, policy); matched != nil {
+			params[param.paramName] = strings.Join(segments[:n], "/")
+			return matched
 		}
 	}
 	return nil
 }
 
-func (m *mux) matchSuffix(segments []string, params Params) (h httpHandlerWithParams, depth int) {
-	N := len(segments)
-	if N == 0 {
-		return m.handler, 0
-	}
-	for staticPath, sub := range m.static {
-		match, d := sub.matchSuffix(segments, params)
-		if match == nil {
-			continue
-		}
-		depth = d + 1
-		actualPath := segments[N-depth]
-		if actualPath != staticPath {
-			continue
+// matchGreedy finds the route that matches the segments following a greedy
+// param that consumes at least one segment. It returns the matching mux and
+// the number of segments consumed by the greedy param. Only one greedy param
+// is allowed per pattern, so m never has greedy params itself.
+func (m *mux) matchGreedy(segments []string, params Params, policy GreedyMatchPolicy) (*mux, int) {
+	if policy == LongestMatch {
+		// Try the longest suffixes first. Match attempts that fail don't write
+		// any params, so params can be used directly.
+		for n := 1; n <= len(segments); n++ {
+			if matched := m.matchPrefix(segments[n:], params, policy); matched != nil {
+				return matched, n
+			}
 		}
-		return match, depth
+		return nil, 0
 	}
-	for _, param := range m.params {
-		match, d := param.mux.matchSuffix(segments, params)
-		if match == nil {
-			continue
+
+	var best *mux
+	var bestN int
+	var bestParams Params
+	for n := 1; n <= len(segments); n++ {
+		candidate := Params{}
+		matched := m.matchPrefix(segments[n:], candidate, policy)
+		if matched != nil && (best == nil || matched.seq < best.seq) {
+			best, bestN, bestParams = matched, n, candidate
 		}
-		depth = d + 1
-		actualPath := segments[N-depth]
-		params[param.paramName] = actualPath // TODO: might be rejected, might spam params
-		return match, depth
 	}
-	return m.handler, 0
+	for k, v := range bestParams {
+		params[k] = v
+	}
+	return best, bestN
 }
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/augustoroman/sandwich/chain"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -128,6 +130,53 @@ func TestMuxRegisterAndMatch(t *testing.T) {
 	}
 }
 
+func TestMuxGreedyMatchPolicy(t *testing.T) {
+	var m mux
+	for _, pattern := range []string{
+		"/f/:p*/raw",
+		"/f/:p*/edit/raw",
+		"/e/:g*/:x/z/w",
+		"/e/:g*/q",
+	} {
+		require.NoError(t, m.Register(pattern, noopHandler(pattern)))
+	}
+
+	testCases := []struct {
+		policy          GreedyMatchPolicy
+		uri             string
+		expectedHandler noopHandler
+		expectedParams  M
+	}{
+		{LongestMatch, "/f/a/edit/raw", "/f/:p*/edit/raw", M{"p": "a"}},
+		{RegistrationOrder, "/f/a/edit/raw", "/f/:p*/raw", M{"p": "a/edit"}},
+		{LongestMatch, "/f/a/b/raw", "/f/:p*/raw", M{"p": "a/b"}},
+		{RegistrationOrder, "/f/a/b/raw", "/f/:p*/raw", M{"p": "a/b"}},
+		// Failed attempts to match x must not leave it in the params.
+		{LongestMatch, "/e/a/b/q", "/e/:g*/q", M{"g": "a/b"}},
+		{RegistrationOrder, "/e/a/b/q", "/e/:g*/q", M{"g": "a/b"}},
+		{RegistrationOrder, "/e/a/b/z/w", "/e/:g*/:x/z/w", M{"g": "a", "x": "b"}},
+	}
+	for _, test := range testCases {
+		m.policy = test.policy
+		params := Params{}
+		assert.Equal(t, test.expectedHandler, m.Match(test.uri, params), "%v %s", test.policy, test.uri)
+		assert.Equal(t, test.expectedParams, params, "%v %s", test.policy, test.uri)
+	}
+
+	r := BuildYourOwn()
+	r.GreedyMatching(RegistrationOrder)
+	r.Get("/f/:p*/raw", func(w http.ResponseWriter, p Params) { _, _ = w.Write([]byte("raw " + p["p"])) })
+	r.Get("/f/:p*/edit/raw", func(w http.ResponseWriter) { _, _ = w.Write([]byte("edit")) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/f/a/edit/raw", nil))
+	assert.Equal(t, "raw a/edit", w.Body.String())
+
+	r.GreedyMatching(LongestMatch)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/f/a/edit/raw", nil))
+	assert.Equal(t, "edit", w.Body.String())
+}
+
 type noopHandler string
 
 func (h noopHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, p Params) {}
@@ -256,3 +305,240 @@ func TestRouter(t *testing.T) {
 // 		})
 // 	}
 // }
+
+func TestRouterExtend(t *testing.T) {
+	type User string
+	authBundle := BuildYourOwn()
+	authBundle.OnErr(func(w http.ResponseWriter, err error) {
+		http.Error(w, "go away", ToError(err).Code)
+	})
+	authBundle.Use(func(r *http.Request) (User, error) {
+		if u := r.Header.Get("user"); u != "" {
+			return User(u), nil
+		}
+		return "", Error{Code: http.StatusUnauthorized}
+	})
+
+	r := BuildYourOwn()
+	r.Extend(authBundle)
+	r.Get("/", func(w http.ResponseWriter, u User) { fmt.Fprintf(w, "Hi %s", u) })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("user", "bob")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "Hi bob", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "go away\n", w.Body.String())
+}
+
+func TestServeHTTPWith(t *testing.T) {
+	type Backend string
+	r := BuildYourOwn()
+	r.Set(Backend("prod"))
+	r.Get("/", func(w http.ResponseWriter, b Backend) { fmt.Fprint(w, b) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTPWith(map[any]any{Backend(""): Backend("staging")}, w,
+		httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "staging", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "prod", w.Body.String())
+}
+
+func TestHeadRequests(t *testing.T) {
+	var log LogEntry
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	WriteLog = func(e LogEntry) { log = e }
+
+	r := TheUsual()
+	r.Get("/hello", hello)
+	r.Get("/sized", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Length", "100")
+		_, _ = w.Write([]byte("partial"))
+	})
+	r.Get("/explicit", hello)
+	r.On("HEAD", "/explicit", func(w http.ResponseWriter) { w.WriteHeader(http.StatusNoContent) })
+	r.Post("/post", hello)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("HEAD", path, nil))
+		return w
+	}
+
+	w := serve("/hello")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Body.String())
+	assert.Equal(t, "12", w.Header().Get("Content-Length"))
+	assert.Equal(t, 12, log.ResponseSize)
+
+	w = serve("/sized")
+	assert.Equal(t, "100", w.Header().Get("Content-Length"))
+	assert.Equal(t, "", w.Body.String())
+
+	w = serve("/explicit")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serve("/post")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSkipToLabel(t *testing.T) {
+	type Report string
+	cache := map[string]string{"cached": "from cache"}
+
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/report/:id",
+		func(w http.ResponseWriter, p Params) error {
+			if v, ok := cache[p["id"]]; ok {
+				_, _ = w.Write([]byte(v))
+				return SkipTo("done")
+			}
+			return nil
+		},
+		func(p Params) Report { return Report("computed " + p["id"]) },
+		func(w http.ResponseWriter, rep Report) { _, _ = w.Write([]byte(rep)) },
+		Label("done"),
+		func(w http.ResponseWriter) { _, _ = w.Write([]byte("!")) },
+	)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/report/cached", nil))
+	assert.Equal(t, "from cache!", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/report/x", nil))
+	assert.Equal(t, "computed x!", w.Body.String())
+}
+
+func TestTryOn(t *testing.T) {
+	type DB struct{}
+	r := TheUsual()
+	r.Use(NoLog)
+
+	err := r.TryOn("GET", "/users", func(db *DB) {})
+	var buildErr *chain.BuildError
+	if assert.ErrorAs(t, err, &buildErr) {
+		assert.Equal(t, reflect.TypeOf((*DB)(nil)), buildErr.Missing)
+		assert.Contains(t, buildErr.Func.File, "router_test.go")
+	}
+	assert.Error(t, r.TryOn("GET", "/users", Wrap{Before: func(db *DB) {}}))
+	assert.EqualError(t, r.TryOn("BAD METHOD", "/users", hello),
+		`Cannot register route: invalid method "BAD METHOD"`)
+
+	// Nothing was registered by the failures.
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.NoError(t, r.TryOn("GET", "/users", hello))
+	assert.Error(t, r.TryOn("GET", "/users", hello), "duplicate route")
+	assert.Panics(t, func() { r.Get("/db", func(db *DB) {}) })
+}
+
+func TestAccumulateWarnings(t *testing.T) {
+	type Warning string
+	r := TheUsual()
+	r.Use(NoLog, Accumulate(Warning("")))
+	r.Use(func() Warning { return "deprecated" })
+	r.Get("/items",
+		func() Warning { return "slow" },
+		func(w http.ResponseWriter, warnings []Warning) {
+			for _, warning := range warnings {
+				w.Header().Add("Warning", string(warning))
+			}
+		})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+	assert.Equal(t, []string{"deprecated", "slow"}, w.Header().Values("Warning"))
+}
+
+func TestDeferLate(t *testing.T) {
+	type User string
+	var users []User
+	r := TheUsual()
+	r.Use(NoLog, DeferLate(func(u User) { users = append(users, u) }))
+	r.Get("/profile", func() User { return "bob" }, hello)
+	r.Get("/public", hello)
+
+	for _, path := range []string{"/profile", "/public"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	assert.Equal(t, []User{"bob", ""}, users)
+}
+
+func TestPrioritized(t *testing.T) {
+	var order []string
+	step := func(name string) func() { return func() { order = append(order, name) } }
+	r := TheUsual()
+	r.Use(NoLog,
+		Prioritized(Wrap{step("before last"), step("last")}, -1),
+		Wrap{step("before a"), step("a")},
+		Prioritized(Wrap{step("before first"), step("first")}, 1),
+		Wrap{step("before b"), step("b")},
+	)
+	r.Get("/", hello)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{
+		"before last", "before a", "before first", "before b",
+		"first", "b", "a", "last",
+	}, order)
+}
+
+func TestExtensionMethodsAndMethodNotAllowed(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog)
+	r.On("PROPFIND", "/dav/:path*", func(w http.ResponseWriter, p Params) {
+		w.WriteHeader(207)
+		_, _ = w.Write([]byte("props of " + p["path"]))
+	})
+	r.On("mkcol", "/dav/:path*", func(w http.ResponseWriter) { w.WriteHeader(http.StatusCreated) })
+	r.Get("/dav/:path*", hello)
+	api := r.SubRouter("/api")
+	api.Post("/items", hello)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve("PROPFIND", "/dav/a/b")
+	assert.Equal(t, 207, w.Code)
+	assert.Equal(t, "props of a/b", w.Body.String())
+	assert.Equal(t, http.StatusCreated, serve("MKCOL", "/dav/x").Code)
+
+	// Disabled by default.
+	assert.Equal(t, http.StatusNotFound, serve("DELETE", "/dav/x").Code)
+
+	r.MethodNotAllowed(true)
+	w = serve("DELETE", "/dav/x")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, HEAD, MKCOL, PROPFIND", w.Header().Get("Allow"))
+	assert.Equal(t, http.StatusNotFound, serve("DELETE", "/nothing").Code)
+
+	w = serve("GET", "/api/items")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "POST", w.Header().Get("Allow"))
+
+	// HEAD falls back to GET even if other paths have HEAD routes.
+	r.Get("/a", hello)
+	r.On("HEAD", "/other", hello)
+	assert.Equal(t, http.StatusOK, serve("HEAD", "/a").Code)
+	w = serve("DELETE", "/a")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+
+	assert.Panics(t, func() { r.On("BAD METHOD", "/x", hello) })
+	assert.Panics(t, func() { r.On("", "/x", hello) })
+}
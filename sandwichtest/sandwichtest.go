@@ -0,0 +1,97 @@
+// Package sandwichtest provides utilities for testing sandwich routers, in
+// particular custom error handlers.
+//
+// For example, to check that a custom error page renders a 404:
+//
+//	func TestErrorPage(t *testing.T) {
+//	  mux := newRouter() // uses mux.OnErr(CustomErrorPage)
+//	  resp := sandwichtest.ServeError(mux, nil, sandwich.Error{Code: 404})
+//	  resp.Check(t, sandwichtest.Want{
+//	    Code:     404,
+//	    Body:     "Page not found",
+//	    LogError: "404",
+//	  })
+//	}
+package sandwichtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/augustoroman/sandwich"
+)
+
+// ErrorResponse is the result of handling an error with ServeError.
+type ErrorResponse struct {
+	*httptest.ResponseRecorder
+	// Log is the request's log entry after the error has been handled and the
+	// deferred handlers have run. If the router doesn't use LogRequests, it's
+	// the initial entry for the request.
+	Log *sandwich.LogEntry
+}
+
+// ServeError runs the router's error flow for req with err, as if a handler
+// had returned it, and records the response and log entry. If req is nil, a
+// GET request for "/" is used. The log entry is committed as usual, so it's
+// written to the router's LogSink.
+func ServeError(router sandwich.Router, req *http.Request, err error) *ErrorResponse {
+	return ServeErrorWith(router, nil, req, err)
+}
+
+// ServeErrorWith is like ServeError, but substitutes the specified values for
+// the request, as with Router.ServeHTTPWith. This is useful to provide fakes
+// for types that the error handlers depend on.
+func ServeErrorWith(router sandwich.Router, overrides map[any]any, req *http.Request, err error) *ErrorResponse {
+	if req == nil {
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+	}
+	resp := &ErrorResponse{httptest.NewRecorder(), sandwich.NewLogEntry(req)}
+	all := map[any]any{(*sandwich.LogEntry)(nil): resp.Log}
+	for k, v := range overrides {
+		all[k] = v
+	}
+	router.ServeError(all, resp, req, err)
+	return resp
+}
+
+// Want describes the expected result of handling an error. Zero-valued fields
+// are not checked.
+type Want struct {
+	Code   int         // the response status code
+	Body   string      // a substring of the response body
+	Header http.Header // headers that must have exactly the listed values
+	// LogError is a substring of the logged error, or "<nil>" if no error
+	// should be logged.
+	LogError string
+	Notes    map[string]string // notes that must be set on the log entry
+}
+
+// Check reports an error to t for each way that the response differs from
+// want.
+func (r *ErrorResponse) Check(t testing.TB, want Want) {
+	t.Helper()
+	if want.Code != 0 && r.Code != want.Code {
+		t.Errorf("wrong status code: got %d, want %d", r.Code, want.Code)
+	}
+	if body := r.Body.String(); !strings.Contains(body, want.Body) {
+		t.Errorf("response body %q does not contain %q", body, want.Body)
+	}
+	for key, vals := range want.Header {
+		if got := r.Header().Values(key); fmt.Sprint(got) != fmt.Sprint(vals) {
+			t.Errorf("wrong %s header: got %q, want %q", key, got, vals)
+		}
+	}
+	if want.LogError != "" {
+		if got := fmt.Sprint(r.Log.Error); !strings.Contains(got, want.LogError) {
+			t.Errorf("logged error %q does not contain %q", got, want.LogError)
+		}
+	}
+	for key, val := range want.Notes {
+		if got, ok := r.Log.Note[key]; !ok || got != val {
+			t.Errorf("wrong log note %q: got %q, want %q", key, got, val)
+		}
+	}
+}
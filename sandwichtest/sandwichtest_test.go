@@ -0,0 +1,81 @@
+package sandwichtest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/augustoroman/sandwich"
+	"github.com/stretchr/testify/assert"
+)
+
+type Theme string
+
+func customErrorPage(w http.ResponseWriter, l *sandwich.LogEntry, theme Theme, err error) {
+	e := sandwich.ToError(err)
+	l.Error = e
+	l.Note["theme"] = string(theme)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(e.Code)
+	fmt.Fprintf(w, "<h1 class=%q>%s</h1>", theme, e.ClientMsg)
+}
+
+func TestServeError(t *testing.T) {
+	mux := sandwich.TheUsual()
+	mux.Use(sandwich.NoLog)
+	mux.Set(Theme("light"))
+	mux.OnErr(customErrorPage)
+	api := mux.SubRouter("/api")
+	api.OnErr(sandwich.HandleErrorJson)
+
+	resp := ServeError(mux, nil, sandwich.Error{Code: 404, ClientMsg: "Not here", LogMsg: "missing"})
+	resp.Check(t, Want{
+		Code:     404,
+		Body:     `<h1 class="light">Not here</h1>`,
+		Header:   http.Header{"Cache-Control": {"no-store"}},
+		LogError: "missing",
+		Notes:    map[string]string{"theme": "light"},
+	})
+	assert.Equal(t, 404, resp.Log.StatusCode)
+
+	// Overrides are applied.
+	resp = ServeErrorWith(mux, map[any]any{Theme(""): Theme("dark")}, nil, errors.New("boom"))
+	resp.Check(t, Want{Code: 500, Body: `class="dark"`, Notes: map[string]string{"theme": "dark"}})
+
+	// The error handler of the sub-router for the request path is used.
+	req := httptest.NewRequest("GET", "/api/items", nil)
+	resp = ServeError(mux, req, sandwich.Error{Code: 400, ClientMsg: "Bad"})
+	resp.Check(t, Want{Code: 400, Body: `{"error":"Bad"}`, LogError: "<nil>"})
+}
+
+// recordingT records the errors reported to it.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCheckReportsMismatches(t *testing.T) {
+	mux := sandwich.TheUsual()
+	mux.Use(sandwich.NoLog)
+	resp := ServeError(mux, nil, sandwich.Error{Code: 403, ClientMsg: "Nope", LogMsg: "denied"})
+
+	var rt recordingT
+	resp.Check(&rt, Want{Code: 403, Body: "Nope", LogError: "denied"})
+	assert.Empty(t, rt.errors)
+
+	resp.Check(&rt, Want{
+		Code:     401,
+		Body:     "Login",
+		Header:   http.Header{"X-Reason": {"auth"}},
+		LogError: "<nil>",
+		Notes:    map[string]string{"user": "bob"},
+	})
+	assert.Len(t, rt.errors, 5, "%q", rt.errors)
+}
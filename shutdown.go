@@ -0,0 +1,72 @@
+package sandwich
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// Shutdowner is implemented by values that need a deadline to shut down
+// gracefully, such as *http.Server.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Shutdown closes all values that have been provided to the router or its
+// sub-routers using Set or SetAs, in reverse order that they were registered.
+// Values that implement Shutdowner are shut down using ctx, otherwise values
+// that implement io.Closer are closed. This allows injected resources such as
+// database pools and clients to be cleaned up without separate bookkeeping.
+//
+// Shutdown attempts to close all values even if some fail, and returns the
+// first error encountered. It's typically called after the http.Server has been
+// shut down:
+//
+//	srv.Shutdown(ctx)
+//	mux.Shutdown(ctx)
+func (r *router) Shutdown(ctx context.Context) error {
+	vals := r.closableValues(nil, map[any]bool{})
+	var firstErr error
+	for i := len(vals) - 1; i >= 0; i-- {
+		var err error
+		switch v := vals[i].(type) {
+		case Shutdowner:
+			err = v.Shutdown(ctx)
+		case io.Closer:
+			err = v.Close()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// closableValues appends the values of r and its sub-routers that implement
+// Shutdowner or io.Closer to vals, skipping any that have been seen already.
+func (r *router) closableValues(vals []any, seen map[any]bool) []any {
+	for _, v := range r.base.Values() {
+		switch v.(type) {
+		case Shutdowner, io.Closer:
+		default:
+			continue
+		}
+		if reflect.TypeOf(v).Comparable() {
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+		}
+		vals = append(vals, v)
+	}
+	prefixes := make([]string, 0, len(r.subRouters))
+	for prefix := range r.subRouters {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		vals = r.subRouters[prefix].closableValues(vals, seen)
+	}
+	return vals
+}
@@ -0,0 +1,44 @@
+package sandwich
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCloser struct {
+	name string
+	log  *[]string
+	err  error
+}
+
+func (c *testCloser) Close() error { *c.log = append(*c.log, "close "+c.name); return c.err }
+
+type testShutdowner struct {
+	testCloser
+}
+
+func (s *testShutdowner) Shutdown(ctx context.Context) error {
+	*s.log = append(*s.log, "shutdown "+s.name)
+	return ctx.Err()
+}
+
+func TestShutdown(t *testing.T) {
+	var log []string
+	db := &testCloser{"db", &log, errors.New("db failed")}
+	cache := &testShutdowner{testCloser{"cache", &log, nil}}
+	client := &testCloser{"client", &log, nil}
+
+	r := BuildYourOwn()
+	r.Set(db, "not closable")
+	r.SetAs(cache, (*Shutdowner)(nil))
+	api := r.SubRouter("/api")
+	api.SetAs(client, (*io.Closer)(nil))
+	r.SubRouter("/other").Set(db) // only closed once
+
+	assert.EqualError(t, r.Shutdown(context.Background()), "db failed")
+	assert.Equal(t, []string{"close client", "shutdown cache", "close db"}, log)
+}
@@ -0,0 +1,337 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// StatsCollector aggregates the latency, response size, and status codes of
+// the requests of each route. It's intended for quick triage in production
+// without a full metrics stack. Use Router.CollectStats to collect the stats
+// of a router's routes, and ServeStats to expose them. If the requests are
+// traced with PropagateTrace, their trace IDs are recorded as exemplars of the
+// latency stats. The state of AdaptiveLimiters may be reported along with the
+// stats, see AddLimiters.
+type StatsCollector struct {
+	mu       sync.Mutex
+	routes   map[string]*RouteStats
+	errors   map[errorKey]uint64
+	limiters []*AdaptiveLimiter
+}
+
+// DefaultStats is the StatsCollector used by Config.Stats. See Stats.
+var DefaultStats = NewStatsCollector()
+
+// Stats returns the stats collected by DefaultStats.
+func Stats() []RouteStats { return DefaultStats.Stats() }
+
+// RouteStats are the aggregated stats of the requests of a single route.
+type RouteStats struct {
+	Route  string            `json:"route"` // e.g. "GET /users/:id"
+	Count  uint64            `json:"count"`
+	Status map[string]uint64 `json:"status"` // by class, e.g. "2xx"
+	Errors uint64            `json:"errors"` // requests that logged an error
+	// ErrorTypes counts the requests that logged an error by the type of the
+	// error, see ErrorCount.
+	ErrorTypes map[string]uint64 `json:"error_types,omitempty"`
+	Latency    Histogram         `json:"latency_ms"`
+	Size       Histogram         `json:"size_bytes"`
+	Duration   time.Duration     `json:"total_duration_ns"`
+	// Slowest are the traced requests with the highest latency, slowest
+	// first, see Exemplar.
+	Slowest []Exemplar `json:"slowest,omitempty"`
+}
+
+// Exemplar links an observed value to the trace of the request, so that a
+// latency spike can be followed to concrete traces of slow requests. The trace
+// ID is the "trace_id" note of the LogEntry, which is recorded by
+// PropagateTrace. Requests without a trace ID have no exemplars.
+type Exemplar struct {
+	TraceID string    `json:"trace_id"`
+	Value   float64   `json:"value"` // e.g. the latency in milliseconds
+	Time    time.Time `json:"time"`  // when the request started
+}
+
+// maxSlowest is the number of Slowest exemplars kept per route.
+const maxSlowest = 5
+
+// Histogram counts the observed values in buckets. Each bucket counts the
+// values that are at most its upper bound, Le, and greater than that of the
+// previous bucket. The last bucket is unbounded and has an Le of 0.
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	Sum     float64           `json:"sum"`
+	Max     float64           `json:"max"`
+}
+
+// ErrorCount is the number of requests of a route that failed with a status
+// class and type of error. The Type is the Go type of the error, e.g.
+// "*fs.PathError", after unwrapping the Cause of an Error and errors wrapped
+// with fmt.Errorf's %w. An Error without a Cause is "sandwich.Error", and
+// panics are "panic".
+type ErrorCount struct {
+	Route  string `json:"route"`
+	Status string `json:"status"` // by class, e.g. "5xx"
+	Type   string `json:"type"`
+	Count  uint64 `json:"count"`
+}
+
+type errorKey struct{ route, status, typ string }
+
+// HistogramBucket is a single bucket of a Histogram.
+type HistogramBucket struct {
+	Le    float64 `json:"le,omitempty"`
+	Count uint64  `json:"count"`
+	// Exemplar is the most recent traced value in the bucket, if any.
+	Exemplar *Exemplar `json:"exemplar,omitempty"`
+}
+
+// Bucket bounds of the latency, in milliseconds, and size, in bytes,
+// histograms.
+var (
+	latencyBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+	sizeBuckets    = []float64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20}
+)
+
+// NewStatsCollector returns an empty StatsCollector.
+func NewStatsCollector() *StatsCollector {
+	return &StatsCollector{routes: map[string]*RouteStats{}, errors: map[errorKey]uint64{}}
+}
+
+func newHistogram(bounds []float64) Histogram {
+	h := Histogram{Buckets: make([]HistogramBucket, len(bounds)+1)}
+	for i, le := range bounds {
+		h.Buckets[i].Le = le
+	}
+	return h
+}
+
+// observe adds v to the histogram. If ex isn't nil, it becomes the exemplar of
+// the bucket of v.
+func (h *Histogram) observe(v float64, ex *Exemplar) {
+	i := sort.Search(len(h.Buckets)-1, func(i int) bool { return v <= h.Buckets[i].Le })
+	h.Buckets[i].Count++
+	if ex != nil {
+		h.Buckets[i].Exemplar = ex
+	}
+	h.Sum += v
+	if v > h.Max {
+		h.Max = v
+	}
+}
+
+// addSlowest adds ex to the slowest exemplars of s if it's among the
+// maxSlowest highest values.
+func (s *RouteStats) addSlowest(ex Exemplar) {
+	i := sort.Search(len(s.Slowest), func(i int) bool { return s.Slowest[i].Value < ex.Value })
+	if i == maxSlowest {
+		return
+	}
+	if len(s.Slowest) < maxSlowest {
+		s.Slowest = append(s.Slowest, Exemplar{})
+	}
+	copy(s.Slowest[i+1:], s.Slowest[i:])
+	s.Slowest[i] = ex
+}
+
+func (h Histogram) clone() Histogram {
+	h.Buckets = append([]HistogramBucket(nil), h.Buckets...)
+	return h
+}
+
+// RecordRequest adds the completed log entry of a request of route to the
+// stats.
+func (c *StatsCollector) RecordRequest(route string, e LogEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.routes[route]
+	if s == nil {
+		s = &RouteStats{
+			Route:   route,
+			Status:  map[string]uint64{},
+			Latency: newHistogram(latencyBuckets),
+			Size:    newHistogram(sizeBuckets),
+		}
+		c.routes[route] = s
+	}
+	class := strconv.Itoa(e.StatusCode/100) + "xx"
+	s.Count++
+	s.Status[class]++
+	if e.Error != nil {
+		typ := errorType(e.Error)
+		s.Errors++
+		if s.ErrorTypes == nil {
+			s.ErrorTypes = map[string]uint64{}
+		}
+		s.ErrorTypes[typ]++
+		c.errors[errorKey{route, class, typ}]++
+	}
+	latency := float64(e.Elapsed) / float64(time.Millisecond)
+	var ex *Exemplar
+	if traceID := e.Note["trace_id"]; traceID != "" {
+		ex = &Exemplar{traceID, latency, e.Start}
+		s.addSlowest(*ex)
+	}
+	s.Latency.observe(latency, ex)
+	s.Size.observe(float64(e.ResponseSize), nil)
+	s.Duration += e.Elapsed
+}
+
+// Stats returns the stats of each route that has been requested, sorted by
+// route.
+func (c *StatsCollector) Stats() []RouteStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make([]RouteStats, 0, len(c.routes))
+	for _, s := range c.routes {
+		rs := *s
+		rs.Status = make(map[string]uint64, len(s.Status))
+		for k, v := range s.Status {
+			rs.Status[k] = v
+		}
+		if s.ErrorTypes != nil {
+			rs.ErrorTypes = make(map[string]uint64, len(s.ErrorTypes))
+			for k, v := range s.ErrorTypes {
+				rs.ErrorTypes[k] = v
+			}
+		}
+		rs.Latency, rs.Size = s.Latency.clone(), s.Size.clone()
+		rs.Slowest = append([]Exemplar(nil), s.Slowest...)
+		stats = append(stats, rs)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Route < stats[j].Route })
+	return stats
+}
+
+// Errors returns the number of failed requests by route, status class, and
+// error type, sorted in that order. This allows spikes of 4xx or 5xx errors to
+// be attributed to their cause even when the request logs are sampled.
+func (c *StatsCollector) Errors() []ErrorCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make([]ErrorCount, 0, len(c.errors))
+	for k, n := range c.errors {
+		counts = append(counts, ErrorCount{k.route, k.status, k.typ, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+		return a.Type < b.Type
+	})
+	return counts
+}
+
+// AddLimiters reports the state of the limiters along with the stats of c,
+// see Limiters.
+func (c *StatsCollector) AddLimiters(limiters ...*AdaptiveLimiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limiters = append(c.limiters, limiters...)
+}
+
+// Limiters returns the current state of the limiters added with AddLimiters,
+// in the order they were added.
+func (c *StatsCollector) Limiters() []LimiterStats {
+	c.mu.Lock()
+	limiters := c.limiters
+	c.mu.Unlock()
+	stats := make([]LimiterStats, len(limiters))
+	for i, l := range limiters {
+		stats[i] = l.Stats()
+	}
+	return stats
+}
+
+// errorType returns the type of the error wrapped by err, following the Cause
+// of Errors and the errors wrapped by fmt.Errorf, or "panic" if err is a panic.
+func errorType(err error) string {
+	for {
+		typ := fmt.Sprintf("%T", err)
+		switch e := err.(type) {
+		case chain.PanicError:
+			return "panic"
+		case Error:
+			if e.Cause == nil {
+				return typ
+			}
+			err = e.Cause
+		default:
+			next := errors.Unwrap(err)
+			if next == nil || !strings.HasPrefix(typ, "*fmt.") {
+				return typ
+			}
+			err = next
+		}
+	}
+}
+
+// RouteMetrics receives the completed log entry of each request of the routes
+// of a router, along with the route, e.g. "GET /users/:id". It must be safe for
+// concurrent use. See Router.ReportMetrics.
+type RouteMetrics interface {
+	RecordRequest(route string, e LogEntry)
+}
+
+// collect is a ChainMutation that reports each request of a route to a
+// RouteMetrics. It requires the *LogEntry provided by LogRequests.
+type collect struct {
+	m     RouteMetrics
+	route string
+}
+
+func (c collect) Apply(fn chain.Func) chain.Func {
+	return fn.Defer(func(e *LogEntry, w *ResponseWriter, err error) {
+		c.m.RecordRequest(c.route, e.snapshot(w, err))
+	})
+}
+
+// Reset discards all collected stats. The limiters added with AddLimiters
+// are still reported.
+func (c *StatsCollector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes = map[string]*RouteStats{}
+	c.errors = map[errorKey]uint64{}
+}
+
+// ServeStats returns a handler that responds with the stats collected by c as
+// JSON. It's intended for a debug endpoint, along with Router.CollectStats:
+//
+//	mux.CollectStats(sandwich.DefaultStats)
+//	... register routes ...
+//	mux.Get("/debug/sandwich/stats", sandwich.ServeStats(sandwich.DefaultStats))
+//
+// If the request has an "errors" query parameter, the error counts are
+// reported instead, see StatsCollector.Errors, and if it has a "limiters"
+// query parameter, the state of the limiters is, see StatsCollector.Limiters.
+// If the request has a "reset" query parameter, the stats are reset after they
+// are reported.
+func ServeStats(c *StatsCollector) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var stats any = c.Stats()
+		if _, errs := r.URL.Query()["errors"]; errs {
+			stats = c.Errors()
+		} else if _, limiters := r.URL.Query()["limiters"]; limiters {
+			stats = c.Limiters()
+		}
+		if _, reset := r.URL.Query()["reset"]; reset {
+			c.Reset()
+		}
+		w.Header().Set(headerContentType, "application/json")
+		return json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,186 @@
+package sandwich
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectStats(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	time_Now = func() time.Time { return now }
+	defer func() { time_Now = time.Now }()
+
+	c := NewStatsCollector()
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/untracked", hello)
+	r.CollectStats(c)
+	api := r.SubRouter("/api")
+	api.Get("/users/:id", func(w http.ResponseWriter, p Params) error {
+		now = now.Add(30 * time.Millisecond)
+		if p["id"] == "0" {
+			return errors.New("no such user")
+		}
+		_, _ = w.Write([]byte(strings.Repeat("x", 2000)))
+		return nil
+	})
+	r.Get("/stats", ServeStats(c))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	serve("/untracked")
+	serve("/api/users/1")
+	serve("/api/users/2")
+	serve("/api/users/0")
+
+	stats := c.Stats()
+	require.Len(t, stats, 1)
+	s := stats[0]
+	assert.Equal(t, "GET /api/users/:id", s.Route)
+	assert.Equal(t, uint64(3), s.Count)
+	assert.Equal(t, map[string]uint64{"2xx": 2, "5xx": 1}, s.Status)
+	assert.Equal(t, uint64(1), s.Errors)
+	assert.Equal(t, map[string]uint64{"*errors.errorString": 1}, s.ErrorTypes)
+	assert.Equal(t, 90*time.Millisecond, s.Duration)
+	assert.Equal(t, HistogramBucket{Le: 50, Count: 3}, s.Latency.Buckets[4])
+	assert.Equal(t, 90.0, s.Latency.Sum)
+	assert.Equal(t, 30.0, s.Latency.Max)
+	assert.Equal(t, HistogramBucket{Le: 1 << 10, Count: 1}, s.Size.Buckets[0])
+	assert.Equal(t, HistogramBucket{Le: 10 << 10, Count: 2}, s.Size.Buckets[1])
+	assert.Equal(t, HistogramBucket{Le: 0, Count: 0}, s.Size.Buckets[len(s.Size.Buckets)-1])
+
+	var served []RouteStats
+	require.NoError(t, json.Unmarshal(serve("/stats?reset").Body.Bytes(), &served))
+	assert.Equal(t, stats, served)
+	// Only the /stats request itself remains after the reset.
+	stats = c.Stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "GET /stats", stats[0].Route)
+}
+
+func TestErrorCounts(t *testing.T) {
+	c := NewStatsCollector()
+	r := TheUsual()
+	r.Use(NoLog)
+	r.CollectStats(c)
+	r.Get("/files/:name", func(p Params) error {
+		if p["name"] == "secret" {
+			return Error{Code: 403, ClientMsg: "Forbidden"}
+		}
+		_, err := os.Open(filepath.Join(os.TempDir(), "no-such-dir", p["name"]))
+		return Error{Code: 404, ClientMsg: "Not found", LogMsg: "Open failed", Cause: err}
+	})
+	r.Get("/panic", func() { panic("boom") })
+	r.Get("/stats", ServeStats(c))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	serve("/files/a")
+	serve("/files/b")
+	serve("/files/secret")
+	serve("/panic")
+
+	want := []ErrorCount{
+		{"GET /files/:name", "4xx", "*fs.PathError", 2},
+		{"GET /files/:name", "4xx", "sandwich.Error", 1},
+		{"GET /panic", "5xx", "panic", 1},
+	}
+	assert.Equal(t, want, c.Errors())
+
+	var served []ErrorCount
+	require.NoError(t, json.Unmarshal(serve("/stats?errors&reset").Body.Bytes(), &served))
+	assert.Equal(t, want, served)
+	assert.Empty(t, c.Errors())
+}
+
+func TestConfigStats(t *testing.T) {
+	DefaultStats.Reset()
+	defer DefaultStats.Reset()
+	r, err := NewWithConfig(Config{Logger: func(LogEntry) {}, Stats: true, StatsEndpoint: "/debug/sandwich/stats"})
+	require.NoError(t, err)
+	r.Get("/", hello)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	stats := Stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "GET /", stats[0].Route)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/sandwich/stats", nil))
+	assert.Contains(t, w.Body.String(), `"route":"GET /"`)
+
+	_, err = NewWithConfig(Config{StatsEndpoint: "no-slash"})
+	assert.Error(t, err)
+
+	// Stats require a LogEntry.
+	plain := BuildYourOwn()
+	plain.CollectStats(NewStatsCollector())
+	assert.Error(t, plain.TryOn("GET", "/", hello))
+}
+
+func TestStatsExemplars(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	time_Now = func() time.Time { return now }
+	defer func() { time_Now = time.Now }()
+
+	c := NewStatsCollector()
+	r := TheUsual()
+	r.Use(NoLog)
+	r.CollectStats(c)
+	r.Get("/untraced", hello)
+	r.Get("/sleep/:ms", PropagateTrace(nil), func(p Params) {
+		ms, _ := strconv.Atoi(p["ms"])
+		now = now.Add(time.Duration(ms) * time.Millisecond)
+	})
+
+	traceIDs := map[int]string{}
+	for i, ms := range []int{3, 700, 40, 2, 300, 20, 600, 8} {
+		traceID := fmt.Sprintf("%032x", i+1)
+		traceIDs[ms] = traceID
+		req := httptest.NewRequest("GET", "/sleep/"+strconv.Itoa(ms), nil)
+		req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/untraced", nil))
+
+	stats := c.Stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "GET /sleep/:ms", stats[0].Route)
+	var slowest []float64
+	for _, ex := range stats[0].Slowest {
+		assert.Equal(t, traceIDs[int(ex.Value)], ex.TraceID)
+		slowest = append(slowest, ex.Value)
+	}
+	assert.Equal(t, []float64{700, 600, 300, 40, 20}, slowest)
+
+	// Each bucket has the most recent trace in it.
+	buckets := stats[0].Latency.Buckets
+	assert.Equal(t, traceIDs[2], buckets[1].Exemplar.TraceID) // 2ms and 3ms <= 5ms
+	assert.Equal(t, traceIDs[8], buckets[2].Exemplar.TraceID) // 8ms <= 10ms
+	assert.Equal(t, traceIDs[600], buckets[8].Exemplar.TraceID)
+	assert.Nil(t, buckets[0].Exemplar)
+
+	assert.Equal(t, "GET /untraced", stats[1].Route)
+	assert.Empty(t, stats[1].Slowest)
+	for _, b := range stats[1].Latency.Buckets {
+		assert.Nil(t, b.Exemplar)
+	}
+}
@@ -0,0 +1,135 @@
+package sandwich
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsDClient sends metrics to a StatsD server. Its methods match those of
+// the Datadog client, github.com/DataDog/datadog-go/statsd, so that it may be
+// used directly, or use DialStatsD for a minimal UDP client.
+type StatsDClient interface {
+	Count(name string, value int64, tags []string, rate float64) error
+	Timing(name string, value time.Duration, tags []string, rate float64) error
+}
+
+// StatsDOptions configures NewStatsDMetrics.
+type StatsDOptions struct {
+	// Prefix is prepended to the metric names, e.g. "myapp.".
+	Prefix string
+	// Tags are added to all metrics, e.g. "env:prod".
+	Tags []string
+	// SampleRate is the fraction of requests that are reported, between 0 and
+	// 1. If zero, all requests are reported.
+	SampleRate float64
+}
+
+// StatsDMetrics is a RouteMetrics that emits the metrics of each request to a
+// StatsD server. For each request, it increments the "http.requests" counter
+// and records the "http.request.duration" timing, tagged with the route,
+// method, and status class of the request, e.g.:
+//
+//	http.requests:1|c|#route:/users/:id,method:GET,status_class:2xx
+//
+// Requests that fail with an error also increment the "http.errors" counter,
+// which is additionally tagged with the error_type, see ErrorCount.
+//
+// Use it with Router.ReportMetrics:
+//
+//	client, err := sandwich.DialStatsD("127.0.0.1:8125")
+//	...
+//	mux.ReportMetrics(sandwich.NewStatsDMetrics(client, sandwich.StatsDOptions{Prefix: "myapp."}))
+type StatsDMetrics struct {
+	client StatsDClient
+	opts   StatsDOptions
+}
+
+// NewStatsDMetrics returns a StatsDMetrics that sends metrics with client.
+func NewStatsDMetrics(client StatsDClient, opts StatsDOptions) *StatsDMetrics {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	return &StatsDMetrics{client, opts}
+}
+
+// RecordRequest emits the metrics of a completed request of route.
+func (m *StatsDMetrics) RecordRequest(route string, e LogEntry) {
+	rate := m.opts.SampleRate
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+	method, pattern, _ := strings.Cut(route, " ")
+	tags := append(m.opts.Tags[:len(m.opts.Tags):len(m.opts.Tags)],
+		"route:"+pattern,
+		"method:"+method,
+		"status_class:"+strconv.Itoa(e.StatusCode/100)+"xx")
+	if e.Error != nil {
+		tags = append(tags, "error:true")
+	}
+	m.client.Count(m.opts.Prefix+"http.requests", 1, tags, rate)
+	m.client.Timing(m.opts.Prefix+"http.request.duration", e.Elapsed, tags, rate)
+	if e.Error != nil {
+		tags = append(tags[:len(tags):len(tags)], "error_type:"+errorType(e.Error))
+		m.client.Count(m.opts.Prefix+"http.errors", 1, tags, rate)
+	}
+}
+
+// StatsDConn is a minimal StatsDClient that sends each metric as a UDP packet
+// in the DogStatsD format, which is also understood by Telegraf. It's safe for
+// concurrent use.
+type StatsDConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialStatsD returns a StatsDConn that sends metrics to the StatsD server at
+// addr, e.g. "127.0.0.1:8125".
+func DialStatsD(addr string) (*StatsDConn, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDConn{conn: conn}, nil
+}
+
+// Count adds value to the counter name.
+func (c *StatsDConn) Count(name string, value int64, tags []string, rate float64) error {
+	return c.send(name, strconv.FormatInt(value, 10), "c", tags, rate)
+}
+
+// Timing records a duration of name in milliseconds.
+func (c *StatsDConn) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	ms := strconv.FormatFloat(float64(value)/float64(time.Millisecond), 'f', -1, 64)
+	return c.send(name, ms, "ms", tags, rate)
+}
+
+// Close closes the connection.
+func (c *StatsDConn) Close() error { return c.conn.Close() }
+
+func (c *StatsDConn) send(name, value, typ string, tags []string, rate float64) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:%s|%s", statsdEscape(name), value, typ)
+	if rate > 0 && rate < 1 {
+		fmt.Fprintf(&b, "|@%g", rate)
+	}
+	for i, tag := range tags {
+		if i == 0 {
+			b.WriteString("|#")
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(statsdEscape(tag))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write([]byte(b.String()))
+	return err
+}
+
+// statsdEscape replaces the characters that delimit the fields of a metric.
+var statsdEscape = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_").Replace
@@ -0,0 +1,88 @@
+package sandwich
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStatsD struct {
+	mu      sync.Mutex
+	metrics []string
+}
+
+func (f *fakeStatsD) Count(name string, value int64, tags []string, rate float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metrics = append(f.metrics, fmt.Sprintf("%s:%d|c %v", name, value, tags))
+	return nil
+}
+
+func (f *fakeStatsD) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metrics = append(f.metrics, fmt.Sprintf("%s:%v|ms %v", name, value, tags))
+	return nil
+}
+
+func TestStatsDMetrics(t *testing.T) {
+	defer func(orig func() time.Time) { time_Now = orig }(time_Now)
+	now := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	time_Now = func() time.Time { now = now.Add(5 * time.Millisecond); return now }
+
+	client := &fakeStatsD{}
+	r := TheUsual()
+	r.Use(NoLog)
+	r.ReportMetrics(NewStatsDMetrics(client, StatsDOptions{Prefix: "app.", Tags: []string{"env:test"}}))
+	r.Get("/users/:id", func(w http.ResponseWriter) {})
+	r.SubRouter("/api").Post("/fail", func() error { return errors.New("oops") })
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/fail", nil))
+
+	assert.Equal(t, []string{
+		"app.http.requests:1|c [env:test route:/users/:id method:GET status_class:2xx]",
+		"app.http.request.duration:5ms|ms [env:test route:/users/:id method:GET status_class:2xx]",
+		"app.http.requests:1|c [env:test route:/api/fail method:POST status_class:5xx error:true]",
+		"app.http.request.duration:5ms|ms [env:test route:/api/fail method:POST status_class:5xx error:true]",
+		"app.http.errors:1|c [env:test route:/api/fail method:POST status_class:5xx error:true error_type:*errors.errorString]",
+	}, client.metrics)
+
+	// Routes registered after ReportMetrics(nil) aren't reported.
+	client.metrics = nil
+	r.ReportMetrics(nil)
+	r.Get("/quiet", func(w http.ResponseWriter) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/quiet", nil))
+	assert.Empty(t, client.metrics)
+}
+
+func TestDialStatsD(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	c, err := DialStatsD(pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	read := func() string {
+		buf := make([]byte, 1024)
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	require.NoError(t, c.Count("app.requests", 1, []string{"route:/a|b", "method:GET"}, 1))
+	assert.Equal(t, "app.requests:1|c|#route:/a_b,method:GET", read())
+	require.NoError(t, c.Timing("app.duration", 1500*time.Microsecond, nil, 0.5))
+	assert.Equal(t, "app.duration:1.5|ms|@0.5", read())
+}
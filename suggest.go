@@ -0,0 +1,122 @@
+package sandwich
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions limits the number of near-miss routes reported for a 404.
+const maxSuggestions = 5
+
+func (r *router) serveNotFoundWithSuggestions(w http.ResponseWriter, req *http.Request) {
+	suggestions := r.suggestions(req.Method, req.URL.Path)
+	e := Error{Code: http.StatusNotFound, ClientMsg: "Not found", LogMsg: "Not found"}
+	if len(suggestions) > 0 {
+		e.ClientMsg += ", did you mean:\n  " + strings.Join(suggestions, "\n  ")
+		e.LogMsg += ", did you mean: " + strings.Join(suggestions, ", ")
+	}
+	r.ServeError(nil, w, req, e)
+}
+
+// suggestions computes the routes that the client may have intended to request
+// when no route matches method and uri. Each suggestion is formatted as
+// "METHOD /pattern".
+func (r *router) suggestions(method, uri string) []string {
+	var found []string
+	seen := map[string]bool{}
+	add := func(h httpHandlerWithParams) {
+		if h, ok := h.(handler); ok {
+			if s := (route{h.method, h.pattern}).String(); !seen[s] {
+				seen[s] = true
+				found = append(found, s)
+			}
+		}
+	}
+
+	routes := r.routes()
+	methods := map[string]bool{}
+	for _, rt := range routes {
+		methods[rt.Method] = true
+	}
+
+	// Different method:
+	for m := range methods {
+		add(r.match(m, uri, Params{}))
+	}
+	// Trailing slash and case differences:
+	alternates := []string{strings.ToLower(uri)}
+	if strings.HasSuffix(uri, "/") {
+		alternates = append(alternates, strings.TrimSuffix(uri, "/"))
+	} else {
+		alternates = append(alternates, uri+"/")
+	}
+	for _, alt := range alternates {
+		if alt == uri {
+			continue
+		}
+		add(r.match(method, alt, Params{}))
+		for m := range methods {
+			add(r.match(m, alt, Params{}))
+		}
+	}
+	sort.Strings(found)
+
+	// Typos, compared against the route patterns directly:
+	type near struct {
+		suggestion string
+		dist       int
+	}
+	var nearby []near
+	maxDist := len(uri)/5 + 1
+	for _, rt := range routes {
+		s := rt.String()
+		if seen[s] {
+			continue
+		}
+		if d := levenshtein(strings.ToLower(uri), strings.ToLower(rt.Pattern)); d <= maxDist {
+			seen[s] = true
+			nearby = append(nearby, near{s, d})
+		}
+	}
+	sort.SliceStable(nearby, func(i, j int) bool { return nearby[i].dist < nearby[j].dist })
+	for _, n := range nearby {
+		found = append(found, n.suggestion)
+	}
+
+	if len(found) > maxSuggestions {
+		found = found[:maxSuggestions]
+	}
+	return found
+}
+
+// levenshtein computes the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(vals ...int) int {
+	m := vals[0]
+	for _, v := range vals[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,83 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutes(t *testing.T) {
+	r := BuildYourOwn()
+	r.Get("/", func() {})
+	r.Get("/users/:id", func() {})
+	r.Post("/users/", func() {})
+	r.Any("/files/:path*", func() {})
+	r.Get("/a/::literal", func() {})
+	api := r.SubRouter("/api")
+	api.Get("/status", func() {})
+
+	var got []string
+	for _, rt := range r.(*router).routes() {
+		got = append(got, rt.String())
+	}
+	assert.Equal(t, []string{
+		"GET /",
+		"GET /a/::literal",
+		"GET /api/status",
+		"* /files/:path*",
+		"POST /users/",
+		"GET /users/:id",
+	}, got)
+}
+
+func TestSuggestRoutes(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog)
+	r.Get("/users/:id", func() {})
+	r.Post("/users", func() {})
+	r.Get("/about/", func() {})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// Disabled by default.
+	w := serve("DELETE", "/users/5")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Not found\n", w.Body.String())
+
+	r.SuggestRoutes(true)
+	testCases := []struct{ method, path, expected string }{
+		{"DELETE", "/users/5", "Not found, did you mean:\n  GET /users/:id\n  POST /users\n"},
+		{"GET", "/about", "Not found, did you mean:\n  GET /about/\n"},
+		{"GET", "/ABOUT/", "Not found, did you mean:\n  GET /about/\n"},
+		{"GET", "/abuot/", "Not found, did you mean:\n  GET /about/\n"},
+		{"GET", "/users", "Not found, did you mean:\n  GET /users/:id\n  POST /users\n"},
+		{"GET", "/something/completely/different", "Not found\n"},
+	}
+	for _, test := range testCases {
+		w := serve(test.method, test.path)
+		assert.Equal(t, http.StatusNotFound, w.Code, "%s %s", test.method, test.path)
+		assert.Equal(t, test.expected, w.Body.String(), "%s %s", test.method, test.path)
+	}
+
+	// The error handling chain is reused until the middleware changes.
+	rt := r.(*router)
+	assert.Same(t, rt.errorHandler(), rt.errorHandler())
+	r.OnErr(HandleErrorJson)
+	w = serve("GET", "/about")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error":"Not found, did you mean:\n  GET /about/"}`, w.Body.String())
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein("abc", "abc"))
+	assert.Equal(t, 3, levenshtein("", "abc"))
+	assert.Equal(t, 1, levenshtein("abc", "abd"))
+	assert.Equal(t, 2, levenshtein("about", "abuot"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+}
@@ -0,0 +1,82 @@
+package sandwich
+
+import (
+	"os"
+	"sync"
+)
+
+// RequestTempFiles is a middleware wrap that provides a *TempFiles to
+// subsequent handlers for scratch space. The files and directories that it
+// allocates are removed when the request completes, regardless of errors. For
+// example:
+//
+//	mux.Post("/convert", sandwich.RequestTempFiles, func(tmp *sandwich.TempFiles, r *http.Request) error {
+//	    dir, err := tmp.Dir("convert-*")
+//	    ...
+//	})
+var RequestTempFiles = Wrap{Before: NewTempFiles, After: (*TempFiles).cleanup}
+
+// TempFiles allocates temporary files and directories on behalf of a single
+// request and removes them all when the request completes. It's safe for
+// concurrent use, e.g. by goroutines started with a Group.
+type TempFiles struct {
+	mu    sync.Mutex
+	files []*os.File
+	paths []string // in order of creation
+}
+
+// NewTempFiles returns an empty TempFiles. It's typically used via
+// RequestTempFiles, otherwise the caller is responsible for calling RemoveAll.
+func NewTempFiles() *TempFiles { return &TempFiles{} }
+
+// File creates a new temporary file in the default temporary directory, as
+// with os.CreateTemp. The file is closed and removed by RemoveAll, but it may
+// also be closed earlier.
+func (t *TempFiles) File(pattern string) (*os.File, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files = append(t.files, f)
+	t.paths = append(t.paths, f.Name())
+	return f, nil
+}
+
+// Dir creates a new temporary directory in the default temporary directory,
+// as with os.MkdirTemp. The directory and everything in it is removed by
+// RemoveAll.
+func (t *TempFiles) Dir(pattern string) (string, error) {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paths = append(t.paths, dir)
+	return dir, nil
+}
+
+// RemoveAll closes the files and removes all of the files and directories
+// that have been allocated, and returns the first error. Files that have
+// already been removed are ignored.
+func (t *TempFiles) RemoveAll() error {
+	t.mu.Lock()
+	files, paths := t.files, t.paths
+	t.files, t.paths = nil, nil
+	t.mu.Unlock()
+
+	for _, f := range files {
+		f.Close() // may have been closed already
+	}
+	var firstErr error
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := os.RemoveAll(paths[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *TempFiles) cleanup() { _ = t.RemoveAll() }
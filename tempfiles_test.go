@@ -0,0 +1,60 @@
+package sandwich
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTempFiles(t *testing.T) {
+	var paths []string
+	r := TheUsual()
+	r.Use(NoLog, RequestTempFiles)
+	r.Get("/scratch", func(tmp *TempFiles) error {
+		f, err := tmp.File("scratch-*.txt")
+		if err != nil {
+			return err
+		}
+		_, _ = f.WriteString("data")
+		dir, err := tmp.Dir("scratch-*")
+		if err != nil {
+			return err
+		}
+		nested := filepath.Join(dir, "nested")
+		if err := os.WriteFile(nested, []byte("x"), 0o600); err != nil {
+			return err
+		}
+		paths = append(paths, f.Name(), dir, nested)
+		return errors.New("fails after allocating")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/scratch", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Len(t, paths, 3)
+	for _, path := range paths {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "%s should be removed: %v", path, err)
+	}
+}
+
+func TestTempFilesRemoveAll(t *testing.T) {
+	tmp := NewTempFiles()
+	f, err := tmp.File("closed-early-*")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(f.Name()))
+	dir, err := tmp.Dir("dir-*")
+	require.NoError(t, err)
+
+	assert.NoError(t, tmp.RemoveAll())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, tmp.RemoveAll())
+}
@@ -0,0 +1,139 @@
+package sandwich
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// TestGenConfig configures Router.GenerateTests.
+type TestGenConfig struct {
+	// Package is the name of the package that the tests are generated for.
+	Package string
+	// NewRouter is a Go expression that returns the Router under test in the
+	// generated tests, e.g. "newServer().Router()". The default is "newRouter()".
+	NewRouter string
+}
+
+// GenerateTests writes a Go test file with a skeleton httptest-based test for
+// each route registered on the router and its sub-routers. Each test sends a
+// request to the route, lists the values provided by Set and SetAs as
+// commented-out overrides that may be replaced with fakes, and checks for a
+// 200 response as a placeholder. This is intended as a starting point for
+// route-level tests:
+//
+//	f, _ := os.Create("routes_test.go")
+//	mux.GenerateTests(f, sandwich.TestGenConfig{Package: "server"})
+func (r *router) GenerateTests(w io.Writer, cfg TestGenConfig) error {
+	if cfg.NewRouter == "" {
+		cfg.NewRouter = "newRouter()"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Skeleton route tests generated by sandwich. Edit as needed.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", cfg.Package)
+	fmt.Fprintf(&buf, "import (\n\t\"net/http\"\n\t\"net/http/httptest\"\n\t\"testing\"\n)\n")
+
+	used := map[string]int{}
+	for _, h := range r.handlers() {
+		name := testName(h.method, h.pattern)
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, used[name])
+		}
+		method := h.method
+		if method == "*" {
+			method = http.MethodGet
+		}
+		fmt.Fprintf(&buf, "\nfunc %s(t *testing.T) {\n", name)
+		fmt.Fprintf(&buf, "\tmux := %s\n", cfg.NewRouter)
+		fmt.Fprintf(&buf, "\tw := httptest.NewRecorder()\n")
+		fmt.Fprintf(&buf, "\treq := httptest.NewRequest(%q, %q, nil)\n", method, samplePath(h.pattern))
+		fmt.Fprintf(&buf, "\tmux.ServeHTTPWith(map[any]any{\n")
+		if types := h.Func.ValueTypes(); len(types) > 0 {
+			fmt.Fprintf(&buf, "\t\t// Replace provided values with fakes as needed:\n")
+			for _, t := range types {
+				fmt.Fprintf(&buf, "\t\t// %s: %s,\n", overrideKey(cfg.Package, t), fakeName(t))
+			}
+		}
+		fmt.Fprintf(&buf, "\t}, w, req)\n\n")
+		fmt.Fprintf(&buf, "\t// TODO: check the response.\n")
+		fmt.Fprintf(&buf, "\tif w.Code != http.StatusOK {\n")
+		fmt.Fprintf(&buf, "\t\tt.Errorf(\"%s %s: got status %%d, want %%d\", w.Code, http.StatusOK)\n",
+			h.method, h.pattern)
+		fmt.Fprintf(&buf, "\t}\n}\n")
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated tests: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// testName returns the test function name for a route, e.g. "TestGetUsersId"
+// for "GET /users/:id".
+func testName(method, pattern string) string {
+	if method == "*" {
+		method = "ANY"
+	}
+	name := "Test" + exportedName(strings.ToLower(method))
+	words := strings.FieldsFunc(pattern, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return name + "Root"
+	}
+	for _, word := range words {
+		name += exportedName(word)
+	}
+	return name
+}
+
+func exportedName(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// samplePath returns a request path that matches pattern, using the param
+// names as the param values.
+func samplePath(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, seg := range segments {
+		static, isStatic, param, _ := entryToInfo(seg)
+		if isStatic {
+			segments[i] = static
+		} else {
+			segments[i] = param
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// overrideKey returns the Go expression for the ServeHTTPWith key that
+// identifies t.
+func overrideKey(pkg string, t reflect.Type) string {
+	name := strings.TrimPrefix(t.String(), pkg+".")
+	switch t.Kind() {
+	case reflect.Interface:
+		return "(*" + name + ")(nil)"
+	case reflect.Ptr:
+		name = "*" + strings.TrimPrefix(t.Elem().String(), pkg+".")
+		return "(" + name + ")(nil)"
+	}
+	return "*new(" + name + ")"
+}
+
+// fakeName returns a placeholder variable name for a fake value of type t.
+func fakeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return "fakeValue"
+	}
+	return "fake" + exportedName(t.Name())
+}
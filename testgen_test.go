@@ -0,0 +1,46 @@
+package sandwich
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTests(t *testing.T) {
+	r := TheUsual()
+	r.SetAs(&bytes.Buffer{}, (*fmt.Stringer)(nil))
+	r.Set(&Error{})
+	r.Get("/", func(w http.ResponseWriter) {})
+	r.Get("/users/:id", func(w http.ResponseWriter) {})
+	r.Post("/users/:id", func(w http.ResponseWriter) {})
+	r.Get("/users/id", func(w http.ResponseWriter) {})
+	api := r.SubRouter("/api")
+	api.Any("/files/:path*", func(w http.ResponseWriter) {})
+
+	var buf bytes.Buffer
+	require.NoError(t, r.GenerateTests(&buf, TestGenConfig{Package: "sandwich"}))
+	src := buf.String()
+
+	_, err := parser.ParseFile(token.NewFileSet(), "routes_test.go", src, 0)
+	require.NoError(t, err, src)
+
+	assert.Contains(t, src, "package sandwich\n")
+	assert.Contains(t, src, "func TestGetRoot(t *testing.T) {")
+	assert.Contains(t, src, "func TestGetUsersId(t *testing.T) {")
+	assert.Contains(t, src, "func TestPostUsersId(t *testing.T) {")
+	assert.Contains(t, src, "func TestAnyApiFilesPath(t *testing.T) {")
+	assert.Contains(t, src, `httptest.NewRequest("POST", "/users/id", nil)`)
+	assert.Contains(t, src, `httptest.NewRequest("GET", "/api/files/path", nil)`)
+	assert.Contains(t, src, "mux := newRouter()")
+	assert.Contains(t, src, "// (*fmt.Stringer)(nil): fakeStringer,")
+	assert.Contains(t, src, "// (*Error)(nil): fakeError,")
+	assert.Contains(t, src, `t.Errorf("* /api/files/:path*: got status %d, want %d", w.Code, http.StatusOK)`)
+
+	assert.Contains(t, src, "func TestGetUsersId_2(t *testing.T) {")
+}
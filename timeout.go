@@ -0,0 +1,183 @@
+package sandwich
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/augustoroman/sandwich/chain"
+)
+
+// Timeout returns a middleware that limits the duration of the subsequent
+// handlers to d. When d elapses, the request's context is canceled and, unless
+// the handlers have already started the response, the client is sent a 503
+// immediately, even if the handlers don't respect the context. For example:
+//
+//	mux.Use(sandwich.Timeout(10 * time.Second))
+//
+// After the deadline, writes to the response fail with http.ErrHandlerTimeout,
+// so that the handlers and any goroutines they started can't write to the
+// response concurrently with the 503 or corrupt it. The logged status code is
+// 503. If the handlers had already started the response, it's truncated
+// instead.
+//
+// No further handlers are called once the deadline passes, but since the
+// running handler can't be forcibly stopped, the request doesn't complete
+// until it returns, and it should still respect the context. Timeout doesn't
+// depend on that to respond to the client, though. It requires the
+// *ResponseWriter provided by WrapResponseWriter, as TheUsual does.
+func Timeout(d time.Duration) ChainMutation { return timeout(d) }
+
+type timeout time.Duration
+
+func (t timeout) Apply(c chain.Func) chain.Func {
+	return c.Then(t.start).Defer((*timeoutWriter).finish)
+}
+
+func (t timeout) start(w *ResponseWriter, r *http.Request) (*http.Request, *timeoutWriter) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(t))
+	tw := &timeoutWriter{
+		w:       w.ResponseWriter,
+		rw:      w,
+		h:       w.Header().Clone(),
+		ctx:     ctx,
+		cancel:  cancel,
+		started: w.Code != 0,
+	}
+	w.ResponseWriter = tw
+	tw.timer = time.AfterFunc(time.Duration(t), tw.timeout)
+	return r.WithContext(ctx), tw
+}
+
+// timeoutWriter guards the underlying http.ResponseWriter of a Timeout: it
+// serializes the writes of the handlers with the 503 response sent when the
+// deadline passes, and discards the writes that follow it.
+type timeoutWriter struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter // the underlying writer
+	rw     *ResponseWriter     // the ResponseWriter that wraps this
+	h      http.Header         // copied to w when the response starts
+	ctx    context.Context
+	cancel context.CancelFunc
+	timer  *time.Timer
+
+	started  bool // whether the handlers started the response
+	timedOut bool // whether the deadline passed before finish
+	done     bool // whether finish was called
+	size     int  // the size of the 503 response body
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.started {
+		return tw.w.Header()
+	}
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.checkDeadline()
+	if !tw.timedOut && !tw.started {
+		tw.writeHeader(code)
+	}
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	dst := tw.w.Header()
+	for k := range dst {
+		delete(dst, k)
+	}
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+	tw.started = true
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.checkDeadline()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.started {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.checkDeadline()
+	if tw.timedOut {
+		return
+	}
+	if !tw.started {
+		tw.writeHeader(http.StatusOK)
+	}
+	if flusher, ok := tw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// timeout is called when the deadline passes.
+func (tw *timeoutWriter) timeout() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.done && !tw.timedOut {
+		tw.expire()
+	}
+}
+
+// checkDeadline expires the response if the deadline passed but the timer
+// hasn't fired yet, so that handlers that respond to the canceled context,
+// e.g. with an error, can't preempt the 503.
+func (tw *timeoutWriter) checkDeadline() {
+	if !tw.timedOut && tw.ctx.Err() == context.DeadlineExceeded {
+		tw.expire()
+	}
+}
+
+// expire sends the 503, unless the response was started. It must be called
+// with mu held.
+func (tw *timeoutWriter) expire() {
+	tw.timedOut = true
+	if tw.started {
+		return
+	}
+	msg := http.StatusText(http.StatusServiceUnavailable) + "\n"
+	h := tw.w.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	h.Set(headerContentType, "text/plain; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(msg)))
+	h.Set("X-Content-Type-Options", "nosniff")
+	tw.w.WriteHeader(http.StatusServiceUnavailable)
+	tw.size, _ = tw.w.Write([]byte(msg))
+	if flusher, ok := tw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// finish stops the timer when the handlers complete and records the 503 in
+// the ResponseWriter if it was sent. Writes from the remaining deferred
+// handlers, such as closing a Gzip stream, are still allowed unless the
+// deadline passed.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timer.Stop()
+	tw.cancel()
+	tw.done = true
+	if tw.timedOut && !tw.started {
+		tw.rw.Code, tw.rw.Size = http.StatusServiceUnavailable, tw.size
+	}
+}
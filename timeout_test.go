@@ -0,0 +1,70 @@
+package sandwich
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	defer func(orig func(LogEntry)) { WriteLog = orig }(WriteLog)
+	var logged LogEntry
+	WriteLog = func(e LogEntry) { logged = e }
+
+	release := make(chan struct{})
+	lateWrite := make(chan error, 1)
+	r := TheUsual()
+	r.Use(Timeout(20 * time.Millisecond))
+	r.Get("/fast", func(w http.ResponseWriter) {
+		w.Header().Set("X-Fast", "yes")
+		w.Write([]byte("done"))
+	})
+	r.Get("/stuck", func(w http.ResponseWriter) {
+		w.Header().Set("X-Stuck", "yes")
+		<-release
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	})
+	r.Get("/ctx", func(w http.ResponseWriter, r *http.Request) error {
+		<-r.Context().Done()
+		return r.Context().Err()
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "done", w.Body.String())
+	assert.Equal(t, "yes", w.Header().Get("X-Fast"))
+
+	// The 503 is sent while the handler is still running.
+	w = httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/stuck", nil))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-served:
+		t.Fatal("request completed before the handler returned")
+	default:
+	}
+	close(release)
+	<-served
+	assert.Equal(t, http.ErrHandlerTimeout, <-lateWrite)
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "Service Unavailable\n", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Stuck"))
+	assert.Equal(t, 503, logged.StatusCode)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/ctx", nil))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "Service Unavailable\n", w.Body.String())
+	assert.Equal(t, 503, logged.StatusCode)
+	assert.Contains(t, logged.Error.Error(), context.DeadlineExceeded.Error())
+}
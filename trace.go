@@ -0,0 +1,202 @@
+package sandwich
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceID identifies a distributed trace, see TraceContext.
+type TraceID [16]byte
+
+// SpanID identifies a span, i.e. the handling of a request by one service,
+// within a trace, see TraceContext.
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid reports whether the ID is not all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// TraceContext is the W3C Trace Context of a request, see
+// https://www.w3.org/TR/trace-context/. It's provided by PropagateTrace.
+type TraceContext struct {
+	TraceID TraceID
+	// ParentID is the span of the caller, or zero if the trace was started by
+	// this request.
+	ParentID SpanID
+	// SpanID is the span of this request, which is the parent of the outgoing
+	// requests.
+	SpanID SpanID
+	// Flags are the trace flags, see Sampled.
+	Flags byte
+	// State is the vendor-specific tracestate, which is propagated unchanged.
+	State string
+}
+
+const (
+	headerTraceparent = "Traceparent"
+	headerTracestate  = "Tracestate"
+
+	traceFlagSampled = 0x01
+)
+
+// Sampled reports whether the caller may have recorded the trace.
+func (tc TraceContext) Sampled() bool { return tc.Flags&traceFlagSampled != 0 }
+
+// Traceparent returns the value of the traceparent header of outgoing requests,
+// e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// Inject sets the traceparent and tracestate headers of an outgoing request so
+// that its span is a child of this request's span.
+func (tc TraceContext) Inject(h http.Header) {
+	h.Set(headerTraceparent, tc.Traceparent())
+	if tc.State != "" {
+		h.Set(headerTracestate, tc.State)
+	} else {
+		h.Del(headerTracestate)
+	}
+}
+
+// TracedClient is an *http.Client that injects the TraceContext of the current
+// request into its outgoing requests. It's provided by PropagateTrace.
+type TracedClient struct{ *http.Client }
+
+// PropagateTrace returns a middleware handler that provides the TraceContext of
+// the request, parsed from its traceparent and tracestate headers, to
+// subsequent handlers. If the traceparent header is missing or invalid, a new
+// sampled trace is started. A new span ID is generated for each request, and
+// the trace and span IDs are recorded as the "trace_id" and "span_id" notes of
+// the LogEntry so that the logs can be correlated with the traces of other
+// services.
+//
+// A TracedClient is also provided that sends the requests of client, or of
+// http.DefaultClient if nil, with the trace headers of the request, e.g.:
+//
+//	mux.Use(sandwich.PropagateTrace(nil))
+//	mux.Get("/orders/:id", func(c sandwich.TracedClient, ...) error {
+//	    resp, err := c.Get(inventoryURL) // continues the trace
+//	    ...
+//	})
+//
+// The TraceContext is also added to the context of the request, see
+// TraceFromContext and CorrelatedClient.
+func PropagateTrace(client *http.Client) func(r *http.Request, e *LogEntry) (TraceContext, TracedClient, *http.Request) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(r *http.Request, e *LogEntry) (TraceContext, TracedClient, *http.Request) {
+		tc, ok := parseTraceparent(r.Header.Get(headerTraceparent))
+		if ok {
+			tc.State = strings.Join(r.Header.Values(headerTracestate), ",")
+		} else {
+			tc = TraceContext{Flags: traceFlagSampled}
+			randomID(tc.TraceID[:])
+		}
+		randomID(tc.SpanID[:])
+		e.Note["trace_id"] = tc.TraceID.String()
+		e.Note["span_id"] = tc.SpanID.String()
+
+		r = r.WithContext(ContextWithTrace(r.Context(), tc))
+		return tc, tc.Client(client), r
+	}
+}
+
+// Client returns a TracedClient that sends the requests of client, or of
+// http.DefaultClient if nil, as children of the span of tc.
+func (tc TraceContext) Client(client *http.Client) TracedClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	traced := *client
+	traced.Transport = traceTransport{client.Transport, tc}
+	return TracedClient{&traced}
+}
+
+type traceContextKey struct{}
+
+// ContextWithTrace returns a copy of ctx with tc, see TraceFromContext. It
+// allows tracing middleware that replaces the span of the request, such as
+// github.com/augustoroman/sandwich/otel, to update the TraceContext provided
+// by PropagateTrace.
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceFromContext returns the TraceContext provided by PropagateTrace for the
+// request with the context ctx, or a derived context.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// parseTraceparent parses a traceparent header. Headers of future versions are
+// accepted if they start with the fields of version 00, as the spec requires.
+func parseTraceparent(s string) (tc TraceContext, ok bool) {
+	const size = 55 // len("00-" + 32 + "-" + 16 + "-" + 2)
+	if len(s) < size || (len(s) > size && s[size] != '-') {
+		return tc, false
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, false
+	}
+	var version, flags [1]byte
+	if !decodeLowerHex(version[:], s[0:2]) || version[0] == 0xff ||
+		(version[0] == 0 && len(s) != size) ||
+		!decodeLowerHex(tc.TraceID[:], s[3:35]) ||
+		!decodeLowerHex(tc.ParentID[:], s[36:52]) ||
+		!decodeLowerHex(flags[:], s[53:55]) {
+		return tc, false
+	}
+	tc.Flags = flags[0]
+	return tc, tc.TraceID.IsValid() && tc.ParentID.IsValid()
+}
+
+// decodeLowerHex decodes s into dst, which must be half its length. Only
+// lowercase hex digits are accepted.
+func decodeLowerHex(dst []byte, s string) bool {
+	if strings.ToLower(s) != s {
+		return false
+	}
+	n, err := hex.Decode(dst, []byte(s))
+	return err == nil && n == len(dst)
+}
+
+func randomID(dst []byte) {
+	for {
+		if _, err := rand.Read(dst); err != nil {
+			panic(err) // crypto/rand never fails on supported platforms
+		}
+		for _, b := range dst {
+			if b != 0 {
+				return
+			}
+		}
+	}
+}
+
+// traceTransport injects the trace headers into outgoing requests.
+type traceTransport struct {
+	base http.RoundTripper
+	tc   TraceContext
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req = req.Clone(req.Context()) // a RoundTripper must not modify the request
+	t.tc.Inject(req.Header)
+	return base.RoundTrip(req)
+}
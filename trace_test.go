@@ -0,0 +1,95 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tc, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", tc.ParentID.String())
+	assert.True(t, tc.Sampled())
+
+	_, ok = parseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	assert.True(t, ok, "future versions may have more fields")
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		_, ok := parseTraceparent(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestPropagateTrace(t *testing.T) {
+	var downstream http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = r.Header
+	}))
+	defer backend.Close()
+
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	var logged LogEntry
+	WriteLog = func(e LogEntry) { logged = e }
+
+	var got TraceContext
+	r := TheUsual()
+	r.Use(PropagateTrace(nil))
+	r.Get("/", func(tc TraceContext, c TracedClient) error {
+		got = tc
+		resp, err := c.Get(backend.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Add("tracestate", "congo=t61rcWkgMzE")
+	req.Header.Add("tracestate", "rojo=00f067aa0ba902b7")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code, w.Body.String())
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", got.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", got.ParentID.String())
+	assert.True(t, got.SpanID.IsValid())
+	assert.NotEqual(t, got.ParentID, got.SpanID)
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", got.State)
+
+	assert.Equal(t, got.Traceparent(), downstream.Get("traceparent"))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+got.SpanID.String()+"-01", downstream.Get("traceparent"))
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", downstream.Get("tracestate"))
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", logged.Note["trace_id"])
+	assert.Equal(t, got.SpanID.String(), logged.Note["span_id"])
+
+	// Without a valid traceparent, a new trace is started.
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "garbage")
+	req.Header.Set("tracestate", "congo=t61rcWkgMzE")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, got.TraceID.IsValid())
+	assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", got.TraceID.String())
+	assert.False(t, got.ParentID.IsValid())
+	assert.True(t, got.Sampled())
+	assert.Equal(t, "", got.State)
+	assert.Equal(t, got.Traceparent(), downstream.Get("traceparent"))
+	assert.Equal(t, "", downstream.Get("tracestate"))
+}
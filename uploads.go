@@ -0,0 +1,253 @@
+package sandwich
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// UploadOptions configures the limits of AcceptUploads.
+type UploadOptions struct {
+	// MaxFileSize is the maximum size of each uploaded file, in bytes. If zero,
+	// 32 MB is used.
+	MaxFileSize int64
+	// MaxFiles is the maximum number of uploaded files. If zero, 10 is used.
+	MaxFiles int
+	// AllowedTypes are the media types of the files that are accepted, e.g.
+	// "image/png" or "image/*". The type of each file is detected from its
+	// content with http.DetectContentType, since the Content-Type sent by the
+	// client can't be trusted. If empty, all types are accepted.
+	AllowedTypes []string
+}
+
+const (
+	defaultMaxFileSize = 32 << 20
+	defaultMaxFiles    = 10
+)
+
+// AcceptUploads returns a middleware Wrap that reads a multipart/form-data
+// request body and provides its files and values to subsequent handlers as
+// *Uploads. The files are streamed to temporary files, which are removed when
+// the request completes, regardless of errors. For example:
+//
+//	mux.Post("/avatar", sandwich.AcceptUploads(sandwich.UploadOptions{
+//	    MaxFileSize:  2 << 20,
+//	    MaxFiles:     1,
+//	    AllowedTypes: []string{"image/png", "image/jpeg"},
+//	}), func(u *sandwich.Uploads, user *User) error {
+//	    avatar := u.File("avatar")
+//	    if avatar == nil {
+//	        return sandwich.BadRequest("Missing avatar")
+//	    }
+//	    return avatar.SaveTo(avatarPath(user))
+//	})
+//
+// Requests that aren't multipart fail with a 415, files that are too large
+// with a 413, files of other types with a 415, and too many files or malformed
+// bodies with a 400. The non-file values are limited to 1 MB in total. To limit
+// the size of the whole body, add MaxBodySize before.
+func AcceptUploads(opts UploadOptions) Wrap {
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = defaultMaxFileSize
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = defaultMaxFiles
+	}
+	return Wrap{Before: opts.read, After: (*Uploads).cleanup}
+}
+
+// Uploads are the files and values of a multipart/form-data request, as read
+// by AcceptUploads.
+type Uploads struct {
+	// Values are the form values that aren't files.
+	Values url.Values
+	// Files are the uploaded files, in the order of the request body.
+	Files []*Upload
+
+	tmp *TempFiles
+}
+
+// File returns the first file uploaded as the named form field, or nil if
+// there is none.
+func (u *Uploads) File(field string) *Upload {
+	for _, f := range u.Files {
+		if f.Field == field {
+			return f
+		}
+	}
+	return nil
+}
+
+// RemoveAll removes the temporary files of the uploads. This is done
+// automatically when the request completes.
+func (u *Uploads) RemoveAll() error { return u.tmp.RemoveAll() }
+
+func (u *Uploads) cleanup() { _ = u.RemoveAll() }
+
+// Upload is an uploaded file, which is stored in a temporary file until the
+// request completes.
+type Upload struct {
+	// Field is the name of the form field of the file.
+	Field string
+	// Filename is the file name sent by the client. It must not be trusted
+	// as a path, e.g. use filepath.Base and validate it.
+	Filename string
+	// ContentType is the media type detected from the content of the file.
+	ContentType string
+	// Size is the size of the file, in bytes.
+	Size int64
+
+	path string // of the temporary file
+}
+
+// Open opens the uploaded file for reading. The caller must close it.
+func (f *Upload) Open() (*os.File, error) { return os.Open(f.path) }
+
+// SaveTo copies the uploaded file to path, which is created or truncated.
+func (f *Upload) SaveTo(path string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// read streams the parts of the multipart body to temporary files.
+func (opts UploadOptions) read(r *http.Request) (*Uploads, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, Error{
+			Code:      http.StatusUnsupportedMediaType,
+			ClientMsg: http.StatusText(http.StatusUnsupportedMediaType),
+			LogMsg:    "Expected a multipart/form-data body",
+			Cause:     err,
+		}
+	}
+	u := &Uploads{Values: url.Values{}, tmp: NewTempFiles()}
+	valueBytes := int64(defaultMaxBodyMemory)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return u, nil
+		}
+		if err == nil {
+			if part.FileName() == "" {
+				err = u.readValue(part, &valueBytes)
+			} else {
+				err = u.readFile(part, opts)
+			}
+			part.Close()
+		}
+		if err != nil {
+			u.RemoveAll()
+			return nil, uploadError(err)
+		}
+	}
+}
+
+func (u *Uploads) readValue(part *multipart.Part, remaining *int64) error {
+	data, err := io.ReadAll(io.LimitReader(part, *remaining+1))
+	if err != nil {
+		return err
+	}
+	if *remaining -= int64(len(data)); *remaining < 0 {
+		return uploadTooLarge("Form values too large")
+	}
+	u.Values.Add(part.FormName(), string(data))
+	return nil
+}
+
+func (u *Uploads) readFile(part *multipart.Part, opts UploadOptions) error {
+	if len(u.Files) >= opts.MaxFiles {
+		return BadRequest(fmt.Sprintf("Too many files, at most %d are allowed", opts.MaxFiles))
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	if len(opts.AllowedTypes) > 0 && !matchesContentType(contentType, opts.AllowedTypes) {
+		return Error{
+			Code:      http.StatusUnsupportedMediaType,
+			ClientMsg: fmt.Sprintf("Unsupported file type of %q", part.FileName()),
+			LogMsg:    fmt.Sprintf("Rejected upload of type %q", contentType),
+		}
+	}
+	f, err := u.tmp.File("upload-*")
+	if err != nil {
+		return Internal(err)
+	}
+	tw := &tempWriter{f: f}
+	size, err := io.Copy(tw, io.MultiReader(
+		bytes.NewReader(head), io.LimitReader(part, opts.MaxFileSize+1-int64(n))))
+	if tw.err != nil {
+		return Internal(tw.err)
+	} else if err != nil {
+		return err
+	}
+	if size > opts.MaxFileSize {
+		return uploadTooLarge(fmt.Sprintf("File %q too large", part.FileName()))
+	}
+	u.Files = append(u.Files, &Upload{
+		Field:       part.FormName(),
+		Filename:    part.FileName(),
+		ContentType: contentType,
+		Size:        size,
+		path:        f.Name(),
+	})
+	if err := f.Close(); err != nil {
+		return Internal(err)
+	}
+	return nil
+}
+
+// tempWriter records the failures to write a temporary file, which are server
+// errors unlike the failures to read the request body.
+type tempWriter struct {
+	f   *os.File
+	err error
+}
+
+func (w *tempWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func uploadTooLarge(msg string) Error {
+	return Error{Code: http.StatusRequestEntityTooLarge, ClientMsg: msg, LogMsg: msg}
+}
+
+// uploadError converts a failure to read a multipart body into a 400 Error,
+// unless it's already an Error or the body was too large.
+func uploadError(err error) error {
+	var e Error
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &e) || errors.As(err, &tooLarge) {
+		return err
+	}
+	return Error{
+		Code:      http.StatusBadRequest,
+		ClientMsg: "Malformed multipart body",
+		LogMsg:    "Malformed multipart body",
+		Cause:     err,
+	}
+}
@@ -0,0 +1,112 @@
+package sandwich
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A")
+
+func TestAcceptUploads(t *testing.T) {
+	var tempPaths []string
+	dir := t.TempDir()
+	mux := TheUsual()
+	mux.Use(NoLog)
+	mux.Post("/upload", AcceptUploads(UploadOptions{
+		MaxFileSize:  64,
+		MaxFiles:     2,
+		AllowedTypes: []string{"image/*", "text/plain"},
+	}), func(w http.ResponseWriter, u *Uploads) error {
+		for _, f := range u.Files {
+			tempPaths = append(tempPaths, f.path)
+		}
+		avatar := u.File("avatar")
+		if avatar == nil {
+			return BadRequest("Missing avatar")
+		}
+		if err := avatar.SaveTo(filepath.Join(dir, "avatar.png")); err != nil {
+			return err
+		}
+		r, err := u.File("notes").Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		notes, _ := io.ReadAll(r)
+		w.Write([]byte(u.Values.Get("title") + ": " + avatar.Filename + " " +
+			avatar.ContentType + ", " + string(notes)))
+		return nil
+	})
+
+	type file struct{ field, name, content string }
+	upload := func(values map[string]string, files ...file) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range values {
+			mw.WriteField(k, v)
+		}
+		for _, f := range files {
+			fw, err := mw.CreateFormFile(f.field, f.name)
+			require.NoError(t, err)
+			fw.Write([]byte(f.content))
+		}
+		mw.Close()
+		req := httptest.NewRequest("POST", "/upload", &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	png := string(pngHeader) + "pixels"
+
+	w := upload(map[string]string{"title": "Me"},
+		file{"avatar", "me.png", png}, file{"notes", "notes.txt", "hello"})
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	assert.Equal(t, "Me: me.png image/png, hello", w.Body.String())
+	saved, err := os.ReadFile(filepath.Join(dir, "avatar.png"))
+	require.NoError(t, err)
+	assert.Equal(t, png, string(saved))
+	require.Len(t, tempPaths, 2)
+	for _, path := range tempPaths {
+		assert.NoFileExists(t, path)
+	}
+
+	testCases := []struct {
+		files []file
+		code  int
+		body  string
+	}{
+		{[]file{{"avatar", "big.png", png + strings.Repeat("x", 64)}}, 413, `File "big.png" too large`},
+		{[]file{{"avatar", "x.png", "%PDF-1.4"}}, 415, `Unsupported file type of "x.png"`},
+		{[]file{{"a", "a.txt", "a"}, {"b", "b.txt", "b"}, {"c", "c.txt", "c"}}, 400, "Too many files"},
+		{[]file{{"notes", "notes.txt", "hello"}}, 400, "Missing avatar"},
+	}
+	for _, test := range testCases {
+		w := upload(nil, test.files...)
+		assert.Equal(t, test.code, w.Code, "%+v", test)
+		assert.Contains(t, w.Body.String(), test.body, "%+v", test)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/upload", strings.NewReader("a=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/upload", strings.NewReader("--x\r\nbroken"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Malformed multipart body")
+}
@@ -0,0 +1,50 @@
+package sandwich
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Validator is implemented by request types that check their own values. Bind
+// and JSONBody call Validate after decoding the request, so that handlers only
+// receive valid values, e.g.:
+//
+//	func (u NewUser) Validate() error {
+//	    var invalid sandwich.ValidationError
+//	    if u.Name == "" {
+//	        invalid.Add("name", "is required")
+//	    }
+//	    if u.Age < 0 {
+//	        invalid.Add("age", "must be positive")
+//	    }
+//	    return invalid.Err()
+//	}
+//
+// Validate may be implemented with a value or pointer receiver.
+type Validator interface {
+	Validate() error
+}
+
+// validate calls the Validate method of *val, if any. A ValidationError or Error
+// is returned as is, other errors are converted to a 422 Error with the error
+// message as the client message.
+func validate[T any](val *T) error {
+	v, ok := any(*val).(Validator)
+	if !ok {
+		if v, ok = any(val).(Validator); !ok {
+			return nil
+		}
+	}
+	err := v.Validate()
+	var invalid ValidationError
+	var e Error
+	if err == nil || errors.As(err, &invalid) || errors.As(err, &e) {
+		return err
+	}
+	return Error{
+		Code:      http.StatusUnprocessableEntity,
+		ClientMsg: err.Error(),
+		LogMsg:    "Validation failed",
+		Cause:     err,
+	}
+}
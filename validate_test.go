@@ -0,0 +1,78 @@
+package sandwich
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validUser struct {
+	Name string `json:"name" query:"name"`
+	Age  int    `json:"age" query:"age"`
+}
+
+func (u validUser) Validate() error {
+	var invalid ValidationError
+	if u.Name == "" {
+		invalid.Add("name", "is required")
+	}
+	if u.Age < 0 {
+		invalid.Add("age", "must be positive")
+	}
+	return invalid.Err()
+}
+
+type validRange struct {
+	Min int `query:"min" json:"min"`
+	Max int `query:"max" json:"max"`
+}
+
+func (r *validRange) Validate() error {
+	if r.Min > r.Max {
+		return errors.New("min must not exceed max")
+	}
+	return nil
+}
+
+func TestValidateAfterBinding(t *testing.T) {
+	mux := TheUsual()
+	mux.Use(NoLog)
+	mux.OnErr(HandleErrorJson)
+	done := func(respond Respond) error { return respond.NoContent() }
+	mux.Get("/bind/user", Bind[validUser](), done)
+	mux.Get("/bind/range", Bind[*validRange](), done)
+	mux.Post("/json/user", JSONBody[*validUser](JSONOptions{}), done)
+	mux.Post("/json/range", JSONBody[validRange](JSONOptions{}), done)
+
+	testCases := []struct {
+		method, target, body string
+		code                 int
+		resp                 string
+	}{
+		{"GET", "/bind/user?name=ann&age=3", "", 204, ``},
+		{"GET", "/bind/user?age=-1", "", 422,
+			`{"error":"Unprocessable Entity","errors":{"age":"must be positive","name":"is required"}}`},
+		{"GET", "/bind/user?age=x", "", 422,
+			`{"error":"Unprocessable Entity","errors":{"age":"must be an integer"}}`},
+		{"GET", "/bind/range?min=1&max=2", "", 204, ``},
+		{"GET", "/bind/range?min=3&max=2", "", 422, `{"error":"min must not exceed max"}`},
+		{"POST", "/json/user", `{"name":"ann"}`, 204, ``},
+		{"POST", "/json/user", `{"age":-5}`, 422,
+			`{"error":"Unprocessable Entity","errors":{"age":"must be positive","name":"is required"}}`},
+		{"POST", "/json/user", `null`, 400, `{"error":"Missing JSON body"}`},
+		{"POST", "/json/range", `{"min":5}`, 422, `{"error":"min must not exceed max"}`},
+		{"POST", "/json/range", `{"min":5,"max":"x"}`, 422,
+			`{"error":"Unprocessable Entity","errors":{"max":"must be an integer"}}`},
+	}
+	for _, test := range testCases {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+		assert.Equal(t, test.code, w.Code, "%+v", test)
+		if test.resp != "" {
+			assert.JSONEq(t, test.resp, w.Body.String(), "%+v", test)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package sandwich
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync/atomic"
+)
+
+// Variant is the name of the experiment variant that a request was assigned
+// to by Experiment.Assign.
+type Variant string
+
+// Experiment deterministically assigns requests to one of several named
+// variants, so that product experiments can be run and measured without an
+// external SDK. Requests with the same key, such as a user ID or a cookie
+// value, are always assigned to the same variant.
+//
+// For example:
+//
+//	checkout := sandwich.NewExperiment("checkout", sandwich.CookieKey("session"),
+//	    "control", "one-page")
+//	mux.Get("/checkout", checkout.Assign, func(w http.ResponseWriter, v sandwich.Variant) {
+//	    if v == "one-page" {
+//	        ...
+//	    }
+//	})
+//
+// The variant is recorded as a note in the LogEntry, and the number of
+// requests assigned to each variant is available from Counts.
+type Experiment struct {
+	name     string
+	key      func(r *http.Request) string
+	variants []string
+	counts   []uint64 // per variant, updated atomically
+}
+
+// NewExperiment creates an Experiment with the given variants. key returns the
+// identifier used to bucket a request, e.g. the ID of the authenticated user.
+// If key is nil or returns an empty string, the remote IP is used. It panics
+// if no variants are given.
+func NewExperiment(name string, key func(r *http.Request) string, variants ...string) *Experiment {
+	if len(variants) == 0 {
+		panic(fmt.Errorf("experiment %q has no variants", name))
+	}
+	return &Experiment{
+		name:     name,
+		key:      key,
+		variants: variants,
+		counts:   make([]uint64, len(variants)),
+	}
+}
+
+// CookieKey returns an Experiment key function that buckets requests by the
+// value of the named cookie.
+func CookieKey(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		c, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}
+}
+
+// Name returns the name of the experiment.
+func (x *Experiment) Name() string { return x.name }
+
+// Assign is a middleware handler that provides the Variant for the request.
+// The variant is recorded in the log entry note named after the experiment.
+func (x *Experiment) Assign(r *http.Request, e *LogEntry) Variant {
+	i := x.bucket(r)
+	atomic.AddUint64(&x.counts[i], 1)
+	e.Note["experiment:"+x.name] = x.variants[i]
+	return Variant(x.variants[i])
+}
+
+// Counts returns the number of requests that have been assigned to each
+// variant.
+func (x *Experiment) Counts() map[string]uint64 {
+	counts := make(map[string]uint64, len(x.variants))
+	for i, v := range x.variants {
+		counts[v] += atomic.LoadUint64(&x.counts[i])
+	}
+	return counts
+}
+
+// bucket returns the index of the variant for the request.
+func (x *Experiment) bucket(r *http.Request) int {
+	var key string
+	if x.key != nil {
+		key = x.key(r)
+	}
+	if key == "" {
+		key = remoteIp(r)
+	}
+	// Include the experiment name so that different experiments are bucketed
+	// independently.
+	h := fnv.New64a()
+	h.Write([]byte(x.name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(len(x.variants)))
+}
@@ -0,0 +1,53 @@
+package sandwich
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExperiment(t *testing.T) {
+	var log LogEntry
+	orig := WriteLog
+	defer func() { WriteLog = orig }()
+	WriteLog = func(e LogEntry) { log = e }
+
+	x := NewExperiment("checkout", CookieKey("session"), "control", "one-page")
+	r := TheUsual()
+	r.Get("/checkout", x.Assign, func(w http.ResponseWriter, v Variant) {
+		_, _ = w.Write([]byte(v))
+	})
+
+	get := func(session string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/checkout", nil)
+		if session != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		}
+		r.ServeHTTP(w, req)
+		assert.Equal(t, w.Body.String(), log.Note["experiment:checkout"])
+		return w.Body.String()
+	}
+
+	// Assignment is deterministic and both variants are used.
+	seen := map[string]int{}
+	for i := 0; i < 100; i++ {
+		session := fmt.Sprint("user-", i)
+		v := get(session)
+		assert.Equal(t, v, get(session), session)
+		seen[v]++
+	}
+	assert.Len(t, seen, 2)
+	assert.Equal(t, map[string]uint64{
+		"control":  uint64(2 * seen["control"]),
+		"one-page": uint64(2 * seen["one-page"]),
+	}, x.Counts())
+
+	// Without a cookie, requests are bucketed by remote IP.
+	assert.Equal(t, get(""), get(""))
+
+	assert.Panics(t, func() { NewExperiment("empty", nil) })
+}
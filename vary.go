@@ -0,0 +1,53 @@
+package sandwich
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AddVary adds the named request headers to the Vary header of a response,
+// which tells caches that the response depends on the values of those request
+// headers. Middleware that keys its behavior on request headers, such as
+// Accept-Encoding, Accept-Language, Origin, or Cookie, should declare them
+// using AddVary rather than setting the Vary header directly so that the
+// declarations of several middleware are combined into a single Vary header
+// without duplicates. If any of the names is "*", the Vary header is "*".
+//
+// The ResponseWriter provided by TheUsual also combines Vary headers that were
+// added directly when the response header is written, but it can't recover
+// values that were replaced with Header().Set.
+func AddVary(h http.Header, names ...string) {
+	vals := h.Values(headerVary)
+	if len(vals) == 0 && len(names) == 0 {
+		return
+	}
+	var combined []string
+	seen := map[string]bool{}
+	for _, val := range append(vals[:len(vals):len(vals)], names...) {
+		for _, name := range strings.Split(val, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				h.Set(headerVary, "*")
+				return
+			}
+			if name = http.CanonicalHeaderKey(name); name != "" && !seen[name] {
+				seen[name] = true
+				combined = append(combined, name)
+			}
+		}
+	}
+	if len(combined) == 0 {
+		h.Del(headerVary)
+		return
+	}
+	h.Set(headerVary, strings.Join(combined, ", "))
+}
+
+// VaryOn returns a middleware handler that declares that subsequent handlers
+// respond differently depending on the named request headers, see AddVary. For
+// example, for routes that render personalized pages:
+//
+//	mux.Get("/", sandwich.VaryOn("Cookie"), renderHome)
+func VaryOn(names ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) { AddVary(w.Header(), names...) }
+}
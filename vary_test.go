@@ -0,0 +1,47 @@
+package sandwich
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddVary(t *testing.T) {
+	testCases := []struct {
+		existing []string
+		names    []string
+		expected []string
+	}{
+		{nil, nil, nil},
+		{nil, []string{"accept-encoding"}, []string{"Accept-Encoding"}},
+		{[]string{"Accept-Encoding"}, []string{"Origin", "accept-encoding"}, []string{"Accept-Encoding, Origin"}},
+		{[]string{"Accept-Encoding, Cookie", "origin"}, nil, []string{"Accept-Encoding, Cookie, Origin"}},
+		{[]string{"Accept-Encoding"}, []string{"*"}, []string{"*"}},
+		{[]string{" , "}, nil, nil},
+	}
+	for _, test := range testCases {
+		h := http.Header{}
+		for _, v := range test.existing {
+			h.Add("Vary", v)
+		}
+		AddVary(h, test.names...)
+		assert.Equal(t, test.expected, h.Values("Vary"), "%+v", test)
+	}
+}
+
+func TestVaryCombinedByMiddleware(t *testing.T) {
+	r := TheUsual()
+	r.Use(NoLog, Gzip, VaryOn("Cookie", "Accept-Encoding"))
+	r.Get("/", func(w http.ResponseWriter) {
+		w.Header().Add("Vary", "origin")
+		_, _ = w.Write([]byte("hi"))
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, []string{"Accept-Encoding, Cookie, Origin"}, w.Header().Values("Vary"))
+}
@@ -2,6 +2,7 @@ package sandwich
 
 import (
 	"net/http"
+	"runtime"
 
 	"github.com/augustoroman/sandwich/chain"
 )
@@ -41,6 +42,23 @@ func (w Wrap) Apply(c chain.Func) chain.Func {
 	return c.Then(toHandlerFunc(w.Before)).Defer(toHandlerFunc(w.After))
 }
 
+// Prioritized returns a ChainMutation that adds w to the chain, but controls
+// when w.After runs relative to other defer'd handlers, regardless of the
+// order that they were registered. Handlers with a higher priority run first,
+// and a plain Wrap has a priority of 0. See chain.Func.DeferPriority.
+func Prioritized(w Wrap, priority int) ChainMutation {
+	return prioritized{w, priority}
+}
+
+type prioritized struct {
+	w        Wrap
+	priority int
+}
+
+func (p prioritized) Apply(c chain.Func) chain.Func {
+	return c.Then(toHandlerFunc(p.w.Before)).DeferPriority(p.priority, toHandlerFunc(p.w.After))
+}
+
 func apply(c chain.Func, handlers ...any) chain.Func {
 	for _, h := range handlers {
 		if mod, ok := h.(ChainMutation); ok {
@@ -52,9 +70,74 @@ func apply(c chain.Func, handlers ...any) chain.Func {
 	return c
 }
 
+// tryApply is like apply, but returns the error instead of panicking if a
+// handler can't be added to the chain.
+func tryApply(c chain.Func, handlers ...any) (_ chain.Func, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			e, ok := x.(error)
+			if _, isRuntime := x.(runtime.Error); !ok || isRuntime {
+				panic(x)
+			}
+			err = e
+		}
+	}()
+	return apply(c, handlers...), nil
+}
+
 func toHandlerFunc(h any) any {
 	if handlerInterface, ok := h.(http.Handler); ok {
 		return handlerInterface.ServeHTTP
 	}
 	return h
 }
+
+// Label returns a ChainMutation that marks a point in the chain that a handler
+// may skip to by returning SkipTo. For example, to skip loading and rendering
+// when a response is already cached:
+//
+//	mux.Get("/report/:id",
+//	    serveFromCache, // returns sandwich.SkipTo("done") on a cache hit
+//	    loadReport, renderReport, storeInCache,
+//	    sandwich.Label("done"),
+//	)
+//
+// Label names must be unique within a chain.
+func Label(name string) ChainMutation { return label(name) }
+
+type label string
+
+func (l label) Apply(c chain.Func) chain.Func { return c.Label(string(l)) }
+
+// SkipTo returns an error that, when returned by a handler, skips the
+// subsequent handlers up to the specified Label. See chain.SkipTo.
+func SkipTo(label string) error { return chain.SkipTo(label) }
+
+// Accumulate returns a ChainMutation that makes all of the values of the
+// specified type provided during the request available to subsequent handlers
+// as a slice. For example, several middleware handlers may each return a
+// Warning, and a later handler may accept []Warning to receive all of them.
+// See chain.Func.Accumulate.
+func Accumulate(typeOrInterfacePtr any) ChainMutation {
+	return accumulate{typeOrInterfacePtr}
+}
+
+type accumulate struct{ typ any }
+
+func (a accumulate) Apply(c chain.Func) chain.Func { return c.Accumulate(a.typ) }
+
+// DeferLate returns a ChainMutation that defers a handler that may accept
+// values provided later in the chain. Values that were never provided, e.g.
+// because an earlier handler failed, are passed as zero values. For example, a
+// metrics handler registered by a shared middleware bundle may record the
+// authenticated user of each request:
+//
+//	mux.Use(sandwich.DeferLate(recordRequest)) // func(*LogEntry, *User)
+//	mux.Get("/profile", loadUser, showProfile)
+//
+// See chain.Func.DeferLate.
+func DeferLate(handler any) ChainMutation { return deferLate{handler} }
+
+type deferLate struct{ handler any }
+
+func (d deferLate) Apply(c chain.Func) chain.Func { return c.DeferLate(d.handler) }